@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+
+	"github.com/carmo-evan/mattermost-plugin-replace/server/pkg/parser"
+)
+
+// commandPrefix starts every s/ command, whatever alias it was typed with.
+const commandPrefix = "s/"
+
+// parseCommandAliases reads the CommandAliases setting, a comma separated list of prefixes such
+// as "fix/, typo/". Each alias must end with a slash and contain no white space.
+func parseCommandAliases(setting string) ([]string, error) {
+	var aliases []string
+
+	for _, alias := range strings.Split(setting, ",") {
+		alias = strings.TrimSpace(alias)
+		if alias == "" || alias == commandPrefix {
+			continue
+		}
+
+		if len(alias) < 2 || !strings.HasSuffix(alias, "/") || strings.Count(alias, "/") > 1 {
+			return nil, errors.Errorf("alias %q must be a word followed by a single slash, such as fix/", alias)
+		}
+
+		if strings.IndexFunc(alias, unicode.IsSpace) >= 0 {
+			return nil, errors.Errorf("alias %q must not contain white space", alias)
+		}
+
+		aliases = append(aliases, alias)
+	}
+
+	return aliases, nil
+}
+
+// canonicalCommand rewrites message, trimmed of white space, to start with s/ when it starts with
+// one of the configured aliases. The second return value reports whether message is a command,
+// with any of the delimiters accepted by the parser.
+func (c *configuration) canonicalCommand(message string) (string, bool) {
+	if parser.IsCommand(message) {
+		return message, true
+	}
+
+	for _, alias := range c.aliases {
+		if strings.HasPrefix(message, alias) {
+			return commandPrefix + strings.TrimPrefix(message, alias), true
+		}
+	}
+
+	return message, false
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCommandAliases(t *testing.T) {
+	aliases, err := parseCommandAliases(" fix/, typo/,, s/")
+	require.Nil(t, err)
+	assert.Equal(t, []string{"fix/", "typo/"}, aliases)
+
+	for _, setting := range []string{"fix", "/", "fix/it/", "my fix/"} {
+		_, err := parseCommandAliases(setting)
+		assert.NotNil(t, err, setting)
+	}
+}
+
+func TestCanonicalCommand(t *testing.T) {
+	config := &configuration{aliases: []string{"fix/", "typo/"}}
+
+	for message, expected := range map[string]string{
+		"s/teh/the":   "s/teh/the",
+		"fix/teh/the": "s/teh/the",
+		"typo/a/b/c":  "s/a/b/c",
+		"s|a/b|c/d|":  "s|a/b|c/d|",
+	} {
+		command, ok := config.canonicalCommand(message)
+		assert.True(t, ok, message)
+		assert.Equal(t, expected, command)
+	}
+
+	for _, message := range []string{"fixed it", "sub/teh/the", "see fix/teh/the", "s#1 is done"} {
+		_, ok := config.canonicalCommand(message)
+		assert.False(t, ok, message)
+	}
+}
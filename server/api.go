@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/carmo-evan/mattermost-plugin-replace/server/pkg/parser"
+	"github.com/carmo-evan/mattermost-plugin-replace/server/pkg/substitute"
+)
+
+// previewRequest is the body of a request to the preview endpoint. TeamID is the team the
+// command would be typed in, which feature flags may target.
+type previewRequest struct {
+	Command string `json:"command"`
+	Message string `json:"message"`
+	TeamID  string `json:"team_id"`
+}
+
+// initializeAPI sets up the routes served under /plugins/{id}/api/v1.
+func (p *Plugin) initializeAPI() *mux.Router {
+	router := mux.NewRouter()
+
+	apiRouter := router.PathPrefix("/api/v1").Subrouter()
+	apiRouter.HandleFunc("/preview", p.handlePreview).Methods(http.MethodPost)
+	apiRouter.HandleFunc("/preview/defaults", p.handlePreviewDefaults).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/build/preview", p.handleBuildPreview).Methods(http.MethodPost)
+	apiRouter.HandleFunc("/build/apply", p.handleBuildApply).Methods(http.MethodPost)
+	apiRouter.HandleFunc("/confirm", p.handleConfirm).Methods(http.MethodPost)
+	apiRouter.HandleFunc("/admin/export", p.requireSystemAdmin(p.handleExport)).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/admin/import", p.requireSystemAdmin(p.handleImport)).Methods(http.MethodPost)
+
+	return router
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// previewDefaults returns the defaults commands of userID typed in teamID are applied with,
+// including the preferences of the user.
+func (p *Plugin) previewDefaults(userID, teamID string) (*substitute.Defaults, error) {
+	prefs, err := p.getUserPreferences(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defaults := p.getConfiguration().defaults(teamID, userID)
+	prefs.applyDefaults(defaults)
+
+	return defaults, nil
+}
+
+// handlePreview applies a command, or several separated by semicolons, to the given message and
+// returns the result without editing any post. It backs live previews in clients that can't run
+// the WebAssembly build of the engine.
+func (p *Plugin) handlePreview(w http.ResponseWriter, r *http.Request) {
+	var request previewRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	userID := r.Header.Get("Mattermost-User-Id")
+	defaults, err := p.previewDefaults(userID, request.TeamID)
+	if err != nil {
+		p.reportError(err, map[string]string{"hook": "ServeHTTP", "user_id": userID})
+		writeJSONError(w, http.StatusInternalServerError, "failed to load user preferences")
+		return
+	}
+
+	cmds, err := parser.ParseScript(request.Command)
+	var subs []*substitute.Substitution
+	if err == nil {
+		subs, err = substitute.FromScript(cmds)
+	}
+	if err == nil {
+		err = defaults.ApplyScript(subs)
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	result, _ := applyScript(subs, request.Message)
+	writeJSON(w, http.StatusOK, result)
+}
+
+// handlePreviewDefaults returns the defaults the commands of the user are applied with in the
+// team given by the team_id query parameter, for the WebAssembly build of the engine to preview
+// commands as the server would apply them.
+func (p *Plugin) handlePreviewDefaults(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-Id")
+	defaults, err := p.previewDefaults(userID, r.URL.Query().Get("team_id"))
+	if err != nil {
+		p.reportError(err, map[string]string{"hook": "ServeHTTP", "user_id": userID})
+		writeJSONError(w, http.StatusInternalServerError, "failed to load user preferences")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, defaults)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/carmo-evan/mattermost-plugin-replace/server/pkg/substitute"
+)
+
+func TestHandlePreview(t *testing.T) {
+	api := &plugintest.API{}
+	defer api.AssertExpectations(t)
+
+	p := setupTestPlugin(t, api)
+	p.router = p.initializeAPI()
+
+	api.On("KVGet", preferencesKey("testUserId")).Return(nil, nil)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/preview", strings.NewReader(`{"command": "s/bee/be", "message": "to bee or not to bee"}`))
+	r.Header.Set("Mattermost-User-Id", "testUserId")
+	p.ServeHTTP(nil, w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var result substitute.Result
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&result))
+	assert.Equal(t, substitute.Result{Original: "to bee or not to bee", Message: "to be or not to be", Replacements: 2}, result)
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodPost, "/api/v1/preview", strings.NewReader(`{"command": "s/bee", "message": "bee"}`))
+	r.Header.Set("Mattermost-User-Id", "testUserId")
+	p.ServeHTTP(nil, w, r)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestHandlePreviewAppliesDefaults(t *testing.T) {
+	api := &plugintest.API{}
+	defer api.AssertExpectations(t)
+
+	p := setupTestPlugin(t, api)
+	p.setConfiguration(&configuration{PatternMode: patternLiteral, MatchInsideWords: true})
+	p.router = p.initializeAPI()
+
+	api.On("KVGet", preferencesKey("testUserId")).Return([]byte(`{"smart_case": true}`), nil)
+
+	preview := func(body string) (int, substitute.Result) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/preview", strings.NewReader(body))
+		r.Header.Set("Mattermost-User-Id", "testUserId")
+		p.ServeHTTP(nil, w, r)
+
+		var result substitute.Result
+		assert.Nil(t, json.NewDecoder(w.Body).Decode(&result))
+		return w.Code, result
+	}
+
+	code, result := preview(`{"command": "s/:(/:)/; s/fail/pass/", "message": "Tests FAILED :("}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, substitute.Result{Original: "Tests FAILED :(", Message: "Tests passED :)", Replacements: 2}, result)
+
+	code, result = preview(`{"command": "s/recieve/receive/f", "message": "did you receve it"}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []string{"receve"}, result.Matched)
+}
+
+func TestHandlePreviewDefaults(t *testing.T) {
+	api := &plugintest.API{}
+	defer api.AssertExpectations(t)
+
+	p := setupTestPlugin(t, api)
+	rules, err := parseFeatureFlags("regex=team:testTeamId")
+	assert.Nil(t, err)
+	p.setConfiguration(&configuration{PatternMode: patternLiteral, SkipHashtags: true, featureRules: rules})
+	p.router = p.initializeAPI()
+
+	api.On("KVGet", preferencesKey("testUserId")).Return([]byte(`{"collapse_whitespace": true}`), nil)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/preview/defaults?team_id=testTeamId", nil)
+	r.Header.Set("Mattermost-User-Id", "testUserId")
+	p.ServeHTTP(nil, w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var defaults substitute.Defaults
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&defaults))
+	assert.Equal(t, substitute.Defaults{Regex: true, Literal: true, SkipHashtags: true, CollapseWhitespace: true}, defaults)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/mattermost/mattermost-server/model"
+
+	"github.com/carmo-evan/mattermost-plugin-replace/server/pkg/substitute"
+)
+
+// rewriteAttachments applies the substitutions of subs given the a flag to the text, pretext and
+// field values of the message attachments of post, in order. It returns the rewritten attachments
+// and the number of replacements made, leaving post unchanged. The attachments are nil when
+// nothing was replaced.
+func rewriteAttachments(subs []*substitute.Substitution, post *model.Post) ([]*model.SlackAttachment, int) {
+	if !rewritesAttachments(subs) {
+		return nil, 0
+	}
+
+	attachments := post.Attachments()
+	rewritten := make([]*model.SlackAttachment, 0, len(attachments))
+	replacements := 0
+
+	for _, attachment := range attachments {
+		copied := *attachment
+
+		var n int
+		copied.Text, n = rewriteAttachmentText(subs, attachment.Text)
+		replacements += n
+		copied.Pretext, n = rewriteAttachmentText(subs, attachment.Pretext)
+		replacements += n
+
+		copied.Fields = make([]*model.SlackAttachmentField, len(attachment.Fields))
+		for i, field := range attachment.Fields {
+			copiedField := *field
+			if value, ok := field.Value.(string); ok {
+				copiedField.Value, n = rewriteAttachmentText(subs, value)
+				replacements += n
+			}
+			copied.Fields[i] = &copiedField
+		}
+
+		rewritten = append(rewritten, &copied)
+	}
+
+	if replacements == 0 {
+		return nil, 0
+	}
+
+	return rewritten, replacements
+}
+
+// rewriteAttachmentText applies the substitutions of subs given the a flag to text, one attachment
+// field.
+func rewriteAttachmentText(subs []*substitute.Substitution, text string) (string, int) {
+	if text == "" {
+		return text, 0
+	}
+
+	replacements := 0
+	for _, sub := range subs {
+		if sub.Attachments {
+			result := sub.Preview(text)
+			text = result.Message
+			replacements += result.Replacements
+		}
+	}
+
+	return text, replacements
+}
+
+// rewritesAttachments reports whether any command of a script was given the a flag.
+func rewritesAttachments(subs []*substitute.Substitution) bool {
+	for _, sub := range subs {
+		if sub.Attachments {
+			return true
+		}
+	}
+
+	return false
+}
+
+// attachmentsConfirmation reports the replacements made in message attachments.
+func attachmentsConfirmation(replacements int) string {
+	return fmt.Sprintf("s/ Replaced %s in the message attachments", occurrences(replacements))
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/mattermost/mattermost-server/plugin"
+	"github.com/mattermost/mattermost-server/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carmo-evan/mattermost-plugin-replace/server/pkg/parser"
+	"github.com/carmo-evan/mattermost-plugin-replace/server/pkg/substitute"
+)
+
+func parseTestScript(t *testing.T, script string) []*substitute.Substitution {
+	cmds, err := parser.ParseScript(script)
+	require.NoError(t, err)
+	subs, err := substitute.FromScript(cmds)
+	require.NoError(t, err)
+
+	return subs
+}
+
+func TestRewriteAttachments(t *testing.T) {
+	post := &model.Post{Message: "Deplyed"}
+	post.AddProp("attachments", []*model.SlackAttachment{{
+		Pretext: "Deplyed to staging",
+		Text:    "Deplyed build 42",
+		Title:   "Deplyed",
+		Fields:  []*model.SlackAttachmentField{{Title: "Status", Value: "Deplyed"}, {Title: "Count", Value: 3}},
+	}})
+
+	t.Run("without the a flag", func(t *testing.T) {
+		attachments, replacements := rewriteAttachments(parseTestScript(t, "s/Deplyed/Deployed/"), post)
+		assert.Nil(t, attachments)
+		assert.Equal(t, 0, replacements)
+	})
+
+	t.Run("with the a flag", func(t *testing.T) {
+		attachments, replacements := rewriteAttachments(parseTestScript(t, "s/Deplyed/Deployed/a"), post)
+		require.Len(t, attachments, 1)
+		assert.Equal(t, 3, replacements)
+		assert.Equal(t, "Deployed to staging", attachments[0].Pretext)
+		assert.Equal(t, "Deployed build 42", attachments[0].Text)
+		assert.Equal(t, "Deplyed", attachments[0].Title)
+		assert.Equal(t, "Deployed", attachments[0].Fields[0].Value)
+		assert.Equal(t, 3, attachments[0].Fields[1].Value)
+
+		// The post itself is left for the caller to update.
+		assert.Equal(t, "Deplyed build 42", post.Attachments()[0].Text)
+	})
+
+	t.Run("only the commands given the a flag", func(t *testing.T) {
+		attachments, replacements := rewriteAttachments(parseTestScript(t, "s/build/Build/; s/staging/prod/a"), post)
+		require.Len(t, attachments, 1)
+		assert.Equal(t, 1, replacements)
+		assert.Equal(t, "Deplyed build 42", attachments[0].Text)
+		assert.Equal(t, "Deplyed to prod", attachments[0].Pretext)
+	})
+}
+
+func TestMessageWillBePostedAttachments(t *testing.T) {
+	api := &plugintest.API{}
+	p := setupTestPlugin(t, api)
+
+	lastPost := &model.Post{Id: "lastPostId", UserId: "testUserId", ChannelId: "testChannelId", Message: "Release notes"}
+	lastPost.AddProp("attachments", []*model.SlackAttachment{{Text: "Fixed teh login page"}})
+
+	api.On("GetUser", "testUserId").Return(&model.User{Id: "testUserId", Username: "test"}, nil)
+	api.On("GetChannel", "testChannelId").Return(&model.Channel{Id: "testChannelId", TeamId: "testTeamId"}, nil)
+	api.On("SearchPostsInTeam", "testTeamId", mock.AnythingOfType("[]*model.SearchParams")).Return([]*model.Post{lastPost}, nil)
+	api.On("HasPermissionToChannel", "testUserId", "testChannelId", model.PERMISSION_EDIT_POST).Return(true)
+	api.On("KVGet", preferencesKey("testUserId")).Return(nil, nil)
+	api.On("KVGet", channelNotificationKey("testChannelId")).Return(nil, nil)
+	api.On("KVGet", channelCorrectionKey("testChannelId")).Return(nil, nil)
+	api.On("KVGet", channelConfirmKey("testChannelId")).Return(nil, nil)
+	api.On("GetPost", "lastPostId").Return(lastPost, nil)
+	api.On("UpdatePost", mock.MatchedBy(func(post *model.Post) bool {
+		return post.Message == "Release notes" && post.Attachments()[0].Text == "Fixed the login page"
+	})).Return(lastPost, nil)
+	api.On("KVGet", historyKey("lastPostId")).Return(nil, nil)
+	api.On("KVSet", historyKey("lastPostId"), mock.AnythingOfType("[]uint8")).Return(nil)
+	api.On("KVSet", lastEditKey("testUserId"), []byte("lastPostId")).Return(nil)
+	api.On("KVSet", undoKey("testUserId"), []byte("lastPostId")).Return(nil)
+	api.On("SendEphemeralPost", "testUserId", mock.MatchedBy(func(notification *model.Post) bool {
+		return strings.HasPrefix(notification.Message, "[Your post](/engineering/pl/lastPostId) was edited.\n") &&
+			strings.HasSuffix(notification.Message, "s/ Replaced 1 occurrence in the message attachments")
+	})).Return(nil)
+	api.On("GetConfig").Return(&model.Config{})
+	api.On("GetTeam", "testTeamId").Return(&model.Team{Name: "engineering"}, nil)
+
+	_, rejection := p.MessageWillBePosted(&plugin.Context{}, &model.Post{UserId: "testUserId", ChannelId: "testChannelId", Message: "s/teh/the/a"})
+
+	assert.Equal(t, "plugin.message_will_be_posted.dismiss_post", rejection)
+	api.AssertExpectations(t)
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/pkg/errors"
+)
+
+// backupEntry is a single KV entry in a backup. Values are stored as text when possible, so
+// backups can be read and edited with everyday tools, and base64 encoded otherwise.
+type backupEntry struct {
+	Key         string `json:"key"`
+	Value       string `json:"value,omitempty"`
+	ValueBase64 string `json:"value_base64,omitempty"`
+}
+
+// backup is the JSON document produced by the export endpoint.
+type backup struct {
+	PluginID      string         `json:"plugin_id"`
+	PluginVersion string         `json:"plugin_version"`
+	CreatedAt     int64          `json:"created_at"`
+	Entries       []*backupEntry `json:"entries"`
+}
+
+// expiringKeyPrefixes start the keys stored with an expiry. The KV store does not tell how long
+// such an entry has left, and restoring it without one would make it permanent, so these are left
+// out of backups.
+var expiringKeyPrefixes = []string{undoKeyPrefix, joinHintKeyPrefix}
+
+// isBackedUp reports whether the entry under key belongs in a backup. The bot account is specific
+// to each server and is recreated on activation instead.
+func isBackedUp(key string) bool {
+	if key == botUserKey {
+		return false
+	}
+
+	for _, prefix := range expiringKeyPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func newBackupEntry(key string, value []byte) *backupEntry {
+	if utf8.Valid(value) {
+		return &backupEntry{Key: key, Value: string(value)}
+	}
+
+	return &backupEntry{Key: key, ValueBase64: base64.StdEncoding.EncodeToString(value)}
+}
+
+func (entry *backupEntry) value() ([]byte, error) {
+	if entry.ValueBase64 == "" {
+		return []byte(entry.Value), nil
+	}
+
+	value, err := base64.StdEncoding.DecodeString(entry.ValueBase64)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid base64 value for %s", entry.Key)
+	}
+
+	return value, nil
+}
+
+// exportEntries reads every backed up entry of the KV store.
+func (p *Plugin) exportEntries() ([]*backupEntry, error) {
+	var keys []string
+	for page := 0; ; page++ {
+		pageKeys, appErr := p.API.KVList(page, kvListPageSize)
+		if appErr != nil {
+			return nil, errors.Wrap(appErr, "failed to list keys")
+		}
+
+		keys = append(keys, pageKeys...)
+		if len(pageKeys) < kvListPageSize {
+			break
+		}
+	}
+
+	entries := make([]*backupEntry, 0, len(keys))
+	for _, key := range keys {
+		if !isBackedUp(key) {
+			continue
+		}
+
+		value, appErr := p.API.KVGet(key)
+		if appErr != nil {
+			return nil, errors.Wrapf(appErr, "failed to get %s", key)
+		}
+
+		// The entry may have expired or been deleted since the listing.
+		if value == nil {
+			continue
+		}
+
+		entries = append(entries, newBackupEntry(key, value))
+	}
+
+	return entries, nil
+}
+
+// requireSystemAdmin wraps a handler so that only system admins may call it.
+func (p *Plugin) requireSystemAdmin(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !p.API.HasPermissionTo(r.Header.Get("Mattermost-User-Id"), model.PERMISSION_MANAGE_SYSTEM) {
+			writeJSONError(w, http.StatusForbidden, "system admins only")
+			return
+		}
+
+		handler(w, r)
+	}
+}
+
+// handleExport writes all plugin data, as a single JSON document or, with ?format=jsonl, as one
+// JSON entry per line.
+func (p *Plugin) handleExport(w http.ResponseWriter, r *http.Request) {
+	entries, err := p.exportEntries()
+	if err != nil {
+		p.reportError(err, map[string]string{"hook": "ServeHTTP", "user_id": r.Header.Get("Mattermost-User-Id")})
+		writeJSONError(w, http.StatusInternalServerError, "failed to export plugin data")
+		return
+	}
+
+	if r.URL.Query().Get("format") == "jsonl" {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		encoder := json.NewEncoder(w)
+		for _, entry := range entries {
+			if err := encoder.Encode(entry); err != nil {
+				return
+			}
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusOK, &backup{
+		PluginID:      manifest.Id,
+		PluginVersion: manifest.Version,
+		CreatedAt:     model.GetMillis(),
+		Entries:       entries,
+	})
+}
+
+// readBackupEntries decodes a backup in either of the formats written by handleExport.
+func readBackupEntries(body io.Reader, format string) ([]*backupEntry, error) {
+	if format != "jsonl" {
+		var document backup
+		if err := json.NewDecoder(body).Decode(&document); err != nil {
+			return nil, errors.Wrap(err, "invalid backup")
+		}
+		return document.Entries, nil
+	}
+
+	var entries []*backupEntry
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(nil, 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		entry := &backupEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), entry); err != nil {
+			return nil, errors.Wrapf(err, "invalid backup entry on line %d", line)
+		}
+		entries = append(entries, entry)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read backup")
+	}
+
+	return entries, nil
+}
+
+// handleImport restores plugin data from a backup, in the format given by ?format. Existing
+// entries are overwritten, and with ?replace=true every other entry is deleted first.
+func (p *Plugin) handleImport(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	context := map[string]string{"hook": "ServeHTTP", "user_id": r.Header.Get("Mattermost-User-Id")}
+
+	entries, err := readBackupEntries(r.Body, query.Get("format"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	values := make(map[string][]byte, len(entries))
+	for _, entry := range entries {
+		if entry.Key == "" {
+			writeJSONError(w, http.StatusBadRequest, "backup entry without a key")
+			return
+		}
+
+		value, err := entry.value()
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		values[entry.Key] = value
+	}
+
+	if query.Get("replace") == "true" {
+		current, err := p.exportEntries()
+		if err != nil {
+			p.reportError(err, context)
+			writeJSONError(w, http.StatusInternalServerError, "failed to read current plugin data")
+			return
+		}
+
+		for _, entry := range current {
+			if _, ok := values[entry.Key]; ok {
+				continue
+			}
+			if appErr := p.API.KVDelete(entry.Key); appErr != nil {
+				p.reportError(appErr, context)
+				writeJSONError(w, http.StatusInternalServerError, "failed to delete current plugin data")
+				return
+			}
+		}
+	}
+
+	imported := 0
+	for key, value := range values {
+		if !isBackedUp(key) {
+			continue
+		}
+
+		if appErr := p.API.KVSet(key, value); appErr != nil {
+			p.reportError(appErr, context)
+			writeJSONError(w, http.StatusInternalServerError, "failed to import plugin data")
+			return
+		}
+		imported++
+	}
+
+	writeJSON(w, http.StatusOK, map[string]int{"imported": imported})
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/mattermost/mattermost-server/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleExport(t *testing.T) {
+	api := &plugintest.API{}
+	defer api.AssertExpectations(t)
+
+	p := setupTestPlugin(t, api)
+	p.router = p.initializeAPI()
+
+	api.On("HasPermissionTo", "adminId", model.PERMISSION_MANAGE_SYSTEM).Return(true)
+	api.On("KVList", 0, kvListPageSize).Return([]string{preferencesKey("testUserId"), botUserKey, undoKey("testUserId"), "binary"}, nil)
+	api.On("KVGet", preferencesKey("testUserId")).Return([]byte(`{"replace_in_spoilers":true}`), nil)
+	api.On("KVGet", "binary").Return([]byte{0xff, 0x00}, nil)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/admin/export?format=jsonl", nil)
+	r.Header.Set("Mattermost-User-Id", "adminId")
+	p.ServeHTTP(nil, w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"key":"preferences_testUserId","value":"{\"replace_in_spoilers\":true}"}`+"\n"+`{"key":"binary","value_base64":"/wA="}`+"\n", w.Body.String())
+}
+
+func TestHandleImport(t *testing.T) {
+	document, err := json.Marshal(&backup{Entries: []*backupEntry{
+		{Key: preferencesKey("testUserId"), Value: `{"replace_in_spoilers":true}`},
+		{Key: "binary", ValueBase64: "/wA="},
+		{Key: botUserKey, Value: "otherServerBotId"},
+		{Key: joinHintKey("testUserId"), Value: "1"},
+	}})
+	require.Nil(t, err)
+
+	t.Run("system admin", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+
+		p := setupTestPlugin(t, api)
+		p.router = p.initializeAPI()
+
+		api.On("HasPermissionTo", "adminId", model.PERMISSION_MANAGE_SYSTEM).Return(true)
+		api.On("KVList", 0, kvListPageSize).Return([]string{"stale", botUserKey}, nil)
+		api.On("KVGet", "stale").Return([]byte("1"), nil)
+		api.On("KVDelete", "stale").Return(nil)
+		api.On("KVSet", preferencesKey("testUserId"), []byte(`{"replace_in_spoilers":true}`)).Return(nil)
+		api.On("KVSet", "binary", []byte{0xff, 0x00}).Return(nil)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/admin/import?replace=true", bytes.NewReader(document))
+		r.Header.Set("Mattermost-User-Id", "adminId")
+		p.ServeHTTP(nil, w, r)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, `{"imported":2}`+"\n", w.Body.String())
+	})
+
+	t.Run("regular user", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+
+		p := setupTestPlugin(t, api)
+		p.router = p.initializeAPI()
+
+		api.On("HasPermissionTo", "testUserId", model.PERMISSION_MANAGE_SYSTEM).Return(false)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/admin/import", strings.NewReader(string(document)))
+		r.Header.Set("Mattermost-User-Id", "testUserId")
+		p.ServeHTTP(nil, w, r)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+}
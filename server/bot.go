@@ -0,0 +1,49 @@
+package main
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-server/model"
+)
+
+const (
+	botUsername string = "replace"
+	botUserKey  string = "bot_user_id"
+)
+
+// ensureBot returns the user ID of the plugin bot, creating the bot on first activation. The ID
+// is remembered in the KV store so later activations reuse the same account.
+func (p *Plugin) ensureBot() (string, error) {
+	data, appErr := p.API.KVGet(botUserKey)
+	if appErr != nil {
+		return "", errors.Wrap(appErr, "failed to get bot user ID")
+	}
+
+	if data != nil {
+		return string(data), nil
+	}
+
+	bot, appErr := p.API.CreateBot(&model.Bot{
+		Username:    botUsername,
+		DisplayName: "Replace",
+		Description: "Posts on behalf of the Replace plugin.",
+	})
+
+	var botUserID string
+	if appErr == nil {
+		botUserID = bot.UserId
+	} else {
+		// The account survives the KV store being cleared, in which case it is adopted.
+		user, userErr := p.API.GetUserByUsername(botUsername)
+		if userErr != nil || !user.IsBot {
+			return "", errors.Wrap(appErr, "failed to create bot")
+		}
+		botUserID = user.Id
+	}
+
+	if appErr := p.API.KVSet(botUserKey, []byte(botUserID)); appErr != nil {
+		return "", errors.Wrap(appErr, "failed to save bot user ID")
+	}
+
+	return botUserID, nil
+}
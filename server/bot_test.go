@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/mattermost/mattermost-server/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestEnsureBot(t *testing.T) {
+	t.Run("known bot", func(t *testing.T) {
+		api := &plugintest.API{}
+		p := setupTestPlugin(t, api)
+
+		api.On("KVGet", botUserKey).Return([]byte("botUserId"), nil)
+
+		botUserID, err := p.ensureBot()
+
+		assert.Nil(t, err)
+		assert.Equal(t, "botUserId", botUserID)
+		api.AssertExpectations(t)
+	})
+
+	t.Run("first activation", func(t *testing.T) {
+		api := &plugintest.API{}
+		p := setupTestPlugin(t, api)
+
+		api.On("KVGet", botUserKey).Return(nil, nil)
+		api.On("CreateBot", mock.AnythingOfType("*model.Bot")).Return(&model.Bot{UserId: "botUserId"}, nil)
+		api.On("KVSet", botUserKey, []byte("botUserId")).Return(nil)
+
+		botUserID, err := p.ensureBot()
+
+		assert.Nil(t, err)
+		assert.Equal(t, "botUserId", botUserID)
+		api.AssertExpectations(t)
+	})
+
+	t.Run("existing account", func(t *testing.T) {
+		api := &plugintest.API{}
+		p := setupTestPlugin(t, api)
+
+		api.On("KVGet", botUserKey).Return(nil, nil)
+		api.On("CreateBot", mock.AnythingOfType("*model.Bot")).Return(nil, model.NewAppError("CreateBot", "", nil, "", http.StatusBadRequest))
+		api.On("GetUserByUsername", botUsername).Return(&model.User{Id: "botUserId", IsBot: true}, nil)
+		api.On("KVSet", botUserKey, []byte("botUserId")).Return(nil)
+
+		botUserID, err := p.ensureBot()
+
+		assert.Nil(t, err)
+		assert.Equal(t, "botUserId", botUserID)
+		api.AssertExpectations(t)
+	})
+}
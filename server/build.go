@@ -0,0 +1,316 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/mattermost/mattermost-server/model"
+
+	"github.com/carmo-evan/mattermost-plugin-replace/server/pkg/substitute"
+)
+
+const (
+	buildPreviewPath string = "/api/v1/build/preview"
+	buildApplyPath   string = "/api/v1/build/apply"
+)
+
+// buildState travels with the builder dialog, which does not otherwise tell where it was opened.
+type buildState struct {
+	ChannelID string `json:"channel_id"`
+	RootID    string `json:"root_id"`
+}
+
+// pluginURL returns the absolute URL of a route served by the plugin, as dialogs and buttons
+// require.
+func (p *Plugin) pluginURL(path string) string {
+	siteURL := ""
+	if config := p.API.GetConfig(); config != nil && config.ServiceSettings.SiteURL != nil {
+		siteURL = strings.TrimRight(*config.ServiceSettings.SiteURL, "/")
+	}
+
+	return siteURL + "/plugins/" + manifest.Id + path
+}
+
+// buildSubstitution turns the plain text entered in the builder into a substitution, so that
+// users need not know which characters are special.
+func buildSubstitution(find, replace string, matchCase bool) *substitute.Substitution {
+	pattern := strings.Replace(find, `\`, `\\`, -1)
+
+	return &substitute.Substitution{Pattern: pattern, Replacement: replace, Literal: true, IgnoreCase: !matchCase}
+}
+
+// executeBuildCommand opens the builder dialog, a guided alternative to the s/ syntax. Its
+// submission is previewed by handleBuildPreview and applied by handleBuildApply.
+func (p *Plugin) executeBuildCommand(args *model.CommandArgs) *model.CommandResponse {
+	state, _ := json.Marshal(buildState{ChannelID: args.ChannelId, RootID: args.RootId})
+
+	targets := []*model.PostActionOptions{
+		{Text: "My last post in this channel", Value: "channel"},
+		{Text: "My last post anywhere in this team", Value: "team"},
+	}
+	defaultTarget := "channel"
+	if args.RootId != "" {
+		targets = append([]*model.PostActionOptions{{Text: "My last post in this thread", Value: "thread"}}, targets...)
+		defaultTarget = "thread"
+	}
+
+	appErr := p.API.OpenInteractiveDialog(model.OpenDialogRequest{
+		TriggerId: args.TriggerId,
+		URL:       p.pluginURL(buildPreviewPath),
+		Dialog: model.Dialog{
+			CallbackId:  "build",
+			Title:       "Fix a post",
+			SubmitLabel: "Preview",
+			State:       string(state),
+			Elements: []model.DialogElement{
+				{DisplayName: "Post to fix", Name: "target", Type: "select", Default: defaultTarget, Options: targets},
+				{DisplayName: "Text to change", Name: "find", Type: "text", HelpText: "Whole words are matched, exactly as typed."},
+				{DisplayName: "New text", Name: "replace", Type: "text"},
+				{DisplayName: "Letter case", Name: "case", Type: "select", Default: "ignore", Options: []*model.PostActionOptions{
+					{Text: "Match any case", Value: "ignore"},
+					{Text: "Match the case exactly", Value: "match"},
+				}},
+			},
+		},
+	})
+	if appErr != nil {
+		p.reportError(appErr, map[string]string{"hook": "ExecuteCommand", "user_id": args.UserId, "channel_id": args.ChannelId})
+		return commandResponse("Failed to open the dialog.")
+	}
+
+	return &model.CommandResponse{}
+}
+
+// handleBuildPreview receives the builder dialog, finds the post to fix and shows the user a
+// preview with buttons to apply or cancel the change.
+func (p *Plugin) handleBuildPreview(w http.ResponseWriter, r *http.Request) {
+	var request model.SubmitDialogRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	userID := r.Header.Get("Mattermost-User-Id")
+	if request.UserId != userID {
+		writeJSONError(w, http.StatusForbidden, "user mismatch")
+		return
+	}
+
+	if request.Cancelled {
+		writeJSON(w, http.StatusOK, &model.SubmitDialogResponse{})
+		return
+	}
+
+	var state buildState
+	if err := json.Unmarshal([]byte(request.State), &state); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid dialog state")
+		return
+	}
+
+	target, _ := request.Submission["target"].(string)
+	find, _ := request.Submission["find"].(string)
+	replace, _ := request.Submission["replace"].(string)
+	letterCase, _ := request.Submission["case"].(string)
+
+	fieldErrors := make(map[string]string)
+	if strings.TrimSpace(find) == "" {
+		fieldErrors["find"] = "Enter the text to change."
+	}
+	if replace == "" {
+		fieldErrors["replace"] = "Enter the new text."
+	}
+	if len(fieldErrors) > 0 {
+		writeJSON(w, http.StatusOK, &model.SubmitDialogResponse{Errors: fieldErrors})
+		return
+	}
+
+	context := map[string]string{"hook": "ServeHTTP", "user_id": userID, "channel_id": state.ChannelID}
+
+	user, appErr := p.getUser(userID)
+	if appErr != nil {
+		p.reportError(appErr, context)
+		writeJSONError(w, http.StatusInternalServerError, "failed to get user")
+		return
+	}
+
+	channel, appErr := p.getChannel(state.ChannelID)
+	if appErr != nil {
+		p.reportError(appErr, context)
+		writeJSONError(w, http.StatusInternalServerError, "failed to get channel")
+		return
+	}
+
+	postTarget := &postTarget{TeamID: channel.TeamId}
+	switch target {
+	case "thread":
+		postTarget.RootID = state.RootID
+	case "channel":
+		postTarget.ChannelName = channel.Name
+	}
+
+	lastPost, errMsg := p.getLastPost(user, userID, postTarget)
+	if errMsg != "" {
+		writeJSON(w, http.StatusOK, &model.SubmitDialogResponse{Errors: map[string]string{"target": strings.Trim(errMsg, "`")}})
+		return
+	}
+
+	prefs, err := p.getUserPreferences(userID)
+	if err != nil {
+		p.reportError(err, context)
+		writeJSONError(w, http.StatusInternalServerError, "failed to load user preferences")
+		return
+	}
+
+	sub := buildSubstitution(find, replace, letterCase == "match")
+	prefs.apply(sub)
+
+	result := sub.Preview(lastPost.Message)
+	if result.Replacements == 0 {
+		writeJSON(w, http.StatusOK, &model.SubmitDialogResponse{Errors: map[string]string{"find": "This text is not in the selected post."}})
+		return
+	}
+
+	actionContext := map[string]interface{}{
+		"post_id":    lastPost.Id,
+		"edit_at":    strconv.FormatInt(lastPost.EditAt, 10),
+		"find":       find,
+		"replace":    replace,
+		"match_case": letterCase == "match",
+		"action":     "apply",
+	}
+	cancelContext := map[string]interface{}{"action": "cancel"}
+
+	preview := &model.Post{
+		ChannelId: state.ChannelID,
+		RootId:    state.RootID,
+		CreateAt:  model.GetMillis(),
+	}
+	preview.AddProp("attachments", []*model.SlackAttachment{{
+		Title: fmt.Sprintf("Preview: %d replacement(s)", result.Replacements),
+		Text:  quoteMessage(result.Message),
+		Actions: []*model.PostAction{
+			{Name: "Apply", Integration: &model.PostActionIntegration{URL: p.pluginURL(buildApplyPath), Context: actionContext}},
+			{Name: "Cancel", Integration: &model.PostActionIntegration{URL: p.pluginURL(buildApplyPath), Context: cancelContext}},
+		},
+	}})
+	p.API.SendEphemeralPost(userID, preview)
+
+	writeJSON(w, http.StatusOK, &model.SubmitDialogResponse{})
+}
+
+// handleBuildApply applies or cancels a change previewed by handleBuildPreview, provided the post
+// was not edited in the meantime.
+func (p *Plugin) handleBuildApply(w http.ResponseWriter, r *http.Request) {
+	var request model.PostActionIntegrationRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	userID := r.Header.Get("Mattermost-User-Id")
+	if request.UserId != userID {
+		writeJSONError(w, http.StatusForbidden, "user mismatch")
+		return
+	}
+
+	respond := func(message string) {
+		p.API.UpdateEphemeralPost(userID, &model.Post{Id: request.PostId, ChannelId: request.ChannelId, Message: message})
+		writeJSON(w, http.StatusOK, &model.PostActionIntegrationResponse{})
+	}
+
+	if action, _ := request.Context["action"].(string); action != "apply" {
+		respond("Replacement cancelled.")
+		return
+	}
+
+	postID, _ := request.Context["post_id"].(string)
+	editAt, _ := request.Context["edit_at"].(string)
+	find, _ := request.Context["find"].(string)
+	replace, _ := request.Context["replace"].(string)
+	matchCase, _ := request.Context["match_case"].(bool)
+
+	context := map[string]string{"hook": "ServeHTTP", "user_id": userID, "post_id": postID}
+
+	post, appErr := p.API.GetPost(postID)
+	if appErr != nil || post.UserId != userID {
+		respond("`s/ Command: The post to fix no longer exists.`")
+		return
+	}
+
+	if strconv.FormatInt(post.EditAt, 10) != editAt {
+		respond("`s/ Command: The post changed since the preview. Run /replace build again.`")
+		return
+	}
+
+	if !p.API.HasPermissionToChannel(userID, post.ChannelId, model.PERMISSION_EDIT_POST) {
+		respond("`s/ Command: You do not have permission to edit that post.`")
+		return
+	}
+
+	channel, appErr := p.getChannel(post.ChannelId)
+	if appErr != nil {
+		p.reportError(appErr, context)
+		writeJSONError(w, http.StatusInternalServerError, "failed to get channel")
+		return
+	}
+
+	if !p.isChannelAllowed(channel) {
+		respond(publicOnlyError)
+		return
+	}
+
+	if reason := propsDrivenReason(post); reason != "" {
+		respond(fmt.Sprintf(propsDrivenError, reason))
+		return
+	}
+
+	prefs, err := p.getUserPreferences(userID)
+	if err != nil {
+		p.reportError(err, context)
+		writeJSONError(w, http.StatusInternalServerError, "failed to load user preferences")
+		return
+	}
+
+	sub := buildSubstitution(find, replace, matchCase)
+	prefs.apply(sub)
+
+	if p.getConfiguration().ShadowMode {
+		p.recordShadow(shadowEdited, sub.Preview(post.Message).Replacements)
+		respond("Shadow mode is on, so the post was not changed.")
+		return
+	}
+
+	original := post.Message
+	message := sub.Apply(post.Message)
+	if message == original {
+		respond("The pattern was not found in the post, so it was left unchanged.")
+		return
+	}
+	if errMsg := lengthError(message); errMsg != "" {
+		respond(errMsg)
+		return
+	}
+
+	post.Message = message
+	if _, appErr := p.updatePost(post); appErr != nil {
+		p.reportError(appErr, context)
+		writeJSONError(w, http.StatusInternalServerError, "failed to update post")
+		return
+	}
+
+	if err := p.recordRevision(post.Id, newRevision(userID, "/replace build", original, post.Message)); err != nil {
+		p.reportError(err, context)
+	} else if err := p.rememberEdit(userID, post.Id); err != nil {
+		p.reportError(err, context)
+	}
+
+	respond(`s/ Replaced "` + find + `" for "` + replace + `"`)
+}
+
+// quoteMessage renders message as a Markdown block quote.
+func quoteMessage(message string) string {
+	return "> " + strings.Replace(message, "\n", "\n> ", -1)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/mattermost/mattermost-server/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestBuildSubstitution(t *testing.T) {
+	assert.Equal(t, "It costs $5 today", buildSubstitution("COSTS $4", "costs $5", false).Apply("It costs $4 today"))
+	assert.Equal(t, "It costs $4 today", buildSubstitution("COSTS $4", "costs $5", true).Apply("It costs $4 today"))
+}
+
+func TestHandleBuildPreview(t *testing.T) {
+	api := &plugintest.API{}
+	defer api.AssertExpectations(t)
+
+	p := setupTestPlugin(t, api)
+	p.router = p.initializeAPI()
+
+	lastPost := &model.Post{Id: "lastPostId", UserId: "testUserId", Message: "Teh quick fox"}
+	api.On("GetUser", "testUserId").Return(&model.User{Id: "testUserId", Username: "test"}, nil)
+	api.On("GetChannel", "testChannelId").Return(&model.Channel{Id: "testChannelId", TeamId: "testTeamId", Name: "town-square"}, nil)
+	api.On("SearchPostsInTeam", "testTeamId", mock.MatchedBy(func(params []*model.SearchParams) bool {
+		return len(params) == 1 && params[0].InChannels[0] == "town-square"
+	})).Return([]*model.Post{lastPost}, nil)
+	api.On("HasPermissionToChannel", "testUserId", "", model.PERMISSION_EDIT_POST).Return(true)
+	api.On("KVGet", preferencesKey("testUserId")).Return(nil, nil)
+	api.On("GetConfig").Return(&model.Config{})
+	api.On("SendEphemeralPost", "testUserId", mock.MatchedBy(func(post *model.Post) bool {
+		attachments := post.Attachments()
+		return len(attachments) == 1 && attachments[0].Text == "> the quick fox" && len(attachments[0].Actions) == 2
+	})).Return(nil)
+
+	body, _ := json.Marshal(model.SubmitDialogRequest{
+		UserId:     "testUserId",
+		State:      `{"channel_id": "testChannelId"}`,
+		Submission: map[string]interface{}{"target": "channel", "find": "teh", "replace": "the", "case": "ignore"},
+	})
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, buildPreviewPath, strings.NewReader(string(body)))
+	r.Header.Set("Mattermost-User-Id", "testUserId")
+	p.ServeHTTP(nil, w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "{}\n", w.Body.String())
+}
+
+func TestHandleBuildApply(t *testing.T) {
+	request := func(editAt string) *http.Request {
+		body, _ := json.Marshal(model.PostActionIntegrationRequest{
+			UserId:    "testUserId",
+			ChannelId: "testChannelId",
+			PostId:    "previewId",
+			Context:   map[string]interface{}{"action": "apply", "post_id": "lastPostId", "edit_at": editAt, "find": "teh", "replace": "the"},
+		})
+		r := httptest.NewRequest(http.MethodPost, buildApplyPath, strings.NewReader(string(body)))
+		r.Header.Set("Mattermost-User-Id", "testUserId")
+		return r
+	}
+
+	t.Run("apply", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+
+		p := setupTestPlugin(t, api)
+		p.router = p.initializeAPI()
+
+		api.On("GetPost", "lastPostId").Return(&model.Post{Id: "lastPostId", UserId: "testUserId", ChannelId: "testChannelId", Message: "Teh quick fox"}, nil)
+		api.On("HasPermissionToChannel", "testUserId", "testChannelId", model.PERMISSION_EDIT_POST).Return(true)
+		api.On("GetChannel", "testChannelId").Return(&model.Channel{Id: "testChannelId", Type: model.CHANNEL_OPEN}, nil)
+		api.On("KVGet", preferencesKey("testUserId")).Return(nil, nil)
+		api.On("UpdatePost", mock.MatchedBy(func(post *model.Post) bool {
+			return post.Message == "the quick fox"
+		})).Return(&model.Post{}, nil)
+		api.On("KVGet", historyKey("lastPostId")).Return(nil, nil)
+		api.On("KVSet", historyKey("lastPostId"), mock.AnythingOfType("[]uint8")).Return(nil)
+		api.On("KVSet", lastEditKey("testUserId"), []byte("lastPostId")).Return(nil)
+		api.On("KVSet", undoKey("testUserId"), []byte("lastPostId")).Return(nil)
+		api.On("UpdateEphemeralPost", "testUserId", mock.MatchedBy(func(post *model.Post) bool {
+			return post.Id == "previewId" && strings.HasPrefix(post.Message, "s/ Replaced")
+		})).Return(&model.Post{})
+
+		w := httptest.NewRecorder()
+		p.ServeHTTP(nil, w, request("0"))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("edited since preview", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+
+		p := setupTestPlugin(t, api)
+		p.router = p.initializeAPI()
+
+		api.On("GetPost", "lastPostId").Return(&model.Post{Id: "lastPostId", UserId: "testUserId", EditAt: 42, Message: "Teh quick fox"}, nil)
+		api.On("UpdateEphemeralPost", "testUserId", mock.MatchedBy(func(post *model.Post) bool {
+			return strings.Contains(post.Message, "changed since the preview")
+		})).Return(&model.Post{})
+
+		w := httptest.NewRecorder()
+		p.ServeHTTP(nil, w, request("0"))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+}
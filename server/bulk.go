@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	bulkPageSize int = 100
+	// bulkMaxPosts caps how many posts a single bulk job inspects under the standard limits
+	// profile.
+	bulkMaxPosts int = 1000
+)
+
+// bulkJob describes a rewrite applied to the most recent posts of a channel.
+type bulkJob struct {
+	ChannelID string
+
+	// UserID restricts the job to posts authored by this user. An empty UserID matches posts
+	// from every author.
+	UserID string
+
+	// RequesterID and Command are the user who started the job and the command they typed, as
+	// recorded in the history of every edited post.
+	RequesterID string
+	Command     string
+
+	// Rewrite returns the new message and the number of changes made to it. Posts for which it
+	// reports no changes are not updated.
+	Rewrite func(message string) (string, int)
+}
+
+// bulkResult summarizes a finished bulk job.
+type bulkResult struct {
+	Scanned      int
+	Edited       int
+	Replacements int
+
+	// Failed counts the posts whose update failed, such as posts deleted while the job ran.
+	Failed int
+
+	// Shadow reports that the job ran in shadow mode, so Edited and Replacements count the
+	// changes it would have made.
+	Shadow bool
+}
+
+// runBulkJob pages through the channel from the newest post backwards, applying the job's
+// rewrite to every matching post until the limit of the configured profile has been reached. In
+// shadow mode no post is updated, and the job is counted in the shadow mode statistics instead.
+func (p *Plugin) runBulkJob(job *bulkJob) (*bulkResult, error) {
+	config := p.getConfiguration()
+	result := &bulkResult{Shadow: config.ShadowMode}
+	maxPosts := config.limits().MaxJobPosts
+	if result.Shadow {
+		defer func() {
+			if result.Edited > 0 {
+				p.recordShadow(shadowEdited, result.Replacements)
+			} else {
+				p.recordShadow(shadowUnchanged, 0)
+			}
+		}()
+	}
+
+	for page := 0; result.Scanned < maxPosts; page++ {
+		postList, appErr := p.API.GetPostsForChannel(job.ChannelID, page, bulkPageSize)
+		if appErr != nil {
+			return result, errors.Wrap(appErr, "failed to get posts for channel")
+		}
+
+		for _, id := range postList.Order {
+			if result.Scanned >= maxPosts {
+				break
+			}
+			result.Scanned++
+
+			post := postList.Posts[id]
+			if !isTypedPost(post) || propsDrivenReason(post) != "" || (job.UserID != "" && post.UserId != job.UserID) {
+				continue
+			}
+
+			// Posts the rewrite would make too long for the server are left alone.
+			message, count := job.Rewrite(post.Message)
+			if count == 0 || messageExcess(message) > 0 {
+				continue
+			}
+
+			if result.Shadow {
+				result.Edited++
+				result.Replacements += count
+				continue
+			}
+
+			original := post.Message
+			post.Message = message
+			if _, updateErr := p.updatePost(post); updateErr != nil {
+				p.reportError(updateErr, postContext("runBulkJob", post))
+				result.Failed++
+				continue
+			}
+
+			if err := p.recordRevision(post.Id, newRevision(job.RequesterID, job.Command, original, message)); err != nil {
+				p.reportError(err, postContext("runBulkJob", post))
+			}
+
+			result.Edited++
+			result.Replacements += count
+		}
+
+		if len(postList.Order) < bulkPageSize {
+			break
+		}
+	}
+
+	return result, nil
+}
+
+// runBulkJobAndNotify runs the job and reports its outcome to userID as an ephemeral post in the
+// job's channel. It is meant to be run in its own goroutine.
+func (p *Plugin) runBulkJobAndNotify(job *bulkJob, userID, summary string) {
+	defer p.recoverPanic(map[string]string{"hook": "runBulkJob", "user_id": userID, "channel_id": job.ChannelID})
+
+	result, err := p.runBulkJob(job)
+	if err != nil {
+		p.reportError(err, map[string]string{"hook": "ExecuteCommand", "user_id": userID, "channel_id": job.ChannelID})
+	}
+
+	message := fmt.Sprintf("%s: %d replacements in %d of %d posts inspected.", summary, result.Replacements, result.Edited, result.Scanned)
+	if result.Shadow {
+		message = fmt.Sprintf("Shadow mode is on, so no post was changed: %d replacements in %d of %d posts inspected would have been made.", result.Replacements, result.Edited, result.Scanned)
+	}
+	if result.Failed > 0 {
+		message += fmt.Sprintf(" %d posts could not be edited.", result.Failed)
+	}
+	if err != nil {
+		message += " The job stopped early because of an error."
+	}
+
+	p.API.SendEphemeralPost(userID, &model.Post{ChannelId: job.ChannelID, Message: message, CreateAt: model.GetMillis()})
+}
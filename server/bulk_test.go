@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/mattermost/mattermost-server/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestRunBulkJob(t *testing.T) {
+	api := &plugintest.API{}
+	defer api.AssertExpectations(t)
+
+	postList := model.NewPostList()
+	postList.AddPost(&model.Post{Id: "post1", UserId: "testUserId", Message: "hello :old:"})
+	postList.AddOrder("post1")
+	postList.AddPost(&model.Post{Id: "post2", UserId: "otherUserId", Message: "hi :old:"})
+	postList.AddOrder("post2")
+	postList.AddPost(&model.Post{Id: "post3", UserId: "testUserId", Message: "no emoji here"})
+	postList.AddOrder("post3")
+	postList.AddPost(&model.Post{Id: "post4", UserId: "testUserId", Message: "deleted :old:"})
+	postList.AddOrder("post4")
+
+	api.On("GetPostsForChannel", "testChannelId", 0, bulkPageSize).Return(postList, nil)
+	api.On("UpdatePost", mock.MatchedBy(func(post *model.Post) bool {
+		return post.Id == "post1" && post.Message == "hello :new:"
+	})).Return(nil, nil)
+	api.On("UpdatePost", mock.MatchedBy(func(post *model.Post) bool {
+		return post.Id == "post4"
+	})).Return(nil, model.NewAppError("UpdatePost", "deleted", nil, "", 400))
+	api.On("LogError", "Unexpected error", "error", mock.AnythingOfType("string")).Return()
+	api.On("KVGet", historyKey("post1")).Return(nil, nil)
+	api.On("KVSet", historyKey("post1"), mock.MatchedBy(func(data []byte) bool {
+		var history postHistory
+		return json.Unmarshal(data, &history) == nil && len(history.Revisions) == 1 &&
+			history.Revisions[0].Command == "/replace emoji :old: :new:" && history.Revisions[0].Original == "hello :old:"
+	})).Return(nil)
+
+	p := setupTestPlugin(t, api)
+
+	result, err := p.runBulkJob(&bulkJob{
+		ChannelID:   "testChannelId",
+		UserID:      "testUserId",
+		RequesterID: "testUserId",
+		Command:     "/replace emoji :old: :new:",
+		Rewrite: func(message string) (string, int) {
+			return replaceEmoji(message, "old", "new")
+		},
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, &bulkResult{Scanned: 4, Edited: 1, Replacements: 1, Failed: 1}, result)
+}
+
+func TestRunBulkJobShadowMode(t *testing.T) {
+	api := &plugintest.API{}
+	defer api.AssertExpectations(t)
+
+	postList := model.NewPostList()
+	postList.AddPost(&model.Post{Id: "post1", UserId: "testUserId", Message: "hello :old: :old:"})
+	postList.AddOrder("post1")
+
+	api.On("GetPostsForChannel", "testChannelId", 0, bulkPageSize).Return(postList, nil)
+	api.On("KVGet", shadowStatsKey).Return(nil, nil)
+	api.On("KVSet", shadowStatsKey, mock.MatchedBy(func(data []byte) bool {
+		var stats shadowStats
+		return json.Unmarshal(data, &stats) == nil && stats.Edited == 1 && stats.Replacements == 2
+	})).Return(nil)
+	api.On("SendEphemeralPost", "testUserId", mock.MatchedBy(func(post *model.Post) bool {
+		return post.Message == "Shadow mode is on, so no post was changed: 2 replacements in 1 of 1 posts inspected would have been made."
+	})).Return(nil)
+
+	p := setupTestPlugin(t, api)
+	p.setConfiguration(&configuration{ShadowMode: true})
+
+	p.runBulkJobAndNotify(&bulkJob{
+		ChannelID: "testChannelId",
+		UserID:    "testUserId",
+		Rewrite: func(message string) (string, int) {
+			return replaceEmoji(message, "old", "new")
+		},
+	}, "testUserId", "Rewrote `:old:` to `:new:`")
+
+	assert.Equal(t, "hello :old: :old:", postList.Posts["post1"].Message)
+}
+
+func TestExecuteReplaceAllCommand(t *testing.T) {
+	args := &model.CommandArgs{UserId: "testUserId", TeamId: "testTeamId", ChannelId: "testChannelId"}
+
+	t.Run("rewrites my posts", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		p := setupTestPlugin(t, api)
+
+		postList := model.NewPostList()
+		postList.AddPost(&model.Post{Id: "post1", UserId: "testUserId", Message: "Project Apollo ships"})
+		postList.AddOrder("post1")
+		postList.AddPost(&model.Post{Id: "post2", UserId: "otherUserId", Message: "Apollo rocks"})
+		postList.AddOrder("post2")
+
+		done := make(chan struct{})
+		api.On("GetChannel", "testChannelId").Return(&model.Channel{Id: "testChannelId", Type: model.CHANNEL_OPEN}, nil)
+		api.On("KVGet", channelCorrectionKey("testChannelId")).Return(nil, nil)
+		api.On("KVGet", preferencesKey("testUserId")).Return(nil, nil)
+		api.On("GetPostsForChannel", "testChannelId", 0, bulkPageSize).Return(postList, nil)
+		api.On("UpdatePost", mock.MatchedBy(func(post *model.Post) bool {
+			return post.Id == "post1" && post.Message == "Project Artemis ships"
+		})).Return(nil, nil)
+		api.On("KVGet", historyKey("post1")).Return(nil, nil)
+		api.On("KVSet", historyKey("post1"), mock.MatchedBy(func(data []byte) bool {
+			var history postHistory
+			return json.Unmarshal(data, &history) == nil && history.Revisions[0].Command == "s/Apollo/Artemis/"
+		})).Return(nil)
+		api.On("SendEphemeralPost", "testUserId", mock.MatchedBy(func(post *model.Post) bool {
+			return post.Message == "Applied `s/Apollo/Artemis/`: 1 replacements in 1 of 2 posts inspected."
+		})).Return(nil).Run(func(mock.Arguments) { close(done) })
+
+		args.Command = "/replace-all s/Apollo/Artemis/"
+		response, appErr := p.ExecuteCommand(nil, args)
+
+		assert.Nil(t, appErr)
+		assert.Contains(t, response.Text, "You will be notified when done.")
+		<-done
+	})
+
+	t.Run("shadow mode", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		p := setupTestPlugin(t, api)
+		p.setConfiguration(&configuration{ShadowMode: true})
+
+		postList := model.NewPostList()
+		postList.AddPost(&model.Post{Id: "post1", UserId: "testUserId", Message: "Project Apollo ships"})
+		postList.AddOrder("post1")
+
+		done := make(chan struct{})
+		api.On("GetChannel", "testChannelId").Return(&model.Channel{Id: "testChannelId", Type: model.CHANNEL_OPEN}, nil)
+		api.On("KVGet", channelCorrectionKey("testChannelId")).Return(nil, nil)
+		api.On("KVGet", preferencesKey("testUserId")).Return(nil, nil)
+		api.On("GetPostsForChannel", "testChannelId", 0, bulkPageSize).Return(postList, nil)
+		api.On("KVGet", shadowStatsKey).Return(nil, nil)
+		api.On("KVSet", shadowStatsKey, mock.AnythingOfType("[]uint8")).Return(nil)
+		api.On("SendEphemeralPost", "testUserId", mock.MatchedBy(func(post *model.Post) bool {
+			return strings.HasPrefix(post.Message, "Shadow mode is on, so no post was changed")
+		})).Return(nil).Run(func(mock.Arguments) { close(done) })
+
+		args.Command = "/replace-all s/Apollo/Artemis/"
+		_, appErr := p.ExecuteCommand(nil, args)
+
+		assert.Nil(t, appErr)
+		<-done
+		assert.Equal(t, "Project Apollo ships", postList.Posts["post1"].Message)
+	})
+
+	t.Run("selectors", func(t *testing.T) {
+		p := setupTestPlugin(t, &plugintest.API{})
+
+		args.Command = "/replace-all s/Apollo/Artemis/ in:town-square"
+		response, appErr := p.ExecuteCommand(nil, args)
+
+		assert.Nil(t, appErr)
+		assert.Contains(t, response.Text, "takes no selectors")
+	})
+
+	t.Run("quote mode", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		p := setupTestPlugin(t, api)
+
+		api.On("GetChannel", "testChannelId").Return(&model.Channel{Id: "testChannelId", Type: model.CHANNEL_OPEN}, nil)
+		api.On("KVGet", channelCorrectionKey("testChannelId")).Return([]byte(correctionQuote), nil)
+
+		args.Command = "/replace-all s/Apollo/Artemis/"
+		response, appErr := p.ExecuteCommand(nil, args)
+
+		assert.Nil(t, appErr)
+		assert.Contains(t, response.Text, "cannot be rewritten in bulk")
+	})
+}
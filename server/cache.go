@@ -0,0 +1,223 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	// maxCacheEntries caps the size of every ttlCache; a full cache is emptied before inserting.
+	maxCacheEntries = 1000
+	// entityCacheTTL is how long users and channels fetched from the server are reused.
+	entityCacheTTL = time.Minute
+)
+
+type cacheEntry struct {
+	value     interface{}
+	expiresAt time.Time
+}
+
+// ttlCache is a small, size-bounded cache whose entries expire. The zero value is ready to use.
+type ttlCache struct {
+	lock    sync.Mutex
+	entries map[string]cacheEntry
+}
+
+func (c *ttlCache) get(key string) (interface{}, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || time.Now().After(entry.expiresAt) {
+		return nil, false
+	}
+
+	return entry.value, true
+}
+
+func (c *ttlCache) set(key string, value interface{}, ttl time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.entries == nil || len(c.entries) >= maxCacheEntries {
+		c.entries = make(map[string]cacheEntry)
+	}
+
+	c.entries[key] = cacheEntry{value: value, expiresAt: time.Now().Add(ttl)}
+}
+
+func (c *ttlCache) delete(key string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	delete(c.entries, key)
+}
+
+// keys returns the keys of the entries that have not expired.
+func (c *ttlCache) keys() []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := time.Now()
+	keys := make([]string, 0, len(c.entries))
+	for key, entry := range c.entries {
+		if !now.After(entry.expiresAt) {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+// clear empties the cache and returns the number of entries dropped.
+func (c *ttlCache) clear() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	count := len(c.entries)
+	c.entries = nil
+
+	return count
+}
+
+// channelSettingKeys are the KV keys of the settings channel admins choose, whose channels are
+// loaded by rebuildCaches.
+var channelSettingKeys = []func(channelID string) string{channelNotificationKey, channelCorrectionKey, channelConfirmKey}
+
+// rebuildCaches drops every in-memory cache and loads users and channels afresh, for example
+// after restoring from a backup or when the caches are suspected to be stale. Besides those that
+// were cached, the users who last edited a post with s/ and the channels with settings of their
+// own are found in the KV store and loaded ahead of use. Spellcheck answers are only dropped. It
+// returns the number of entries dropped and of users and channels loaded.
+func (p *Plugin) rebuildCaches() (int, int, error) {
+	userIDs := p.userCache.keys()
+	channelIDs := p.channelCache.keys()
+	dropped := p.userCache.clear() + p.channelCache.clear() + p.spellcheckCache.clear()
+
+	for page := 0; ; page++ {
+		keys, appErr := p.API.KVList(page, kvListPageSize)
+		if appErr != nil {
+			return dropped, 0, errors.Wrap(appErr, "failed to list keys")
+		}
+
+		for _, key := range keys {
+			if strings.HasPrefix(key, lastEditKey("")) {
+				userIDs = append(userIDs, strings.TrimPrefix(key, lastEditKey("")))
+			}
+			for _, settingKey := range channelSettingKeys {
+				if strings.HasPrefix(key, settingKey("")) {
+					channelIDs = append(channelIDs, strings.TrimPrefix(key, settingKey("")))
+				}
+			}
+		}
+
+		if len(keys) < kvListPageSize {
+			break
+		}
+	}
+
+	loaded := warmCache(&p.userCache, userIDs, func(id string) bool {
+		_, appErr := p.getUser(id)
+		return appErr == nil
+	})
+	loaded += warmCache(&p.channelCache, channelIDs, func(id string) bool {
+		_, appErr := p.getChannel(id)
+		return appErr == nil
+	})
+
+	return dropped, loaded, nil
+}
+
+// warmCache loads each of ids once with load until cache is full, the first ids taking precedence,
+// and returns how many were loaded. Entries that fail to load, such as deleted channels, are
+// skipped.
+func warmCache(cache *ttlCache, ids []string, load func(id string) bool) int {
+	seen := make(map[string]bool, len(ids))
+	loaded := 0
+	for _, id := range ids {
+		if seen[id] || len(seen) >= maxCacheEntries {
+			continue
+		}
+		seen[id] = true
+
+		if load(id) {
+			loaded++
+		}
+	}
+
+	return loaded
+}
+
+// getUser returns the user, reusing a recently fetched copy when possible. The returned user
+// must not be modified.
+func (p *Plugin) getUser(userID string) (*model.User, *model.AppError) {
+	if user, ok := p.userCache.get(userID); ok {
+		return user.(*model.User), nil
+	}
+
+	user, appErr := p.API.GetUser(userID)
+	if appErr != nil {
+		return nil, appErr
+	}
+
+	p.userCache.set(userID, user, entityCacheTTL)
+
+	return user, nil
+}
+
+// getChannel returns the channel, reusing a recently fetched copy when possible. The returned
+// channel must not be modified.
+func (p *Plugin) getChannel(channelID string) (*model.Channel, *model.AppError) {
+	if channel, ok := p.channelCache.get(channelID); ok {
+		return channel.(*model.Channel), nil
+	}
+
+	channel, appErr := p.API.GetChannel(channelID)
+	if appErr != nil {
+		return nil, appErr
+	}
+
+	p.channelCache.set(channelID, channel, entityCacheTTL)
+
+	return channel, nil
+}
+
+// notificationPool recycles the ephemeral posts sent in reply to commands, one of which is built
+// for every s/ message. The API serializes a post before sending it, so it may be reused as soon as
+// the call returns.
+var notificationPool = sync.Pool{
+	New: func() interface{} {
+		return &model.Post{}
+	},
+}
+
+// newNotification returns an empty ephemeral reply to post, to be handed back with
+// releaseNotification once sent. It must not be kept past the hook it is used in.
+func newNotification(post *model.Post) *model.Post {
+	notification := notificationPool.Get().(*model.Post)
+	*notification = model.Post{ChannelId: post.ChannelId, CreateAt: model.GetMillis(), RootId: post.RootId}
+
+	return notification
+}
+
+func releaseNotification(notification *model.Post) {
+	notificationPool.Put(notification)
+}
+
+// getFreshChannel fetches the channel from the server, bypassing the cache, and refreshes the
+// cached copy. It is meant for decisions that must not rest on stale data, such as whether the
+// channel is archived.
+func (p *Plugin) getFreshChannel(channelID string) (*model.Channel, *model.AppError) {
+	channel, appErr := p.API.GetChannel(channelID)
+	if appErr != nil {
+		return nil, appErr
+	}
+
+	p.channelCache.set(channelID, channel, entityCacheTTL)
+
+	return channel, nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/mattermost/mattermost-server/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTTLCache(t *testing.T) {
+	var cache ttlCache
+
+	_, ok := cache.get("key")
+	assert.False(t, ok)
+
+	cache.set("key", "value", time.Minute)
+	value, ok := cache.get("key")
+	assert.True(t, ok)
+	assert.Equal(t, "value", value)
+
+	cache.set("expired", "value", -time.Second)
+	_, ok = cache.get("expired")
+	assert.False(t, ok)
+
+	cache.delete("key")
+	_, ok = cache.get("key")
+	assert.False(t, ok)
+
+	cache.set("key", "value", time.Minute)
+	assert.Equal(t, 2, cache.clear())
+	_, ok = cache.get("key")
+	assert.False(t, ok)
+}
+
+func TestExecuteCacheCommand(t *testing.T) {
+	args := &model.CommandArgs{UserId: "testUserId", Command: "/replace cache rebuild"}
+
+	t.Run("system admin", func(t *testing.T) {
+		api := &plugintest.API{}
+		p := setupTestPlugin(t, api)
+		p.userCache.set("testUserId", &model.User{Username: "stale"}, time.Minute)
+		p.channelCache.set("testChannelId", &model.Channel{Name: "stale"}, time.Minute)
+		p.spellcheckCache.set("teh", []string{"the"}, time.Minute)
+
+		api.On("HasPermissionTo", "testUserId", model.PERMISSION_MANAGE_SYSTEM).Return(true)
+		api.On("KVList", 0, kvListPageSize).Return([]string{lastEditKey("otherUserId"), channelCorrectionKey("testChannelId"), channelConfirmKey("deletedChannelId"), preferencesKey("testUserId")}, nil)
+		api.On("GetUser", "testUserId").Return(&model.User{Id: "testUserId", Username: "fresh"}, nil).Once()
+		api.On("GetUser", "otherUserId").Return(&model.User{Id: "otherUserId"}, nil).Once()
+		api.On("GetChannel", "testChannelId").Return(&model.Channel{Id: "testChannelId", Name: "fresh"}, nil).Once()
+		api.On("GetChannel", "deletedChannelId").Return(nil, &model.AppError{Message: "not found"}).Once()
+
+		response, appErr := p.ExecuteCommand(nil, args)
+
+		assert.Nil(t, appErr)
+		assert.Equal(t, "Dropped 3 cached entries and loaded 3 users and channels from the server. Others are loaded as needed.", response.Text)
+
+		user, appErr := p.getUser("testUserId")
+		assert.Nil(t, appErr)
+		assert.Equal(t, "fresh", user.Username)
+		_, appErr = p.getUser("otherUserId")
+		assert.Nil(t, appErr)
+		channel, appErr := p.getChannel("testChannelId")
+		assert.Nil(t, appErr)
+		assert.Equal(t, "fresh", channel.Name)
+		_, ok := p.spellcheckCache.get("teh")
+		assert.False(t, ok)
+		api.AssertExpectations(t)
+	})
+
+	t.Run("regular user", func(t *testing.T) {
+		api := &plugintest.API{}
+		p := setupTestPlugin(t, api)
+		p.userCache.set("testUserId", &model.User{}, time.Minute)
+
+		api.On("HasPermissionTo", "testUserId", model.PERMISSION_MANAGE_SYSTEM).Return(false)
+
+		response, appErr := p.ExecuteCommand(nil, args)
+
+		assert.Nil(t, appErr)
+		assert.Contains(t, response.Text, "Only system admins")
+		_, ok := p.userCache.get("testUserId")
+		assert.True(t, ok)
+		api.AssertExpectations(t)
+	})
+}
+
+func TestGetUserAndChannelAreCached(t *testing.T) {
+	api := &plugintest.API{}
+	defer api.AssertExpectations(t)
+
+	p := setupTestPlugin(t, api)
+	api.On("GetUser", "testUserId").Return(&model.User{Id: "testUserId"}, nil).Once()
+	api.On("GetChannel", "testChannelId").Return(&model.Channel{Id: "testChannelId"}, nil).Once()
+
+	for i := 0; i < 3; i++ {
+		user, appErr := p.getUser("testUserId")
+		assert.Nil(t, appErr)
+		assert.Equal(t, "testUserId", user.Id)
+
+		channel, appErr := p.getChannel("testChannelId")
+		assert.Nil(t, appErr)
+		assert.Equal(t, "testChannelId", channel.Id)
+	}
+}
+
+func TestEditRejectionIgnoresCachedChannel(t *testing.T) {
+	api := &plugintest.API{}
+	defer api.AssertExpectations(t)
+
+	p := setupTestPlugin(t, api)
+	p.channelCache.set("testChannelId", &model.Channel{Id: "testChannelId", Type: model.CHANNEL_OPEN}, time.Minute)
+	api.On("GetChannel", "testChannelId").Return(&model.Channel{Id: "testChannelId", Type: model.CHANNEL_OPEN, DeleteAt: 1}, nil)
+
+	refusal, appErr := p.editRejection(&model.Post{ChannelId: "testChannelId"}, "testUserId")
+	assert.Nil(t, appErr)
+	assert.Equal(t, archivedError, refusal)
+
+	channel, _ := p.getChannel("testChannelId")
+	assert.NotZero(t, channel.DeleteAt)
+}
+
+// The API calls of these benchmarks are answered by a mock. In a running plugin each uncached call
+// is a round trip to the server over RPC, so the savings there are larger still.
+func BenchmarkGetUser(b *testing.B) {
+	api := &plugintest.API{}
+	api.On("GetUser", "testUserId").Return(&model.User{Id: "testUserId"}, nil)
+	p := &Plugin{}
+	p.SetAPI(api)
+
+	b.Run("uncached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			p.API.GetUser("testUserId")
+		}
+	})
+
+	b.Run("cached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			p.getUser("testUserId")
+		}
+	})
+}
+
+func BenchmarkGetChannel(b *testing.B) {
+	api := &plugintest.API{}
+	api.On("GetChannel", "testChannelId").Return(&model.Channel{Id: "testChannelId"}, nil)
+	p := &Plugin{}
+	p.SetAPI(api)
+
+	b.Run("uncached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			p.API.GetChannel("testChannelId")
+		}
+	})
+
+	b.Run("cached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			p.getChannel("testChannelId")
+		}
+	})
+}
+
+func BenchmarkNotification(b *testing.B) {
+	post := &model.Post{ChannelId: "testChannelId", RootId: "testRootId"}
+
+	b.Run("allocated", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			notification := &model.Post{ChannelId: post.ChannelId, CreateAt: model.GetMillis(), RootId: post.RootId}
+			notification.Message = "s/ Replaced 1 occurrence"
+			sink = notification
+		}
+	})
+
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			notification := newNotification(post)
+			notification.Message = "s/ Replaced 1 occurrence"
+			sink = notification
+			releaseNotification(notification)
+		}
+	})
+}
+
+// sink keeps benchmarked values alive, so that the compiler cannot elide their allocation.
+var sink interface{}
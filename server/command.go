@@ -0,0 +1,462 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/mattermost/mattermost-server/plugin"
+
+	"github.com/carmo-evan/mattermost-plugin-replace/server/pkg/parser"
+	"github.com/carmo-evan/mattermost-plugin-replace/server/pkg/substitute"
+)
+
+// replaceAllTrigger applies an s/ command to every recent post of the user in a channel.
+const replaceAllTrigger = "replace-all"
+
+const (
+	commandTrigger string = "replace"
+	commandHelp    string = "###### Replace plugin commands\n" +
+		"* `/replace emoji :old_name: :new_name:` - Rewrite an emoji shortcode in your recent posts in this channel. Channel admins may append `channel` to rewrite everyone's posts.\n" +
+		"* `/replace build` - Fix a post step by step in a dialog, with a preview before applying.\n" +
+		"* `/replace diff <permalink>` - Show how a post differs from its original message.\n" +
+		"* `/replace explain s/old/new/flags` - Explain how a command is read, without applying it.\n" +
+		"* `/replace undo` - Restore the post you last edited to its message before the edit, as `s/undo` does.\n" +
+		"* `/replace settings [setting] [value]` - Show or change your personal settings.\n" +
+		"* `/replace channel notifications [style]` - Channel admins: choose how replacements in this channel are confirmed.\n" +
+		"* `/replace channel corrections [edit|quote]` - Channel admins: choose whether `s/` edits posts in this channel or posts a corrected quote.\n" +
+		"* `/replace channel confirm [on|off]` - Channel admins: require every edit in this channel to be confirmed with Apply and Cancel buttons.\n" +
+		"* `/replace cache rebuild` - System admins: reload the plugin caches from the server.\n" +
+		"* `/replace shadow [reset]` - System admins: show or reset what shadow mode would have changed.\n" +
+		"* `/replace team preview s/old/new/` - System admins: find and replace across the history of this team, after a dry run.\n" +
+		"* `/replace feedback <text>` - Send feedback or a bug report to the administrators.\n" +
+		"* `/replace-all s/old/new/flags` - Apply a command to all your recent posts in this channel.\n" +
+		"* `/replace help` - Show this help text."
+)
+
+func getCommand() *model.Command {
+	return &model.Command{
+		Trigger:          commandTrigger,
+		DisplayName:      "Replace",
+		Description:      "Helpers for the s/ command.",
+		AutoComplete:     true,
+		AutoCompleteDesc: "Available commands: emoji, build, diff, explain, undo, settings, channel, cache, shadow, team, feedback, help",
+		AutoCompleteHint: "[command]",
+	}
+}
+
+func getReplaceAllCommand() *model.Command {
+	return &model.Command{
+		Trigger:          replaceAllTrigger,
+		DisplayName:      "Replace all",
+		Description:      "Apply an s/ command to all your recent posts in this channel.",
+		AutoComplete:     true,
+		AutoCompleteDesc: "Apply an s/ command to all your recent posts in this channel, such as after a project rename.",
+		AutoCompleteHint: "s/old/new/flags",
+	}
+}
+
+func commandResponse(format string, args ...interface{}) *model.CommandResponse {
+	return &model.CommandResponse{
+		ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+		Text:         fmt.Sprintf(format, args...),
+	}
+}
+
+// subcommandText returns the raw text following the subcommand, with its inner spacing preserved.
+func subcommandText(command, subcommand string) string {
+	text := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(command), "/"+commandTrigger))
+	return strings.TrimSpace(strings.TrimPrefix(text, subcommand))
+}
+
+// ExecuteCommand dispatches /replace-all and the /replace subcommands.
+func (p *Plugin) ExecuteCommand(c *plugin.Context, args *model.CommandArgs) (response *model.CommandResponse, _ *model.AppError) {
+	defer func() {
+		if r := recover(); r != nil {
+			p.reportPanic(r, map[string]string{"hook": "ExecuteCommand", "user_id": args.UserId, "channel_id": args.ChannelId})
+			response = commandResponse("The command failed unexpectedly.")
+		}
+	}()
+
+	fields := strings.Fields(args.Command)
+	if len(fields) > 0 && fields[0] == "/"+replaceAllTrigger {
+		text := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(args.Command), fields[0]))
+		return p.executeReplaceAllCommand(args, text), nil
+	}
+
+	if len(fields) < 2 {
+		return commandResponse(commandHelp), nil
+	}
+
+	switch fields[1] {
+	case "emoji":
+		return p.executeEmojiCommand(args, fields[2:]), nil
+	case "build":
+		return p.executeBuildCommand(args), nil
+	case "diff":
+		return p.executeDiffCommand(args, fields[2:]), nil
+	case "explain":
+		return commandResponse(p.explainCommand(args.UserId, args.TeamId, subcommandText(args.Command, fields[1]))), nil
+	case "settings":
+		return p.executeSettingsCommand(args, fields[2:]), nil
+	case "channel":
+		return p.executeChannelCommand(args, fields[2:]), nil
+	case "cache":
+		return p.executeCacheCommand(args, fields[2:]), nil
+	case "shadow":
+		return p.executeShadowCommand(args, fields[2:]), nil
+	case "team":
+		return p.executeTeamCommand(args, fields[2:]), nil
+	case "feedback":
+		return p.executeFeedbackCommand(args, subcommandText(args.Command, fields[1])), nil
+	case "undo":
+		return p.executeUndoCommand(args), nil
+	case "help":
+		return commandResponse(commandHelp), nil
+	default:
+		return commandResponse("Unknown command `%s`.\n\n%s", fields[1], commandHelp), nil
+	}
+}
+
+// executeEmojiCommand starts a bulk job rewriting an emoji shortcode after a custom emoji has
+// been renamed.
+func (p *Plugin) executeEmojiCommand(args *model.CommandArgs, params []string) *model.CommandResponse {
+	if len(params) < 2 || len(params) > 3 {
+		return commandResponse("Usage: `/replace emoji :old_name: :new_name: [channel]`")
+	}
+
+	oldName, newName := trimEmojiName(params[0]), trimEmojiName(params[1])
+	if !isValidEmojiName(oldName) || !isValidEmojiName(newName) {
+		return commandResponse("Emoji names may only contain letters, numbers, `_`, `+` and `-`.")
+	}
+
+	if p.getConfiguration().PublicChannelsOnly {
+		channel, appErr := p.getChannel(args.ChannelId)
+		if appErr != nil {
+			p.reportError(appErr, map[string]string{"hook": "ExecuteCommand", "user_id": args.UserId, "channel_id": args.ChannelId})
+			return commandResponse("Failed to look up this channel.")
+		}
+
+		if !p.isChannelAllowed(channel) {
+			return commandResponse("Editing posts is only enabled in public channels.")
+		}
+	}
+
+	job := &bulkJob{
+		ChannelID:   args.ChannelId,
+		UserID:      args.UserId,
+		RequesterID: args.UserId,
+		Command:     strings.TrimSpace(args.Command),
+		Rewrite: func(message string) (string, int) {
+			return replaceEmoji(message, oldName, newName)
+		},
+	}
+
+	scope := "your recent posts"
+	if len(params) == 3 {
+		if params[2] != "channel" {
+			return commandResponse("Unknown scope `%s`. The only supported scope is `channel`.", params[2])
+		}
+
+		if !p.getConfiguration().limits().AllowModerator {
+			return commandResponse("Rewriting other users' posts is disabled on this server.")
+		}
+
+		if !p.API.HasPermissionToChannel(args.UserId, args.ChannelId, model.PERMISSION_EDIT_OTHERS_POSTS) {
+			return commandResponse("Only users allowed to edit others' posts may rewrite emoji for the whole channel.")
+		}
+
+		job.UserID = ""
+		scope = "the recent posts of this channel"
+	}
+
+	go p.runBulkJobAndNotify(job, args.UserId, fmt.Sprintf("Rewrote `:%s:` to `:%s:`", oldName, newName))
+
+	return commandResponse("Rewriting `:%s:` to `:%s:` in %s. You will be notified when done.", oldName, newName, scope)
+}
+
+// executeReplaceAllCommand starts a bulk job applying text, an s/ command or several separated by
+// semicolons, to the recent posts of the user in the channel.
+func (p *Plugin) executeReplaceAllCommand(args *model.CommandArgs, text string) *model.CommandResponse {
+	if text == "" {
+		return commandResponse("Usage: `/replace-all s/old/new/flags`")
+	}
+
+	cmds, err := parser.ParseScript(text)
+	var subs []*substitute.Substitution
+	if err == nil {
+		subs, err = substitute.FromScript(cmds)
+	}
+	if err == nil {
+		err = p.getConfiguration().applyScriptDefaults(subs, args.TeamId, args.UserId)
+	}
+	if err != nil {
+		return commandResponse("`%s` is not a valid command: %s.", text, err.Error())
+	}
+
+	last := cmds[len(cmds)-1]
+	if len(last.Scopes) > 0 || last.Rank > 0 {
+		return commandResponse("`/replace-all` always applies to your posts in this channel and takes no selectors or `~n`.")
+	}
+
+	// Explaining is the point of the e flag, so it must not start a rewrite.
+	if explainsScript(subs) {
+		return commandResponse("Use `/replace explain` to see how a command is read.")
+	}
+	if rewritesAttachments(subs) {
+		return commandResponse("`/replace-all` only rewrites the text of posts and does not take the `a` flag.")
+	}
+	if previewsScript(subs) {
+		return commandResponse("`/replace-all` cannot be previewed, so it does not take the `n` flag. Try the command on your last post with `n` first.")
+	}
+
+	context := map[string]string{"hook": "ExecuteCommand", "user_id": args.UserId, "channel_id": args.ChannelId}
+
+	channel, appErr := p.getChannel(args.ChannelId)
+	if appErr != nil {
+		p.reportError(appErr, context)
+		return commandResponse("Failed to look up this channel.")
+	}
+
+	if !p.isChannelAllowed(channel) {
+		return commandResponse("Editing posts is only enabled in public channels.")
+	}
+
+	mode, err := p.getChannelCorrectionMode(args.ChannelId)
+	if err != nil {
+		p.reportError(err, context)
+		return commandResponse("Failed to load the channel settings.")
+	}
+	if mode == correctionQuote {
+		return commandResponse("Posts in this channel are corrected with a quote and cannot be rewritten in bulk.")
+	}
+
+	prefs, err := p.getUserPreferences(args.UserId)
+	if err != nil {
+		p.reportError(err, context)
+		return commandResponse("Failed to load your settings.")
+	}
+	for _, sub := range subs {
+		prefs.apply(sub)
+	}
+
+	job := &bulkJob{
+		ChannelID:   args.ChannelId,
+		UserID:      args.UserId,
+		RequesterID: args.UserId,
+		Command:     text,
+		Rewrite: func(message string) (string, int) {
+			result, _ := applyScript(subs, message)
+			return result.Message, result.Replacements
+		},
+	}
+
+	go p.runBulkJobAndNotify(job, args.UserId, fmt.Sprintf("Applied `%s`", text))
+
+	return commandResponse("Applying `%s` to your recent posts in this channel. You will be notified when done.", text)
+}
+
+// executeSettingsCommand shows or changes the calling user's preferences.
+func (p *Plugin) executeSettingsCommand(args *model.CommandArgs, params []string) *model.CommandResponse {
+	prefs, err := p.getUserPreferences(args.UserId)
+	if err != nil {
+		p.reportError(err, map[string]string{"hook": "ExecuteCommand", "user_id": args.UserId})
+		return commandResponse("Failed to load your settings.")
+	}
+
+	if len(params) == 0 {
+		text := "###### Your settings"
+		for _, setting := range userSettings {
+			text += fmt.Sprintf("\n* `%s`: %s - %s", setting.Name, setting.Get(prefs), setting.Description)
+		}
+		return commandResponse("%s", text)
+	}
+
+	if len(params) != 2 {
+		return commandResponse("Usage: `/replace settings [setting] [value]`")
+	}
+
+	var setting *userSetting
+	for i := range userSettings {
+		if userSettings[i].Name == params[0] {
+			setting = &userSettings[i]
+		}
+	}
+
+	if setting == nil {
+		return commandResponse("Unknown setting `%s`.", params[0])
+	}
+
+	if !setting.accepts(params[1]) {
+		return commandResponse("Setting `%s` accepts `%s`.", setting.Name, strings.Join(setting.Values, "`, `"))
+	}
+
+	setting.Set(prefs, params[1])
+
+	if err := p.saveUserPreferences(args.UserId, prefs); err != nil {
+		p.reportError(err, map[string]string{"hook": "ExecuteCommand", "user_id": args.UserId})
+		return commandResponse("Failed to save your settings.")
+	}
+
+	return commandResponse("Setting `%s` is now %s.", setting.Name, setting.Get(prefs))
+}
+
+// channelSetting describes a setting of a channel that channel admins may change with /replace
+// channel. An empty value is the default.
+type channelSetting struct {
+	Name string
+
+	// Noun names a value in error messages, and Values lists the accepted ones besides default.
+	Noun   string
+	Values []string
+
+	Get func(p *Plugin, channelID string) (string, error)
+	Set func(p *Plugin, channelID, value string) error
+
+	// Current and Changed format the replies showing and changing the value.
+	Current string
+	Changed string
+}
+
+// accepts reports whether value, other than default, is valid for the setting.
+func (setting channelSetting) accepts(value string) bool {
+	for _, v := range setting.Values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// channelSettings lists the settings of /replace channel.
+var channelSettings = []channelSetting{
+	{
+		Name:    "notifications",
+		Noun:    "style",
+		Values:  notificationStyles,
+		Get:     (*Plugin).getChannelNotificationStyle,
+		Set:     (*Plugin).setChannelNotificationStyle,
+		Current: "Replacements in this channel are confirmed with style `%s`.",
+		Changed: "Replacements in this channel are now confirmed with style `%s`.",
+	},
+	{
+		Name:    "corrections",
+		Noun:    "mode",
+		Values:  correctionModes,
+		Get:     (*Plugin).getChannelCorrectionMode,
+		Set:     (*Plugin).setChannelCorrectionMode,
+		Current: "`s/` commands in this channel are applied with mode `%s`.",
+		Changed: "`s/` commands in this channel are now applied with mode `%s`.",
+	},
+	{
+		Name:    "confirm",
+		Noun:    "mode",
+		Values:  confirmModes,
+		Get:     (*Plugin).getChannelConfirmMode,
+		Set:     (*Plugin).setChannelConfirmMode,
+		Current: "Confirmation of edits in this channel is `%s`.",
+		Changed: "Confirmation of edits in this channel is now `%s`.",
+	},
+}
+
+// channelUsage returns the usage of /replace channel.
+func channelUsage() string {
+	usages := make([]string, 0, len(channelSettings))
+	for _, setting := range channelSettings {
+		usages = append(usages, fmt.Sprintf("`/replace channel %s [%s|default]`", setting.Name, strings.Join(setting.Values, "|")))
+	}
+
+	return "Usage: " + strings.Join(usages, " or ")
+}
+
+// executeChannelCommand shows or changes the settings of the current channel, which only channel
+// admins may change.
+func (p *Plugin) executeChannelCommand(args *model.CommandArgs, params []string) *model.CommandResponse {
+	if len(params) == 0 || len(params) > 2 {
+		return commandResponse("%s", channelUsage())
+	}
+
+	var setting *channelSetting
+	for i := range channelSettings {
+		if channelSettings[i].Name == params[0] {
+			setting = &channelSettings[i]
+		}
+	}
+	if setting == nil {
+		return commandResponse("%s", channelUsage())
+	}
+
+	context := map[string]string{"hook": "ExecuteCommand", "user_id": args.UserId, "channel_id": args.ChannelId}
+
+	value, err := setting.Get(p, args.ChannelId)
+	if err != nil {
+		p.reportError(err, context)
+		return commandResponse("Failed to load the channel settings.")
+	}
+
+	if len(params) == 1 {
+		if value == "" {
+			value = "default"
+		}
+		return commandResponse(setting.Current, value)
+	}
+
+	value = params[1]
+	if value != "default" && !setting.accepts(value) {
+		return commandResponse("Unknown %s `%s`. Choose one of `%s` or `default`.", setting.Noun, value, strings.Join(setting.Values, "`, `"))
+	}
+
+	channel, appErr := p.getChannel(args.ChannelId)
+	if appErr != nil {
+		p.reportError(appErr, context)
+		return commandResponse("Failed to look up this channel.")
+	}
+
+	permission := model.PERMISSION_MANAGE_PUBLIC_CHANNEL_PROPERTIES
+	if channel.Type != model.CHANNEL_OPEN {
+		permission = model.PERMISSION_MANAGE_PRIVATE_CHANNEL_PROPERTIES
+	}
+
+	if !p.API.HasPermissionToChannel(args.UserId, args.ChannelId, permission) {
+		return commandResponse("Only channel admins may change the channel settings.")
+	}
+
+	if value == "default" {
+		value = ""
+	}
+
+	if err := setting.Set(p, args.ChannelId, value); err != nil {
+		p.reportError(err, context)
+		return commandResponse("Failed to save the channel settings.")
+	}
+
+	return commandResponse(setting.Changed, params[1])
+}
+
+// executeCacheCommand lets system admins rebuild the plugin caches.
+func (p *Plugin) executeCacheCommand(args *model.CommandArgs, params []string) *model.CommandResponse {
+	if len(params) != 1 || params[0] != "rebuild" {
+		return commandResponse("Usage: `/replace cache rebuild`")
+	}
+
+	if !p.API.HasPermissionTo(args.UserId, model.PERMISSION_MANAGE_SYSTEM) {
+		return commandResponse("Only system admins may rebuild the caches.")
+	}
+
+	dropped, loaded, err := p.rebuildCaches()
+	if err != nil {
+		p.reportError(err, map[string]string{"hook": "ExecuteCommand", "user_id": args.UserId})
+		return commandResponse("Dropped %d cached entries, but failed to load them again. They will be reloaded from the server as needed.", dropped)
+	}
+
+	return commandResponse("Dropped %d cached entries and loaded %d users and channels from the server. Others are loaded as needed.", dropped, loaded)
+}
+
+func onOff(enabled bool) string {
+	if enabled {
+		return "on"
+	}
+
+	return "off"
+}
@@ -18,6 +18,108 @@ import (
 // If you add non-reference types to your configuration struct, be sure to rewrite Clone as a deep
 // copy appropriate for your types.
 type configuration struct {
+	// ErrorReportingURL is an optional webhook that receives reports of unexpected errors.
+	ErrorReportingURL string
+
+	// EnableJoinHint sends users a one-time usage hint when they join a channel.
+	EnableJoinHint bool
+
+	// JoinHintIntervalHours is the minimum time between two hints to the same user. Zero means
+	// the hint is only ever sent once.
+	JoinHintIntervalHours int
+
+	// SpellcheckURL is the base URL of an optional LanguageTool compatible service used to
+	// suggest corrections for s/word commands.
+	SpellcheckURL string
+
+	// SpellcheckLanguage is the language code sent to the spellcheck service.
+	SpellcheckLanguage string
+
+	// PublicChannelsOnly refuses edits in private channels and direct or group messages.
+	PublicChannelsOnly bool
+
+	// NotificationStyle is the default confirmation style for a replacement: ephemeral, public or
+	// none. Users and channel admins may override it.
+	NotificationStyle string
+
+	// RejectionMode is how a refused s/ command is reported: as an ephemeral post, or as the
+	// rejection reason returned to the client.
+	RejectionMode string
+
+	// FeedbackChannelID is the channel receiving /replace feedback messages. Feedback is disabled
+	// when empty.
+	FeedbackChannelID string
+
+	// ShadowMode records what s/ commands would change without editing any post.
+	ShadowMode bool
+
+	// LimitsProfile selects the bundled limits enforced by the plugin: standard or restricted.
+	LimitsProfile string
+
+	// PatternMode is how patterns are matched when no flag says otherwise: regex for regular
+	// expressions matching whole words, or literal for plain text.
+	PatternMode string
+
+	// MatchInsideWords lets patterns match inside words by default, instead of whole words only.
+	// The w flag restores whole words for a single command.
+	MatchInsideWords bool
+
+	// ReplaceInLinks lets replacements change the targets of Markdown links and bare URLs, which
+	// are otherwise left alone so that only the visible text of links is edited.
+	ReplaceInLinks bool
+
+	// SkipHashtags leaves hashtags alone, so that broad replacements cannot change the tags posts
+	// are searched by.
+	SkipHashtags bool
+
+	// ThreadTarget is which post of a thread an s/ command typed in it edits when the command
+	// does not say: the latest reply of the user, or the root post.
+	ThreadTarget string
+
+	// ThreadFallback edits the last post of the user in the channel when an s/ command is typed
+	// in a thread they have not posted in.
+	ThreadFallback bool
+
+	// LookbackPosts caps how many of the recent posts of a user an s/ command may consider,
+	// whether selected with ~n or searched back for the pattern. Zero means no limit.
+	LookbackPosts int
+
+	// SearchBackPosts is how many recent posts of the user are searched for the pattern when
+	// their last post does not contain it. Zero or one only ever edits the last post.
+	SearchBackPosts int
+
+	// ConfirmAboveOccurrences and ConfirmAbovePercent make an s/ command wait for confirmation,
+	// as with the confirm setting, when it would replace more occurrences or change a larger
+	// share of the words of the post than they allow. Zero disables either check.
+	ConfirmAboveOccurrences int
+	ConfirmAbovePercent     int
+
+	// ModeratorRole is who may edit the posts of other users with the u: selector: system_admin
+	// or channel_admin, the latter including system admins.
+	ModeratorRole string
+
+	// FeatureFlags enables capabilities under gradual rollout, as read by parseFeatureFlags.
+	FeatureFlags string
+
+	// CommandAliases lists prefixes accepted in addition to s/, as read by parseCommandAliases.
+	CommandAliases string
+
+	// HistoryRetentionDays, AuditRetentionDays and StatsRetentionDays bound how long edit
+	// history, audit entries and statistics are kept. Zero keeps them forever.
+	HistoryRetentionDays int
+	AuditRetentionDays   int
+	StatsRetentionDays   int
+
+	// UndoWindowMinutes is how long after an edit s/undo may revert it. Zero means until the
+	// next edit.
+	UndoWindowMinutes int
+
+	// featureRules is computed from FeatureFlags and never modified afterwards, so clones may
+	// share it.
+	featureRules map[string]*featureRule
+
+	// aliases is computed from CommandAliases and never modified afterwards.
+	aliases []string
 }
 
 // Clone shallow copies the configuration. Your implementation may require a deep copy if
@@ -77,6 +179,18 @@ func (p *Plugin) OnConfigurationChange() error {
 		return errors.Wrap(err, "failed to load plugin configuration")
 	}
 
+	featureRules, err := parseFeatureFlags(configuration.FeatureFlags)
+	if err != nil {
+		return errors.Wrap(err, "failed to parse feature flags")
+	}
+	configuration.featureRules = featureRules
+
+	aliases, err := parseCommandAliases(configuration.CommandAliases)
+	if err != nil {
+		return errors.Wrap(err, "failed to parse command aliases")
+	}
+	configuration.aliases = aliases
+
 	p.setConfiguration(configuration)
 
 	return nil
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-server/model"
+
+	"github.com/carmo-evan/mattermost-plugin-replace/server/pkg/parser"
+	"github.com/carmo-evan/mattermost-plugin-replace/server/pkg/substitute"
+)
+
+const confirmPath string = "/api/v1/confirm"
+
+// Whether s/ commands in a channel wait for confirmation, chosen by its admins. The default leaves
+// it to each user's confirm setting.
+const (
+	confirmOn  string = "on"
+	confirmOff string = "off"
+)
+
+// confirmModes lists the valid confirmation modes of a channel.
+var confirmModes = []string{confirmOn, confirmOff}
+
+func channelConfirmKey(channelID string) string {
+	return "channel_confirm_" + channelID
+}
+
+// getChannelConfirmMode returns the confirmation mode of a channel, or the empty string if it
+// was never set.
+func (p *Plugin) getChannelConfirmMode(channelID string) (string, error) {
+	data, appErr := p.API.KVGet(channelConfirmKey(channelID))
+	if appErr != nil {
+		return "", errors.Wrap(appErr, "failed to get channel confirmation mode")
+	}
+
+	return string(data), nil
+}
+
+// setChannelConfirmMode stores the confirmation mode of a channel. An empty mode restores the
+// default.
+func (p *Plugin) setChannelConfirmMode(channelID, mode string) error {
+	var appErr *model.AppError
+	if mode == "" {
+		appErr = p.API.KVDelete(channelConfirmKey(channelID))
+	} else {
+		appErr = p.API.KVSet(channelConfirmKey(channelID), []byte(mode))
+	}
+
+	if appErr != nil {
+		return errors.Wrap(appErr, "failed to save channel confirmation mode")
+	}
+
+	return nil
+}
+
+// needsConfirmation reports whether an edit of a post in a channel must be confirmed first. A
+// channel setting wins over the user's preference.
+func (p *Plugin) needsConfirmation(channelID string, prefs *userPreferences) (bool, error) {
+	mode, err := p.getChannelConfirmMode(channelID)
+	if err != nil {
+		return false, err
+	}
+
+	switch mode {
+	case confirmOn:
+		return true, nil
+	case confirmOff:
+		return false, nil
+	}
+
+	return prefs.ConfirmEdits, nil
+}
+
+// largeEditReason returns why result is a large edit that must be confirmed first under the
+// ConfirmAboveOccurrences and ConfirmAbovePercent settings, or the empty string if it is not.
+func (c *configuration) largeEditReason(result *substitute.Result) string {
+	if c.ConfirmAboveOccurrences > 0 && result.Replacements > c.ConfirmAboveOccurrences {
+		return fmt.Sprintf("This command replaces %s.", occurrences(result.Replacements))
+	}
+
+	if c.ConfirmAbovePercent > 0 {
+		if percent := changedPercent(result.Original, result.Message); percent > c.ConfirmAbovePercent {
+			return fmt.Sprintf("This command changes %d%% of the words of the post.", percent)
+		}
+	}
+
+	return ""
+}
+
+// changedPercent returns the share of the words of before that are not kept in after, in
+// percent, words being separated by white space.
+func changedPercent(before, after string) int {
+	a := strings.Fields(before)
+	if len(a) == 0 {
+		return 0
+	}
+	b := strings.Fields(after)
+
+	// The words both texts start and end with are kept, and need no comparing.
+	start := 0
+	for start < len(a) && start < len(b) && a[start] == b[start] {
+		start++
+	}
+	end := 0
+	for end < len(a)-start && end < len(b)-start && a[len(a)-1-end] == b[len(b)-1-end] {
+		end++
+	}
+
+	// kept[j] is the length of the longest common subsequence of the words compared so far and
+	// the first j words of the middle of b.
+	middleA, middleB := a[start:len(a)-end], b[start:len(b)-end]
+	kept := make([]int, len(middleB)+1)
+	for _, word := range middleA {
+		diagonal := 0
+		for j := range middleB {
+			above := kept[j+1]
+			switch {
+			case word == middleB[j]:
+				kept[j+1] = diagonal + 1
+			case kept[j] > kept[j+1]:
+				kept[j+1] = kept[j]
+			}
+			diagonal = above
+		}
+	}
+
+	return (len(middleA) - kept[len(middleB)]) * 100 / len(a)
+}
+
+// confirmationRequest shows userID the result of command on post, in the channel and thread of
+// the command, with buttons to apply or cancel the edit. reason, if any, says why confirmation
+// is needed. The edit is made by handleConfirm, unless
+// the post was edited in the meantime.
+func (p *Plugin) confirmationRequest(command *model.Post, userID, teamID, script string, post *model.Post, result *substitute.Result, confirmation, reason string) *model.Post {
+	actionContext := map[string]interface{}{
+		"action":  "apply",
+		"post_id": post.Id,
+		"team_id": teamID,
+		"edit_at": strconv.FormatInt(post.EditAt, 10),
+		"command": script,
+	}
+	cancelContext := map[string]interface{}{"action": "cancel"}
+
+	request := &model.Post{
+		UserId:    userID,
+		ChannelId: command.ChannelId,
+		RootId:    command.RootId,
+		CreateAt:  model.GetMillis(),
+	}
+	request.AddProp("attachments", []*model.SlackAttachment{{
+		Pretext: strings.TrimSpace(fmt.Sprintf("%s Apply `%s` to [the post](%s)?", reason, script, p.permalink(teamID, post.Id))),
+		Title:   fmt.Sprintf("Preview: %s", occurrences(result.Replacements)),
+		Text:    quoteMessage(result.Message) + "\n" + confirmation,
+		Actions: []*model.PostAction{
+			{Name: "Apply", Integration: &model.PostActionIntegration{URL: p.pluginURL(confirmPath), Context: actionContext}},
+			{Name: "Cancel", Integration: &model.PostActionIntegration{URL: p.pluginURL(confirmPath), Context: cancelContext}},
+		},
+	}})
+
+	return request
+}
+
+// mayEdit reports whether userID may edit post with an s/ command, as its author, as the manager
+// of the bot that wrote it or as a moderator, and whether the edit is a moderator's.
+func (p *Plugin) mayEdit(userID string, post *model.Post) (allowed, moderated bool) {
+	if post.UserId == userID {
+		return true, false
+	}
+
+	author, appErr := p.getUser(post.UserId)
+	if appErr != nil {
+		return false, false
+	}
+
+	if author.IsBot {
+		bot, appErr := p.API.GetBot(author.Id, false)
+		if appErr != nil {
+			return false, false
+		}
+		return bot.OwnerId == userID || p.API.HasPermissionTo(userID, model.PERMISSION_MANAGE_OTHERS_BOTS), false
+	}
+
+	return p.canModerate(userID, post.ChannelId), true
+}
+
+// handleConfirm applies or cancels an edit shown by confirmationRequest. The command is applied
+// again to the post as stored, provided it was not changed since and may still be edited.
+func (p *Plugin) handleConfirm(w http.ResponseWriter, r *http.Request) {
+	var request model.PostActionIntegrationRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	userID := r.Header.Get("Mattermost-User-Id")
+	if request.UserId != userID {
+		writeJSONError(w, http.StatusForbidden, "user mismatch")
+		return
+	}
+
+	respond := func(message string) {
+		p.API.UpdateEphemeralPost(userID, &model.Post{Id: request.PostId, ChannelId: request.ChannelId, Message: message})
+		writeJSON(w, http.StatusOK, &model.PostActionIntegrationResponse{})
+	}
+
+	if action, _ := request.Context["action"].(string); action != "apply" {
+		respond("Replacement cancelled.")
+		return
+	}
+
+	postID, _ := request.Context["post_id"].(string)
+	teamID, _ := request.Context["team_id"].(string)
+	editAt, _ := request.Context["edit_at"].(string)
+	command, _ := request.Context["command"].(string)
+
+	context := map[string]string{"hook": "ServeHTTP", "user_id": userID, "post_id": postID}
+
+	post, appErr := p.API.GetPost(postID)
+	if appErr != nil || post.DeleteAt != 0 {
+		respond("`s/ Command: The post to edit no longer exists.`")
+		return
+	}
+
+	if strconv.FormatInt(post.EditAt, 10) != editAt {
+		respond("`s/ Command: The post changed since the preview. Send the command again.`")
+		return
+	}
+
+	allowed, moderated := p.mayEdit(userID, post)
+	if !allowed {
+		respond("`s/ Command: You may no longer edit that post.`")
+		return
+	}
+
+	refusal, appErr := p.editRejection(post, userID)
+	if appErr != nil {
+		p.reportError(appErr, context)
+		writeJSONError(w, http.StatusInternalServerError, "failed to get channel")
+		return
+	}
+	if refusal != "" {
+		respond(refusal)
+		return
+	}
+
+	cmds, err := parser.ParseScript(command)
+	var subs []*substitute.Substitution
+	if err == nil {
+		subs, err = substitute.FromScript(cmds)
+	}
+	if err == nil {
+		err = p.getConfiguration().applyScriptDefaults(subs, teamID, userID)
+	}
+	if err != nil {
+		respond(fmt.Sprintf("`s/ Command: The command is no longer valid: %s.`", err.Error()))
+		return
+	}
+
+	prefs, err := p.getUserPreferences(userID)
+	if err != nil {
+		p.reportError(err, context)
+		writeJSONError(w, http.StatusInternalServerError, "failed to load user preferences")
+		return
+	}
+	for _, sub := range subs {
+		prefs.apply(sub)
+	}
+
+	result, confirmation := applyScript(subs, post.Message)
+	attachments, attachmentReplacements := rewriteAttachments(subs, post)
+
+	if p.getConfiguration().ShadowMode {
+		p.recordShadow(shadowEdited, result.Replacements)
+		respond("Shadow mode is on, so the post was not changed.")
+		return
+	}
+
+	if result.Replacements+attachmentReplacements == 0 {
+		respond("The pattern was not found in the post, so it was left unchanged.")
+		return
+	}
+	if errMsg := lengthError(result.Message); errMsg != "" {
+		respond(errMsg)
+		return
+	}
+
+	post.Message = result.Message
+	if attachments != nil {
+		post.AddProp("attachments", attachments)
+		confirmation += "\n" + attachmentsConfirmation(attachmentReplacements)
+	}
+	if moderated {
+		post.AddProp(moderatorProp, userID)
+	}
+
+	p.editLock.Lock()
+	_, appErr = p.updatePost(post)
+	p.editLock.Unlock()
+	if appErr != nil {
+		p.reportError(appErr, context)
+		writeJSONError(w, http.StatusInternalServerError, "failed to update post")
+		return
+	}
+
+	if err := p.recordRevision(post.Id, newRevision(userID, command, result.Original, result.Message)); err != nil {
+		p.reportError(err, context)
+	} else if err := p.rememberEdit(userID, post.Id); err != nil {
+		p.reportError(err, context)
+	}
+
+	// The user is only needed for their time zone.
+	hint := ""
+	if config := p.getConfiguration(); config.undoWindow() > 0 {
+		if user, appErr := p.getUser(userID); appErr == nil {
+			hint = config.undoHint(user, time.Now())
+		}
+	}
+
+	respond(fmt.Sprintf("[The post](%s) was edited.\n%s%s", p.permalink(teamID, post.Id), confirmation, hint))
+}
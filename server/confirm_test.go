@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/mattermost/mattermost-server/plugin"
+	"github.com/mattermost/mattermost-server/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/carmo-evan/mattermost-plugin-replace/server/pkg/substitute"
+)
+
+func TestNeedsConfirmation(t *testing.T) {
+	for _, tc := range []struct {
+		mode     string
+		prefers  bool
+		expected bool
+	}{
+		{"", false, false},
+		{"", true, true},
+		{confirmOn, false, true},
+		{confirmOff, true, false},
+	} {
+		api := &plugintest.API{}
+		p := setupTestPlugin(t, api)
+
+		var data []byte
+		if tc.mode != "" {
+			data = []byte(tc.mode)
+		}
+		api.On("KVGet", channelConfirmKey("testChannelId")).Return(data, nil)
+
+		confirm, err := p.needsConfirmation("testChannelId", &userPreferences{ConfirmEdits: tc.prefers})
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, confirm, "mode %q, preference %v", tc.mode, tc.prefers)
+	}
+}
+
+func TestChangedPercent(t *testing.T) {
+	assert.Equal(t, 0, changedPercent("", "anything"))
+	assert.Equal(t, 0, changedPercent("the same text", "the same text"))
+	assert.Equal(t, 25, changedPercent("fix teh typo here", "fix the typo here"))
+	assert.Equal(t, 50, changedPercent("one two three four", "one 2 three 4"))
+	assert.Equal(t, 25, changedPercent("a b c d", "a c d"))
+	assert.Equal(t, 100, changedPercent("see the green tree", "s33 th3 gr33n tr33"))
+}
+
+func TestLargeEditReason(t *testing.T) {
+	result := &substitute.Result{Original: "see the green tree", Message: "s33 th3 gr33n tr33", Replacements: 7}
+
+	assert.Equal(t, "", (&configuration{}).largeEditReason(result))
+	assert.Equal(t, "", (&configuration{ConfirmAboveOccurrences: 7}).largeEditReason(result))
+	assert.Equal(t, "This command replaces 7 occurrences.", (&configuration{ConfirmAboveOccurrences: 6}).largeEditReason(result))
+	assert.Equal(t, "This command changes 100% of the words of the post.", (&configuration{ConfirmAbovePercent: 50}).largeEditReason(result))
+}
+
+func TestMessageWillBePostedConfirm(t *testing.T) {
+	api := &plugintest.API{}
+	p := setupTestPlugin(t, api)
+
+	lastPost := &model.Post{Id: "lastPostId", UserId: "testUserId", ChannelId: "testChannelId", Message: "teh cat", EditAt: 7}
+	api.On("GetUser", "testUserId").Return(&model.User{Id: "testUserId", Username: "test"}, nil)
+	api.On("GetChannel", "testChannelId").Return(&model.Channel{Id: "testChannelId", TeamId: "testTeamId"}, nil)
+	api.On("SearchPostsInTeam", "testTeamId", mock.AnythingOfType("[]*model.SearchParams")).Return([]*model.Post{lastPost}, nil)
+	api.On("HasPermissionToChannel", "testUserId", "testChannelId", model.PERMISSION_EDIT_POST).Return(true)
+	api.On("KVGet", preferencesKey("testUserId")).Return([]byte(`{"confirm_edits": true}`), nil)
+	api.On("KVGet", channelCorrectionKey("testChannelId")).Return(nil, nil)
+	api.On("KVGet", channelConfirmKey("testChannelId")).Return(nil, nil)
+	api.On("GetConfig").Return(&model.Config{})
+	api.On("GetTeam", "testTeamId").Return(&model.Team{Name: "engineering"}, nil)
+	api.On("SendEphemeralPost", "testUserId", mock.MatchedBy(func(request *model.Post) bool {
+		attachments := request.Attachments()
+		if len(attachments) != 1 || len(attachments[0].Actions) != 2 {
+			return false
+		}
+		apply := attachments[0].Actions[0].Integration
+		return attachments[0].Text == "> the cat\n"+`s/ Replaced 1 occurrence of "teh" with "the"` &&
+			apply.URL == "/plugins/"+manifest.Id+confirmPath &&
+			apply.Context["post_id"] == "lastPostId" && apply.Context["edit_at"] == "7" && apply.Context["command"] == "s/teh/the/"
+	})).Return(nil)
+
+	_, rejection := p.MessageWillBePosted(&plugin.Context{}, &model.Post{UserId: "testUserId", ChannelId: "testChannelId", Message: "s/teh/the/"})
+
+	assert.Equal(t, "plugin.message_will_be_posted.dismiss_post", rejection)
+	assert.Equal(t, "teh cat", lastPost.Message)
+	api.AssertExpectations(t)
+}
+
+func TestHandleConfirm(t *testing.T) {
+	request := func(action, editAt string) *http.Request {
+		body, _ := json.Marshal(model.PostActionIntegrationRequest{
+			UserId:    "testUserId",
+			ChannelId: "testChannelId",
+			PostId:    "requestId",
+			Context:   map[string]interface{}{"action": action, "post_id": "lastPostId", "team_id": "testTeamId", "edit_at": editAt, "command": "s/teh/the/"},
+		})
+		r := httptest.NewRequest(http.MethodPost, confirmPath, strings.NewReader(string(body)))
+		r.Header.Set("Mattermost-User-Id", "testUserId")
+		return r
+	}
+
+	t.Run("apply", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+
+		p := setupTestPlugin(t, api)
+		p.router = p.initializeAPI()
+
+		api.On("GetPost", "lastPostId").Return(&model.Post{Id: "lastPostId", UserId: "testUserId", ChannelId: "testChannelId", Message: "teh cat"}, nil)
+		api.On("GetChannel", "testChannelId").Return(&model.Channel{Id: "testChannelId", Type: model.CHANNEL_OPEN}, nil)
+		api.On("HasPermissionToChannel", "testUserId", "testChannelId", model.PERMISSION_EDIT_POST).Return(true)
+		api.On("KVGet", preferencesKey("testUserId")).Return(nil, nil)
+		api.On("UpdatePost", mock.MatchedBy(func(post *model.Post) bool {
+			return post.Message == "the cat"
+		})).Return(&model.Post{}, nil)
+		api.On("KVGet", historyKey("lastPostId")).Return(nil, nil)
+		api.On("KVSet", historyKey("lastPostId"), mock.AnythingOfType("[]uint8")).Return(nil)
+		api.On("KVSet", lastEditKey("testUserId"), []byte("lastPostId")).Return(nil)
+		api.On("KVSet", undoKey("testUserId"), []byte("lastPostId")).Return(nil)
+		api.On("GetConfig").Return(&model.Config{})
+		api.On("GetTeam", "testTeamId").Return(&model.Team{Name: "engineering"}, nil)
+		api.On("UpdateEphemeralPost", "testUserId", mock.MatchedBy(func(post *model.Post) bool {
+			return post.Id == "requestId" && post.Message == "[The post](/engineering/pl/lastPostId) was edited.\n"+`s/ Replaced 1 occurrence of "teh" with "the"`
+		})).Return(&model.Post{})
+
+		w := httptest.NewRecorder()
+		p.ServeHTTP(nil, w, request("apply", "0"))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+
+		p := setupTestPlugin(t, api)
+		p.router = p.initializeAPI()
+
+		api.On("UpdateEphemeralPost", "testUserId", mock.MatchedBy(func(post *model.Post) bool {
+			return post.Message == "Replacement cancelled."
+		})).Return(&model.Post{})
+
+		w := httptest.NewRecorder()
+		p.ServeHTTP(nil, w, request("cancel", "0"))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("edited since the preview", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+
+		p := setupTestPlugin(t, api)
+		p.router = p.initializeAPI()
+
+		api.On("GetPost", "lastPostId").Return(&model.Post{Id: "lastPostId", UserId: "testUserId", EditAt: 42, Message: "teh cat"}, nil)
+		api.On("UpdateEphemeralPost", "testUserId", mock.MatchedBy(func(post *model.Post) bool {
+			return strings.Contains(post.Message, "changed since the preview")
+		})).Return(&model.Post{})
+
+		w := httptest.NewRecorder()
+		p.ServeHTTP(nil, w, request("apply", "0"))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("post of another user", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+
+		p := setupTestPlugin(t, api)
+		p.router = p.initializeAPI()
+
+		api.On("GetPost", "lastPostId").Return(&model.Post{Id: "lastPostId", UserId: "otherUserId", ChannelId: "testChannelId", Message: "teh cat"}, nil)
+		api.On("GetUser", "otherUserId").Return(&model.User{Id: "otherUserId", Username: "other"}, nil)
+		api.On("HasPermissionTo", "testUserId", model.PERMISSION_MANAGE_SYSTEM).Return(false)
+		api.On("UpdateEphemeralPost", "testUserId", mock.MatchedBy(func(post *model.Post) bool {
+			return post.Message == "`s/ Command: You may no longer edit that post.`"
+		})).Return(&model.Post{})
+
+		w := httptest.NewRecorder()
+		p.ServeHTTP(nil, w, request("apply", "0"))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+}
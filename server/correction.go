@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-server/model"
+
+	"github.com/carmo-evan/mattermost-plugin-replace/server/pkg/parser"
+	"github.com/carmo-evan/mattermost-plugin-replace/server/pkg/substitute"
+)
+
+// addresseePattern matches a message addressed to a user in the IRC style, as in
+// "alice: s/teh/the/", capturing the username and the rest of the message.
+var addresseePattern = regexp.MustCompile(`(?is)^@?([a-z0-9._-]+):\s+(.+)$`)
+
+// correctionCommand splits message, trimmed of white space, into the user it is addressed to and
+// the s/ command following, rewritten as by canonicalCommand. The last return value reports
+// whether message is such a correction.
+func (c *configuration) correctionCommand(message string) (string, string, bool) {
+	match := addresseePattern.FindStringSubmatch(message)
+	if match == nil {
+		return "", "", false
+	}
+
+	command, isCommand := c.canonicalCommand(strings.TrimSpace(match[2]))
+	if !isCommand {
+		return "", "", false
+	}
+
+	return strings.ToLower(match[1]), command, true
+}
+
+// runCorrection applies command, an s/ command addressed to username in post, to the last post of
+// that user. Rather than editing it, which would need rights over the post, post is replaced by a
+// quote of the corrected text. The return values are those of MessageWillBePosted.
+func (p *Plugin) runCorrection(post *model.Post, username, command string) (*model.Post, string) {
+	config := p.getConfiguration()
+
+	// In shadow mode nothing is changed, including the post itself.
+	if config.ShadowMode {
+		return nil, ""
+	}
+
+	notification := newNotification(post)
+	defer releaseNotification(notification)
+
+	cmds, err := parser.ParseScript(command)
+	var subs []*substitute.Substitution
+	if err == nil {
+		subs, err = substitute.FromScript(cmds)
+	}
+	if err != nil {
+		return p.dismiss(post.UserId, notification, p.invalidCommandMessage(post.UserId, err))
+	}
+
+	p.usageShown.delete(post.UserId)
+
+	user, appErr := p.getUser(post.UserId)
+	if appErr != nil {
+		p.reportError(appErr, postContext("MessageWillBePosted", post))
+		return nil, ""
+	}
+
+	ch, appErr := p.getChannel(post.ChannelId)
+	if appErr != nil {
+		p.reportError(appErr, postContext("MessageWillBePosted", post))
+		return nil, ""
+	}
+
+	for i, sub := range subs {
+		if err := config.applyDefaults(sub, ch.TeamId, user.Id); err != nil {
+			return p.dismiss(post.UserId, notification, invalidPatternMessage(i, len(subs), err))
+		}
+	}
+
+	scopes := cmds[len(cmds)-1].Scopes
+	for _, key := range []string{"bot", "u"} {
+		if _, ok := scopes[key]; ok {
+			return p.dismiss(post.UserId, notification, "`s/ Command: A correction addressed to a user cannot be combined with bot: or u:.`")
+		}
+	}
+
+	target, errMsg := p.resolveTarget(user, ch, post.RootId, scopes)
+	if errMsg != "" {
+		return p.dismiss(post.UserId, notification, errMsg)
+	}
+	target.Rank = cmds[len(cmds)-1].Rank
+
+	author, appErr := p.API.GetUserByUsername(username)
+	if appErr != nil {
+		return p.dismiss(post.UserId, notification, fmt.Sprintf("`s/ Command: User %s not found.`", username))
+	}
+
+	corrected, errMsg := p.getLastPost(author, user.Id, target)
+	if errMsg != "" {
+		return p.dismiss(post.UserId, notification, errMsg)
+	}
+
+	prefs, err := p.getUserPreferences(user.Id)
+	if err != nil {
+		p.reportError(err, postContext("MessageWillBePosted", post))
+		return nil, ""
+	}
+
+	for _, sub := range subs {
+		prefs.apply(sub)
+	}
+	result, _ := applyScript(subs, corrected.Message)
+	if result.Replacements == 0 {
+		return p.dismiss(post.UserId, notification, fmt.Sprintf("`s/ Command: The last post of @%s does not contain the pattern.`", author.Username))
+	}
+
+	post.Message = correctionMessage(author.Username, p.permalink(target.TeamID, corrected.Id), result.Message)
+	if errMsg := lengthError(post.Message); errMsg != "" {
+		return p.dismiss(post.UserId, notification, errMsg)
+	}
+
+	// A dry run shows the correction to its author only.
+	if previewsScript(subs) {
+		notification.Message = "Preview of your correction, which was not posted:\n" + post.Message
+		p.API.SendEphemeralPost(post.UserId, notification)
+		return nil, "plugin.message_will_be_posted.dismiss_post"
+	}
+
+	return post, ""
+}
+
+// correctionMessage quotes message, the corrected text of the post of username at link.
+func correctionMessage(username, link, message string) string {
+	lines := strings.Split(message, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight("> "+line, " ")
+	}
+
+	return fmt.Sprintf("Correction to [@%s's post](%s):\n%s", username, link, strings.Join(lines, "\n"))
+}
+
+// How s/ commands are applied in a channel, chosen by its admins: by editing the post, or by
+// posting a quote of the corrected text so that the channel history is only ever added to.
+const (
+	correctionEdit  string = "edit"
+	correctionQuote string = "quote"
+)
+
+// correctionModes lists the valid correction modes.
+var correctionModes = []string{correctionEdit, correctionQuote}
+
+func channelCorrectionKey(channelID string) string {
+	return "channel_correction_" + channelID
+}
+
+// getChannelCorrectionMode returns the correction mode chosen by the admins of a channel, or an
+// empty string if they kept the default of editing posts.
+func (p *Plugin) getChannelCorrectionMode(channelID string) (string, error) {
+	data, appErr := p.API.KVGet(channelCorrectionKey(channelID))
+	if appErr != nil {
+		return "", errors.Wrap(appErr, "failed to get channel correction mode")
+	}
+
+	return string(data), nil
+}
+
+// setChannelCorrectionMode stores the correction mode of a channel. An empty mode restores the
+// default.
+func (p *Plugin) setChannelCorrectionMode(channelID, mode string) error {
+	var appErr *model.AppError
+	if mode == "" {
+		appErr = p.API.KVDelete(channelCorrectionKey(channelID))
+	} else {
+		appErr = p.API.KVSet(channelCorrectionKey(channelID), []byte(mode))
+	}
+
+	if appErr != nil {
+		return errors.Wrap(appErr, "failed to save channel correction mode")
+	}
+
+	return nil
+}
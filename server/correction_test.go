@@ -0,0 +1,137 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/mattermost/mattermost-server/plugin"
+	"github.com/mattermost/mattermost-server/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestCorrectionCommand(t *testing.T) {
+	config := &configuration{}
+
+	for _, tc := range []struct {
+		Message  string
+		Username string
+		Command  string
+		OK       bool
+	}{
+		{"alice: s/teh/the/", "alice", "s/teh/the/", true},
+		{"@Alice:  s|a/b|c|g", "alice", "s|a/b|c|g", true},
+		{"alice: thanks", "", "", false},
+		{"s/teh/the/", "", "", false},
+		{"alice:s/teh/the/", "", "", false},
+	} {
+		t.Run(tc.Message, func(t *testing.T) {
+			username, command, ok := config.correctionCommand(tc.Message)
+			assert.Equal(t, tc.OK, ok)
+			assert.Equal(t, tc.Username, username)
+			assert.Equal(t, tc.Command, command)
+		})
+	}
+}
+
+func TestCorrectionMessage(t *testing.T) {
+	assert.Equal(t, "Correction to [@alice's post](/team/pl/postId):\n> the first line\n>\n> the second",
+		correctionMessage("alice", "/team/pl/postId", "the first line\n\nthe second"))
+}
+
+func TestMessageWillBePostedCorrection(t *testing.T) {
+	channel := &model.Channel{Id: "testChannelId", TeamId: "testTeamId", Name: "town-square", Type: model.CHANNEL_OPEN}
+
+	setup := func(t *testing.T, quoted *model.Post) (*Plugin, *plugintest.API) {
+		api := &plugintest.API{}
+		p := setupTestPlugin(t, api)
+
+		api.On("GetUser", "testUserId").Return(&model.User{Id: "testUserId", Username: "test"}, nil)
+		api.On("GetChannel", "testChannelId").Return(channel, nil)
+		api.On("GetUserByUsername", "alice").Return(&model.User{Id: "aliceId", Username: "alice"}, nil)
+		api.On("SearchPostsInTeam", "testTeamId", mock.AnythingOfType("[]*model.SearchParams")).Return([]*model.Post{quoted}, nil)
+		api.On("HasPermissionToChannel", "testUserId", "testChannelId", model.PERMISSION_EDIT_POST).Return(true)
+		api.On("KVGet", preferencesKey("testUserId")).Return(nil, nil)
+
+		return p, api
+	}
+
+	t.Run("quoted", func(t *testing.T) {
+		quoted := &model.Post{Id: "quotedId", UserId: "aliceId", ChannelId: "testChannelId", Message: "teh build is green"}
+		p, api := setup(t, quoted)
+		api.On("GetConfig").Return(&model.Config{})
+		api.On("GetTeam", "testTeamId").Return(&model.Team{Name: "engineering"}, nil)
+
+		post := &model.Post{UserId: "testUserId", ChannelId: "testChannelId", Message: "alice: s/teh/the/"}
+		updated, rejection := p.MessageWillBePosted(&plugin.Context{}, post)
+
+		assert.Equal(t, "", rejection)
+		assert.Equal(t, "Correction to [@alice's post](/engineering/pl/quotedId):\n> the build is green", updated.Message)
+		assert.Equal(t, "teh build is green", quoted.Message)
+		api.AssertExpectations(t)
+	})
+
+	t.Run("pattern not found", func(t *testing.T) {
+		quoted := &model.Post{Id: "quotedId", UserId: "aliceId", ChannelId: "testChannelId", Message: "all good"}
+		p, api := setup(t, quoted)
+		api.On("SendEphemeralPost", "testUserId", mock.MatchedBy(func(notification *model.Post) bool {
+			return notification.Message == "`s/ Command: The last post of @alice does not contain the pattern.`"
+		})).Return(nil)
+
+		post := &model.Post{UserId: "testUserId", ChannelId: "testChannelId", Message: "alice: s/teh/the/"}
+		updated, rejection := p.MessageWillBePosted(&plugin.Context{}, post)
+
+		assert.Nil(t, updated)
+		assert.Equal(t, "plugin.message_will_be_posted.dismiss_post", rejection)
+		api.AssertExpectations(t)
+	})
+}
+
+func TestMessageWillBePostedQuoteMode(t *testing.T) {
+	api := &plugintest.API{}
+	p := setupTestPlugin(t, api)
+
+	lastPost := &model.Post{Id: "lastPostId", UserId: "testUserId", ChannelId: "testChannelId", Message: "teh plan"}
+	api.On("GetUser", "testUserId").Return(&model.User{Id: "testUserId", Username: "test"}, nil)
+	api.On("GetChannel", "testChannelId").Return(&model.Channel{Id: "testChannelId", TeamId: "testTeamId"}, nil)
+	api.On("SearchPostsInTeam", "testTeamId", mock.AnythingOfType("[]*model.SearchParams")).Return([]*model.Post{lastPost}, nil)
+	api.On("HasPermissionToChannel", "testUserId", "testChannelId", model.PERMISSION_EDIT_POST).Return(true)
+	api.On("KVGet", preferencesKey("testUserId")).Return(nil, nil)
+	api.On("KVGet", channelCorrectionKey("testChannelId")).Return([]byte(correctionQuote), nil)
+	api.On("GetConfig").Return(&model.Config{})
+	api.On("GetTeam", "testTeamId").Return(&model.Team{Name: "engineering"}, nil)
+
+	post := &model.Post{UserId: "testUserId", ChannelId: "testChannelId", Message: "s/teh/the/"}
+	updated, rejection := p.MessageWillBePosted(&plugin.Context{}, post)
+
+	assert.Equal(t, "", rejection)
+	assert.Equal(t, "Correction to [@test's post](/engineering/pl/lastPostId):\n> the plan", updated.Message)
+	assert.Equal(t, "teh plan", lastPost.Message)
+	api.AssertExpectations(t)
+}
+
+func TestExecuteChannelCorrectionsCommand(t *testing.T) {
+	api := &plugintest.API{}
+	p := setupTestPlugin(t, api)
+
+	api.On("KVGet", channelCorrectionKey("testChannelId")).Return(nil, nil)
+	api.On("GetChannel", "testChannelId").Return(&model.Channel{Id: "testChannelId", Type: model.CHANNEL_OPEN}, nil)
+	api.On("HasPermissionToChannel", "testUserId", "testChannelId", model.PERMISSION_MANAGE_PUBLIC_CHANNEL_PROPERTIES).Return(true)
+	api.On("KVSet", channelCorrectionKey("testChannelId"), []byte(correctionQuote)).Return(nil)
+
+	args := &model.CommandArgs{UserId: "testUserId", ChannelId: "testChannelId", Command: "/replace channel corrections quote"}
+	response, appErr := p.ExecuteCommand(nil, args)
+	assert.Nil(t, appErr)
+	assert.Equal(t, "`s/` commands in this channel are now applied with mode `quote`.", response.Text)
+
+	args.Command = "/replace channel corrections"
+	response, appErr = p.ExecuteCommand(nil, args)
+	assert.Nil(t, appErr)
+	assert.Equal(t, "`s/` commands in this channel are applied with mode `default`.", response.Text)
+
+	args.Command = "/replace channel corrections rewrite"
+	response, appErr = p.ExecuteCommand(nil, args)
+	assert.Nil(t, appErr)
+	assert.Equal(t, "Unknown mode `rewrite`. Choose one of `edit`, `quote` or `default`.", response.Text)
+	api.AssertExpectations(t)
+}
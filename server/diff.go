@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/model"
+)
+
+// diffLines compares two texts line by line and returns the lines of a unified style diff without
+// headers, prefixed with "-", "+" or " ".
+func diffLines(before, after string) []string {
+	a := strings.Split(before, "\n")
+	b := strings.Split(after, "\n")
+
+	// lengths[i][j] is the length of the longest common subsequence of a[i:] and b[j:].
+	lengths := make([][]int, len(a)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else if lengths[i+1][j] >= lengths[i][j+1] {
+				lengths[i][j] = lengths[i+1][j]
+			} else {
+				lengths[i][j] = lengths[i][j+1]
+			}
+		}
+	}
+
+	var lines []string
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			lines = append(lines, " "+a[i])
+			i++
+			j++
+		case lengths[i+1][j] >= lengths[i][j+1]:
+			lines = append(lines, "-"+a[i])
+			i++
+		default:
+			lines = append(lines, "+"+b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		lines = append(lines, "-"+a[i])
+	}
+	for ; j < len(b); j++ {
+		lines = append(lines, "+"+b[j])
+	}
+
+	return lines
+}
+
+// postIDFromReference extracts the post ID from a permalink such as
+// https://chat.example.com/team/pl/<id>, or returns the reference itself if it is an ID.
+func postIDFromReference(reference string) (string, bool) {
+	reference = strings.Trim(reference, "<>")
+	if model.IsValidId(reference) {
+		return reference, true
+	}
+
+	link, err := url.Parse(reference)
+	if err != nil {
+		return "", false
+	}
+
+	segments := strings.Split(strings.Trim(link.Path, "/"), "/")
+	if len(segments) < 2 || segments[len(segments)-2] != "pl" || !model.IsValidId(segments[len(segments)-1]) {
+		return "", false
+	}
+
+	return segments[len(segments)-1], true
+}
+
+// executeDiffCommand shows how a post differs from the original message the plugin has on record,
+// for auditing a specific message.
+func (p *Plugin) executeDiffCommand(args *model.CommandArgs, params []string) *model.CommandResponse {
+	if len(params) != 1 {
+		return commandResponse("Usage: `/replace diff <permalink>`")
+	}
+
+	postID, ok := postIDFromReference(params[0])
+	if !ok {
+		return commandResponse("`%s` is not a permalink or post ID.", params[0])
+	}
+
+	post, appErr := p.API.GetPost(postID)
+	if appErr != nil || !p.API.HasPermissionToChannel(args.UserId, post.ChannelId, model.PERMISSION_READ_CHANNEL) {
+		return commandResponse("Post not found.")
+	}
+
+	history, err := p.getPostHistory(postID)
+	if err != nil {
+		p.reportError(err, map[string]string{"hook": "ExecuteCommand", "user_id": args.UserId, "post_id": postID})
+		return commandResponse("Failed to load the history of the post.")
+	}
+
+	if len(history.Revisions) == 0 {
+		return commandResponse("The plugin has no record of editing this post.")
+	}
+
+	first := history.Revisions[0]
+	if contentHash(first.Original) != first.OriginalHash {
+		return commandResponse("The original message on record is corrupted.")
+	}
+
+	if first.Original == post.Message {
+		return commandResponse("The post matches its original message.")
+	}
+
+	return commandResponse("###### Changes since the original message\n%d edit(s) by the plugin, the first made %s.\n%s",
+		len(history.Revisions), time.Unix(0, first.CreatedAt*int64(time.Millisecond)).UTC().Format("2006-01-02 15:04 MST"), formatDiff(first.Original, post.Message))
+}
+
+// formatDiff renders the difference between two texts as a diff code block.
+func formatDiff(before, after string) string {
+	return fmt.Sprintf("```diff\n%s\n```", strings.Join(diffLines(before, after), "\n"))
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/mattermost/mattermost-server/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffLines(t *testing.T) {
+	assert.Equal(t, []string{"-teh fox", "+the fox"}, diffLines("teh fox", "the fox"))
+	assert.Equal(t, []string{" one", "-two", "+2", " three", "+four"}, diffLines("one\ntwo\nthree", "one\n2\nthree\nfour"))
+	assert.Equal(t, []string{" same"}, diffLines("same", "same"))
+}
+
+func TestPostIDFromReference(t *testing.T) {
+	id := model.NewId()
+
+	for _, reference := range []string{id, "https://chat.example.com/team/pl/" + id, "<https://chat.example.com/sub/team/pl/" + id + ">"} {
+		postID, ok := postIDFromReference(reference)
+		assert.True(t, ok, reference)
+		assert.Equal(t, id, postID)
+	}
+
+	for _, reference := range []string{"nope", "https://chat.example.com/team/channels/town-square"} {
+		_, ok := postIDFromReference(reference)
+		assert.False(t, ok, reference)
+	}
+}
+
+func TestExecuteDiffCommand(t *testing.T) {
+	api := &plugintest.API{}
+	defer api.AssertExpectations(t)
+
+	p := setupTestPlugin(t, api)
+	id := model.NewId()
+
+	history, _ := json.Marshal(&postHistory{Revisions: []*revision{newRevision("testUserId", "s/teh/the", "teh fox", "the fox")}})
+	api.On("GetPost", id).Return(&model.Post{Id: id, ChannelId: "testChannelId", Message: "the quick fox"}, nil)
+	api.On("HasPermissionToChannel", "testUserId", "testChannelId", model.PERMISSION_READ_CHANNEL).Return(true)
+	api.On("KVGet", historyKey(id)).Return(history, nil)
+
+	response, appErr := p.ExecuteCommand(nil, &model.CommandArgs{UserId: "testUserId", Command: "/replace diff https://chat.example.com/team/pl/" + id})
+
+	assert.Nil(t, appErr)
+	assert.Contains(t, response.Text, "1 edit(s) by the plugin")
+	assert.Contains(t, response.Text, "```diff\n-teh fox\n+the quick fox\n```")
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// emojiPattern matches a single emoji shortcode such as :smile: or :+1:.
+var emojiPattern = regexp.MustCompile(`:[a-zA-Z0-9_+-]+:`)
+
+// emojiNamePattern matches a bare emoji name, without the surrounding colons.
+var emojiNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_+-]+$`)
+
+func trimEmojiName(name string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(name, ":"), ":")
+}
+
+func isValidEmojiName(name string) bool {
+	return emojiNamePattern.MatchString(name)
+}
+
+// replaceEmoji rewrites every :oldName: shortcode in message to :newName:. Shortcodes are matched
+// as whole tokens, so :oldName_2: and plain occurrences of oldName are left untouched. It returns
+// the new message and the number of shortcodes rewritten.
+func replaceEmoji(message, oldName, newName string) (string, int) {
+	count := 0
+	result := emojiPattern.ReplaceAllStringFunc(message, func(shortcode string) string {
+		if trimEmojiName(shortcode) != oldName {
+			return shortcode
+		}
+
+		count++
+		return ":" + newName + ":"
+	})
+
+	return result, count
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplaceEmoji(t *testing.T) {
+	cases := []struct {
+		message  string
+		expected string
+		count    int
+	}{
+		{"ship it :party_parrot:", "ship it :partyparrot:", 1},
+		{":party_parrot::party_parrot:", ":partyparrot::partyparrot:", 2},
+		{"party_parrot is not an emoji", "party_parrot is not an emoji", 0},
+		{"keep :party_parrot_2: as is", "keep :party_parrot_2: as is", 0},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.message, func(t *testing.T) {
+			result, count := replaceEmoji(tc.message, "party_parrot", "partyparrot")
+			assert.Equal(t, tc.expected, result)
+			assert.Equal(t, tc.count, count)
+		})
+	}
+}
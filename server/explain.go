@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/carmo-evan/mattermost-plugin-replace/server/pkg/parser"
+	"github.com/carmo-evan/mattermost-plugin-replace/server/pkg/substitute"
+)
+
+// explainCommand describes how an s/ command, or several separated by semicolons, typed by
+// userID in teamID is parsed and would be applied, without applying it.
+func (p *Plugin) explainCommand(userID, teamID, text string) string {
+	cmds, err := parser.ParseScript(text)
+	var subs []*substitute.Substitution
+	if err == nil {
+		subs, err = substitute.FromScript(cmds)
+	}
+	if err == nil {
+		err = p.getConfiguration().applyScriptDefaults(subs, teamID, userID)
+	}
+
+	if err != nil {
+		return fmt.Sprintf("`%s` is not a valid command: %s. %s", text, err.Error(), usage)
+	}
+
+	prefs, prefsErr := p.getUserPreferences(userID)
+
+	var lines []string
+	for i, cmd := range cmds {
+		if len(cmds) > 1 {
+			lines = append(lines, fmt.Sprintf("**Command %d**, applied to the result of the previous ones: `%s`", i+1, cmd.String()))
+		}
+
+		if prefsErr == nil {
+			prefs.apply(subs[i])
+		}
+
+		lines = append(lines, fmt.Sprintf("Fields are separated by `%c`.", cmd.Delimiter))
+		lines = append(lines, subs[i].Describe()...)
+		if cmd.Flags != "" {
+			lines = append(lines, fmt.Sprintf("Flags given: `%s`.", cmd.Flags))
+		}
+	}
+	lines = append(lines, describeTarget(cmds[0].Scopes, cmds[0].Rank))
+	if within, ok := cmds[0].Scopes["within"]; ok {
+		lines = append(lines, fmt.Sprintf("Only posts from the last %s are considered.", within))
+	}
+	if at, ok := cmds[0].Scopes["at"]; ok {
+		lines = append(lines, fmt.Sprintf("The post is found now but only edited at %s.", at))
+	}
+
+	return fmt.Sprintf("###### How `%s` is read\n* %s", text, strings.Join(lines, "\n* "))
+}
+
+// describeTarget explains which post the scopes and the ~n rank of a command select.
+func describeTarget(scopes map[string]string, rank int) string {
+	teamName, hasTeam := scopes["team"]
+	channelName, hasChannel := scopes["in"]
+
+	if postID, ok := scopes["id"]; ok {
+		if botName, ok := scopes["bot"]; ok {
+			return fmt.Sprintf("Post %s is edited, provided your bot %s wrote it.", postID, botName)
+		}
+		return fmt.Sprintf("Post %s is edited, provided you wrote it.", postID)
+	}
+
+	recent, post := "most recent post", "last post"
+	if rank > 1 {
+		recent = ordinal(rank) + " most recent post"
+		post = recent
+	}
+
+	phrase, hasPhrase := scopes["match"]
+	if hasPhrase {
+		post = fmt.Sprintf("%s containing \"%s\"", recent, phrase)
+	}
+
+	owner := "Your " + post
+	if botName, ok := scopes["bot"]; ok {
+		owner = fmt.Sprintf("The %s of your bot %s", post, botName)
+	}
+	if username, ok := scopes["u"]; ok {
+		owner = fmt.Sprintf("The %s of @%s", post, username)
+	}
+
+	if thread, ok := scopes["thread"]; ok && thread == threadRoot && !hasTeam && !hasChannel {
+		return owner + " is edited, or in a thread its root post, provided you wrote it."
+	}
+
+	switch {
+	case hasTeam && channelName == anyChannel:
+		return fmt.Sprintf("%s in any channel of team %s is edited.", owner, teamName)
+	case channelName == anyChannel:
+		return owner + " in any channel of this team is edited."
+	case hasTeam && hasChannel:
+		return fmt.Sprintf("%s in channel %s of team %s is edited.", owner, channelName, teamName)
+	case hasTeam:
+		return fmt.Sprintf("%s in team %s is edited.", owner, teamName)
+	case hasChannel:
+		return fmt.Sprintf("%s in channel %s is edited.", owner, channelName)
+	case hasPhrase || rank > 1:
+		return owner + " in this channel is edited, searching only the thread when used in one."
+	default:
+		return owner + " in this channel is edited, or the last reply when used in a thread."
+	}
+}
+
+// ordinal spells n as an English ordinal number, such as 2nd or 11th.
+func ordinal(n int) string {
+	suffix := "th"
+	switch {
+	case n%100 >= 11 && n%100 <= 13:
+	case n%10 == 1:
+		suffix = "st"
+	case n%10 == 2:
+		suffix = "nd"
+	case n%10 == 3:
+		suffix = "rd"
+	}
+
+	return strconv.Itoa(n) + suffix
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/mattermost/mattermost-server/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExplainCommand(t *testing.T) {
+	api := &plugintest.API{}
+	defer api.AssertExpectations(t)
+
+	p := setupTestPlugin(t, api)
+	api.On("KVGet", preferencesKey("testUserId")).Return(nil, nil)
+
+	explanation := p.explainCommand("testUserId", "testTeamId", "s/teh/the/ce in:deploys")
+	assert.Contains(t, explanation, "Fields are separated by `/`.")
+	assert.Contains(t, explanation, "whole words only, in any script: `(?:teh)`")
+	assert.Contains(t, explanation, "Code blocks and inline code are included (c flag).")
+	assert.Contains(t, explanation, "Spoiler and collapsible blocks are skipped.")
+	assert.Contains(t, explanation, "Flags given: `ce`.")
+	assert.Contains(t, explanation, "Your last post in channel deploys is edited.")
+
+	assert.Contains(t, p.explainCommand("testUserId", "testTeamId", "s/teh/the/~3"), "Your 3rd most recent post in this channel is edited, searching only the thread when used in one.")
+	assert.Contains(t, p.explainCommand("testUserId", "testTeamId", `s/teh/the/~2 match:"deploy"`), `Your 2nd most recent post containing "deploy" in this channel is edited`)
+	assert.Contains(t, p.explainCommand("testUserId", "testTeamId", "s/teh/the within:10m"), "Only posts from the last 10m are considered.")
+	assert.Contains(t, p.explainCommand("testUserId", "testTeamId", "s/teh/the thread:root"), "in a thread its root post")
+	assert.Contains(t, p.explainCommand("testUserId", "testTeamId", "s/teh/the in:*"), "Your last post in any channel of this team is edited.")
+	assert.Contains(t, p.explainCommand("testUserId", "testTeamId", "s/teh/the/ https://chat.example.com/eng/pl/abcdefghijklmnopqrstuvwxyz"), "Post abcdefghijklmnopqrstuvwxyz is edited, provided you wrote it.")
+
+	assert.Contains(t, p.explainCommand("testUserId", "testTeamId", "s/teh"), "is not a valid command: unterminated pattern at column 3")
+}
+
+func TestExecuteExplainCommand(t *testing.T) {
+	api := &plugintest.API{}
+	p := setupTestPlugin(t, api)
+	api.On("KVGet", preferencesKey("testUserId")).Return(nil, nil)
+
+	response, appErr := p.ExecuteCommand(nil, &model.CommandArgs{UserId: "testUserId", Command: "/replace explain s/teh/the"})
+	assert.Nil(t, appErr)
+	assert.Contains(t, response.Text, "###### How `s/teh/the` is read")
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mattermost/mattermost-server/model"
+)
+
+// maxFeedbackLength bounds the text of a single feedback message.
+const maxFeedbackLength = 4000
+
+// executeFeedbackCommand forwards feedback typed with /replace feedback to the configured admin
+// channel, posted by the plugin bot.
+func (p *Plugin) executeFeedbackCommand(args *model.CommandArgs, text string) *model.CommandResponse {
+	channelID := p.getConfiguration().FeedbackChannelID
+	if channelID == "" || p.botUserID == "" {
+		return commandResponse("Feedback is not enabled on this server.")
+	}
+
+	if text == "" {
+		return commandResponse("Usage: `/replace feedback <text>`")
+	}
+
+	if len(text) > maxFeedbackLength {
+		return commandResponse("Feedback is limited to %d characters.", maxFeedbackLength)
+	}
+
+	context := map[string]string{"hook": "ExecuteCommand", "user_id": args.UserId, "team_id": args.TeamId, "channel_id": args.ChannelId, "root_id": args.RootId}
+
+	user, appErr := p.getUser(args.UserId)
+	if appErr != nil {
+		p.reportError(appErr, context)
+		return commandResponse("Failed to send your feedback.")
+	}
+
+	post := &model.Post{
+		UserId:    p.botUserID,
+		ChannelId: channelID,
+		Message:   feedbackMessage(text, user.Username, p.API.GetServerVersion(), context),
+	}
+	if _, appErr := p.API.CreatePost(post); appErr != nil {
+		p.reportError(appErr, context)
+		return commandResponse("Failed to send your feedback.")
+	}
+
+	return commandResponse("Thanks, your feedback was sent to the administrators.")
+}
+
+// feedbackMessage formats feedback along with the plugin version and the IDs describing where it
+// was sent from, so reports can be followed up without asking for them.
+func feedbackMessage(text, username, serverVersion string, context map[string]string) string {
+	sanitized := sanitizeReportContext(context)
+	delete(sanitized, "hook")
+
+	keys := make([]string, 0, len(sanitized))
+	for key := range sanitized {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var message strings.Builder
+	fmt.Fprintf(&message, "#### Replace plugin feedback from @%s\n%s\n\n", username, text)
+	fmt.Fprintf(&message, "* Plugin version: `%s`\n* Server version: `%s`", manifest.Version, serverVersion)
+	for _, key := range keys {
+		fmt.Fprintf(&message, "\n* %s: `%s`", key, sanitized[key])
+	}
+
+	return message.String()
+}
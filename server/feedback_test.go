@@ -0,0 +1,50 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/mattermost/mattermost-server/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestExecuteFeedbackCommand(t *testing.T) {
+	args := &model.CommandArgs{UserId: "testUserId", TeamId: "testTeamId", ChannelId: "testChannelId", Command: "/replace feedback s/ ate  my  spaces"}
+
+	t.Run("disabled", func(t *testing.T) {
+		api := &plugintest.API{}
+		p := setupTestPlugin(t, api)
+		p.botUserID = "botUserId"
+
+		response, appErr := p.ExecuteCommand(nil, args)
+
+		assert.Nil(t, appErr)
+		assert.Equal(t, "Feedback is not enabled on this server.", response.Text)
+		api.AssertExpectations(t)
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		api := &plugintest.API{}
+		p := setupTestPlugin(t, api)
+		p.botUserID = "botUserId"
+		p.setConfiguration(&configuration{FeedbackChannelID: "feedbackChannelId"})
+
+		api.On("GetUser", "testUserId").Return(&model.User{Id: "testUserId", Username: "test"}, nil)
+		api.On("GetServerVersion").Return("5.10.0")
+		api.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+			return post.UserId == "botUserId" && post.ChannelId == "feedbackChannelId" &&
+				strings.Contains(post.Message, "from @test\ns/ ate  my  spaces\n") &&
+				strings.Contains(post.Message, "* Plugin version: `"+manifest.Version+"`") &&
+				strings.Contains(post.Message, "* channel_id: `testChannelId`") &&
+				!strings.Contains(post.Message, "hook")
+		})).Return(&model.Post{}, nil)
+
+		response, appErr := p.ExecuteCommand(nil, args)
+
+		assert.Nil(t, appErr)
+		assert.Contains(t, response.Text, "Thanks")
+		api.AssertExpectations(t)
+	})
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"hash/fnv"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/carmo-evan/mattermost-plugin-replace/server/pkg/substitute"
+)
+
+// Feature flags gating risky capabilities while they are rolled out.
+const (
+	flagRegex     string = "regex"
+	flagModerator string = "moderator"
+)
+
+var knownFeatureFlags = map[string]bool{
+	flagRegex:     true,
+	flagModerator: true,
+}
+
+// featureRule decides who a feature flag is enabled for. A flag is enabled for everyone, for the
+// listed teams, and for a stable share of the remaining users.
+type featureRule struct {
+	All     bool
+	Teams   map[string]bool
+	Percent uint32
+}
+
+// enabled reports whether the rule enables the flag for a user in a team. Users are assigned to
+// the percentage by hashing their ID with the flag name, so a user keeps the same answer across
+// requests and raising the percentage only ever adds users.
+func (rule *featureRule) enabled(flag, teamID, userID string) bool {
+	if rule.All || rule.Teams[teamID] {
+		return true
+	}
+
+	if rule.Percent == 0 || userID == "" {
+		return false
+	}
+
+	hash := fnv.New32a()
+	hash.Write([]byte(flag + ":" + userID))
+
+	return hash.Sum32()%100 < rule.Percent
+}
+
+// parseFeatureFlags reads the FeatureFlags setting. Flags are separated by semicolons or new
+// lines, each written as name=terms where terms is a comma separated list of on, off, N% and
+// team:<team ID>. For example: "regex=25%, team:abc; moderator=on".
+func parseFeatureFlags(setting string) (map[string]*featureRule, error) {
+	rules := make(map[string]*featureRule)
+
+	for _, entry := range strings.FieldsFunc(setting, func(r rune) bool { return r == ';' || r == '\n' }) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, "=", 2)
+		name := strings.TrimSpace(parts[0])
+		if !knownFeatureFlags[name] {
+			return nil, errors.Errorf("unknown feature flag %q", name)
+		}
+		if len(parts) != 2 {
+			return nil, errors.Errorf("feature flag %q has no value", name)
+		}
+
+		rule := &featureRule{Teams: make(map[string]bool)}
+		for _, term := range strings.Split(parts[1], ",") {
+			term = strings.TrimSpace(term)
+			switch {
+			case term == "on":
+				rule.All = true
+			case term == "off" || term == "":
+			case strings.HasPrefix(term, "team:") && len(term) > len("team:"):
+				rule.Teams[strings.TrimPrefix(term, "team:")] = true
+			case strings.HasSuffix(term, "%"):
+				percent, err := strconv.ParseUint(strings.TrimSuffix(term, "%"), 10, 32)
+				if err != nil || percent > 100 {
+					return nil, errors.Errorf("invalid percentage %q for feature flag %q", term, name)
+				}
+				rule.Percent = uint32(percent)
+			default:
+				return nil, errors.Errorf("invalid value %q for feature flag %q", term, name)
+			}
+		}
+
+		rules[name] = rule
+	}
+
+	return rules, nil
+}
+
+// isFeatureEnabled reports whether a feature flag is enabled for a user in a team. Flags missing
+// from the configuration, or forbidden by the limits profile, are disabled.
+func (c *configuration) isFeatureEnabled(flag, teamID, userID string) bool {
+	if flag == flagModerator && !c.limits().AllowModerator {
+		return false
+	}
+
+	rule, ok := c.featureRules[flag]
+	if !ok {
+		return false
+	}
+
+	return rule.enabled(flag, teamID, userID)
+}
+
+// Pattern modes for the PatternMode setting.
+const (
+	patternRegex   string = "regex"
+	patternLiteral string = "literal"
+)
+
+// defaults returns the defaults the configuration sets for the substitutions of a user in a team.
+// The regex feature flag makes full regular expressions the default for the users it covers;
+// otherwise the PatternMode setting applies. MatchInsideWords decides whether patterns must match
+// whole words.
+func (c *configuration) defaults(teamID, userID string) *substitute.Defaults {
+	return &substitute.Defaults{
+		Regex:        c.isFeatureEnabled(flagRegex, teamID, userID),
+		Literal:      c.PatternMode == patternLiteral,
+		PartialWords: c.MatchInsideWords,
+		IncludeLinks: c.ReplaceInLinks,
+		SkipHashtags: c.SkipHashtags,
+	}
+}
+
+// applyDefaults chooses how the pattern of sub is matched for a user in a team when its flags do
+// not, and validates sub in its final form. User preferences are applied afterwards.
+func (c *configuration) applyDefaults(sub *substitute.Substitution, teamID, userID string) error {
+	return c.defaults(teamID, userID).Apply(sub)
+}
+
+// applyScriptDefaults applies the defaults to each substitution of a script. Errors name the
+// command they concern when there are several.
+func (c *configuration) applyScriptDefaults(subs []*substitute.Substitution, teamID, userID string) error {
+	return c.defaults(teamID, userID).ApplyScript(subs)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carmo-evan/mattermost-plugin-replace/server/pkg/parser"
+	"github.com/carmo-evan/mattermost-plugin-replace/server/pkg/substitute"
+)
+
+func TestParseFeatureFlags(t *testing.T) {
+	rules, err := parseFeatureFlags("regex=25%, team:abc;\n moderator=on")
+	require.Nil(t, err)
+
+	assert.Equal(t, &featureRule{Teams: map[string]bool{"abc": true}, Percent: 25}, rules[flagRegex])
+	assert.Equal(t, &featureRule{All: true, Teams: map[string]bool{}}, rules[flagModerator])
+
+	rules, err = parseFeatureFlags("moderator=off")
+	require.Nil(t, err)
+	assert.Equal(t, &featureRule{Teams: map[string]bool{}}, rules[flagModerator])
+
+	for _, setting := range []string{"unknown=on", "autocorrect=on", "regex", "regex=101%", "regex=-1%", "regex=sometimes", "regex=team:"} {
+		_, err := parseFeatureFlags(setting)
+		assert.NotNil(t, err, setting)
+	}
+}
+
+func TestIsFeatureEnabled(t *testing.T) {
+	rules, err := parseFeatureFlags("regex=team:abc, 30%; moderator=on")
+	require.Nil(t, err)
+	config := &configuration{featureRules: rules}
+
+	assert.True(t, config.isFeatureEnabled(flagModerator, "xyz", "user"))
+	assert.True(t, config.isFeatureEnabled(flagRegex, "abc", "user"))
+	assert.False(t, config.isFeatureEnabled("unknown", "abc", "user"))
+	assert.False(t, (&configuration{}).isFeatureEnabled(flagRegex, "abc", "user"))
+
+	enabled := 0
+	for i := 0; i < 1000; i++ {
+		userID := fmt.Sprintf("user%d", i)
+		if config.isFeatureEnabled(flagRegex, "xyz", userID) {
+			enabled++
+			assert.True(t, config.isFeatureEnabled(flagRegex, "xyz", userID), "answers must be stable")
+		}
+	}
+	assert.InDelta(t, 300, enabled, 60)
+}
+
+func TestApplyDefaults(t *testing.T) {
+	rules, err := parseFeatureFlags("regex=team:abc")
+	require.Nil(t, err)
+	config := &configuration{featureRules: rules}
+
+	sub := &substitute.Substitution{Pattern: "teh"}
+	assert.Nil(t, (&configuration{}).applyDefaults(sub, "abc", "user"))
+	assert.False(t, sub.Regex)
+
+	assert.Nil(t, config.applyDefaults(sub, "abc", "user"))
+	assert.True(t, sub.Regex)
+
+	config.PatternMode = patternLiteral
+	cmd, err := parser.Parse("s/v1.0(beta)/v1.0/")
+	require.Nil(t, err)
+	sub, err = substitute.FromCommand(cmd)
+	require.Nil(t, err)
+	assert.Nil(t, config.applyDefaults(sub, "xyz", "user"))
+	assert.True(t, sub.Literal)
+
+	sub = &substitute.Substitution{Pattern: "a)(b", Regex: true}
+	assert.NotNil(t, config.applyDefaults(sub, "xyz", "user"))
+	assert.False(t, sub.Literal)
+	config.MatchInsideWords = true
+	sub = &substitute.Substitution{Pattern: "qu"}
+	assert.Nil(t, config.applyDefaults(sub, "xyz", "user"))
+	assert.True(t, sub.PartialWords)
+}
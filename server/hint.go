@@ -0,0 +1,50 @@
+package main
+
+import (
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/mattermost/mattermost-server/plugin"
+)
+
+const joinHint string = "Tip: made a typo? Post `s/old text/new text` to fix your last post in this channel. Type `/replace help` for more."
+
+// joinHintKeyPrefix starts the keys marking users who were sent the hint, which expire with the
+// hint interval.
+const joinHintKeyPrefix string = "join_hint_"
+
+func joinHintKey(userID string) string {
+	return joinHintKeyPrefix + userID
+}
+
+// UserHasJoinedChannel sends the optional usage hint to a user joining a channel, at most once
+// per configured interval.
+func (p *Plugin) UserHasJoinedChannel(c *plugin.Context, channelMember *model.ChannelMember, actor *model.User) {
+	config := p.getConfiguration()
+	if !config.EnableJoinHint {
+		return
+	}
+
+	context := map[string]string{"hook": "UserHasJoinedChannel", "user_id": channelMember.UserId, "channel_id": channelMember.ChannelId}
+
+	key := joinHintKey(channelMember.UserId)
+	sent, appErr := p.API.KVGet(key)
+	if appErr != nil {
+		p.reportError(appErr, context)
+		return
+	}
+
+	if sent != nil {
+		return
+	}
+
+	// An interval of zero stores the marker without expiry, so the hint is only ever sent once.
+	if appErr = p.API.KVSetWithExpiry(key, []byte("1"), int64(config.JoinHintIntervalHours)*60*60); appErr != nil {
+		p.reportError(appErr, context)
+		return
+	}
+
+	p.API.SendEphemeralPost(channelMember.UserId, &model.Post{
+		ChannelId: channelMember.ChannelId,
+		Message:   joinHint,
+		CreateAt:  model.GetMillis(),
+	})
+}
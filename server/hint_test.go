@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/mattermost/mattermost-server/plugin"
+	"github.com/mattermost/mattermost-server/plugin/plugintest"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestUserHasJoinedChannel(t *testing.T) {
+	member := &model.ChannelMember{UserId: "testUserId", ChannelId: "testChannelId"}
+
+	t.Run("disabled", func(t *testing.T) {
+		api := &plugintest.API{}
+		p := setupTestPlugin(t, api)
+
+		p.UserHasJoinedChannel(&plugin.Context{}, member, nil)
+
+		api.AssertExpectations(t)
+	})
+
+	t.Run("first join", func(t *testing.T) {
+		api := &plugintest.API{}
+		p := setupTestPlugin(t, api)
+		p.setConfiguration(&configuration{EnableJoinHint: true, JoinHintIntervalHours: 24})
+
+		api.On("KVGet", joinHintKey("testUserId")).Return(nil, nil)
+		api.On("KVSetWithExpiry", joinHintKey("testUserId"), []byte("1"), int64(24*60*60)).Return(nil)
+		api.On("SendEphemeralPost", "testUserId", mock.AnythingOfType("*model.Post")).Return(nil)
+
+		p.UserHasJoinedChannel(&plugin.Context{}, member, nil)
+
+		api.AssertExpectations(t)
+	})
+
+	t.Run("throttled", func(t *testing.T) {
+		api := &plugintest.API{}
+		p := setupTestPlugin(t, api)
+		p.setConfiguration(&configuration{EnableJoinHint: true, JoinHintIntervalHours: 24})
+
+		api.On("KVGet", joinHintKey("testUserId")).Return([]byte("1"), nil)
+
+		p.UserHasJoinedChannel(&plugin.Context{}, member, nil)
+
+		api.AssertExpectations(t)
+	})
+}
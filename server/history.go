@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// historyCommand is the s/ message listing the edits of the post last edited for its author.
+const historyCommand = "s/history"
+
+// revertPattern matches s/revert n, which restores the message from before edit n.
+var revertPattern = regexp.MustCompile(`^s/revert\s+(\d{1,9})$`)
+
+// revertNumber returns the edit number of an s/revert command, or false if command is not one.
+func revertNumber(command string) (int, bool) {
+	match := revertPattern.FindStringSubmatch(command)
+	if match == nil {
+		return 0, false
+	}
+
+	n, err := strconv.Atoi(match[1])
+	return n, err == nil
+}
+
+// listHistory lists the revisions of the post most recently edited for userID, oldest first and
+// numbered for s/revert.
+func (p *Plugin) listHistory(userID, teamID string) (string, bool, error) {
+	postID, history, err := p.lastEdited(userID)
+	if err != nil {
+		return "", false, err
+	}
+	if postID == "" {
+		return "`s/ Command: The plugin has no record of editing a post of yours.`", false, nil
+	}
+
+	lines := []string{fmt.Sprintf("Edits of [the post](%s) by the plugin, oldest first. `s/revert <n>` restores the message from before edit n:", p.permalink(teamID, postID))}
+	for i, rev := range history.Revisions {
+		author := rev.UserID
+		if user, appErr := p.getUser(rev.UserID); appErr == nil {
+			author = "@" + user.Username
+		}
+		createdAt := time.Unix(0, rev.CreatedAt*int64(time.Millisecond)).UTC().Format("2006-01-02 15:04 MST")
+		lines = append(lines, fmt.Sprintf("%d. `%s` by %s on %s", i+1, rev.Command, author, createdAt))
+	}
+
+	if len(history.Revisions) == maxRevisionsPerPost {
+		lines = append(lines, fmt.Sprintf("Only the last %d edits are kept.", maxRevisionsPerPost))
+	}
+
+	return strings.Join(lines, "\n"), true, nil
+}
+
+// revertEdit restores the post most recently edited for userID to its message from before edit n
+// of its history, counted from 1, provided nobody changed it since the latest edit. The revert is
+// recorded as an edit of its own, so that it can be undone in turn.
+func (p *Plugin) revertEdit(userID, teamID string, n int) (string, bool, error) {
+	postID, history, err := p.lastEdited(userID)
+	if err != nil {
+		return "", false, err
+	}
+	if postID == "" {
+		return "`s/ Command: The plugin has no record of editing a post of yours.`", false, nil
+	}
+	if n < 1 || n > len(history.Revisions) {
+		return fmt.Sprintf("`s/ Command: There is no edit %d, the post was edited %d time(s). Use s/history to list the edits.`", n, len(history.Revisions)), false, nil
+	}
+
+	latest := history.Revisions[len(history.Revisions)-1]
+	target := history.Revisions[n-1]
+	link := p.permalink(teamID, postID)
+
+	post, appErr := p.API.GetPost(postID)
+	if appErr != nil || post.DeleteAt != 0 {
+		return "`s/ Command: The post to restore no longer exists.`", false, nil
+	}
+
+	switch err := latest.check(post.Message); err {
+	case nil:
+	case errEditedSince:
+		return fmt.Sprintf("[The post](%s) was edited since `%s`, so it was left as it is:\n%s", link, latest.Command, latest.threeWayView(post.Message)), false, nil
+	default:
+		return fmt.Sprintf("`s/ Command: The post cannot be restored, %s.`", err.Error()), false, nil
+	}
+	if contentHash(target.Original) != target.OriginalHash {
+		return fmt.Sprintf("`s/ Command: The post cannot be restored, %s.`", errRevisionCorrupted.Error()), false, nil
+	}
+
+	if refusal, err := p.deferredEditRejection(userID, post); refusal != "" || err != nil {
+		return refusal, false, err
+	}
+
+	current := post.Message
+	post.Message = target.Original
+	p.editLock.Lock()
+	_, appErr = p.updatePost(post)
+	p.editLock.Unlock()
+	if appErr != nil {
+		return "", false, appErr
+	}
+
+	command := fmt.Sprintf("s/revert %d", n)
+	if err := p.recordRevision(postID, newRevision(userID, command, current, post.Message)); err != nil {
+		return "", false, err
+	}
+	if err := p.rememberEdit(userID, postID); err != nil {
+		return "", false, err
+	}
+
+	return fmt.Sprintf("[The post](%s) was restored to its message before edit %d, `%s`.", link, n, target.Command), true, nil
+}
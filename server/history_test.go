@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/mattermost/mattermost-server/plugin"
+	"github.com/mattermost/mattermost-server/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestRevertNumber(t *testing.T) {
+	for command, expected := range map[string]int{"s/revert 1": 1, "s/revert  12": 12} {
+		n, ok := revertNumber(command)
+		assert.True(t, ok, command)
+		assert.Equal(t, expected, n, command)
+	}
+
+	for _, command := range []string{"s/revert", "s/revert one", "s/revert 1 2", "s/revert/x/"} {
+		_, ok := revertNumber(command)
+		assert.False(t, ok, command)
+	}
+}
+
+// setupHistory stores two edits of lastPostId made for testUserId, turning "teh dog" into
+// "teh cat" and then "the cat".
+func setupHistory(api *plugintest.API) {
+	first := newRevision("testUserId", "s/dog/cat/", "teh dog", "teh cat")
+	first.CreatedAt = 1767225600000
+	second := newRevision("testUserId", "s/teh/the/", "teh cat", "the cat")
+	second.CreatedAt = 1767229200000
+	data, _ := json.Marshal(&postHistory{Revisions: []*revision{first, second}})
+
+	api.On("KVGet", lastEditKey("testUserId")).Return([]byte("lastPostId"), nil)
+	api.On("KVGet", historyKey("lastPostId")).Return(data, nil)
+	api.On("GetConfig").Return(&model.Config{})
+	api.On("GetTeam", "testTeamId").Return(&model.Team{Name: "engineering"}, nil)
+}
+
+func TestListHistory(t *testing.T) {
+	api := &plugintest.API{}
+	defer api.AssertExpectations(t)
+
+	p := setupTestPlugin(t, api)
+	setupHistory(api)
+	api.On("GetUser", "testUserId").Return(&model.User{Id: "testUserId", Username: "test"}, nil)
+
+	message, _, err := p.listHistory("testUserId", "testTeamId")
+	assert.NoError(t, err)
+	assert.Equal(t, "Edits of [the post](/engineering/pl/lastPostId) by the plugin, oldest first. `s/revert <n>` restores the message from before edit n:\n"+
+		"1. `s/dog/cat/` by @test on 2026-01-01 00:00 UTC\n"+
+		"2. `s/teh/the/` by @test on 2026-01-01 01:00 UTC", message)
+}
+
+func TestRevertEdit(t *testing.T) {
+	t.Run("revert", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+
+		p := setupTestPlugin(t, api)
+		setupHistory(api)
+		post := &model.Post{Id: "lastPostId", UserId: "testUserId", ChannelId: "testChannelId", Message: "the cat"}
+		api.On("GetPost", "lastPostId").Return(post, nil)
+		api.On("GetChannel", "testChannelId").Return(&model.Channel{Id: "testChannelId", Type: model.CHANNEL_OPEN}, nil)
+		api.On("HasPermissionToChannel", "testUserId", "testChannelId", model.PERMISSION_EDIT_POST).Return(true)
+		api.On("UpdatePost", mock.MatchedBy(func(post *model.Post) bool {
+			return post.Message == "teh dog"
+		})).Return(post, nil)
+		api.On("KVSet", historyKey("lastPostId"), mock.MatchedBy(func(data []byte) bool {
+			var saved postHistory
+			return json.Unmarshal(data, &saved) == nil && len(saved.Revisions) == 3 &&
+				saved.Revisions[2].Command == "s/revert 1" && saved.Revisions[2].Original == "the cat" && saved.Revisions[2].Edited == "teh dog"
+		})).Return(nil)
+		api.On("KVSet", lastEditKey("testUserId"), []byte("lastPostId")).Return(nil)
+		api.On("KVSet", undoKey("testUserId"), []byte("lastPostId")).Return(nil)
+
+		message, ok, err := p.revertEdit("testUserId", "testTeamId", 1)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, "[The post](/engineering/pl/lastPostId) was restored to its message before edit 1, `s/dog/cat/`.", message)
+	})
+
+	t.Run("out of range", func(t *testing.T) {
+		api := &plugintest.API{}
+		p := setupTestPlugin(t, api)
+		setupHistory(api)
+
+		message, ok, err := p.revertEdit("testUserId", "testTeamId", 3)
+		assert.NoError(t, err)
+		assert.False(t, ok)
+		assert.Equal(t, "`s/ Command: There is no edit 3, the post was edited 2 time(s). Use s/history to list the edits.`", message)
+	})
+}
+
+func TestMessageWillBePostedHistory(t *testing.T) {
+	api := &plugintest.API{}
+	defer api.AssertExpectations(t)
+
+	p := setupTestPlugin(t, api)
+	api.On("GetChannel", "testChannelId").Return(&model.Channel{Id: "testChannelId", TeamId: "testTeamId"}, nil)
+	api.On("KVGet", lastEditKey("testUserId")).Return(nil, nil)
+	api.On("SendEphemeralPost", "testUserId", mock.MatchedBy(func(notification *model.Post) bool {
+		return notification.Message == "`s/ Command: The plugin has no record of editing a post of yours.`"
+	})).Return(nil)
+
+	_, rejection := p.MessageWillBePosted(&plugin.Context{}, &model.Post{UserId: "testUserId", ChannelId: "testChannelId", Message: "s/history"})
+
+	assert.Equal(t, "plugin.message_will_be_posted.dismiss_post", rejection)
+}
@@ -0,0 +1,44 @@
+package main
+
+// Profiles selectable with the LimitsProfile setting.
+const (
+	profileStandard   string = "standard"
+	profileRestricted string = "restricted"
+)
+
+// limits bounds the work and reach of the plugin. They come as bundled profiles rather than
+// individual settings, so shared environments such as Mattermost Cloud can be locked down with a
+// single choice.
+type limits struct {
+	// MaxJobPosts caps how many posts a single bulk job inspects.
+	MaxJobPosts int
+
+	// MaxLookbackPosts caps how many posts of a thread are searched for the user's last reply.
+	// Zero means no limit.
+	MaxLookbackPosts int
+
+	// AllowModerator allows editing posts written by other users, such as through moderator mode
+	// or channel-wide emoji rewrites.
+	AllowModerator bool
+}
+
+var limitsProfiles = map[string]limits{
+	profileStandard: {
+		MaxJobPosts:    bulkMaxPosts,
+		AllowModerator: true,
+	},
+	profileRestricted: {
+		MaxJobPosts:      200,
+		MaxLookbackPosts: 200,
+		AllowModerator:   false,
+	},
+}
+
+// limits returns the limits of the configured profile, falling back to the standard profile.
+func (c *configuration) limits() limits {
+	if profile, ok := limitsProfiles[c.LimitsProfile]; ok {
+		return profile
+	}
+
+	return limitsProfiles[profileStandard]
+}
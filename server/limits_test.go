@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/mattermost/mattermost-server/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimitsProfile(t *testing.T) {
+	assert.Equal(t, limitsProfiles[profileStandard], (&configuration{}).limits())
+	assert.Equal(t, limitsProfiles[profileStandard], (&configuration{LimitsProfile: "unknown"}).limits())
+
+	rules, err := parseFeatureFlags("moderator=on")
+	require.Nil(t, err)
+
+	assert.True(t, (&configuration{featureRules: rules}).isFeatureEnabled(flagModerator, "teamId", "userId"))
+	assert.False(t, (&configuration{LimitsProfile: profileRestricted, featureRules: rules}).isFeatureEnabled(flagModerator, "teamId", "userId"))
+}
+
+func TestRunBulkJobRestricted(t *testing.T) {
+	api := &plugintest.API{}
+	defer api.AssertExpectations(t)
+
+	postList := model.NewPostList()
+	for i := 0; i < bulkPageSize; i++ {
+		id := fmt.Sprintf("post%d", i)
+		postList.AddPost(&model.Post{Id: id, UserId: "testUserId", Message: "nothing to see"})
+		postList.AddOrder(id)
+	}
+
+	api.On("GetPostsForChannel", "testChannelId", 0, bulkPageSize).Return(postList, nil)
+	api.On("GetPostsForChannel", "testChannelId", 1, bulkPageSize).Return(postList, nil)
+
+	p := setupTestPlugin(t, api)
+	p.setConfiguration(&configuration{LimitsProfile: profileRestricted})
+
+	result, err := p.runBulkJob(&bulkJob{
+		ChannelID: "testChannelId",
+		Rewrite: func(message string) (string, int) {
+			return message, 0
+		},
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, limitsProfiles[profileRestricted].MaxJobPosts, result.Scanned)
+}
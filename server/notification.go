@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-server/model"
+
+	"github.com/carmo-evan/mattermost-plugin-replace/server/pkg/substitute"
+)
+
+// Confirmation styles for a successful replacement.
+const (
+	notifyEphemeral string = "ephemeral"
+	notifyPublic    string = "public"
+	notifyNone      string = "none"
+)
+
+// notificationStyles lists the valid confirmation styles.
+var notificationStyles = []string{notifyEphemeral, notifyPublic, notifyNone}
+
+func isNotificationStyle(style string) bool {
+	for _, s := range notificationStyles {
+		if s == style {
+			return true
+		}
+	}
+
+	return false
+}
+
+func channelNotificationKey(channelID string) string {
+	return "channel_notification_" + channelID
+}
+
+// getChannelNotificationStyle returns the confirmation style chosen by the admins of a channel,
+// or an empty string if they kept the default.
+func (p *Plugin) getChannelNotificationStyle(channelID string) (string, error) {
+	data, appErr := p.API.KVGet(channelNotificationKey(channelID))
+	if appErr != nil {
+		return "", errors.Wrap(appErr, "failed to get channel notification style")
+	}
+
+	return string(data), nil
+}
+
+// setChannelNotificationStyle stores the confirmation style of a channel. An empty style restores
+// the default.
+func (p *Plugin) setChannelNotificationStyle(channelID, style string) error {
+	var appErr *model.AppError
+	if style == "" {
+		appErr = p.API.KVDelete(channelNotificationKey(channelID))
+	} else {
+		appErr = p.API.KVSet(channelNotificationKey(channelID), []byte(style))
+	}
+
+	if appErr != nil {
+		return errors.Wrap(appErr, "failed to save channel notification style")
+	}
+
+	return nil
+}
+
+// resolveNotificationStyle picks the confirmation style for a replacement made in a channel. A
+// channel override wins over the user's preference, which wins over the global setting.
+func (p *Plugin) resolveNotificationStyle(channelID string, prefs *userPreferences) (string, error) {
+	style, err := p.getChannelNotificationStyle(channelID)
+	if err != nil {
+		return notifyEphemeral, err
+	}
+
+	for _, candidate := range []string{style, prefs.NotificationStyle, p.getConfiguration().NotificationStyle} {
+		if isNotificationStyle(candidate) {
+			return candidate, nil
+		}
+	}
+
+	return notifyEphemeral, nil
+}
+
+// confirmationMessage describes a replacement that was applied. With the g flag the number of
+// occurrences replaced is given.
+func confirmationMessage(sub *substitute.Substitution, result *substitute.Result) string {
+	if sub.Transliteration != nil {
+		return fmt.Sprintf(`y/ Replaced %d characters of "%s" with "%s"`, result.Replacements, sub.Pattern, sub.Replacement)
+	}
+
+	if sub.Fuzzy && result.Replacements > 0 && !(len(result.Matched) == 1 && result.Matched[0] == sub.Pattern) {
+		return fuzzyMessage(sub, result)
+	}
+
+	if sub.Replacement == "" {
+		return removalMessage(sub, result)
+	}
+
+	if result.Replacements > 0 && sub.Pattern == "^" && !sub.Literal {
+		return `s/ Added "` + sub.Replacement + `" at the start of the post`
+	}
+
+	if result.Replacements > 0 && sub.Pattern == "$" && !sub.Literal {
+		return `s/ Added "` + sub.Replacement + `" at the end of the post`
+	}
+
+	if sub.Occurrence > 0 {
+		if result.Replacements == 0 {
+			return fmt.Sprintf(`s/ "%s" occurs fewer than %d times, nothing was replaced`, sub.Pattern, sub.Occurrence)
+		}
+		if sub.Global {
+			return fmt.Sprintf(`s/ Replaced %d occurrences of "%s" with "%s", from occurrence %d on`, result.Replacements, sub.Pattern, sub.Replacement, sub.Occurrence)
+		}
+		return fmt.Sprintf(`s/ Replaced occurrence %d of "%s" with "%s"`, sub.Occurrence, sub.Pattern, sub.Replacement)
+	}
+
+	if sub.Global {
+		return fmt.Sprintf(`s/ Replaced all %d occurrences of "%s" with "%s"`, result.Replacements, sub.Pattern, sub.Replacement)
+	}
+
+	return fmt.Sprintf(`s/ Replaced %s of "%s" with "%s"`, occurrences(result.Replacements), sub.Pattern, sub.Replacement)
+}
+
+// occurrences counts n occurrences in words.
+func occurrences(n int) string {
+	if n == 1 {
+		return "1 occurrence"
+	}
+
+	return fmt.Sprintf("%d occurrences", n)
+}
+
+// removalMessage is the confirmation of a substitution deleting its pattern.
+func removalMessage(sub *substitute.Substitution, result *substitute.Result) string {
+	switch {
+	case sub.Occurrence > 0 && result.Replacements == 0:
+		return fmt.Sprintf(`s/ "%s" occurs fewer than %d times, nothing was removed`, sub.Pattern, sub.Occurrence)
+	case sub.Occurrence > 0 && sub.Global:
+		return fmt.Sprintf(`s/ Removed %d occurrences of "%s", from occurrence %d on`, result.Replacements, sub.Pattern, sub.Occurrence)
+	case sub.Occurrence > 0:
+		return fmt.Sprintf(`s/ Removed occurrence %d of "%s"`, sub.Occurrence, sub.Pattern)
+	case sub.Global:
+		return fmt.Sprintf(`s/ Removed all %d occurrences of "%s"`, result.Replacements, sub.Pattern)
+	}
+
+	return fmt.Sprintf(`s/ Removed %s of "%s"`, occurrences(result.Replacements), sub.Pattern)
+}
+
+// fuzzyMessage is the confirmation of a fuzzy substitution, naming the texts actually matched
+// since they may differ from the pattern.
+func fuzzyMessage(sub *substitute.Substitution, result *substitute.Result) string {
+	matched := `"` + strings.Join(result.Matched, `", "`) + `" (close to "` + sub.Pattern + `")`
+
+	count := occurrences(result.Replacements) + " of "
+
+	if sub.Replacement == "" {
+		return "s/ Removed " + count + matched
+	}
+
+	return "s/ Replaced " + count + matched + ` with "` + sub.Replacement + `"`
+}
+
+// sendConfirmation tells the channel or the user that a replacement was made, according to style.
+// Public confirmations fall back to an ephemeral post when the bot is unavailable.
+func (p *Plugin) sendConfirmation(style string, user *model.User, notification *model.Post) {
+	switch style {
+	case notifyNone:
+		return
+	case notifyPublic:
+		if p.botUserID == "" {
+			break
+		}
+
+		post := &model.Post{
+			UserId:    p.botUserID,
+			ChannelId: notification.ChannelId,
+			RootId:    notification.RootId,
+			Message:   "@" + user.Username + " " + notification.Message,
+		}
+		if _, appErr := p.API.CreatePost(post); appErr != nil {
+			p.reportError(appErr, postContext("MessageWillBePosted", post))
+			break
+		}
+
+		return
+	}
+
+	p.API.SendEphemeralPost(user.Id, notification)
+}
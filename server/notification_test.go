@@ -0,0 +1,140 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/mattermost/mattermost-server/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/carmo-evan/mattermost-plugin-replace/server/pkg/substitute"
+)
+
+func TestConfirmationMessage(t *testing.T) {
+	result := &substitute.Result{Replacements: 3}
+
+	assert.Equal(t, `s/ Replaced 3 occurrences of "bee" with "be"`, confirmationMessage(&substitute.Substitution{Pattern: "bee", Replacement: "be"}, result))
+	assert.Equal(t, `s/ Replaced 1 occurrence of "bee" with "be"`, confirmationMessage(&substitute.Substitution{Pattern: "bee", Replacement: "be"}, &substitute.Result{Replacements: 1}))
+	assert.Equal(t, `s/ Replaced all 3 occurrences of "bee" with "be"`, confirmationMessage(&substitute.Substitution{Pattern: "bee", Replacement: "be", Global: true}, result))
+	assert.Equal(t, `s/ Replaced 2 occurrences of "bee" with "be", from occurrence 2 on`, confirmationMessage(&substitute.Substitution{Pattern: "bee", Replacement: "be", Occurrence: 2, Global: true}, &substitute.Result{Replacements: 2}))
+	assert.Equal(t, `s/ Replaced occurrence 2 of "bee" with "be"`, confirmationMessage(&substitute.Substitution{Pattern: "bee", Replacement: "be", Occurrence: 2}, &substitute.Result{Replacements: 1}))
+	assert.Equal(t, `s/ "bee" occurs fewer than 4 times, nothing was replaced`, confirmationMessage(&substitute.Substitution{Pattern: "bee", Replacement: "be", Occurrence: 4}, &substitute.Result{}))
+	assert.Equal(t, `s/ Removed 3 occurrences of "very"`, confirmationMessage(&substitute.Substitution{Pattern: "very"}, result))
+	assert.Equal(t, `s/ Removed all 3 occurrences of "very"`, confirmationMessage(&substitute.Substitution{Pattern: "very", Global: true}, result))
+	assert.Equal(t, `s/ Removed occurrence 2 of "very"`, confirmationMessage(&substitute.Substitution{Pattern: "very", Occurrence: 2}, &substitute.Result{Replacements: 1}))
+	assert.Equal(t, `s/ Added " (edited)" at the end of the post`, confirmationMessage(&substitute.Substitution{Pattern: "$", Replacement: " (edited)"}, &substitute.Result{Replacements: 1}))
+	assert.Equal(t, `s/ Replaced 1 occurrence of "receve" (close to "recieve") with "receive"`, confirmationMessage(&substitute.Substitution{Pattern: "recieve", Replacement: "receive", Fuzzy: true}, &substitute.Result{Replacements: 1, Matched: []string{"receve"}}))
+	assert.Equal(t, `s/ Replaced 2 occurrences of "receve", "recieve" (close to "recieve") with "receive"`, confirmationMessage(&substitute.Substitution{Pattern: "recieve", Replacement: "receive", Fuzzy: true}, &substitute.Result{Replacements: 2, Matched: []string{"receve", "recieve"}}))
+	assert.Equal(t, `s/ Replaced 1 occurrence of "recieve" with "receive"`, confirmationMessage(&substitute.Substitution{Pattern: "recieve", Replacement: "receive", Fuzzy: true}, &substitute.Result{Replacements: 1, Matched: []string{"recieve"}}))
+	assert.Equal(t, `y/ Replaced 3 characters of "ab" with "xy"`, confirmationMessage(&substitute.Substitution{Pattern: "ab", Replacement: "xy", Transliteration: map[rune]rune{'a': 'x', 'b': 'y'}}, result))
+}
+
+func TestResolveNotificationStyle(t *testing.T) {
+	cases := []struct {
+		name     string
+		channel  string
+		user     string
+		global   string
+		expected string
+	}{
+		{"defaults", "", "", "", notifyEphemeral},
+		{"global", "", "", notifyNone, notifyNone},
+		{"user over global", "", notifyPublic, notifyNone, notifyPublic},
+		{"channel over user", notifyNone, notifyPublic, notifyEphemeral, notifyNone},
+		{"invalid values ignored", "loud", "", notifyPublic, notifyPublic},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			api := &plugintest.API{}
+			p := setupTestPlugin(t, api)
+			p.setConfiguration(&configuration{NotificationStyle: tc.global})
+
+			var data []byte
+			if tc.channel != "" {
+				data = []byte(tc.channel)
+			}
+			api.On("KVGet", channelNotificationKey("testChannelId")).Return(data, nil)
+
+			style, err := p.resolveNotificationStyle("testChannelId", &userPreferences{NotificationStyle: tc.user})
+
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, style)
+		})
+	}
+}
+
+func TestSendConfirmation(t *testing.T) {
+	user := &model.User{Id: "testUserId", Username: "test"}
+	notification := &model.Post{ChannelId: "testChannelId", Message: "s/ Replaced"}
+
+	t.Run("public", func(t *testing.T) {
+		api := &plugintest.API{}
+		p := setupTestPlugin(t, api)
+		p.botUserID = "botUserId"
+
+		api.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool {
+			return post.UserId == "botUserId" && post.ChannelId == "testChannelId" && post.Message == "@test s/ Replaced"
+		})).Return(&model.Post{}, nil)
+
+		p.sendConfirmation(notifyPublic, user, notification)
+
+		api.AssertExpectations(t)
+	})
+
+	t.Run("public without bot", func(t *testing.T) {
+		api := &plugintest.API{}
+		p := setupTestPlugin(t, api)
+
+		api.On("SendEphemeralPost", "testUserId", notification).Return(nil)
+
+		p.sendConfirmation(notifyPublic, user, notification)
+
+		api.AssertExpectations(t)
+	})
+
+	t.Run("none", func(t *testing.T) {
+		api := &plugintest.API{}
+		p := setupTestPlugin(t, api)
+
+		p.sendConfirmation(notifyNone, user, notification)
+
+		api.AssertExpectations(t)
+	})
+}
+
+func TestExecuteChannelCommand(t *testing.T) {
+	args := &model.CommandArgs{UserId: "testUserId", ChannelId: "testChannelId", Command: "/replace channel notifications public"}
+
+	t.Run("channel admin", func(t *testing.T) {
+		api := &plugintest.API{}
+		p := setupTestPlugin(t, api)
+
+		api.On("KVGet", channelNotificationKey("testChannelId")).Return(nil, nil)
+		api.On("GetChannel", "testChannelId").Return(&model.Channel{Id: "testChannelId", Type: model.CHANNEL_OPEN}, nil)
+		api.On("HasPermissionToChannel", "testUserId", "testChannelId", model.PERMISSION_MANAGE_PUBLIC_CHANNEL_PROPERTIES).Return(true)
+		api.On("KVSet", channelNotificationKey("testChannelId"), []byte(notifyPublic)).Return(nil)
+
+		response, appErr := p.ExecuteCommand(nil, args)
+
+		assert.Nil(t, appErr)
+		assert.Contains(t, response.Text, "now confirmed with style `public`")
+		api.AssertExpectations(t)
+	})
+
+	t.Run("regular member", func(t *testing.T) {
+		api := &plugintest.API{}
+		p := setupTestPlugin(t, api)
+
+		api.On("KVGet", channelNotificationKey("testChannelId")).Return(nil, nil)
+		api.On("GetChannel", "testChannelId").Return(&model.Channel{Id: "testChannelId", Type: model.CHANNEL_PRIVATE}, nil)
+		api.On("HasPermissionToChannel", "testUserId", "testChannelId", model.PERMISSION_MANAGE_PRIVATE_CHANNEL_PROPERTIES).Return(false)
+
+		response, appErr := p.ExecuteCommand(nil, args)
+
+		assert.Nil(t, appErr)
+		assert.Contains(t, response.Text, "Only channel admins")
+		api.AssertExpectations(t)
+	})
+}
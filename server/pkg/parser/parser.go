@@ -0,0 +1,583 @@
+// Package parser turns the text of an s/ or y/ command into its parts.
+//
+// The grammar, from the start of the trimmed input, is:
+//
+//	command     = [ address ] verb delimiter pattern delimiter replacement [ delimiter flags ] { space scope }
+//	address     = line [ "," line ]
+//	line        = digit { digit }
+//	verb        = "s" | "y"
+//	delimiter   = "/" | "|" | "#" | "!" | "~" | "%"
+//	pattern     = field
+//	replacement = field
+//	field       = { char | escape char }
+//	escape      = "\"
+//	flags       = { letter | digit } [ "~" digit { digit } ]
+//	scope       = key ":" value | "match" ":" ( value | quote phrase quote ) | permalink
+//	key         = "team" | "in" | "bot" | "u" | "id" | "thread" | "within" | "at"
+//	permalink   = url ending in "/pl/" post ID
+//	quote       = `"`
+//
+// The character following the verb is the delimiter of the whole command, so that text
+// containing slashes can be written as s|a/b|c/d| without escapes. An escape keeps the following
+// character, including the delimiter, from ending the field. Both
+// the escape and the character are kept as written, so that regular expression escapes such as
+// \b reach the matcher untouched. The pattern must not be empty. An empty replacement deletes the
+// pattern and must be followed by the closing delimiter, as in s/typo//, so that an unfinished
+// s/typo/ is not taken for a deletion. A field may not end with a dangling escape. Scopes are only recognized at the very end of the input, so that
+// replacement text containing a colon is left alone. A quoted phrase may contain spaces but no
+// quotes.
+//
+// The command is split into tokens by a lexer and read by a recursive descent parser. Input that
+// does not follow the grammar is reported as a *SyntaxError locating the problem.
+//
+// An address restricts the command to a line of a multi-line post, counted from 1, or to a range
+// of lines: 2s/foo/bar/ only edits the second line and 2,4s/foo/bar/ the second to the fourth.
+//
+// Several commands may be given in one message, separated by a semicolon followed by the next
+// command, as in s/teh/the/; s/recieve/receive/. ParseScript reads them; scopes may only follow
+// the last one and apply to all of them.
+//
+// A number after a tilde at the end of the flags selects an older post of the user: s/foo/bar/~3
+// edits the third most recent one. It is not available with ~ as the delimiter.
+//
+// The verb y transliterates, as in sed: y/abc/xyz/ replaces each a with x, b with y and c with z.
+// It takes no flags, and its closing delimiter is required for IsCommand to recognize it.
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Verbs of the commands.
+const (
+	VerbSubstitute    = 's'
+	VerbTransliterate = 'y'
+)
+
+const escape = '\\'
+
+// Delimiters lists the characters that may separate the fields of a command, the first being the
+// usual one.
+const Delimiters = "/|#!~%"
+
+// scopePattern matches a single key:value scope token.
+var scopePattern = regexp.MustCompile(`^(team|in|bot|u|match|id|thread|within|at):([^\s/"]+)$`)
+
+// permalinkPattern matches the permalink of a post, such as https://chat.example.com/team/pl/<id>,
+// capturing the post ID.
+var permalinkPattern = regexp.MustCompile(`^https?://\S+/pl/([a-z0-9]{26})/?$`)
+
+// quotedScopePattern matches a trailing scope whose value is a quoted phrase.
+var quotedScopePattern = regexp.MustCompile(`\s(match):"([^"]+)"$`)
+
+// Command is a parsed s/ or y/ command.
+type Command struct {
+	// Verb is VerbSubstitute or VerbTransliterate.
+	Verb rune
+
+	// Delimiter is the character separating the fields of the command.
+	Delimiter rune
+
+	// FirstLine and LastLine are the lines of the address, counted from 1, or 0 when the command
+	// applies to the whole post. LastLine equals FirstLine for a single line.
+	FirstLine int
+	LastLine  int
+
+	Pattern     string
+	Replacement string
+	Flags       string
+
+	// Rank selects the nth most recent post of the user, counted from 1, as given by ~n after
+	// the flags. It is 0 when not given, which selects the most recent post too.
+	Rank int
+
+	// Scopes holds the trailing key:value tokens selecting which post to edit, by key.
+	Scopes map[string]string
+}
+
+// SyntaxError reports where the input deviates from the grammar.
+type SyntaxError struct {
+	// Column is the position of the problem in the trimmed input, counted in characters from 1.
+	Column int
+
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s at column %d", e.Msg, e.Column)
+}
+
+func syntaxErrorf(column int, format string, args ...interface{}) *SyntaxError {
+	return &SyntaxError{Column: column, Msg: fmt.Sprintf(format, args...)}
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenDelimiter
+	tokenText
+)
+
+// token is a lexical unit of a command. Text tokens hold the characters of a field between two
+// delimiters, escapes included.
+type token struct {
+	kind   tokenKind
+	text   string
+	column int
+}
+
+// lexer splits the body of a command, without its scopes, into tokens.
+type lexer struct {
+	input     []rune
+	pos       int
+	delimiter rune
+}
+
+// column returns the column of the next unread character.
+func (l *lexer) column() int {
+	return l.pos + 1
+}
+
+// skipSpace skips any white space at the current position.
+func (l *lexer) skipSpace() {
+	for l.pos < len(l.input) && unicode.IsSpace(l.input[l.pos]) {
+		l.pos++
+	}
+}
+
+// next returns the next token. A text token ends at the first delimiter not preceded by an
+// escape.
+func (l *lexer) next() (token, error) {
+	column := l.column()
+	if l.pos >= len(l.input) {
+		return token{kind: tokenEOF, column: column}, nil
+	}
+
+	if l.input[l.pos] == l.delimiter {
+		l.pos++
+		return token{kind: tokenDelimiter, text: string(l.delimiter), column: column}, nil
+	}
+
+	start := l.pos
+	for l.pos < len(l.input) && l.input[l.pos] != l.delimiter {
+		if l.input[l.pos] == escape {
+			if l.pos+1 == len(l.input) {
+				return token{}, syntaxErrorf(l.column(), "dangling escape")
+			}
+			l.pos++
+		}
+		l.pos++
+	}
+
+	return token{kind: tokenText, text: string(l.input[start:l.pos]), column: column}, nil
+}
+
+// parser reads a command from the tokens of a lexer.
+type parser struct {
+	lex  *lexer
+	peek *token
+}
+
+// next consumes and returns the next token.
+func (p *parser) next() (token, error) {
+	if p.peek != nil {
+		tok := *p.peek
+		p.peek = nil
+		return tok, nil
+	}
+
+	return p.lex.next()
+}
+
+// field reads an optional text token, returning its text and the column it starts at. A missing
+// field is empty.
+func (p *parser) field() (string, int, error) {
+	tok, err := p.next()
+	if err != nil {
+		return "", 0, err
+	}
+
+	if tok.kind != tokenText {
+		p.peek = &tok
+		return "", tok.column, nil
+	}
+
+	return tok.text, tok.column, nil
+}
+
+// Parse parses input according to the package grammar.
+func Parse(input string) (*Command, error) {
+	body, scopes := splitScopes(strings.TrimSpace(input))
+
+	runes := []rune(body)
+	start := addressLength(runes)
+	delimiter, ok := delimiterOf(runes[start:])
+	if !ok {
+		return nil, syntaxErrorf(start+1, "command must start with s or y followed by a delimiter such as /")
+	}
+
+	first, last, err := parseAddress(runes[:start])
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{lex: &lexer{input: runes, pos: start + 2, delimiter: delimiter}}
+	p.lex.skipSpace()
+
+	cmd, err := p.command(runes[start], scopes)
+	if err != nil {
+		return nil, err
+	}
+	cmd.FirstLine, cmd.LastLine = first, last
+
+	return cmd, nil
+}
+
+// addressLength returns the number of characters of the address at the start of input.
+func addressLength(input []rune) int {
+	n := digits(input)
+	if n > 0 && n+1 < len(input) && input[n] == ',' && digits(input[n+1:]) > 0 {
+		n += 1 + digits(input[n+1:])
+	}
+
+	return n
+}
+
+// digits returns the number of ASCII digits at the start of input.
+func digits(input []rune) int {
+	n := 0
+	for n < len(input) && input[n] >= '0' && input[n] <= '9' {
+		n++
+	}
+
+	return n
+}
+
+// parseAddress returns the lines of an address, or zeros when it is empty.
+func parseAddress(address []rune) (int, int, error) {
+	if len(address) == 0 {
+		return 0, 0, nil
+	}
+
+	fields := strings.SplitN(string(address), ",", 2)
+	first, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return 0, 0, syntaxErrorf(1, "line number %s is too large", fields[0])
+	}
+	if first == 0 {
+		return 0, 0, syntaxErrorf(1, "lines are counted from 1")
+	}
+
+	if len(fields) == 1 {
+		return first, first, nil
+	}
+
+	column := len(fields[0]) + 2
+	last, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return 0, 0, syntaxErrorf(column, "line number %s is too large", fields[1])
+	}
+	if last < first {
+		return 0, 0, syntaxErrorf(column, "line range %d,%d ends before it starts", first, last)
+	}
+
+	return first, last, nil
+}
+
+// ParseScript parses input as one or more commands separated by semicolons. The columns of
+// syntax errors count from the start of the whole input.
+func ParseScript(input string) ([]*Command, error) {
+	parts := splitScript(strings.TrimSpace(input))
+
+	commands := make([]*Command, 0, len(parts))
+	for i, part := range parts {
+		cmd, err := Parse(part.text)
+		if syntaxErr, ok := err.(*SyntaxError); ok {
+			return nil, syntaxErrorf(part.column+syntaxErr.Column-1, "%s", syntaxErr.Msg)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if i < len(parts)-1 && len(cmd.Scopes) > 0 {
+			return nil, syntaxErrorf(part.column, "scopes must follow the last command")
+		}
+		if i < len(parts)-1 && cmd.Rank > 0 {
+			return nil, syntaxErrorf(part.column, "~n must follow the last command")
+		}
+
+		commands = append(commands, cmd)
+	}
+
+	last := commands[len(commands)-1]
+	for _, cmd := range commands {
+		cmd.Scopes = last.Scopes
+		cmd.Rank = last.Rank
+	}
+
+	return commands, nil
+}
+
+// scriptPart is one command of a script and the column it starts at.
+type scriptPart struct {
+	text   string
+	column int
+}
+
+// splitScript splits input at each semicolon not preceded by an escape and followed, after any
+// white space, by the start of another command.
+func splitScript(input string) []scriptPart {
+	var parts []scriptPart
+
+	runes := []rune(input)
+	start := 0
+	for i := 0; i < len(runes); i++ {
+		if runes[i] == escape {
+			i++
+			continue
+		}
+
+		if runes[i] != ';' {
+			continue
+		}
+
+		next := i + 1
+		for next < len(runes) && unicode.IsSpace(runes[next]) {
+			next++
+		}
+
+		if _, ok := delimiterOf(runes[next+addressLength(runes[next:]):]); !ok {
+			continue
+		}
+
+		parts = append(parts, scriptPart{text: string(runes[start:i]), column: start + 1})
+		start = next
+		i = next - 1
+	}
+
+	return append(parts, scriptPart{text: string(runes[start:]), column: start + 1})
+}
+
+// IsCommand reports whether input, trimmed of white space, is meant as a command. Anything
+// starting with s/ is, while other delimiters must appear again to separate the pattern from
+// the replacement, so that messages such as "s#1 is done" are left alone. A y command must be
+// complete, ending with its delimiter before any scopes, so that "y/n" is not taken for one.
+func IsCommand(input string) bool {
+	input = strings.TrimSpace(input)
+	if strings.HasPrefix(input, "s/") {
+		return true
+	}
+
+	runes := []rune(input)
+	start := addressLength(runes)
+	delimiter, ok := delimiterOf(runes[start:])
+	if !ok {
+		return false
+	}
+
+	if runes[start] == VerbTransliterate {
+		body, _ := splitScopes(input)
+		rest := string([]rune(body)[start+2:])
+		return strings.Count(rest, string(delimiter)) >= 2 && strings.HasSuffix(rest, string(delimiter))
+	}
+
+	return strings.ContainsRune(string(runes[start+2:]), delimiter)
+}
+
+// delimiterOf returns the delimiter following the verb at the start of input.
+func delimiterOf(input []rune) (rune, bool) {
+	if len(input) < 2 || input[0] != VerbSubstitute && input[0] != VerbTransliterate || !strings.ContainsRune(Delimiters, input[1]) {
+		return 0, false
+	}
+
+	return input[1], true
+}
+
+// command reads everything after the verb and its delimiter.
+func (p *parser) command(verb rune, scopes map[string]string) (*Command, error) {
+	cmd := &Command{Verb: verb, Delimiter: p.lex.delimiter, Scopes: scopes}
+
+	pattern, column, err := p.field()
+	if err != nil {
+		return nil, err
+	}
+
+	tok, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+
+	if tok.kind == tokenEOF {
+		if pattern == "" {
+			return nil, syntaxErrorf(column, "missing pattern and replacement")
+		}
+		return nil, syntaxErrorf(column, "unterminated pattern")
+	}
+
+	if pattern == "" {
+		return nil, syntaxErrorf(column, "empty pattern")
+	}
+	cmd.Pattern = pattern
+
+	cmd.Replacement, column, err = p.field()
+	if err != nil {
+		return nil, err
+	}
+
+	tok, err = p.next()
+	if err != nil {
+		return nil, err
+	}
+
+	if tok.kind == tokenEOF {
+		if cmd.Replacement == "" {
+			return nil, syntaxErrorf(column, "empty replacement, end the command with %q to delete the pattern", cmd.Delimiter)
+		}
+		return cmd, nil
+	}
+
+	cmd.Flags, column, err = p.field()
+	if err != nil {
+		return nil, err
+	}
+
+	if i := strings.IndexRune(cmd.Flags, '~'); i >= 0 {
+		rankColumn := column + utf8.RuneCountInString(cmd.Flags[:i])
+		if cmd.Rank, err = parseRank(cmd.Flags[i+1:], rankColumn); err != nil {
+			return nil, err
+		}
+		cmd.Flags = cmd.Flags[:i]
+	}
+
+	if verb == VerbTransliterate && cmd.Flags != "" {
+		return nil, syntaxErrorf(column, "y takes no flags")
+	}
+
+	for i, flag := range []rune(cmd.Flags) {
+		if !unicode.IsLetter(flag) && !unicode.IsDigit(flag) {
+			return nil, syntaxErrorf(column+i, "invalid character %q in flags", flag)
+		}
+	}
+
+	tok, err = p.next()
+	if err != nil {
+		return nil, err
+	}
+
+	if tok.kind != tokenEOF {
+		return nil, syntaxErrorf(tok.column, "unexpected %q after the flags", cmd.Delimiter)
+	}
+
+	return cmd, nil
+}
+
+// maxRank bounds the post selected by ~n, far beyond the posts a search returns.
+const maxRank = 1000
+
+// parseRank reads the number following the ~ found at column.
+func parseRank(text string, column int) (int, error) {
+	if text == "" || digits([]rune(text)) != len([]rune(text)) {
+		return 0, syntaxErrorf(column, "~ must be followed by the number of the post, such as ~2")
+	}
+
+	rank, err := strconv.Atoi(text)
+	if err != nil || rank > maxRank {
+		return 0, syntaxErrorf(column+1, "post ~%s is too far back", text)
+	}
+	if rank == 0 {
+		return 0, syntaxErrorf(column+1, "posts are counted from 1")
+	}
+
+	return rank, nil
+}
+
+// String renders the command back into the grammar. Parsing the result yields the same command.
+func (c *Command) String() string {
+	delim := string(c.Delimiter)
+	result := string(c.Verb) + delim
+	switch {
+	case c.FirstLine > 0 && c.LastLine > c.FirstLine:
+		result = fmt.Sprintf("%d,%d", c.FirstLine, c.LastLine) + result
+	case c.FirstLine > 0:
+		result = strconv.Itoa(c.FirstLine) + result
+	}
+	result += c.Pattern + delim + c.Replacement + delim + c.Flags
+	if c.Rank > 0 {
+		result += "~" + strconv.Itoa(c.Rank)
+	}
+
+	keys := make([]string, 0, len(c.Scopes))
+	for key := range c.Scopes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if key == "match" {
+			result += " " + key + `:"` + c.Scopes[key] + `"`
+			continue
+		}
+		result += " " + key + ":" + c.Scopes[key]
+	}
+
+	return result
+}
+
+// splitScopes removes the trailing scope tokens, such as team:engineering or in:deploys, from
+// input and returns them by key. Channel, team, bot and user names may be written with a leading ~
+// or @.
+// A permalink is taken for the id scope of the post it points to.
+func splitScopes(input string) (string, map[string]string) {
+	scopes := make(map[string]string)
+
+	for {
+		if match := quotedScopePattern.FindStringSubmatch(input); match != nil {
+			if _, ok := scopes[match[1]]; !ok {
+				scopes[match[1]] = match[2]
+			}
+			input = strings.TrimSpace(strings.TrimSuffix(input, match[0]))
+			continue
+		}
+
+		index := strings.LastIndexFunc(input, unicode.IsSpace)
+		if index < 0 {
+			break
+		}
+
+		_, size := utf8.DecodeRuneInString(input[index:])
+		if link := permalinkPattern.FindStringSubmatch(input[index+size:]); link != nil {
+			if _, ok := scopes["id"]; !ok {
+				scopes["id"] = link[1]
+			}
+			input = strings.TrimSpace(input[:index])
+			continue
+		}
+
+		match := scopePattern.FindStringSubmatch(input[index+size:])
+		if match == nil {
+			break
+		}
+
+		value := match[2]
+		if match[1] != "match" {
+			value = strings.TrimLeft(value, "~@")
+		}
+		if value == "" {
+			break
+		}
+
+		if _, ok := scopes[match[1]]; !ok {
+			scopes[match[1]] = value
+		}
+		input = strings.TrimSpace(input[:index])
+	}
+
+	return input, scopes
+}
@@ -0,0 +1,47 @@
+//go:build go1.18
+// +build go1.18
+
+package parser
+
+import (
+	"testing"
+)
+
+// FuzzParse checks that Parse never panics and that every command it accepts survives a round
+// trip through String. Run with: go test ./server/pkg/parser -fuzz FuzzParse
+func FuzzParse(f *testing.F) {
+	for _, seed := range []string{
+		"s/bee/be",
+		"s/bee/be/c",
+		`s/a\/b/c\\/`,
+		"s/old/new team:engineering in:~deploys",
+		"s/old/see in:deploys later",
+		`s/old/new match:"deploy failed"`,
+		"s|a/b|c/d|g",
+		"s//",
+		"s/typo//",
+		`s/a/b\`,
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		cmd, err := Parse(input)
+		if err != nil {
+			return
+		}
+
+		if cmd.Pattern == "" {
+			t.Fatalf("Parse(%q) accepted an empty pattern: %#v", input, cmd)
+		}
+
+		again, err := Parse(cmd.String())
+		if err != nil {
+			t.Fatalf("Parse(%q) failed on rendered command %q: %v", input, cmd.String(), err)
+		}
+
+		if again.String() != cmd.String() {
+			t.Fatalf("round trip of %q changed %q into %q", input, cmd.String(), again.String())
+		}
+	})
+}
@@ -0,0 +1,163 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected *Command
+	}{
+		{"s/bee/be", &Command{Verb: 's', Delimiter: '/', Pattern: "bee", Replacement: "be", Scopes: map[string]string{}}},
+		{" s/ bee/be ", &Command{Verb: 's', Delimiter: '/', Pattern: "bee", Replacement: "be", Scopes: map[string]string{}}},
+		{"s/bee/be/", &Command{Verb: 's', Delimiter: '/', Pattern: "bee", Replacement: "be", Scopes: map[string]string{}}},
+		{"s/bee/be/c", &Command{Verb: 's', Delimiter: '/', Pattern: "bee", Replacement: "be", Flags: "c", Scopes: map[string]string{}}},
+		{`s/a\/b/c`, &Command{Verb: 's', Delimiter: '/', Pattern: `a\/b`, Replacement: "c", Scopes: map[string]string{}}},
+		{`s/\bx\b/y`, &Command{Verb: 's', Delimiter: '/', Pattern: `\bx\b`, Replacement: "y", Scopes: map[string]string{}}},
+		{"s/old/new team:engineering in:deploys", &Command{Verb: 's', Delimiter: '/', Pattern: "old", Replacement: "new", Scopes: map[string]string{"team": "engineering", "in": "deploys"}}},
+		{"s/old/new/c in:~deploys", &Command{Verb: 's', Delimiter: '/', Pattern: "old", Replacement: "new", Flags: "c", Scopes: map[string]string{"in": "deploys"}}},
+		{"s/old/new text in:deploys", &Command{Verb: 's', Delimiter: '/', Pattern: "old", Replacement: "new text", Scopes: map[string]string{"in": "deploys"}}},
+		{"s/old/see in:deploys later", &Command{Verb: 's', Delimiter: '/', Pattern: "old", Replacement: "see in:deploys later", Scopes: map[string]string{}}},
+		{"s/old/new/ https://chat.example.com/eng/pl/abcdefghijklmnopqrstuvwxyz", &Command{Verb: 's', Delimiter: '/', Pattern: "old", Replacement: "new", Scopes: map[string]string{"id": "abcdefghijklmnopqrstuvwxyz"}}},
+		{"s/old/new id:abcdefghijklmnopqrstuvwxyz", &Command{Verb: 's', Delimiter: '/', Pattern: "old", Replacement: "new", Scopes: map[string]string{"id": "abcdefghijklmnopqrstuvwxyz"}}},
+		{"s|old|see https://chat.example.com/eng/pl/short", &Command{Verb: 's', Delimiter: '|', Pattern: "old", Replacement: "see https://chat.example.com/eng/pl/short", Scopes: map[string]string{}}},
+		{"s/old/new thread:root", &Command{Verb: 's', Delimiter: '/', Pattern: "old", Replacement: "new", Scopes: map[string]string{"thread": "root"}}},
+		{"s/old/new bot:@deploybot", &Command{Verb: 's', Delimiter: '/', Pattern: "old", Replacement: "new", Scopes: map[string]string{"bot": "deploybot"}}},
+		{"s/old/new u:@alice", &Command{Verb: 's', Delimiter: '/', Pattern: "old", Replacement: "new", Scopes: map[string]string{"u": "alice"}}},
+		{"s/old/new/ at:17:00", &Command{Verb: 's', Delimiter: '/', Pattern: "old", Replacement: "new", Scopes: map[string]string{"at": "17:00"}}},
+		{`s/old/new match:"deploy failed"`, &Command{Verb: 's', Delimiter: '/', Pattern: "old", Replacement: "new", Scopes: map[string]string{"match": "deploy failed"}}},
+		{`s/old/new/c match:@here in:deploys`, &Command{Verb: 's', Delimiter: '/', Pattern: "old", Replacement: "new", Flags: "c", Scopes: map[string]string{"match": "@here", "in": "deploys"}}},
+		{`s/old/say "hi"`, &Command{Verb: 's', Delimiter: '/', Pattern: "old", Replacement: `say "hi"`, Scopes: map[string]string{}}},
+		{"s/old/ratio 1:2", &Command{Verb: 's', Delimiter: '/', Pattern: "old", Replacement: "ratio 1:2", Scopes: map[string]string{}}},
+		{"s/old/new in:a/", &Command{Verb: 's', Delimiter: '/', Pattern: "old", Replacement: "new in:a", Scopes: map[string]string{}}},
+		{"s|a/b|c/d|g", &Command{Verb: 's', Delimiter: '|', Pattern: "a/b", Replacement: "c/d", Flags: "g", Scopes: map[string]string{}}},
+		{`s#a\#b#c in:deploys`, &Command{Verb: 's', Delimiter: '#', Pattern: `a\#b`, Replacement: "c", Scopes: map[string]string{"in": "deploys"}}},
+		{"s/typo//", &Command{Verb: 's', Delimiter: '/', Pattern: "typo", Replacement: "", Scopes: map[string]string{}}},
+		{"s/typo//g in:deploys", &Command{Verb: 's', Delimiter: '/', Pattern: "typo", Replacement: "", Flags: "g", Scopes: map[string]string{"in": "deploys"}}},
+		{"2s/foo/bar/", &Command{Verb: 's', Delimiter: '/', FirstLine: 2, LastLine: 2, Pattern: "foo", Replacement: "bar", Scopes: map[string]string{}}},
+		{"2,14s|a|b|g", &Command{Verb: 's', Delimiter: '|', FirstLine: 2, LastLine: 14, Pattern: "a", Replacement: "b", Flags: "g", Scopes: map[string]string{}}},
+		{"s/bee/be/gi~3", &Command{Verb: 's', Delimiter: '/', Pattern: "bee", Replacement: "be", Flags: "gi", Rank: 3, Scopes: map[string]string{}}},
+		{"s/bee/be/~2 in:deploys", &Command{Verb: 's', Delimiter: '/', Pattern: "bee", Replacement: "be", Rank: 2, Scopes: map[string]string{"in": "deploys"}}},
+		{"y/abc/xyz/", &Command{Verb: 'y', Delimiter: '/', Pattern: "abc", Replacement: "xyz", Scopes: map[string]string{}}},
+		{"y|a/|b_| in:deploys", &Command{Verb: 'y', Delimiter: '|', Pattern: "a/", Replacement: "b_", Scopes: map[string]string{"in": "deploys"}}},
+		{"hello", nil},
+		{"s?a?b", nil},
+		{"s/", nil},
+		{"s//", nil},
+		{"s/bad", nil},
+		{"s/baaad/", nil},
+		{"s//bar", nil},
+		{"s/a/b/c/d", nil},
+		{"s/a/b/g i", nil},
+		{`s/a/b\`, nil},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.input, func(t *testing.T) {
+			cmd, err := Parse(tc.input)
+			if tc.expected == nil {
+				assert.NotNil(t, err)
+				return
+			}
+
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, cmd)
+		})
+	}
+}
+
+func TestParseSyntaxError(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected string
+	}{
+		{"y/ab/xy/g", "y takes no flags at column 9"},
+		{"0s/a/b/", "lines are counted from 1 at column 1"},
+		{"4,2s/a/b/", "line range 4,2 ends before it starts at column 3"},
+		{"2,s/a/b/", "command must start with s or y followed by a delimiter such as / at column 2"},
+		{"3s/a", "unterminated pattern at column 4"},
+		{"hello", "command must start with s or y followed by a delimiter such as / at column 1"},
+		{"s|a|b|c|", "unexpected '|' after the flags at column 8"},
+		{"s/", "missing pattern and replacement at column 3"},
+		{"s//bar", "empty pattern at column 3"},
+		{"s/bad", "unterminated pattern at column 3"},
+		{"s/  bad", "unterminated pattern at column 5"},
+		{"s/baaad/", "empty replacement, end the command with '/' to delete the pattern at column 9"},
+		{"s/a/b/c/d", "unexpected '/' after the flags at column 8"},
+		{"s/a/b/g i", "invalid character ' ' in flags at column 8"},
+		{`s/a/b\`, "dangling escape at column 6"},
+		{"s/é/b/cö!", "invalid character '!' in flags at column 9"},
+		{"s/a/b/g~", "~ must be followed by the number of the post, such as ~2 at column 8"},
+		{"s/a/b/~2g", "~ must be followed by the number of the post, such as ~2 at column 7"},
+		{"s/a/b/~0", "posts are counted from 1 at column 8"},
+		{"s/a/b/~5000", "post ~5000 is too far back at column 8"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.input, func(t *testing.T) {
+			_, err := Parse(tc.input)
+			if assert.IsType(t, &SyntaxError{}, err) {
+				assert.Equal(t, tc.expected, err.Error())
+			}
+		})
+	}
+}
+
+func TestIsCommand(t *testing.T) {
+	for _, input := range []string{"s/", " s/old/new", "s|old|new", "s#a#b#", "s%a/b%c", "y/ab/xy/", "y|a|b| in:deploys", "2s/a/b", "1,3y/a/b/"} {
+		assert.True(t, IsCommand(input), input)
+	}
+
+	for _, input := range []string{"hello", "s", "s#1 is done", "s!", "s~old", "s?a?b?", "y/n", "y/n/maybe", "y/a/b/c", "2s/day", "10,20"} {
+		assert.False(t, IsCommand(input), input)
+	}
+}
+
+func TestString(t *testing.T) {
+	addressed := &Command{Verb: 's', Delimiter: '/', FirstLine: 2, LastLine: 3, Pattern: "a", Replacement: "b", Scopes: map[string]string{}}
+	assert.Equal(t, "2,3s/a/b/", addressed.String())
+
+	ranked := &Command{Verb: 's', Delimiter: '/', Pattern: "a", Replacement: "b", Flags: "g", Rank: 2, Scopes: map[string]string{}}
+	assert.Equal(t, "s/a/b/g~2", ranked.String())
+
+	cmd := &Command{Verb: 's', Delimiter: '/', Pattern: `a\/b`, Replacement: "c d", Flags: "c", Scopes: map[string]string{"in": "deploys", "match": "x y", "team": "eng"}}
+	assert.Equal(t, `s/a\/b/c d/c in:deploys match:"x y" team:eng`, cmd.String())
+
+	parsed, err := Parse(cmd.String())
+	assert.Nil(t, err)
+	assert.Equal(t, cmd, parsed)
+}
+
+func TestParseScript(t *testing.T) {
+	cmds, err := ParseScript("s/teh/the/; s/recieve/receive/g;y/ab/xy/ in:deploys")
+	assert.Nil(t, err)
+	if assert.Len(t, cmds, 3) {
+		assert.Equal(t, &Command{Verb: 's', Delimiter: '/', Pattern: "teh", Replacement: "the", Scopes: map[string]string{"in": "deploys"}}, cmds[0])
+		assert.Equal(t, &Command{Verb: 's', Delimiter: '/', Pattern: "recieve", Replacement: "receive", Flags: "g", Scopes: map[string]string{"in": "deploys"}}, cmds[1])
+		assert.Equal(t, 'y', cmds[2].Verb)
+	}
+
+	cmds, err = ParseScript("s/a/b; c/")
+	assert.Nil(t, err)
+	if assert.Len(t, cmds, 1) {
+		assert.Equal(t, "b; c", cmds[0].Replacement)
+	}
+
+	_, err = ParseScript("s/a/b/; s/c")
+	assert.EqualError(t, err, "unterminated pattern at column 11")
+
+	_, err = ParseScript("s/a/b in:x; s/c/d")
+	assert.EqualError(t, err, "scopes must follow the last command at column 1")
+
+	_, err = ParseScript("s/a/b/~2; s/c/d")
+	assert.EqualError(t, err, "~n must follow the last command at column 1")
+
+	cmds, err = ParseScript("s/a/b/; s/c/d/~2")
+	assert.Nil(t, err)
+	if assert.Len(t, cmds, 2) {
+		assert.Equal(t, 2, cmds[0].Rank)
+	}
+}
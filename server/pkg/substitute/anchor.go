@@ -0,0 +1,42 @@
+package substitute
+
+// Anchors of a pattern made of a single anchor, as in s/^/FYI: / or s/$/ (edited)/.
+const (
+	anchorStart = "^"
+	anchorEnd   = "$"
+)
+
+// isAnchor reports whether s only prepends or appends its replacement to the message. Such a
+// pattern would otherwise match at the start or end of every part of the message left outside
+// code blocks. With the m flag the anchor matches on every line instead.
+func (s *Substitution) isAnchor() bool {
+	return !s.Literal && !s.Fuzzy && !s.Multiline && s.Transliteration == nil && (s.Pattern == anchorStart || s.Pattern == anchorEnd)
+}
+
+// previewAnchor adds the replacement at the start or end of the whole message.
+func (s *Substitution) previewAnchor(message string) *Result {
+	result := &Result{Original: message, Message: message}
+	if s.Occurrence > 1 {
+		return result
+	}
+
+	re, err := compile(s.Pattern)
+	if err != nil {
+		return result
+	}
+	text := string(s.expand(re, "", []int{0, 0}))
+
+	if s.isAddressed() {
+		result.Message, result.Replacements = s.addToLines(message, text, s.Pattern == anchorStart)
+		return result
+	}
+
+	if s.Pattern == anchorStart {
+		result.Message = text + message
+	} else {
+		result.Message = message + text
+	}
+	result.Replacements = 1
+
+	return result
+}
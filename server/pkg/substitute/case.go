@@ -0,0 +1,109 @@
+package substitute
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// caseSpan is a part of a replacement template expanded under one case conversion.
+type caseSpan struct {
+	template string
+	convert  func(string) string
+}
+
+// splitCaseEscapes splits a template at the perl style case escapes: \U upper cases the
+// expansion up to the next escape, \L lower cases it and \E ends the conversion.
+func splitCaseEscapes(template string) []caseSpan {
+	var spans []caseSpan
+	var current strings.Builder
+	var convert func(string) string
+
+	for i := 0; i < len(template); i++ {
+		if template[i] != '\\' || i+1 == len(template) {
+			current.WriteByte(template[i])
+			continue
+		}
+
+		next := convert
+		switch template[i+1] {
+		case 'U':
+			next = strings.ToUpper
+		case 'L':
+			next = strings.ToLower
+		case 'E':
+			next = nil
+		default:
+			current.WriteString(template[i : i+2])
+			i++
+			continue
+		}
+
+		spans = append(spans, caseSpan{template: current.String(), convert: convert})
+		current.Reset()
+		convert = next
+		i++
+	}
+
+	return append(spans, caseSpan{template: current.String(), convert: convert})
+}
+
+// expand returns the replacement of the given match of re in segment.
+func (s *Substitution) expand(re *regexp.Regexp, segment string, match []int) []byte {
+	if s.Transliteration != nil {
+		return s.transliterate(segment[match[0]:match[1]])
+	}
+
+	var expansion []byte
+	if s.Literal {
+		expansion = re.ExpandString(nil, s.template(), segment, match)
+	} else {
+		for _, span := range splitCaseEscapes(s.template()) {
+			text := re.ExpandString(nil, span.template, segment, match)
+			if span.convert != nil {
+				text = []byte(span.convert(string(text)))
+			}
+			expansion = append(expansion, text...)
+		}
+	}
+
+	if s.PreserveCase {
+		return []byte(preserveCase(segment[match[0]:match[1]], string(expansion)))
+	}
+
+	return expansion
+}
+
+// preserveCase gives replacement the case of matched: upper case when matched is written in
+// capitals, as in TEH, and a capital first letter when matched starts with one, as in Teh.
+// Otherwise replacement is kept as written.
+func preserveCase(matched, replacement string) string {
+	letters, upper := 0, 0
+	first := rune(0)
+	for _, r := range matched {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		if first == 0 {
+			first = r
+		}
+		letters++
+		if unicode.IsUpper(r) {
+			upper++
+		}
+	}
+
+	switch {
+	case letters > 1 && upper == letters:
+		return strings.ToUpper(replacement)
+	case unicode.IsUpper(first):
+		for i, r := range replacement {
+			if unicode.IsLetter(r) {
+				return replacement[:i] + string(unicode.ToUpper(r)) + replacement[i+utf8.RuneLen(r):]
+			}
+		}
+	}
+
+	return replacement
+}
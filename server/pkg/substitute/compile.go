@@ -0,0 +1,41 @@
+package substitute
+
+import (
+	"regexp"
+	"sync"
+)
+
+// maxCompiled caps the number of cached expressions; the cache is emptied when it is full.
+const maxCompiled = 512
+
+var (
+	compiledLock sync.RWMutex
+	compiled     = make(map[string]*regexp.Regexp)
+)
+
+// compile returns the compiled form of expr, reusing it across calls. Users tend to repeat the
+// same commands, so most lookups hit the cache. Patterns come from users, so invalid expressions
+// are expected and returned as errors.
+func compile(expr string) (*regexp.Regexp, error) {
+	compiledLock.RLock()
+	re, ok := compiled[expr]
+	compiledLock.RUnlock()
+	if ok {
+		return re, nil
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	compiledLock.Lock()
+	defer compiledLock.Unlock()
+
+	if len(compiled) >= maxCompiled {
+		compiled = make(map[string]*regexp.Regexp)
+	}
+	compiled[expr] = re
+
+	return re, nil
+}
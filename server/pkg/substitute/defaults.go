@@ -0,0 +1,56 @@
+package substitute
+
+import (
+	"github.com/pkg/errors"
+)
+
+// Defaults holds the settings a server applies to substitutions beyond what their flags say: the
+// mode used when the flags choose none, and options set by its configuration or the preferences
+// of the user. Clients previewing a command get them from the server, so that the preview
+// matches the edit.
+type Defaults struct {
+	// Regex and Literal choose the mode of substitutions whose flags leave it open, Regex taking
+	// precedence. Without either, patterns are regular expressions matched as whole words.
+	Regex   bool `json:"regex"`
+	Literal bool `json:"literal"`
+
+	PartialWords       bool `json:"partial_words"`
+	IncludeLinks       bool `json:"include_links"`
+	SkipHashtags       bool `json:"skip_hashtags"`
+	IncludeSpoilers    bool `json:"include_spoilers"`
+	CollapseWhitespace bool `json:"collapse_whitespace"`
+	SmartCase          bool `json:"smart_case"`
+}
+
+// Apply configures s with the defaults and validates it in its final form.
+func (d *Defaults) Apply(s *Substitution) error {
+	s.PartialWords = d.PartialWords
+	s.IncludeLinks = d.IncludeLinks
+	s.SkipHashtags = d.SkipHashtags
+	s.IncludeSpoilers = d.IncludeSpoilers
+	s.CollapseWhitespace = d.CollapseWhitespace
+	s.SmartCase = d.SmartCase
+
+	if !s.Regex && !s.Literal && !s.Fuzzy {
+		s.Regex = d.Regex
+		s.Literal = d.Literal && !d.Regex
+	}
+
+	return s.Validate()
+}
+
+// ApplyScript applies the defaults to each substitution of a script. Errors name the command they
+// concern when there are several.
+func (d *Defaults) ApplyScript(subs []*Substitution) error {
+	for i, s := range subs {
+		err := d.Apply(s)
+		if err != nil && len(subs) > 1 {
+			return errors.Wrapf(err, "command %d", i+1)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
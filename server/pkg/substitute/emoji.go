@@ -0,0 +1,38 @@
+package substitute
+
+import (
+	"regexp"
+	"strings"
+)
+
+// shortcodePattern matches emoji shortcodes such as :smile: or :+1:. A name made of digits alone
+// is not taken for one, so that times such as 10:30:45 stay editable.
+var shortcodePattern = regexp.MustCompile(`:[a-zA-Z0-9_+-]*[a-zA-Z+][a-zA-Z0-9_+-]*:`)
+
+// emojiFilter keeps emoji shortcodes whole unless the substitution includes them, so that
+// s/smile/grin/ leaves :smile: alone. A pattern holding a colon is meant to match shortcodes and is
+// not restricted.
+type emojiFilter struct{}
+
+func (emojiFilter) Name() string {
+	return "emoji"
+}
+
+func (emojiFilter) Exclude(message string, s *Substitution) [][]int {
+	if s.IncludeEmoji || strings.Contains(s.Pattern, ":") {
+		return nil
+	}
+
+	return findRegions(message, shortcodePattern)
+}
+
+func (emojiFilter) Describe(s *Substitution) string {
+	switch {
+	case s.IncludeEmoji:
+		return "Emoji shortcodes are included (j flag)."
+	case strings.Contains(s.Pattern, ":"):
+		return "Emoji shortcodes are included, since the pattern holds a colon."
+	}
+
+	return "Emoji shortcodes such as :smile: are skipped. Add the j flag to include them."
+}
@@ -0,0 +1,112 @@
+package substitute
+
+import (
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// supportedFlags lists the flags accepted after the replacement, for error messages.
+const supportedFlags = "a (message attachments too), b (include blockquotes), c (include code), C (code only), e (explain), f (fuzzy match), g (every occurrence), i (ignore case), j (include :emoji: shortcodes), l (literal text), m (^ and $ match at every line), n (preview without editing), p (preserve case), r (full regular expression), s (. matches newlines), u (include @mentions), w (whole words only) and a number n (nth occurrence)"
+
+// maxOccurrence bounds numeric flags, far above the number of matches a post can hold.
+const maxOccurrence = 100000
+
+// parseFlags sets the options of s given by flags, the field following the replacement. Letters
+// may be combined in any order but each given only once, along with at most one number, as in gi
+// or 2g.
+func (s *Substitution) parseFlags(flags string) error {
+	seen := make(map[rune]bool)
+	hasNumber := false
+
+	runes := []rune(flags)
+	for i := 0; i < len(runes); i++ {
+		flag := runes[i]
+
+		if isASCIIDigit(flag) {
+			if hasNumber {
+				return errors.New("only one occurrence number may be given")
+			}
+			hasNumber = true
+
+			end := i
+			for end < len(runes) && isASCIIDigit(runes[end]) {
+				end++
+			}
+
+			n, err := strconv.Atoi(string(runes[i:end]))
+			if err != nil || n > maxOccurrence {
+				return errors.Errorf("occurrence %s is too large", string(runes[i:end]))
+			}
+			if n == 0 {
+				return errors.New("occurrences are counted from 1")
+			}
+
+			s.Occurrence = n
+			i = end - 1
+			continue
+		}
+
+		if seen[flag] {
+			return errors.Errorf("flag %q is given twice", flag)
+		}
+		seen[flag] = true
+
+		switch flag {
+		case 'a':
+			s.Attachments = true
+		case 'b':
+			s.IncludeQuotes = true
+		case 'c':
+			s.IncludeCode = true
+		case 'C':
+			s.CodeOnly = true
+		case 'e':
+			s.Explain = true
+		case 'f':
+			s.Fuzzy = true
+		case 'g':
+			s.Global = true
+		case 'i':
+			s.IgnoreCase = true
+		case 'j':
+			s.IncludeEmoji = true
+		case 'l':
+			s.Literal = true
+		case 'm':
+			s.Multiline = true
+		case 'n':
+			s.DryRun = true
+		case 'p':
+			s.PreserveCase = true
+		case 'r':
+			s.Regex = true
+		case 's':
+			s.DotAll = true
+		case 'u':
+			s.IncludeMentions = true
+		case 'w':
+			s.WholeWords = true
+		default:
+			return errors.Errorf("unknown flag %q, supported flags are %s", flag, supportedFlags)
+		}
+	}
+
+	if s.IncludeCode && s.CodeOnly {
+		return errors.New("flags c and C cannot be combined")
+	}
+
+	if s.Literal && s.Regex {
+		return errors.New("flags l and r cannot be combined")
+	}
+
+	if s.Fuzzy && s.Regex {
+		return errors.New("flags f and r cannot be combined")
+	}
+
+	return nil
+}
+
+func isASCIIDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
@@ -0,0 +1,55 @@
+package substitute
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFlags(t *testing.T) {
+	cases := []struct {
+		flags    string
+		expected *Substitution
+	}{
+		{"", &Substitution{}},
+		{"gi", &Substitution{Global: true, IgnoreCase: true}},
+		{"ig", &Substitution{Global: true, IgnoreCase: true}},
+		{"2g", &Substitution{Global: true, Occurrence: 2}},
+		{"c10i", &Substitution{IncludeCode: true, IgnoreCase: true, Occurrence: 10}},
+		{"ag", &Substitution{Attachments: true, Global: true}},
+		{"ui", &Substitution{IncludeMentions: true, IgnoreCase: true}},
+		{"jg", &Substitution{IncludeEmoji: true, Global: true}},
+		{"bg", &Substitution{IncludeQuotes: true, Global: true}},
+		{"Cg", &Substitution{CodeOnly: true, Global: true}},
+		{"ni", &Substitution{DryRun: true, IgnoreCase: true}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.flags, func(t *testing.T) {
+			s := &Substitution{}
+			assert.Nil(t, s.parseFlags(tc.flags))
+			assert.Equal(t, tc.expected, s)
+		})
+	}
+
+	for flags, expected := range map[string]string{
+		"q":       "unknown flag 'q', supported flags are " + supportedFlags,
+		"gg":      "flag 'g' is given twice",
+		"2g3":     "only one occurrence number may be given",
+		"0":       "occurrences are counted from 1",
+		"9999999": "occurrence 9999999 is too large",
+		"٣":       "unknown flag '٣', supported flags are " + supportedFlags,
+		"fr":      "flags f and r cannot be combined",
+		"cC":      "flags c and C cannot be combined",
+	} {
+		err := (&Substitution{}).parseFlags(flags)
+		if assert.NotNil(t, err, flags) {
+			assert.Equal(t, expected, err.Error())
+		}
+	}
+}
+
+func TestPreviewOccurrenceOnward(t *testing.T) {
+	s := &Substitution{Pattern: "bee", Replacement: "be", Occurrence: 2, Global: true}
+	assert.Equal(t, &Result{Original: "bee bee bee", Message: "bee be be", Replacements: 2}, s.Preview("bee bee bee"))
+}
@@ -0,0 +1,141 @@
+package substitute
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxFuzzyDistance bounds the number of edits tolerated by the f flag, whatever the length of
+// the pattern.
+const maxFuzzyDistance = 3
+
+// fuzzyDistance returns the number of edits tolerated between a pattern of n characters and the
+// text it matches: one for every four characters, so that words shorter than four characters
+// only match exactly.
+func fuzzyDistance(n int) int {
+	distance := n / 4
+	if distance > maxFuzzyDistance {
+		return maxFuzzyDistance
+	}
+
+	return distance
+}
+
+// fuzzyCandidates returns the runs of whole words of segment, as many as in the pattern, that are
+// within the tolerated distance of the pattern. Case is ignored if the substitution ignores it.
+func (s *Substitution) fuzzyCandidates(segment string) []string {
+	pattern := unescape(s.Pattern)
+	words := len(strings.Fields(pattern))
+	if words == 0 {
+		return nil
+	}
+
+	fold := func(text string) []rune {
+		if s.ignoresCase() {
+			text = strings.ToLower(text)
+		}
+		return []rune(text)
+	}
+
+	target := fold(pattern)
+	limit := fuzzyDistance(len(target))
+
+	var candidates []string
+	spans := wordSpans(segment)
+	for i := 0; i+words <= len(spans); i++ {
+		text := segment[spans[i][0]:spans[i+words-1][1]]
+		if abs(utf8.RuneCountInString(text)-len(target)) > limit {
+			continue
+		}
+
+		if editDistance(fold(text), target) <= limit {
+			candidates = append(candidates, text)
+		}
+	}
+
+	return candidates
+}
+
+// fuzzyExpression extends expr, the expression matching the pattern exactly, to match any of the
+// candidates too. The exact pattern still matches where it is not made of words only, as in c++.
+func fuzzyExpression(expr string, candidates []string) string {
+	for _, candidate := range candidates {
+		expr += "|" + regexp.QuoteMeta(candidate)
+	}
+
+	return expr
+}
+
+// wordSpans returns the byte ranges of the words of text.
+func wordSpans(text string) [][]int {
+	var spans [][]int
+
+	start := -1
+	for i, r := range text {
+		switch {
+		case isWordChar(r) && start < 0:
+			start = i
+		case !isWordChar(r) && start >= 0:
+			spans = append(spans, []int{start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		spans = append(spans, []int{start, len(text)})
+	}
+
+	return spans
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b []rune) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min3(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+
+	return a
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+
+	return n
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
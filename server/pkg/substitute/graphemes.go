@@ -0,0 +1,67 @@
+package substitute
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// zeroWidthJoiner glues emoji into a single sequence, such as a family or a profession.
+const zeroWidthJoiner = '\u200d'
+
+// extendsCluster reports whether r continues the grapheme cluster of the rune before it rather
+// than starting a new one.
+func extendsCluster(r rune) bool {
+	switch {
+	case unicode.In(r, unicode.Mn, unicode.Me, unicode.Mc):
+		return true
+	case r == zeroWidthJoiner:
+		return true
+	case r >= '\ufe00' && r <= '\ufe0f': // variation selectors
+		return true
+	case r >= 0x1f3fb && r <= 0x1f3ff: // emoji skin tone modifiers
+		return true
+	case r >= 0xe0020 && r <= 0xe007f: // emoji tag sequences, used by subdivision flags
+		return true
+	}
+
+	return false
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1f1e6 && r <= 0x1f1ff
+}
+
+// isClusterBoundary reports whether byte offset i of s falls between two grapheme clusters, so
+// that cutting s there splits no combined emoji, flag or accented character. It implements the
+// subset of the Unicode segmentation rules that matters for chat messages.
+func isClusterBoundary(s string, i int) bool {
+	if i <= 0 || i >= len(s) {
+		return true
+	}
+
+	next, _ := utf8.DecodeRuneInString(s[i:])
+	previous, _ := utf8.DecodeLastRuneInString(s[:i])
+
+	switch {
+	case previous == '\r' && next == '\n':
+		return false
+	case extendsCluster(next):
+		return false
+	case previous == zeroWidthJoiner:
+		return false
+	case isRegionalIndicator(previous) && isRegionalIndicator(next):
+		// Flags are pairs of regional indicators: only break after an even number of them.
+		count := 0
+		for j := i; j > 0; {
+			r, size := utf8.DecodeLastRuneInString(s[:j])
+			if !isRegionalIndicator(r) {
+				break
+			}
+			count++
+			j -= size
+		}
+		return count%2 == 0
+	}
+
+	return true
+}
@@ -0,0 +1,46 @@
+package substitute
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsClusterBoundary(t *testing.T) {
+	assert.True(t, isClusterBoundary("ab", 1))
+	assert.False(t, isClusterBoundary("e\u0301", 1))
+	assert.False(t, isClusterBoundary("👩‍💻", len("👩")))
+	assert.False(t, isClusterBoundary("👍🏽", len("👍")))
+	assert.False(t, isClusterBoundary("🇺🇸", len("🇺")))
+	assert.True(t, isClusterBoundary("🇺🇸🇫🇷", len("🇺🇸")))
+	assert.False(t, isClusterBoundary("🇺🇸🇫🇷", len("🇺🇸🇫")))
+	assert.False(t, isClusterBoundary("\r\n", 1))
+}
+
+func TestReplaceMatchesKeepsGraphemeClusters(t *testing.T) {
+	cases := []struct {
+		pattern     string
+		replacement string
+		message     string
+		expected    string
+	}{
+		{"e", "a", "caf\u0065\u0301 e", "caf\u0065\u0301 a"},
+		{"👩", "👨", "👩‍💻 and 👩", "👩‍💻 and 👨"},
+		{"👍", "👎", "👍🏽 👍", "👍🏽 👎"},
+		{"🇸🇫", "🇫🇷", "🇺🇸🇫🇷 🇸🇫", "🇺🇸🇫🇷 🇫🇷"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.message, func(t *testing.T) {
+			s := &Substitution{Pattern: tc.pattern, Replacement: tc.replacement}
+			seen := 0
+			var matched []string
+			re, _ := compile(tc.pattern)
+			result, _ := s.replaceMatches(re, tc.message, &seen, &matched)
+			assert.Equal(t, tc.expected, result)
+		})
+	}
+
+	s := &Substitution{Pattern: "e", Replacement: "a"}
+	assert.Equal(t, "cafe\u0301 a", s.Apply("cafe\u0301 e"))
+}
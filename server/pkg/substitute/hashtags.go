@@ -0,0 +1,43 @@
+package substitute
+
+import (
+	"regexp"
+	"strings"
+)
+
+// hashtagPattern matches hashtags as Mattermost recognizes them: a # that does not follow a word
+// character, then a letter and at least two more letters, digits, dots, dashes or underscores.
+var hashtagPattern = regexp.MustCompile(`(?:^|[^\pL\pN_&])(#\pL[\pL\pN_.-]{2,})`)
+
+// hashtagFilter protects hashtags when the substitution asks for it, so that broad replacements
+// leave the tags a team searches by alone. A pattern holding a # is meant to match hashtags and
+// is not restricted.
+type hashtagFilter struct{}
+
+func (hashtagFilter) Name() string {
+	return "hashtags"
+}
+
+func (hashtagFilter) Exclude(message string, s *Substitution) [][]int {
+	if !s.SkipHashtags || strings.Contains(s.Pattern, "#") {
+		return nil
+	}
+
+	var regions [][]int
+	for _, match := range hashtagPattern.FindAllStringSubmatchIndex(message, -1) {
+		regions = append(regions, match[2:4])
+	}
+
+	return regions
+}
+
+func (hashtagFilter) Describe(s *Substitution) string {
+	switch {
+	case !s.SkipHashtags:
+		return ""
+	case strings.Contains(s.Pattern, "#"):
+		return "Hashtags are included, since the pattern holds a #."
+	}
+
+	return "Hashtags are skipped (hashtags setting)."
+}
@@ -0,0 +1,77 @@
+package substitute
+
+import (
+	"fmt"
+	"strings"
+)
+
+// isAddressed reports whether s only applies to some lines of the message.
+func (s *Substitution) isAddressed() bool {
+	return s.FirstLine > 0
+}
+
+// outsideLines returns the byte ranges of message outside the lines addressed by s, including
+// the newlines ending them, to be left untouched.
+func (s *Substitution) outsideLines(message string) [][]int {
+	start, end := len(message), len(message)
+
+	line, offset := 1, 0
+	for {
+		next := strings.IndexByte(message[offset:], '\n')
+		lineEnd := len(message)
+		if next >= 0 {
+			lineEnd = offset + next
+		}
+
+		if line == s.FirstLine {
+			start = offset
+		}
+		if line == s.LastLine || next < 0 && line >= s.FirstLine {
+			end = lineEnd
+			break
+		}
+		if next < 0 {
+			break
+		}
+
+		line++
+		offset = lineEnd + 1
+	}
+
+	var regions [][]int
+	if start > 0 {
+		regions = append(regions, []int{0, start})
+	}
+	if end < len(message) {
+		regions = append(regions, []int{end, len(message)})
+	}
+
+	return regions
+}
+
+// addToLines adds text at the start, or the end, of each line of message addressed by s. It
+// returns the new message and the number of lines changed.
+func (s *Substitution) addToLines(message, text string, atStart bool) (string, int) {
+	lines := strings.Split(message, "\n")
+
+	count := 0
+	for i := s.FirstLine - 1; i < len(lines) && i < s.LastLine; i++ {
+		if atStart {
+			lines[i] = text + lines[i]
+		} else {
+			lines[i] += text
+		}
+		count++
+	}
+
+	return strings.Join(lines, "\n"), count
+}
+
+// describeLines tells which lines of the message s applies to.
+func (s *Substitution) describeLines() string {
+	if s.LastLine > s.FirstLine {
+		return fmt.Sprintf("Only lines %d to %d of the post are edited.", s.FirstLine, s.LastLine)
+	}
+
+	return fmt.Sprintf("Only line %d of the post is edited.", s.FirstLine)
+}
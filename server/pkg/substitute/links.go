@@ -0,0 +1,53 @@
+package substitute
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	// linkTargetPattern matches the destination of an inline link or image, as in [text](url) or
+	// [text](url "title"). The destination may hold one level of parentheses, as Wikipedia URLs do.
+	linkTargetPattern = regexp.MustCompile(`\]\((?:[^()\s]|\([^()\s]*\))*(?:\s+"[^"\n]*")?\)`)
+	// linkReferencePattern matches link reference definitions, as in [1]: https://example.com.
+	linkReferencePattern = regexp.MustCompile(`(?m)^[ \t]*\[[^\]\n]+\]:[ \t]*\S+`)
+	// autolinkPattern matches links between angle brackets, as in <https://example.com>.
+	autolinkPattern = regexp.MustCompile(`<[a-zA-Z][a-zA-Z0-9+.-]*:[^\s<>]*>`)
+	// bareURLPattern matches the URLs Mattermost turns into links by itself. Trailing punctuation
+	// is left out, as it usually ends the sentence.
+	bareURLPattern = regexp.MustCompile(`(?i)\b(?:(?:https?|ftp|mailto):|www\.)[^\s<>]*[^\s<>.,;:!?'")\]*_~]`)
+)
+
+// linkFilter protects link targets and bare URLs unless the substitution includes them, so that
+// only the visible text of a link is replaced and s/is/was/ leaves https://this-is-a.link alone.
+// A pattern that is itself a URL, such as a dangerous link being removed, is meant to match
+// links and is not restricted.
+type linkFilter struct{}
+
+func (linkFilter) Name() string {
+	return "links"
+}
+
+func (linkFilter) Exclude(message string, s *Substitution) [][]int {
+	if s.IncludeLinks || s.targetsLinks() {
+		return nil
+	}
+
+	return findRegions(message, linkTargetPattern, linkReferencePattern, autolinkPattern, bareURLPattern)
+}
+
+func (linkFilter) Describe(s *Substitution) string {
+	if s.IncludeLinks {
+		return "Link targets and URLs are included (links setting)."
+	}
+	if s.targetsLinks() {
+		return "Link targets and URLs are included, since the pattern is a URL."
+	}
+
+	return "Link targets and URLs are skipped, only the visible text of links is replaced."
+}
+
+// targetsLinks reports whether the pattern is a URL, or the start of one.
+func (s *Substitution) targetsLinks() bool {
+	return strings.Contains(s.Pattern, "://") || bareURLPattern.MatchString(s.Pattern)
+}
@@ -0,0 +1,43 @@
+package substitute
+
+import (
+	"regexp"
+	"strings"
+)
+
+// mentionPattern matches @mentions of users and groups, as well as @channel, @here and @all. The
+// @ must not follow a letter or digit, so that email addresses are left out.
+var mentionPattern = regexp.MustCompile(`(?i)(?:^|[^a-z0-9_.-])(@[a-z0-9._-]+)`)
+
+// mentionFilter protects @mentions unless the substitution includes them, so that a broad
+// substitution such as s/al/AL/ cannot change who a post notifies. A pattern holding an @ is
+// meant to match mentions and is not restricted.
+type mentionFilter struct{}
+
+func (mentionFilter) Name() string {
+	return "mentions"
+}
+
+func (mentionFilter) Exclude(message string, s *Substitution) [][]int {
+	if s.IncludeMentions || strings.Contains(s.Pattern, "@") {
+		return nil
+	}
+
+	var regions [][]int
+	for _, match := range mentionPattern.FindAllStringSubmatchIndex(message, -1) {
+		regions = append(regions, match[2:4])
+	}
+
+	return regions
+}
+
+func (mentionFilter) Describe(s *Substitution) string {
+	switch {
+	case s.IncludeMentions:
+		return "@mentions are included (u flag)."
+	case strings.Contains(s.Pattern, "@"):
+		return "@mentions are included, since the pattern holds an @."
+	}
+
+	return "@mentions are skipped. Add the u flag to include them."
+}
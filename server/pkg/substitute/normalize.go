@@ -0,0 +1,82 @@
+package substitute
+
+import (
+	"golang.org/x/text/unicode/norm"
+)
+
+// previewNormalized applies s to message after composing it to NFC, so that text typed with
+// combining accents matches a pattern typed with precomposed characters and the other way round.
+// Before the first replacement and after the last one, the original text is kept as written.
+func (s *Substitution) previewNormalized(message string) *Result {
+	normalized, originalOffsets, normalizedOffsets := composeChunks(message)
+
+	result := s.Preview(normalized)
+	result.Original = message
+	if result.Replacements == 0 {
+		result.Message = message
+		return result
+	}
+
+	replaced := result.Message
+
+	// Keep the unchanged chunks at either end as they were written.
+	first := 0
+	prefix := commonPrefix(normalized, replaced)
+	for first+1 < len(normalizedOffsets) && normalizedOffsets[first+1] <= prefix {
+		first++
+	}
+
+	last := len(normalizedOffsets) - 1
+	suffix := commonSuffix(normalized[normalizedOffsets[first]:], replaced[normalizedOffsets[first]:])
+	for last-1 > first && len(normalized)-normalizedOffsets[last-1] <= suffix {
+		last--
+	}
+
+	tail := len(normalized) - normalizedOffsets[last]
+	result.Message = message[:originalOffsets[first]] +
+		replaced[normalizedOffsets[first]:len(replaced)-tail] +
+		message[originalOffsets[last]:]
+
+	return result
+}
+
+// composeChunks returns message composed to NFC, along with the offsets at which the chunks
+// composed independently start in the original and in the composed text. Both offset lists end
+// with the length of their text.
+func composeChunks(message string) (string, []int, []int) {
+	var composed []byte
+	originalOffsets := []int{0}
+	composedOffsets := []int{0}
+
+	for offset := 0; offset < len(message); {
+		size := norm.NFC.NextBoundaryInString(message[offset:], true)
+		if size <= 0 {
+			size = len(message) - offset
+		}
+
+		composed = norm.NFC.AppendString(composed, message[offset:offset+size])
+		offset += size
+		originalOffsets = append(originalOffsets, offset)
+		composedOffsets = append(composedOffsets, len(composed))
+	}
+
+	return string(composed), originalOffsets, composedOffsets
+}
+
+func commonPrefix(a, b string) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+
+	return n
+}
+
+func commonSuffix(a, b string) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[len(a)-1-n] == b[len(b)-1-n] {
+		n++
+	}
+
+	return n
+}
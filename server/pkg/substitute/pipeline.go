@@ -0,0 +1,117 @@
+package substitute
+
+// Filter is a step of a Pipeline. Every filter has a name, used when describing how a command is
+// applied, and implements at least one of Normalizer, Excluder and Fixup. A filter implementing
+// several of them takes part in each of the corresponding stages.
+type Filter interface {
+	Name() string
+}
+
+// Normalizer rewrites the message before it is matched.
+type Normalizer interface {
+	Filter
+	Normalize(message string, s *Substitution) string
+}
+
+// Excluder reports the byte ranges of the message that must not be modified.
+type Excluder interface {
+	Filter
+	Exclude(message string, s *Substitution) [][]int
+}
+
+// Fixup adjusts the message once the replacement has been made. original is the message as it
+// was after normalization.
+type Fixup interface {
+	Filter
+	Fixup(original, replaced string, s *Substitution) string
+}
+
+// Describer is implemented by filters that can explain their effect on a substitution. An empty
+// description means the filter leaves the substitution alone.
+type Describer interface {
+	Filter
+	Describe(s *Substitution) string
+}
+
+// Pipeline is the ordered list of filters run around the match and replace step: normalizers
+// first, then the replacement outside of every excluded range, then fix-ups. Filters of the same
+// stage run in the order they were registered.
+type Pipeline struct {
+	filters []Filter
+}
+
+// DefaultPipeline is used by substitutions that don't set their own. Filters should only be
+// registered with it during initialization, as Pipeline is not safe for concurrent modification.
+var DefaultPipeline = NewPipeline(
+	codeFilter{},
+	spoilerFilter{},
+	linkFilter{},
+	mentionFilter{},
+	emojiFilter{},
+	hashtagFilter{},
+	quoteFilter{},
+)
+
+// NewPipeline returns a pipeline running the given filters.
+func NewPipeline(filters ...Filter) *Pipeline {
+	return &Pipeline{filters: filters}
+}
+
+// Register appends a filter to the pipeline.
+func (p *Pipeline) Register(filter Filter) {
+	p.filters = append(p.filters, filter)
+}
+
+// With returns a copy of the pipeline with the given filters appended, leaving p unchanged.
+func (p *Pipeline) With(filters ...Filter) *Pipeline {
+	return NewPipeline(append(append([]Filter{}, p.filters...), filters...)...)
+}
+
+// Filters returns the filters of the pipeline, in order.
+func (p *Pipeline) Filters() []Filter {
+	return append([]Filter{}, p.filters...)
+}
+
+func (p *Pipeline) normalize(message string, s *Substitution) string {
+	for _, filter := range p.filters {
+		if normalizer, ok := filter.(Normalizer); ok {
+			message = normalizer.Normalize(message, s)
+		}
+	}
+
+	return message
+}
+
+func (p *Pipeline) exclude(message string, s *Substitution) [][]int {
+	var regions [][]int
+	for _, filter := range p.filters {
+		if excluder, ok := filter.(Excluder); ok {
+			regions = append(regions, excluder.Exclude(message, s)...)
+		}
+	}
+
+	return mergeRegions(regions)
+}
+
+func (p *Pipeline) fixup(original, replaced string, s *Substitution) string {
+	for _, filter := range p.filters {
+		if fixup, ok := filter.(Fixup); ok {
+			replaced = fixup.Fixup(original, replaced, s)
+		}
+	}
+
+	return replaced
+}
+
+func (p *Pipeline) describe(s *Substitution) []string {
+	var descriptions []string
+	for _, filter := range p.filters {
+		if describer, ok := filter.(Describer); ok {
+			if description := describer.Describe(s); description != "" {
+				descriptions = append(descriptions, description)
+			}
+		}
+	}
+
+	return descriptions
+}
@@ -0,0 +1,43 @@
+package substitute
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testFilter takes part in every stage of the pipeline.
+type testFilter struct{}
+
+func (testFilter) Name() string {
+	return "test"
+}
+
+func (testFilter) Normalize(message string, s *Substitution) string {
+	return strings.ReplaceAll(message, "\u00a0", " ")
+}
+
+func (testFilter) Exclude(message string, s *Substitution) [][]int {
+	if index := strings.Index(message, "#keep"); index >= 0 {
+		return [][]int{{index, len(message)}}
+	}
+
+	return nil
+}
+
+func (testFilter) Fixup(original, replaced string, s *Substitution) string {
+	return strings.TrimSpace(replaced)
+}
+
+func TestPipeline(t *testing.T) {
+	pipeline := DefaultPipeline.With(testFilter{})
+	assert.Len(t, DefaultPipeline.Filters(), 7)
+	assert.Len(t, pipeline.Filters(), 8)
+
+	s := &Substitution{Pattern: "bee", Replacement: "be", Pipeline: pipeline}
+	result := s.Preview("to bee `bee` #keep bee ")
+
+	assert.Equal(t, "to be `bee` #keep bee", result.Message)
+	assert.Equal(t, 1, result.Replacements)
+}
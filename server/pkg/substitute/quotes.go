@@ -0,0 +1,38 @@
+package substitute
+
+import (
+	"regexp"
+	"strings"
+)
+
+// blockquotePattern matches the lines of Markdown blockquotes, such as the text quoted from
+// another post.
+var blockquotePattern = regexp.MustCompile(`(?m)^[ \t]{0,3}>.*$`)
+
+// quoteFilter protects blockquotes unless the substitution includes them, so that only the words
+// of the author are replaced and not those they quote. A pattern holding a > is meant to match
+// quotes and is not restricted.
+type quoteFilter struct{}
+
+func (quoteFilter) Name() string {
+	return "quotes"
+}
+
+func (quoteFilter) Exclude(message string, s *Substitution) [][]int {
+	if s.IncludeQuotes || strings.Contains(s.Pattern, ">") {
+		return nil
+	}
+
+	return findRegions(message, blockquotePattern)
+}
+
+func (quoteFilter) Describe(s *Substitution) string {
+	switch {
+	case s.IncludeQuotes:
+		return "Quoted lines are included (b flag)."
+	case strings.Contains(s.Pattern, ">"):
+		return "Quoted lines are included, since the pattern holds a >."
+	}
+
+	return "Quoted lines starting with > are skipped. Add the b flag to include them."
+}
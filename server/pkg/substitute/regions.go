@@ -0,0 +1,325 @@
+package substitute
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	// spoilerPattern matches inline ||spoiler|| spans.
+	spoilerPattern = regexp.MustCompile(`\|\|[^|\n](?:[^|]|\|[^|])*?\|\|`)
+	// detailsPattern matches collapsible <details> blocks, including their summary.
+	detailsPattern = regexp.MustCompile(`(?is)<details\b.*?</details>`)
+)
+
+// findRegions returns the byte ranges of message matched by any of the patterns.
+func findRegions(message string, patterns ...*regexp.Regexp) [][]int {
+	var regions [][]int
+	for _, pattern := range patterns {
+		regions = append(regions, pattern.FindAllStringIndex(message, -1)...)
+	}
+
+	return mergeRegions(regions)
+}
+
+// mergeRegions sorts the regions and merges the ones that overlap.
+func mergeRegions(regions [][]int) [][]int {
+	if len(regions) == 0 {
+		return nil
+	}
+
+	sort.Slice(regions, func(i, j int) bool {
+		return regions[i][0] < regions[j][0]
+	})
+
+	merged := [][]int{regions[0]}
+	for _, region := range regions[1:] {
+		last := merged[len(merged)-1]
+		if region[0] <= last[1] {
+			if region[1] > last[1] {
+				last[1] = region[1]
+			}
+			continue
+		}
+
+		merged = append(merged, region)
+	}
+
+	return merged
+}
+
+// codeFilter protects code blocks and inline code unless the substitution includes code.
+type codeFilter struct{}
+
+func (codeFilter) Name() string {
+	return "code"
+}
+
+func (codeFilter) Exclude(message string, s *Substitution) [][]int {
+	if s.CodeOnly {
+		return complementRegions(codeRegions(message, true), len(message))
+	}
+
+	if s.IncludeCode {
+		return nil
+	}
+
+	return codeRegions(message, false)
+}
+
+// codeRegions returns the byte ranges of the code blocks and inline code spans of message, read as
+// Markdown. A fence is a line starting with at least three backticks or tildes, and is closed by a
+// line of at least as many of the same character; an unterminated fence runs to the end of the
+// message, as it does when rendered. An indented code block starts with a line indented by four
+// spaces or a tab that follows a blank line, the start of the message or a code block, since an
+// indented line would otherwise continue a paragraph, and runs over the following lines that are
+// indented or blank. An inline code span opened by a run of backticks is closed by a run of as many
+// in the same paragraph, so that a span opened by two backticks may hold a single one and a span
+// may cross a line break but not a blank line. With contents, the ranges only cover the code
+// itself, without the fence lines and backticks around it.
+func codeRegions(message string, contents bool) [][]int {
+	var regions [][]int
+
+	inline := func(start, end int) {
+		for _, span := range inlineCodeSpans(message[start:end]) {
+			if contents {
+				span = []int{span[0] + backtickRun(message[start:end], span[0]), span[1] - backtickRun(message[start:end], span[0])}
+			}
+			regions = append(regions, []int{start + span[0], start + span[1]})
+		}
+	}
+
+	// fenceStart is the offset of the open fence, or -1, codeStart the offset of its first line
+	// of code and fence its opening run. indentStart is the offset of the open indented code
+	// block, or -1, and indentEnd the end of its last line that is not blank.
+	fenceStart, codeStart, fence := -1, 0, ""
+	indentStart, indentEnd := -1, 0
+	textStart := 0
+	// afterBreak tells whether the previous line ends a paragraph, as the start of the message does.
+	afterBreak := true
+	for lineStart := 0; lineStart < len(message); {
+		lineEnd := strings.IndexByte(message[lineStart:], '\n')
+		if lineEnd < 0 {
+			lineEnd = len(message)
+		} else {
+			lineEnd += lineStart
+		}
+
+		raw := message[lineStart:lineEnd]
+		line := strings.TrimLeft(raw, " \t")
+		blank := strings.TrimSpace(line) == ""
+		switch {
+		case fenceStart >= 0:
+			if strings.HasPrefix(line, fence) && strings.TrimSpace(strings.TrimLeft(line, fence[:1])) == "" {
+				if contents {
+					regions = append(regions, []int{codeStart, lineStart})
+				} else {
+					regions = append(regions, []int{fenceStart, lineEnd})
+				}
+				fenceStart, textStart = -1, lineEnd
+				blank = true
+			}
+		case indentStart >= 0 && (blank || isIndented(raw)):
+			if !blank {
+				indentEnd = lineEnd
+			}
+		default:
+			if indentStart >= 0 {
+				regions = append(regions, []int{indentStart, indentEnd})
+				indentStart, textStart = -1, indentEnd
+			}
+
+			if afterBreak && !blank && isIndented(raw) {
+				inline(textStart, lineStart)
+				indentStart, indentEnd = lineStart, lineEnd
+			} else if run := fenceRun(line); run != "" {
+				inline(textStart, lineStart)
+				fenceStart, codeStart, fence = lineStart, lineEnd+1, run
+			}
+		}
+
+		afterBreak = blank || indentStart >= 0
+		lineStart = lineEnd + 1
+	}
+
+	switch {
+	case fenceStart >= 0 && contents:
+		if codeStart < len(message) {
+			regions = append(regions, []int{codeStart, len(message)})
+		}
+	case fenceStart >= 0:
+		regions = append(regions, []int{fenceStart, len(message)})
+	case indentStart >= 0:
+		regions = append(regions, []int{indentStart, indentEnd})
+	default:
+		inline(textStart, len(message))
+	}
+
+	return regions
+}
+
+// isIndented reports whether line is indented by at least four columns, a tab reaching the next
+// multiple of four.
+func isIndented(line string) bool {
+	width := 0
+	for i := 0; i < len(line) && width < 4; i++ {
+		switch line[i] {
+		case ' ':
+			width++
+		case '\t':
+			width += 4 - width%4
+		default:
+			return false
+		}
+	}
+
+	return width >= 4
+}
+
+// complementRegions returns the byte ranges of a message of length n lying outside regions, which
+// must be sorted and disjoint.
+func complementRegions(regions [][]int, n int) [][]int {
+	var complement [][]int
+	start := 0
+	for _, region := range regions {
+		if region[0] > start {
+			complement = append(complement, []int{start, region[0]})
+		}
+		start = region[1]
+	}
+	if start < n {
+		complement = append(complement, []int{start, n})
+	}
+
+	return complement
+}
+
+// fenceRun returns the run of backticks or tildes opening a code fence at the start of line, or
+// the empty string if line does not open one.
+func fenceRun(line string) string {
+	if !strings.HasPrefix(line, "```") && !strings.HasPrefix(line, "~~~") {
+		return ""
+	}
+
+	n := 3
+	for n < len(line) && line[n] == line[0] {
+		n++
+	}
+
+	return line[:n]
+}
+
+// inlineCodeSpans returns the byte ranges of the inline code spans of text, which holds no code
+// block. Spans may cross line breaks but not blank lines, which end the paragraph.
+func inlineCodeSpans(text string) [][]int {
+	var spans [][]int
+
+	for i := 0; i < len(text); {
+		if text[i] != '`' {
+			i++
+			continue
+		}
+
+		open := backtickRun(text, i)
+		closed := false
+		for j := i + open; j < len(text) && !endsParagraph(text, j); {
+			if text[j] != '`' {
+				j++
+				continue
+			}
+
+			run := backtickRun(text, j)
+			if run == open && j > i+open {
+				spans = append(spans, []int{i, j + run})
+				i, closed = j+run, true
+				break
+			}
+			j += run
+		}
+
+		// Backticks left unclosed are plain text.
+		if !closed {
+			i += open
+		}
+	}
+
+	return spans
+}
+
+// endsParagraph reports whether text has a line break at i followed by a blank line.
+func endsParagraph(text string, i int) bool {
+	if text[i] != '\n' {
+		return false
+	}
+
+	next := strings.IndexByte(text[i+1:], '\n')
+	if next < 0 {
+		return strings.TrimSpace(text[i+1:]) == ""
+	}
+
+	return strings.TrimSpace(text[i+1:i+1+next]) == ""
+}
+
+// backtickRun returns the number of consecutive backticks of text starting at i.
+func backtickRun(text string, i int) int {
+	n := 0
+	for i+n < len(text) && text[i+n] == '`' {
+		n++
+	}
+
+	return n
+}
+
+func (codeFilter) Describe(s *Substitution) string {
+	if s.CodeOnly {
+		return "Only code blocks and inline code are replaced, leaving the text around them alone (C flag)."
+	}
+	if s.IncludeCode {
+		return "Code blocks and inline code are included (c flag)."
+	}
+
+	return "Code blocks and inline code are skipped. Add the c flag to include them."
+}
+
+// spoilerFilter protects spoiler and collapsible blocks unless the substitution includes them.
+type spoilerFilter struct{}
+
+func (spoilerFilter) Name() string {
+	return "spoilers"
+}
+
+func (spoilerFilter) Exclude(message string, s *Substitution) [][]int {
+	if s.IncludeSpoilers {
+		return nil
+	}
+
+	return findRegions(message, spoilerPattern, detailsPattern)
+}
+
+func (spoilerFilter) Describe(s *Substitution) string {
+	if s.IncludeSpoilers {
+		return "Spoiler and collapsible blocks are included (spoilers setting)."
+	}
+
+	return "Spoiler and collapsible blocks are skipped. Turn on the spoilers setting to include them."
+}
+
+// replaceOutside applies replace to each part of message lying outside the protected regions,
+// leaving the protected regions as they are.
+func replaceOutside(message string, protected [][]int, replace func(string) string) string {
+	if len(protected) == 0 {
+		return replace(message)
+	}
+
+	var result strings.Builder
+	start := 0
+	for _, region := range protected {
+		result.WriteString(replace(message[start:region[0]]))
+		result.WriteString(message[region[0]:region[1]])
+		start = region[1]
+	}
+	result.WriteString(replace(message[start:]))
+
+	return result.String()
+}
@@ -0,0 +1,84 @@
+package substitute
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplaceOutsideProtectedRegions(t *testing.T) {
+	upper := func(segment string) string {
+		return strings.ToUpper(segment)
+	}
+
+	cases := []struct {
+		message  string
+		s        Substitution
+		expected string
+	}{
+		{"plain text", Substitution{}, "PLAIN TEXT"},
+		{"the ||butler did it|| again", Substitution{}, "THE ||butler did it|| AGAIN"},
+		{"the ||butler did it|| again", Substitution{IncludeSpoilers: true}, "THE ||BUTLER DID IT|| AGAIN"},
+		{"a <details><summary>s</summary>b</details> c", Substitution{}, "A <details><summary>s</summary>b</details> C"},
+		{"a || b", Substitution{}, "A || B"},
+		{"run `make all` now", Substitution{}, "RUN `make all` NOW"},
+		{"run `make all` now", Substitution{IncludeCode: true}, "RUN `MAKE ALL` NOW"},
+		{"see ``a ` b`` here", Substitution{}, "SEE ``a ` b`` HERE"},
+		{"before\n```go\nfmt.Println()\n```\nafter", Substitution{}, "BEFORE\n```go\nfmt.Println()\n```\nAFTER"},
+		{"before\n~~~\ncode\n~~~\nafter", Substitution{}, "BEFORE\n~~~\ncode\n~~~\nAFTER"},
+		{"before\n```\nunterminated", Substitution{}, "BEFORE\n```\nunterminated"},
+		{"a\n````md\n```\nnested\n```\n````\nb", Substitution{}, "A\n````md\n```\nnested\n```\n````\nB"},
+		{"a\n```\n```go\nstill code\n```\nb", Substitution{}, "A\n```\n```go\nstill code\n```\nB"},
+		{"use ```x `y` z``` or `w`", Substitution{}, "USE ```x `y` z``` OR `w`"},
+		{"a ` lone backtick", Substitution{}, "A ` LONE BACKTICK"},
+		{"`spanning\ntwo` lines", Substitution{}, "`spanning\ntwo` LINES"},
+		{"`not\n\nspanning` paragraphs", Substitution{}, "`NOT\n\nSPANNING` PARAGRAPHS"},
+		{"see:\n\n    code indented\n\n    more code\nafter", Substitution{}, "SEE:\n\n    code indented\n\n    more code\nAFTER"},
+		{"\tcode first\ntext", Substitution{}, "\tcode first\nTEXT"},
+		{"text\n    continued", Substitution{}, "TEXT\n    CONTINUED"},
+		{"```\nfenced\n```\n    indented", Substitution{}, "```\nfenced\n```\n    indented"},
+		{"see https://this-is-a.link.", Substitution{}, "SEE https://this-is-a.link."},
+		{"see www.example.com/is now", Substitution{}, "SEE www.example.com/is NOW"},
+		{"read [this is it](https://is.example.com/a_(b) \"is\") now", Substitution{}, "READ [THIS IS IT](https://is.example.com/a_(b) \"is\") NOW"},
+		{"[docs][1]\n[1]: https://docs.example.com/is", Substitution{}, "[DOCS][1]\n[1]: https://docs.example.com/is"},
+		{"mail <mailto:is@example.com> it", Substitution{}, "MAIL <mailto:is@example.com> IT"},
+		{"see https://this-is-a.link", Substitution{IncludeLinks: true}, "SEE HTTPS://THIS-IS-A.LINK"},
+		{"see https://this-is-a.link", Substitution{Pattern: "https://this-is-a.link"}, "SEE HTTPS://THIS-IS-A.LINK"},
+		{"ask @alice and @bob.smith, or @channel", Substitution{}, "ASK @alice AND @bob.smith, OR @channel"},
+		{"@alice: mail alice@example.com", Substitution{}, "@alice: MAIL ALICE@EXAMPLE.COM"},
+		{"ask @alice", Substitution{IncludeMentions: true}, "ASK @ALICE"},
+		{"ask @alice", Substitution{Pattern: "@alice"}, "ASK @ALICE"},
+		{"big :smile: and :+1:", Substitution{}, "BIG :smile: AND :+1:"},
+		{"big :smile:", Substitution{IncludeEmoji: true}, "BIG :SMILE:"},
+		{"big :smile:", Substitution{Pattern: ":smile:"}, "BIG :SMILE:"},
+		{"see #release-notes", Substitution{}, "SEE #RELEASE-NOTES"},
+		{"see #release-notes, #qa and issue#123", Substitution{SkipHashtags: true}, "SEE #release-notes, #QA AND ISSUE#123"},
+		{"see #release-notes", Substitution{SkipHashtags: true, Pattern: "#release"}, "SEE #RELEASE-NOTES"},
+		{"> you said teh\n>\n> twice\nyes teh", Substitution{}, "> you said teh\n>\n> twice\nYES TEH"},
+		{"a > b", Substitution{}, "A > B"},
+		{"> you said teh", Substitution{IncludeQuotes: true}, "> YOU SAID TEH"},
+		{"run `make all` now", Substitution{CodeOnly: true}, "run `MAKE ALL` now"},
+		{"code:\n```go\nfmt.Println()\n```\ndone", Substitution{CodeOnly: true}, "code:\n```go\nFMT.PRINTLN()\n```\ndone"},
+		{"code:\n~~~\nunterminated", Substitution{CodeOnly: true}, "code:\n~~~\nUNTERMINATED"},
+		{"no code here", Substitution{CodeOnly: true}, "no code here"},
+		{"run:\n\n    make all\ndone", Substitution{CodeOnly: true}, "run:\n\n    MAKE ALL\ndone"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.message, func(t *testing.T) {
+			protected := DefaultPipeline.exclude(tc.message, &tc.s)
+			assert.Equal(t, tc.expected, replaceOutside(tc.message, protected, upper))
+		})
+	}
+}
+
+func TestEmojiFilterSkipsTimes(t *testing.T) {
+	assert.Nil(t, emojiFilter{}.Exclude("at 10:30:45", &Substitution{}))
+	assert.Equal(t, [][]int{{3, 9}}, emojiFilter{}.Exclude("at :tada: 10:30:45", &Substitution{}))
+}
+
+func TestMergeRegions(t *testing.T) {
+	assert.Nil(t, mergeRegions(nil))
+	assert.Equal(t, [][]int{{0, 5}, {6, 8}}, mergeRegions([][]int{{6, 8}, {0, 3}, {2, 5}}))
+}
@@ -0,0 +1,689 @@
+// Package substitute implements the find and replace engine behind the s/ command: parsing a
+// command into a Substitution and applying it to a message.
+package substitute
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+	"golang.org/x/text/unicode/norm"
+
+	"github.com/carmo-evan/mattermost-plugin-replace/server/pkg/parser"
+)
+
+// Substitution is a parsed s/old/new/flags command.
+type Substitution struct {
+	// Pattern is the text to be replaced.
+	Pattern string
+
+	// Replacement is the text Pattern is replaced with. Unless Literal is set, $1 or \1 insert
+	// what the first group of the pattern matched, ${name} the group (?P<name>...) and $0 the
+	// whole match, as does &. \U and \L upper or lower case the rest of the replacement, or the
+	// part up to \E.
+	Replacement string
+
+	// IncludeCode allows replacing inside code blocks and inline code, which are skipped by
+	// default. It is set by the c flag.
+	IncludeCode bool
+
+	// Attachments also applies the substitution to the text, pretext and field values of the
+	// message attachments of the post, as formatted by integrations. It is set by the a flag.
+	Attachments bool
+
+	// IncludeLinks allows replacing inside the targets of Markdown links and in bare URLs, which
+	// are skipped by default so that only the visible text of links changes. It is set by the
+	// configuration rather than a flag.
+	IncludeLinks bool
+
+	// IncludeEmoji allows replacing inside emoji shortcodes such as :smile:, which are kept whole
+	// by default. It is set by the j flag.
+	IncludeEmoji bool
+
+	// SkipHashtags protects hashtags such as #release-notes from being replaced. It is set by the
+	// configuration rather than a flag.
+	SkipHashtags bool
+
+	// IncludeMentions allows replacing inside @mentions, which are skipped by default so that a
+	// broad substitution cannot change who is notified. It is set by the u flag.
+	IncludeMentions bool
+
+	// IncludeQuotes allows replacing inside blockquotes, which are skipped by default so that
+	// quoted text is left as its author wrote it. It is set by the b flag.
+	IncludeQuotes bool
+
+	// CodeOnly restricts the substitution to the contents of code blocks and inline code, the
+	// reverse of the default, so that a name can be fixed in a snippet without touching the text
+	// around it. It is set by the C flag.
+	CodeOnly bool
+
+	// IncludeSpoilers allows replacing inside spoiler and collapsible blocks, which are skipped
+	// by default.
+	IncludeSpoilers bool
+
+	// CollapseWhitespace removes the doubled spaces a replacement leaves behind, such as when a
+	// word is deleted.
+	CollapseWhitespace bool
+
+	// IgnoreCase matches the pattern regardless of case. It is set by the i flag.
+	IgnoreCase bool
+
+	// Global asks explicitly for every match to be replaced, as sed does. It is set by the g
+	// flag. Every match is also replaced without it, so alone it only changes how the result is
+	// reported; combined with Occurrence, the nth match and all later ones are replaced.
+	Global bool
+
+	// PreserveCase matches the pattern regardless of case and gives each replacement the case of
+	// the text it replaces, so that s/teh/the/p also turns Teh into The and TEH into THE. It is
+	// set by the p flag.
+	PreserveCase bool
+
+	// SmartCase ignores case when Pattern has no uppercase letter, as IgnoreCase does, and matches
+	// it exactly otherwise. It is set by a user preference.
+	SmartCase bool
+
+	// Multiline makes ^ and $ match at the start and end of every line instead of only the
+	// message. It is set by the m flag.
+	Multiline bool
+
+	// DotAll makes . match newlines too, so that a pattern can span lines. It is set by the s
+	// flag.
+	DotAll bool
+
+	// WholeWords wraps the pattern in word boundaries in every mode, including with Regex or
+	// PartialWords. It is set by the w flag.
+	WholeWords bool
+
+	// PartialWords lets the pattern match inside words, such as qu in "qeue", instead of whole
+	// words only. It is set by the configuration rather than a flag.
+	PartialWords bool
+
+	// Regex matches Pattern as a complete regular expression, without the word boundaries
+	// added around it otherwise. It is set by the r flag.
+	Regex bool
+
+	// Literal matches Pattern as plain text, in which a backslash only escapes the following
+	// character, and inserts Replacement as is. Whole words are matched where the pattern starts
+	// or ends with a letter or digit. It is set by the l flag.
+	Literal bool
+
+	// Fuzzy matches Pattern as plain text, as Literal does, but also matches runs of whole words
+	// a few edits away from it, such as receve for recieve. It is set by the f flag.
+	Fuzzy bool
+
+	// Occurrence, when positive, restricts the replacement to the nth match in the message,
+	// counted from 1, or with Global to the nth and later matches. It is set by a numeric flag,
+	// such as 2.
+	Occurrence int
+
+	// FirstLine and LastLine, when positive, restrict the substitution to a range of lines of the
+	// message, counted from 1. They are set by an address before the command, as in 2s/a/b/.
+	FirstLine int
+	LastLine  int
+
+	// Transliteration, when set by a y command, maps each character of Pattern to the
+	// character of Replacement at the same position. Every character of the message found in
+	// Pattern is replaced, and the other matching options are ignored.
+	Transliteration map[rune]rune
+
+	// Explain asks for a description of how the command is interpreted instead of applying it. It
+	// is set by the e flag.
+	Explain bool
+
+	// DryRun asks for the post to be shown as it would look after the substitution, without
+	// editing it. It is set by the n flag.
+	DryRun bool
+
+	// Pipeline holds the filters run around the replacement. DefaultPipeline is used when nil.
+	Pipeline *Pipeline
+}
+
+// Result describes the outcome of applying a Substitution to a message.
+type Result struct {
+	// Original is the message before the substitution.
+	Original string `json:"original"`
+
+	// Message is the message after the substitution.
+	Message string `json:"message"`
+
+	// Replacements is the number of occurrences replaced.
+	Replacements int `json:"replacements"`
+
+	// Matched lists the distinct texts replaced by a Fuzzy substitution, which may differ from
+	// the pattern.
+	Matched []string `json:"matched,omitempty"`
+}
+
+// Parse parses an s/old/new/flags command into a Substitution and validates it. Scopes following
+// the command are accepted but ignored; use the parser package directly to get at them.
+func Parse(command string) (*Substitution, error) {
+	cmd, err := parser.Parse(command)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := FromCommand(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// FromCommand builds the Substitution described by a parsed command, interpreting its flags. The
+// pattern is not validated, as a default mode may still be chosen for it; call Validate once the
+// mode is final.
+func FromCommand(cmd *parser.Command) (*Substitution, error) {
+	if cmd.Verb == parser.VerbTransliterate {
+		return fromTransliteration(cmd)
+	}
+
+	s := &Substitution{
+		Pattern:     cmd.Pattern,
+		Replacement: unescapeDelimiter(cmd.Replacement, cmd.Delimiter),
+		FirstLine:   cmd.FirstLine,
+		LastLine:    cmd.LastLine,
+	}
+	if err := s.parseFlags(cmd.Flags); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// FromScript builds the substitutions of several commands, to be applied in order, without
+// validating them, as FromCommand. Errors name the command they concern when there are several.
+func FromScript(cmds []*parser.Command) ([]*Substitution, error) {
+	subs := make([]*Substitution, 0, len(cmds))
+	for i, cmd := range cmds {
+		s, err := FromCommand(cmd)
+		if err != nil && len(cmds) > 1 {
+			return nil, errors.Wrapf(err, "command %d", i+1)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		subs = append(subs, s)
+	}
+
+	return subs, nil
+}
+
+// unescapeDelimiter removes the escapes protecting delimiters in a replacement, which is not a
+// regular expression and would otherwise keep them. Escaped backslashes are skipped over, so
+// that in \\\/ only the slash is unescaped.
+func unescapeDelimiter(replacement string, delimiter rune) string {
+	var result strings.Builder
+
+	runes := []rune(replacement)
+	for i := 0; i < len(runes); i++ {
+		if runes[i] == '\\' && i+1 < len(runes) {
+			i++
+			if runes[i] != delimiter {
+				result.WriteRune('\\')
+			}
+		}
+		result.WriteRune(runes[i])
+	}
+
+	return result.String()
+}
+
+// Apply returns message with the substitution applied.
+func (s *Substitution) Apply(message string) string {
+	return s.Preview(message).Message
+}
+
+// Preview applies the substitution to message and reports what changed. The message is left
+// unchanged when the pattern is invalid; Validate tells why.
+func (s *Substitution) Preview(message string) *Result {
+	if s.isAnchor() {
+		return s.previewAnchor(message)
+	}
+
+	if !norm.NFC.IsNormalString(message) {
+		return s.previewNormalized(message)
+	}
+
+	pipeline := s.Pipeline
+	if pipeline == nil {
+		pipeline = DefaultPipeline
+	}
+
+	normalized := pipeline.normalize(message, s)
+
+	excluded := pipeline.exclude(normalized, s)
+	if s.isAddressed() {
+		excluded = mergeRegions(append(excluded, s.outsideLines(normalized)...))
+	}
+
+	expr := s.expression()
+	if s.Fuzzy {
+		var candidates []string
+		replaceOutside(normalized, excluded, func(segment string) string {
+			candidates = append(candidates, s.fuzzyCandidates(segment)...)
+			return segment
+		})
+		expr = fuzzyExpression(expr, candidates)
+	}
+
+	re, err := compile(expr)
+	if err != nil {
+		return &Result{Original: message, Message: message}
+	}
+
+	count, seen := 0, 0
+	var matched []string
+	result := replaceOutside(normalized, excluded, func(segment string) string {
+		replaced, n := s.replaceMatches(re, segment, &seen, &matched)
+		count += n
+		return replaced
+	})
+
+	return &Result{Original: message, Message: pipeline.fixup(normalized, result, s), Replacements: count, Matched: matched}
+}
+
+// replaceMatches replaces the matches of re in segment and returns the result along with the
+// number of replacements. Matches that would split a grapheme cluster, such as the base letter of
+// an accented character or half of a flag, are skipped, as are matches inside words when whole
+// words are required. seen counts the matches found so far in
+// the message, so that an Occurrence can be picked across segments, and the distinct texts
+// replaced are added to matched for Fuzzy substitutions.
+func (s *Substitution) replaceMatches(re *regexp.Regexp, segment string, seen *int, matched *[]string) (string, int) {
+	var result []byte
+	count := 0
+	last := 0
+	for _, match := range re.FindAllStringSubmatchIndex(segment, -1) {
+		start, end := match[0], match[1]
+		// Spaces swallowed by collapseSpaces may have consumed the start of this match.
+		if start < last {
+			continue
+		}
+		if !isClusterBoundary(segment, start) || !isClusterBoundary(segment, end) || !s.atWordBounds(segment, start, end) {
+			continue
+		}
+
+		*seen++
+		if *seen < s.Occurrence || *seen > s.Occurrence && s.Occurrence > 0 && !s.Global {
+			continue
+		}
+
+		if s.Fuzzy && !containsString(*matched, segment[start:end]) {
+			*matched = append(*matched, segment[start:end])
+		}
+
+		result = append(result, segment[last:start]...)
+		expansion := s.expand(re, segment, match)
+		last = end
+		if s.CollapseWhitespace {
+			result, expansion, last = collapseSpaces(result, expansion, segment, last)
+		}
+		result = append(result, expansion...)
+		count++
+	}
+	result = append(result, segment[last:]...)
+
+	return string(result), count
+}
+
+// collapseSpaces avoids the doubled spaces created where a replacement meets the text around it,
+// typically when a word is deleted. before is the output so far, expansion the replacement text
+// and next the offset in segment following the match. Spaces leading the replacement, or the
+// text after it, are dropped when a space or the start of a line already precedes them, and a
+// deletion at the end of a line takes the spaces before it along.
+func collapseSpaces(before, expansion []byte, segment string, next int) ([]byte, []byte, int) {
+	atLineStart := len(before) == 0 || before[len(before)-1] == '\n'
+	spaceBefore := len(before) > 0 && before[len(before)-1] == ' '
+
+	if atLineStart || spaceBefore {
+		expansion = bytes.TrimLeft(expansion, " ")
+	}
+
+	if len(expansion) > 0 && expansion[len(expansion)-1] == ' ' || len(expansion) == 0 && (atLineStart || spaceBefore) {
+		for next < len(segment) && segment[next] == ' ' {
+			next++
+		}
+	}
+
+	if len(expansion) == 0 && (next == len(segment) || segment[next] == '\n') {
+		before = bytes.TrimRight(before, " ")
+	}
+
+	return before, expansion, next
+}
+
+// expression returns the regular expression the pattern is matched with.
+func (s *Substitution) expression() string {
+	// Messages are matched in NFC, see previewNormalized.
+	pattern := norm.NFC.String(s.Pattern)
+
+	var expr string
+	switch {
+	case s.Transliteration != nil:
+		return characterClass(s.Pattern)
+	case s.Regex && !s.WholeWords:
+		expr = pattern
+	case s.Literal || s.Fuzzy:
+		expr = regexp.QuoteMeta(unescape(pattern))
+	case s.matchesWholeWords():
+		expr = `(?:` + pattern + `)`
+	default:
+		expr = pattern
+	}
+
+	var modes string
+	if s.ignoresCase() {
+		modes += "i"
+	}
+	if s.Multiline {
+		modes += "m"
+	}
+	if s.DotAll {
+		modes += "s"
+	}
+
+	if modes != "" {
+		return `(?` + modes + `)` + expr
+	}
+
+	return expr
+}
+
+// ignoresCase reports whether the pattern is matched regardless of case.
+func (s *Substitution) ignoresCase() bool {
+	return s.IgnoreCase || s.PreserveCase || s.SmartCase && !hasUpper(s.Pattern)
+}
+
+// hasUpper reports whether pattern contains an uppercase letter, not counting the letters of
+// escapes such as \W or \p{Lu}.
+func hasUpper(pattern string) bool {
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		if runes[i] == '\\' && i+1 < len(runes) {
+			i++
+			if (runes[i] == 'p' || runes[i] == 'P') && i+1 < len(runes) && runes[i+1] == '{' {
+				for i < len(runes) && runes[i] != '}' {
+					i++
+				}
+			}
+			continue
+		}
+
+		if unicode.IsUpper(runes[i]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchesWholeWords reports whether the pattern is wrapped in word boundaries.
+func (s *Substitution) matchesWholeWords() bool {
+	return s.WholeWords || !s.PartialWords
+}
+
+// template returns the replacement in the form expected by regexp.Expand.
+func (s *Substitution) template() string {
+	if s.Literal {
+		return strings.Replace(s.Replacement, "$", "$$", -1)
+	}
+
+	return expandTemplate(s.Replacement)
+}
+
+// expandTemplate rewrites the group references of a replacement into the braced form of
+// regexp.Expand. sed style \1 becomes ${1}, and $1 becomes ${1} too, so that in $1st the
+// reference ends at the last digit instead of naming a group "1st". As in sed, & stands for the
+// whole match and \& for an ampersand.
+func expandTemplate(replacement string) string {
+	var result strings.Builder
+
+	runes := []rune(replacement)
+	for i := 0; i < len(runes); i++ {
+		switch {
+		case runes[i] == '\\' && i+1 < len(runes) && isASCIIDigit(runes[i+1]):
+			result.WriteString("${" + string(runes[i+1]) + "}")
+			i++
+		case runes[i] == '\\' && i+1 < len(runes) && runes[i+1] == '&':
+			result.WriteRune('&')
+			i++
+		case runes[i] == '&':
+			result.WriteString("${0}")
+		case runes[i] == '$' && i+1 < len(runes) && runes[i+1] == '$':
+			result.WriteString("$$")
+			i++
+		case runes[i] == '$' && i+1 < len(runes) && isASCIIDigit(runes[i+1]):
+			end := i + 1
+			for end < len(runes) && isASCIIDigit(runes[end]) {
+				end++
+			}
+			result.WriteString("${" + string(runes[i+1:end]) + "}")
+			i = end - 1
+		default:
+			result.WriteRune(runes[i])
+		}
+	}
+
+	return result.String()
+}
+
+// unescape removes the backslashes escaping the character after them in literal text.
+func unescape(text string) string {
+	var result strings.Builder
+
+	runes := []rune(text)
+	for i := 0; i < len(runes); i++ {
+		if runes[i] == '\\' && i+1 < len(runes) {
+			i++
+		}
+		result.WriteRune(runes[i])
+	}
+
+	return result.String()
+}
+
+// Validate checks that the pattern is a valid regular expression in the selected mode. It must
+// be called again after changing the mode of a parsed substitution, before applying it. Errors
+// quote the invalid part of the pattern as the user wrote it.
+func (s *Substitution) Validate() error {
+	if s.Transliteration != nil {
+		return nil
+	}
+
+	if !s.Literal && !s.Fuzzy {
+		if _, err := regexp.Compile(s.Pattern); err != nil {
+			return describeSyntaxError(err)
+		}
+	}
+
+	re, err := compile(s.expression())
+	if err != nil {
+		return describeSyntaxError(err)
+	}
+
+	if s.Literal {
+		return nil
+	}
+
+	return checkGroupReferences(re, s.template())
+}
+
+// checkGroupReferences returns an error when template refers to a group that re does not
+// define, which regexp.Expand would silently replace with nothing.
+func checkGroupReferences(re *regexp.Regexp, template string) error {
+	for i := 0; i < len(template); i++ {
+		if template[i] != '$' || i+1 == len(template) {
+			continue
+		}
+
+		var name string
+		switch {
+		case template[i+1] == '$':
+			i++
+			continue
+		case template[i+1] == '{':
+			end := strings.IndexByte(template[i:], '}')
+			if end < 0 {
+				continue
+			}
+			name = template[i+2 : i+end]
+			i += end
+		default:
+			end := i + 1
+			for end < len(template) && isGroupNameByte(template[end]) {
+				end++
+			}
+			name = template[i+1 : end]
+			i = end - 1
+		}
+
+		if name == "" || hasGroup(re, name) {
+			continue
+		}
+
+		return errors.Errorf("the replacement refers to group %s, which the pattern does not define", name)
+	}
+
+	return nil
+}
+
+func hasGroup(re *regexp.Regexp, name string) bool {
+	if n, err := strconv.Atoi(name); err == nil {
+		return n <= re.NumSubexp()
+	}
+
+	for _, subexp := range re.SubexpNames() {
+		if subexp == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isGroupNameByte(b byte) bool {
+	return b == '_' || b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z'
+}
+
+// describeSyntaxError rewords the errors of the regexp package, which start with "error parsing
+// regexp", for users.
+func describeSyntaxError(err error) error {
+	if syntaxErr, ok := err.(*syntax.Error); ok {
+		return errors.Errorf("invalid regular expression, %s in %s", syntaxErr.Code, syntaxErr.Expr)
+	}
+
+	return err
+}
+
+// Describe explains, one statement per line, how the substitution is matched and applied.
+func (s *Substitution) Describe() []string {
+	pipeline := s.Pipeline
+	if pipeline == nil {
+		pipeline = DefaultPipeline
+	}
+
+	if s.Transliteration != nil {
+		lines := []string{"Each character of `" + s.Pattern + "` is replaced with the character of `" + s.Replacement + "` at the same position (y command)."}
+		return append(lines, pipeline.describe(s)...)
+	}
+
+	action := "replaced with `" + s.Replacement + "`"
+	if s.Replacement == "" {
+		action = "removed"
+	}
+
+	lines := []string{
+		"Pattern `" + s.Pattern + "` is matched as a regular expression, whole words only, in any script: `" + s.expression() + "`. `$1` or `\\1` in the replacement insert what its first group matched, `${name}` a group named with `(?P<name>...)`.",
+		"Every match is " + action + ".",
+	}
+
+	if !s.matchesWholeWords() {
+		lines[0] = "Pattern `" + s.Pattern + "` is matched as a regular expression, also inside words: `" + s.expression() + "`. `$1` or `\\1` in the replacement insert what its first group matched, `${name}` a group named with `(?P<name>...)`."
+	}
+
+	if s.Literal {
+		lines[0] = "Pattern `" + s.Pattern + "` is matched as literal text: `" + s.expression() + "`. The replacement is inserted as is."
+	}
+
+	if s.Fuzzy {
+		lines[0] = "Pattern `" + s.Pattern + "` is matched as literal text, along with runs of whole words a few edits away from it (f flag). The replacement may use `&` for the text actually matched."
+	}
+
+	if s.Regex {
+		lines[0] = "Pattern `" + s.Pattern + "` is matched as a complete regular expression (r flag); `$1` or `\\1` in the replacement insert what its first group matched, `${name}` a group named with `(?P<name>...)`."
+	}
+
+	if s.WholeWords {
+		lines = append(lines, "Only whole words are matched (w flag).")
+	}
+
+	switch {
+	case s.PreserveCase:
+		lines = append(lines, "Case is ignored, and each replacement takes the case of the text it replaces (p flag).")
+	case s.IgnoreCase:
+		lines = append(lines, "Case is ignored (i flag).")
+	case s.ignoresCase():
+		lines = append(lines, "Case is ignored since the pattern is all lowercase (smartcase setting).")
+	case s.SmartCase:
+		lines = append(lines, "Case is matched exactly since the pattern has an uppercase letter (smartcase setting).")
+	}
+
+	if s.Multiline {
+		lines = append(lines, "`^` and `$` match at the start and end of every line (m flag).")
+	}
+
+	if s.DotAll {
+		lines = append(lines, "`.` also matches newlines (s flag).")
+	}
+
+	if s.Attachments {
+		lines = append(lines, "The text, pretext and field values of message attachments are edited too (a flag).")
+	}
+
+	if s.DryRun {
+		lines = append(lines, "The post is only previewed, nothing is edited (n flag).")
+	}
+
+	if s.Global {
+		lines[1] = "Every match is " + action + " (g flag)."
+	}
+
+	where := "the post"
+	if s.isAddressed() {
+		where = "each addressed line"
+	}
+
+	switch {
+	case s.isAnchor() && s.Pattern == anchorStart:
+		lines[1] = "`" + s.Replacement + "` is added at the start of " + where + "."
+	case s.isAnchor():
+		lines[1] = "`" + s.Replacement + "` is added at the end of " + where + "."
+	}
+
+	switch {
+	case s.Occurrence > 0 && s.Global:
+		lines[1] = fmt.Sprintf("Match number %d and every later match are %s.", s.Occurrence, action)
+	case s.Occurrence > 0:
+		lines[1] = fmt.Sprintf("Only match number %d is %s.", s.Occurrence, action)
+	}
+
+	if s.isAddressed() {
+		lines = append(lines, s.describeLines())
+	}
+
+	if s.CollapseWhitespace {
+		lines = append(lines, "Doubled spaces left around a replacement are collapsed (whitespace setting).")
+	}
+
+	return append(lines, pipeline.describe(s)...)
+}
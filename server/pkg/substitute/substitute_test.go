@@ -0,0 +1,435 @@
+package substitute
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/carmo-evan/mattermost-plugin-replace/server/pkg/parser"
+)
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		command  string
+		expected *Substitution
+	}{
+		{"s/bee/be", &Substitution{Pattern: "bee", Replacement: "be"}},
+		{" s/bee/be ", &Substitution{Pattern: "bee", Replacement: "be"}},
+		{"s/bee/be/", &Substitution{Pattern: "bee", Replacement: "be"}},
+		{"s/bee/be/c", &Substitution{Pattern: "bee", Replacement: "be", IncludeCode: true}},
+		{"s/bee/be/gc", &Substitution{Pattern: "bee", Replacement: "be", IncludeCode: true, Global: true}},
+		{"s/Bee/be/i", &Substitution{Pattern: "Bee", Replacement: "be", IgnoreCase: true}},
+		{`s/path\/to\/file/path\/to\/dir/`, &Substitution{Pattern: `path\/to\/file`, Replacement: "path/to/dir"}},
+		{`s/a/b\\\/c`, &Substitution{Pattern: "a", Replacement: `b\\/c`}},
+		{"s/bee/be/12", &Substitution{Pattern: "bee", Replacement: "be", Occurrence: 12}},
+		{"s/bee/be/0", nil},
+		{"s/(/x", nil},
+		{"s/a)(b/x/r", nil},
+		{"s/bad", nil},
+		{"s/baaad/", nil},
+		{"s/", nil},
+		{"s//", nil},
+		{"s/bee/be/q", nil},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.command, func(t *testing.T) {
+			s, err := Parse(tc.command)
+			if tc.expected == nil {
+				assert.NotNil(t, err)
+				return
+			}
+
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, s)
+		})
+	}
+}
+
+func TestPreview(t *testing.T) {
+	message := "use `bee` to buzz, bee, bee"
+
+	result := (&Substitution{Pattern: "bee", Replacement: "be"}).Preview(message)
+	assert.Equal(t, &Result{Original: message, Message: "use `bee` to buzz, be, be", Replacements: 2}, result)
+
+	s := &Substitution{Pattern: "bee", Replacement: "be", IncludeCode: true}
+	assert.Equal(t, "use `be` to buzz, be, be", s.Apply(message))
+	assert.Equal(t, "be, be and be", (&Substitution{Pattern: "Bee", Replacement: "be", IgnoreCase: true}).Apply("bee, BEE and Bee"))
+	assert.Equal(t, "bee, BEE and be", (&Substitution{Pattern: "Bee", Replacement: "be"}).Apply("bee, BEE and Bee"))
+	assert.Equal(t, &Result{Original: message, Message: "use `bee` to buzz, bee, be", Replacements: 1}, (&Substitution{Pattern: "bee", Replacement: "be", Occurrence: 2}).Preview(message))
+	assert.Equal(t, "use `bee` to buzz, be, bee", (&Substitution{Pattern: "bee", Replacement: "be", IncludeCode: true, Occurrence: 2}).Apply(message))
+	assert.Equal(t, message, (&Substitution{Pattern: "bee", Replacement: "be", Occurrence: 3}).Apply(message))
+	paths, err := Parse(`s/path\/to\/file/path\/to\/dir/`)
+	assert.Nil(t, err)
+	assert.Equal(t, "see path/to/dir", paths.Apply("see path/to/file"))
+	assert.Equal(t, "what if I type the word typical", (&Substitution{Pattern: "typ", Replacement: "type"}).Apply("what if I typ the word typical"))
+}
+
+func TestRegex(t *testing.T) {
+	s, err := Parse(`s/(\w+)@(?P<host>\w+)\.com/$1 at ${host}/r`)
+	assert.Nil(t, err)
+	assert.Equal(t, "mail jane at example today", s.Apply("mail jane@example.com today"))
+
+	// Without the r flag the pattern must match whole words.
+	assert.Equal(t, "reran [ran]", (&Substitution{Pattern: "ran", Replacement: "[$0]"}).Apply("reran ran"))
+	assert.Equal(t, "re[ran] [ran]", (&Substitution{Pattern: "ran", Replacement: "[$0]", Regex: true}).Apply("reran ran"))
+
+	err = (&Substitution{Pattern: "a)(b", Regex: true}).Validate()
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "invalid regular expression, unexpected ) in a)(b", err.Error())
+	}
+}
+
+func TestBackreferences(t *testing.T) {
+	for _, command := range []string{`s/(\d+)-(\d+)/$2-$1/`, `s/(\d+)-(\d+)/\2-\1/`, `s/(\d+)-(\d+)/$2-$1/r`} {
+		s, err := Parse(command)
+		assert.Nil(t, err)
+		assert.Equal(t, "from 20-10 on", s.Apply("from 10-20 on"), command)
+	}
+
+	s := &Substitution{Pattern: `(\d)`, Replacement: "$1st, $$1 and \\x"}
+	assert.Equal(t, "1st, $1 and \\x", s.Apply("1"))
+
+	literal := &Substitution{Pattern: "price", Replacement: `$1 \1`, Literal: true}
+	assert.Equal(t, `$1 \1`, literal.Apply("price"))
+}
+
+func TestNamedGroups(t *testing.T) {
+	s, err := Parse(`s/(?P<day>\d+)\.(?P<month>\d+)/${month}\/${day}/`)
+	assert.Nil(t, err)
+	assert.Equal(t, "due 3/14 at noon", s.Apply("due 14.3 at noon"))
+
+	s, err = Parse(`s/(?P<day>\d+)/${dya}/`)
+	assert.Nil(t, s)
+	assert.EqualError(t, err, "the replacement refers to group dya, which the pattern does not define")
+
+	_, err = Parse(`s/(\d+)/$2/`)
+	assert.EqualError(t, err, "the replacement refers to group 2, which the pattern does not define")
+}
+
+func TestWholeMatchToken(t *testing.T) {
+	s, err := Parse(`s/urgent/**&**/`)
+	assert.Nil(t, err)
+	assert.Equal(t, "this is **urgent**", s.Apply("this is urgent"))
+
+	s, err = Parse(`s/and/\&/`)
+	assert.Nil(t, err)
+	assert.Equal(t, "salt & pepper", s.Apply("salt and pepper"))
+
+	literal := &Substitution{Pattern: "and", Replacement: "&", Literal: true}
+	assert.Equal(t, "salt & pepper", literal.Apply("salt and pepper"))
+}
+
+func TestCaseEscapes(t *testing.T) {
+	for input, expected := range map[string]string{
+		`s/mattermost/\UMattermost\E/`: "MATTERMOST rocks",
+		`s/(\w+) (\w+)/\U$1\E $2/r`:    "MATTERMOST rocks",
+		`s/(\w+) (\w+)/\L$2 \U$1/r`:    "rocks MATTERMOST",
+		`s/mattermost/a\tb/`:           `a\tb rocks`,
+	} {
+		s, err := Parse(input)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, s.Apply("mattermost rocks"), input)
+	}
+
+	literal := &Substitution{Pattern: "x", Replacement: `\Uy`, Literal: true}
+	assert.Equal(t, `\Uy`, literal.Apply("x"))
+}
+
+func TestDeletion(t *testing.T) {
+	s, err := Parse("s/very//")
+	assert.Nil(t, err)
+	assert.Equal(t, "a  good idea", s.Apply("a very good idea"))
+	assert.Contains(t, s.Describe(), "Every match is removed.")
+
+	s.CollapseWhitespace = true
+	assert.Equal(t, "a good idea", s.Apply("a very good idea"))
+}
+
+func TestAnchors(t *testing.T) {
+	message := "deploy `now`\n```\ncode\n```\nthen wait"
+
+	s, err := Parse("s/$/ (edit: done)/")
+	assert.Nil(t, err)
+	assert.Equal(t, message+" (edit: done)", s.Apply(message))
+
+	s, err = Parse("s/^/FYI: /")
+	assert.Nil(t, err)
+	assert.Equal(t, "FYI: "+message, s.Apply(message))
+
+	s, err = Parse("s/^deploy/Deploy/")
+	assert.Nil(t, err)
+	assert.Equal(t, "Deploy now.", s.Apply("deploy now."))
+
+	s, err = Parse(`s/now\.$/later./`)
+	assert.Nil(t, err)
+	assert.Equal(t, "deploy later.", s.Apply("deploy now."))
+
+	literal := &Substitution{Pattern: "$", Replacement: "USD", Literal: true}
+	assert.Equal(t, "5 USD", literal.Apply("5 $"))
+}
+
+func TestMultilineAndDotAll(t *testing.T) {
+	message := "first item\nsecond item"
+
+	s, err := Parse("s/^/- /m")
+	assert.Nil(t, err)
+	assert.Equal(t, "- first item\n- second item", s.Apply(message))
+
+	s, err = Parse("s/item$/entry/m")
+	assert.Nil(t, err)
+	assert.Equal(t, "first entry\nsecond entry", s.Apply(message))
+
+	s, err = Parse("s/first.*second/one and two/")
+	assert.Nil(t, err)
+	assert.Equal(t, message, s.Apply(message))
+
+	s, err = Parse("s/first.*second/one and two/s")
+	assert.Nil(t, err)
+	assert.Equal(t, "one and two item", s.Apply(message))
+
+	s, err = Parse("s/x/y/ims")
+	assert.Nil(t, err)
+	assert.Equal(t, `(?ims)(?:x)`, s.expression())
+}
+
+func TestWordBoundaries(t *testing.T) {
+	partial := &Substitution{Pattern: "qu", Replacement: "q", PartialWords: true}
+	assert.Equal(t, "qeue", partial.Apply("queue"))
+
+	partial.WholeWords = true
+	assert.Equal(t, "queue", partial.Apply("queue"))
+
+	literal := &Substitution{Pattern: "qu", Replacement: "q", Literal: true, PartialWords: true}
+	assert.Equal(t, "qeue", literal.Apply("queue"))
+
+	s, err := Parse("s/ran/run/rw")
+	assert.Nil(t, err)
+	assert.Equal(t, "reran run", s.Apply("reran ran"))
+	assert.Contains(t, s.Describe(), "Only whole words are matched (w flag).")
+}
+
+func TestSmartCase(t *testing.T) {
+	s := &Substitution{Pattern: "mattermost", Replacement: "Mattermost", SmartCase: true}
+	assert.Equal(t, "Mattermost and Mattermost", s.Apply("MATTERMOST and mattermost"))
+
+	s = &Substitution{Pattern: "Go", Replacement: "Golang", SmartCase: true}
+	assert.Equal(t, "Golang, go", s.Apply("Go, go"))
+
+	s = &Substitution{Pattern: `go\W\p{Lu}`, Replacement: "x", Regex: true, SmartCase: true}
+	assert.Equal(t, "x", s.Apply("GO A"))
+	assert.True(t, hasUpper(`\\A`))
+}
+
+func TestPreserveCase(t *testing.T) {
+	s, err := Parse("s/teh/the/p")
+	assert.Nil(t, err)
+	assert.Equal(t, "the The THE the", s.Apply("teh Teh TEH tEh"))
+
+	s, err = Parse("s/ios/iOS/p")
+	assert.Nil(t, err)
+	assert.Equal(t, "iOS IOS", s.Apply("ios IOS"))
+
+	assert.Equal(t, "Über", preserveCase("A", "über"))
+	assert.Equal(t, "2 Items", preserveCase("2 It", "2 items"))
+}
+
+func TestUnicodeWords(t *testing.T) {
+	cases := []struct {
+		command  string
+		message  string
+		expected string
+	}{
+		{"s/naive/naïve/", "naive, naïveté", "naïve, naïveté"},
+		{"s/café/bar/", "café", "bar"},
+		{"s/ве/X/", "вечер ве", "вечер X"},
+		{"s/世界/world/", "你好世界", "你好world"},
+		{"s/ran/run/l", "reran ran", "reran run"},
+		{"s/(ran)/run/w", "reran ran", "reran run"},
+		{"s/ran/run/r", "reran ran", "rerun run"},
+	}
+
+	for _, tc := range cases {
+		s, err := Parse(tc.command)
+		assert.Nil(t, err)
+		assert.Equal(t, tc.expected, s.Apply(tc.message), tc.command)
+	}
+}
+
+func TestUnicodeNormalization(t *testing.T) {
+	decomposed := "cafe\u0301 and nai\u0308ve"
+
+	s := &Substitution{Pattern: "café", Replacement: "bar"}
+	assert.Equal(t, "bar and nai\u0308ve", s.Apply(decomposed))
+
+	s = &Substitution{Pattern: "and", Replacement: "or"}
+	assert.Equal(t, "cafe\u0301 or nai\u0308ve", s.Apply(decomposed))
+
+	s = &Substitution{Pattern: "missing", Replacement: "x"}
+	assert.Equal(t, decomposed, s.Apply(decomposed))
+
+	s = &Substitution{Pattern: "cafe\u0301", Replacement: "tea"}
+	assert.Equal(t, "tea", s.Apply("café"))
+}
+
+func TestLineAddress(t *testing.T) {
+	message := "foo one\nfoo two\nfoo three"
+
+	for command, expected := range map[string]string{
+		"2s/foo/bar/":     "foo one\nbar two\nfoo three",
+		"2,3s/foo/bar/":   "foo one\nbar two\nbar three",
+		"3,9s/foo/bar/":   "foo one\nfoo two\nbar three",
+		"4s/foo/bar/":     message,
+		"1s/$/ (edited)/": "foo one (edited)\nfoo two\nfoo three",
+		"2,3s/^/- /":      "foo one\n- foo two\n- foo three",
+		"2y/o/0/":         "foo one\nf00 tw0\nfoo three",
+		"1,2s/foo/bar/2":  "foo one\nbar two\nfoo three",
+	} {
+		s, err := Parse(command)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, s.Apply(message), command)
+	}
+
+	s, err := Parse("2,3s/foo/bar/")
+	assert.Nil(t, err)
+	assert.Contains(t, s.Describe(), "Only lines 2 to 3 of the post are edited.")
+}
+
+func TestTransliteration(t *testing.T) {
+	s, err := Parse("y/abc/xyz/")
+	assert.Nil(t, err)
+	assert.Equal(t, "xyzd `abc` zyx", s.Apply("abcd `abc` cba"))
+
+	s, err = Parse(`y/-^\/]/_~|)/`)
+	assert.Nil(t, err)
+	assert.Equal(t, "a_b~c|d)e", s.Apply("a-b^c/d]e"))
+
+	_, err = Parse("y/abc/xy/")
+	assert.EqualError(t, err, "y needs as many characters in the replacement as in the pattern, got 2 and 3")
+
+	_, err = Parse("y/aba/xyz/")
+	assert.EqualError(t, err, `character 'a' is given twice in the pattern`)
+}
+
+func TestFromScript(t *testing.T) {
+	cmds, err := parser.ParseScript("s/teh/the/; s/a/b/q")
+	assert.Nil(t, err)
+
+	_, err = FromScript(cmds)
+	assert.EqualError(t, err, "command 2: unknown flag 'q', supported flags are "+supportedFlags)
+}
+
+func TestInvalidPattern(t *testing.T) {
+	_, err := Parse("s/foo(/bar")
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "invalid regular expression, missing closing ) in foo(", err.Error())
+	}
+
+	_, err = Parse(`s/a[z-a]/b`)
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "invalid regular expression, invalid character class range in z-a", err.Error())
+	}
+
+	s := &Substitution{Pattern: "foo(", Replacement: "bar"}
+	assert.Equal(t, &Result{Original: "foo(", Message: "foo("}, s.Preview("foo("))
+}
+
+func TestLiteral(t *testing.T) {
+	s, err := Parse("s/v1.0(beta)/v1.1 ($5)/l")
+	assert.Nil(t, err)
+	assert.Equal(t, "Released v1.1 ($5) today, not v1x0(beta)", s.Apply("Released v1.0(beta) today, not v1x0(beta)"))
+
+	assert.Equal(t, "C:/new and C:/newer", (&Substitution{Pattern: `C:\\old`, Replacement: "C:/new", Literal: true}).Apply(`C:\old and C:/newer`))
+	assert.Equal(t, "cat concat", (&Substitution{Pattern: "dog", Replacement: "cat", Literal: true}).Apply("dog concat"))
+
+	_, err = Parse("s/a/b/lr")
+	assert.NotNil(t, err)
+}
+
+func TestCollapseWhitespace(t *testing.T) {
+	cases := []struct {
+		replacement string
+		message     string
+		expected    string
+	}{
+		{"", "a very good day", "a good day"},
+		{"", "very good", "good"},
+		{"", "this is very", "this is"},
+		{"", "this is very\nvery good", "this is\ngood"},
+		{" ", "a very good day", "a good day"},
+		{"really ", "a very good day", "a really good day"},
+		{"", "keep  this  very spacing", "keep  this  spacing"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.message, func(t *testing.T) {
+			s := &Substitution{Pattern: "very", Replacement: tc.replacement, CollapseWhitespace: true}
+			assert.Equal(t, tc.expected, s.Apply(tc.message))
+		})
+	}
+
+	s := &Substitution{Pattern: "very", Replacement: ""}
+	assert.Equal(t, "a  good day", s.Apply("a very good day"))
+
+	// Empty matches following the swallowed spaces used to slice the segment backwards.
+	for _, command := range []string{"s/a?//r", "s/|//"} {
+		s, err := Parse(command)
+		assert.Nil(t, err)
+		s.CollapseWhitespace = true
+		assert.NotPanics(t, func() { s.Apply("  ") }, command)
+		assert.NotPanics(t, func() { s.Apply("a  b") }, command)
+	}
+}
+
+func BenchmarkPreview(b *testing.B) {
+	s := &Substitution{Pattern: "teh", Replacement: "the"}
+	message := "I think teh build is broken, see `teh logs` and teh ||spoiler|| for details"
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		s.Preview(message)
+	}
+}
+
+func BenchmarkPreviewDistinctPatterns(b *testing.B) {
+	patterns := []string{"teh", "recieve", "adress", "seperate", "occured"}
+	message := "teh adress was recieve and it occured seperate"
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		s := &Substitution{Pattern: patterns[i%len(patterns)], Replacement: "x"}
+		s.Preview(message)
+	}
+}
+
+func TestFuzzy(t *testing.T) {
+	cases := []struct {
+		command  string
+		message  string
+		expected string
+		matched  []string
+	}{
+		{"s/recieve/receive/f", "I did not receve it", "I did not receive it", []string{"receve"}},
+		{"s/recieve/receive/f", "recieve, recieved or receve", "receive, recieved or receive", []string{"recieve", "receve"}},
+		{"s/recieve/receive/f", "Receve", "Receve", nil},
+		{"s/recieve/receive/fi", "Receve", "receive", []string{"Receve"}},
+		{"s/teh/the/f", "the ten", "the ten", nil},
+		{"s/new yrok/New York/f", "in new yrk today", "in New York today", []string{"new yrk"}},
+		{"s/recieve/[&]/f", "receve", "[receve]", []string{"receve"}},
+		{"s/recieve/receive/f2", "receve recieve", "receve receive", []string{"recieve"}},
+		{"s/c++/C++/f", "c++ and `receve`", "C++ and `receve`", []string{"c++"}},
+	}
+
+	for _, tc := range cases {
+		s, err := Parse(tc.command)
+		if assert.Nil(t, err, tc.command) {
+			result := s.Preview(tc.message)
+			assert.Equal(t, tc.expected, result.Message, tc.command)
+			assert.Equal(t, tc.matched, result.Matched, tc.command)
+		}
+	}
+
+	assert.Equal(t, 3, editDistance([]rune("kitten"), []rune("sitting")))
+	assert.Equal(t, 0, fuzzyDistance(3))
+	assert.Equal(t, 3, fuzzyDistance(40))
+}
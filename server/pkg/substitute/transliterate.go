@@ -0,0 +1,61 @@
+package substitute
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/carmo-evan/mattermost-plugin-replace/server/pkg/parser"
+)
+
+// fromTransliteration builds the Substitution of a y/source/target/ command, which replaces each
+// character of source with the character of target at the same position.
+func fromTransliteration(cmd *parser.Command) (*Substitution, error) {
+	source := []rune(unescape(cmd.Pattern))
+	target := []rune(unescape(cmd.Replacement))
+	if len(source) != len(target) {
+		return nil, errors.Errorf("y needs as many characters in the replacement as in the pattern, got %d and %d", len(target), len(source))
+	}
+
+	mapping := make(map[rune]rune, len(source))
+	for i, r := range source {
+		if _, ok := mapping[r]; ok {
+			return nil, errors.Errorf("character %q is given twice in the pattern", r)
+		}
+		mapping[r] = target[i]
+	}
+
+	return &Substitution{
+		Pattern:         string(source),
+		Replacement:     string(target),
+		FirstLine:       cmd.FirstLine,
+		LastLine:        cmd.LastLine,
+		Transliteration: mapping,
+	}, nil
+}
+
+// characterClass returns a regular expression matching any one character of chars.
+func characterClass(chars string) string {
+	var class strings.Builder
+
+	class.WriteRune('[')
+	for _, r := range chars {
+		if strings.ContainsRune(`\[]^-`, r) {
+			class.WriteRune('\\')
+		}
+		class.WriteRune(r)
+	}
+	class.WriteRune(']')
+
+	return class.String()
+}
+
+// transliterate replaces the characters of text by their counterparts in s.Transliteration.
+func (s *Substitution) transliterate(text string) []byte {
+	return []byte(strings.Map(func(r rune) rune {
+		if mapped, ok := s.Transliteration[r]; ok {
+			return mapped
+		}
+		return r
+	}, text))
+}
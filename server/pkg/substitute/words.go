@@ -0,0 +1,50 @@
+package substitute
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// isWordChar reports whether r belongs to a word: a letter, a digit, an underscore or a mark
+// combining with the letter before it, in any script.
+func isWordChar(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r)
+}
+
+// isUnspaced reports whether r belongs to a script written without spaces between words, in which
+// every character may start or end a word.
+func isUnspaced(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Thai, unicode.Lao, unicode.Khmer, unicode.Myanmar)
+}
+
+// isWordBoundary reports whether byte offset i of s separates a word from what surrounds it, as
+// \b does for ASCII only. The start and end of s are boundaries next to word characters.
+func isWordBoundary(s string, i int) bool {
+	before, after := rune(-1), rune(-1)
+	if i > 0 {
+		before, _ = utf8.DecodeLastRuneInString(s[:i])
+	}
+	if i < len(s) {
+		after, _ = utf8.DecodeRuneInString(s[i:])
+	}
+
+	if before >= 0 && after >= 0 && (isUnspaced(before) || isUnspaced(after)) {
+		return true
+	}
+
+	return (before >= 0 && isWordChar(before)) != (after >= 0 && isWordChar(after))
+}
+
+// atWordBounds reports whether the match of segment from start to end is a run of whole words, as
+// far as it starts or ends with a word character, when s requires whole words. Edges made of
+// other characters, such as the parenthesis of "v1.0(beta)", need no boundary.
+func (s *Substitution) atWordBounds(segment string, start, end int) bool {
+	if s.Transliteration != nil || s.Regex && !s.WholeWords || !s.matchesWholeWords() || start == end {
+		return true
+	}
+
+	first, _ := utf8.DecodeRuneInString(segment[start:end])
+	last, _ := utf8.DecodeLastRuneInString(segment[start:end])
+
+	return (!isWordChar(first) || isWordBoundary(segment, start)) && (!isWordChar(last) || isWordBoundary(segment, end))
+}
@@ -3,9 +3,11 @@ package main
 import (
 	"fmt"
 	"net/http"
-	"regexp"
+	"sort"
 	"strings"
 	"sync"
+	"time"
+	"unicode/utf8"
 
 	"github.com/blang/semver"
 	"github.com/gorilla/mux"
@@ -13,12 +15,29 @@ import (
 
 	"github.com/mattermost/mattermost-server/model"
 	"github.com/mattermost/mattermost-server/plugin"
+
+	"github.com/carmo-evan/mattermost-plugin-replace/server/pkg/parser"
+	"github.com/carmo-evan/mattermost-plugin-replace/server/pkg/substitute"
 )
 
+// usageRepeatInterval is how long the compact error replaces the full usage after a malformed
+// command.
+const usageRepeatInterval = 10 * time.Minute
+
 const (
 	minServerVersion  string = "5.10.0" // dependent on method SearchPostsInTeam
-	usage             string = `Usage: s/{text to be replaced}/{new text}`
+	usage             string = `Usage: s/{text to be replaced}/{new text}[/{flags}]`
+	compactUsage      string = "Type `/replace help` for the syntax."
 	noPostsFoundError string = "`s/ Command: No previous post to be replaced.`"
+	propsDrivenError  string = "`s/ Command: Your last post is rendered from %s by an integration and cannot be edited safely.`"
+	publicOnlyError   string = "`s/ Command: Editing posts is only enabled in public channels.`"
+	moderatorError    string = "`s/ Command: You are not allowed to edit other users' posts in that channel.`"
+	archivedError     string = "`s/ Command: The post cannot be edited, as its channel is archived.`"
+	readOnlyError     string = "`s/ Command: The post cannot be edited, as ~%s is read-only.`"
+	moderationError   string = "`s/ Command: Editing posts in ~%s is disabled by the channel's moderation settings.`"
+
+	// editedCommandRejection is the reason given for rejecting a post edited into a command.
+	editedCommandRejection string = "s/ commands are applied to your previous post rather than saved as an edit."
 )
 
 type Plugin struct {
@@ -26,15 +45,53 @@ type Plugin struct {
 
 	router *mux.Router
 
+	// botUserID is the account used for public confirmations and other posts by the plugin.
+	botUserID string
+
 	// configurationLock synchronizes access to the configuration.
 	configurationLock sync.RWMutex
 
 	// configuration is the active plugin configuration. Consult getConfiguration and
 	// setConfiguration for usage.
 	configuration *configuration
+
+	// spellcheckCache holds recent answers from the spellcheck service.
+	spellcheckCache ttlCache
+
+	// userCache and channelCache hold recently fetched users and channels.
+	userCache    ttlCache
+	channelCache ttlCache
+
+	// ownUpdates holds the IDs of the posts being updated by the plugin.
+	ownUpdates ttlCache
+
+	// usageShown remembers users recently shown the full usage after a malformed command.
+	usageShown ttlCache
+
+	// shadowLock serializes updates to the shadow mode statistics.
+	shadowLock sync.Mutex
+
+	// editLock serializes the final check and update of posts edited by s/ commands, so that two
+	// commands on the same post cannot overwrite each other.
+	editLock sync.Mutex
+
+	// pruneStop stops the pruning job when closed.
+	pruneStop chan struct{}
+
+	// scheduleLock serializes updates to the scheduled replacements, and scheduleStop stops the
+	// scheduler when closed.
+	scheduleLock sync.Mutex
+	scheduleStop chan struct{}
 }
 
 func (p *Plugin) ServeHTTP(c *plugin.Context, w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			p.reportPanic(recovered, map[string]string{"hook": "ServeHTTP", "path": r.URL.Path, "user_id": r.Header.Get("Mattermost-User-Id")})
+			http.Error(w, "internal error", http.StatusInternalServerError)
+		}
+	}()
+
 	if r.Header.Get("Mattermost-User-Id") == "" {
 		http.Error(w, "please log in", http.StatusForbidden)
 		return
@@ -58,130 +115,783 @@ func (p *Plugin) checkServerVersion() error {
 	return nil
 }
 
-// OnActivate registers the /s command with the API
+// OnActivate checks the server version, ensures the plugin bot exists, sets up the HTTP API,
+// starts the pruning job and the scheduler, and registers the /replace and /replace-all commands
+// with the API
 func (p *Plugin) OnActivate() error {
-	return p.checkServerVersion()
+	if err := p.checkServerVersion(); err != nil {
+		return err
+	}
+
+	botUserID, err := p.ensureBot()
+	if err != nil {
+		return err
+	}
+	p.botUserID = botUserID
+
+	p.router = p.initializeAPI()
+	p.startPruning()
+	p.startScheduler()
+
+	for _, command := range []*model.Command{getCommand(), getReplaceAllCommand()} {
+		if err := p.API.RegisterCommand(command); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
-func splitAndValidateInput(message string) ([]string, error) {
+// OnDeactivate stops the pruning job and the scheduler.
+func (p *Plugin) OnDeactivate() error {
+	p.stopPruning()
+	p.stopScheduler()
 
-	input := strings.TrimSpace(strings.TrimPrefix(message, "s/"))
+	return nil
+}
+
+// getLastPost finds the last post of user within target that requesterID may edit, or the nth
+// most recent one when target has a Rank. The second return value is the error message to show
+// the user, if any.
+func (p *Plugin) getLastPost(user *model.User, requesterID string, target *postTarget) (*model.Post, string) {
+	if target.PostID != "" {
+		return p.getPostByID(user, requesterID, target)
+	}
 
-	if input == "" {
-		return nil, errors.New("No input")
+	rank := target.Rank
+	if rank < 1 {
+		rank = 1
 	}
 
-	strs := strings.Split(input, "/")
+	if lookback := p.getConfiguration().LookbackPosts; lookback > 0 && rank > lookback {
+		return nil, fmt.Sprintf("`s/ Command: Only your last %d posts may be edited.`", lookback)
+	}
 
-	if len(strs) < 2 || len(strs[0]) < 1 || len(strs[1]) < 1 {
-		return nil, errors.New("Bad user input")
+	posts, errMsg := p.getRecentPosts(user, requesterID, target, rank)
+	if errMsg != "" {
+		return nil, errMsg
+	}
+
+	// In a thread the user has not posted in, their last post in the channel is edited instead.
+	if len(posts) == 0 && target.RootID != "" && !target.Root && p.getConfiguration().ThreadFallback {
+		channelTarget := *target
+		channelTarget.RootID = ""
+		if posts, errMsg = p.getRecentPosts(user, requesterID, &channelTarget, rank); errMsg != "" {
+			return nil, errMsg
+		}
 	}
 
-	return strs, nil
+	if len(posts) < rank {
+		return nil, target.notFoundMessage()
+	}
+
+	return posts[rank-1], ""
 }
 
-func (p *Plugin) getLastPost(user *model.User, teamId string, rootId string) (*model.Post, string) {
+// getPostByID returns the post named by target, after checking that user wrote it and that
+// requesterID may edit posts in its channel. Posts of others are reported as not found, so that
+// their IDs reveal nothing. The second return value is the error message to show the user, if
+// any.
+func (p *Plugin) getPostByID(user *model.User, requesterID string, target *postTarget) (*model.Post, string) {
+	if target.Rank > 1 {
+		return nil, "`s/ Command: ~n cannot be combined with a permalink or post ID.`"
+	}
+
+	post, appErr := p.API.GetPost(target.PostID)
+	if appErr != nil || post.DeleteAt != 0 || post.UserId != user.Id || !isTypedPost(post) || !target.matches(post) {
+		return nil, target.notFoundMessage()
+	}
+
+	if !p.API.HasPermissionToChannel(requesterID, post.ChannelId, model.PERMISSION_EDIT_POST) {
+		return nil, target.notFoundMessage()
+	}
+
+	return post, ""
+}
+
+// getRecentPosts returns up to limit posts of user within target that requesterID may edit, most
+// recent first, and never more than the LookbackPosts setting allows. The second return value is
+// the error message to show the user, if any.
+func (p *Plugin) getRecentPosts(user *model.User, requesterID string, target *postTarget, limit int) ([]*model.Post, string) {
+	if lookback := p.getConfiguration().LookbackPosts; lookback > 0 && limit > lookback {
+		limit = lookback
+	}
+
+	var ranked []*model.Post
 
 	// if we have a rootId, it means we are in a chat thread.
-	if rootId != "" {
-		postThread, err := p.API.GetPostThread(rootId)
+	if target.RootID != "" {
+		postThread, err := p.API.GetPostThread(target.RootID)
 		if err != nil {
 			return nil, err.Error()
 		}
 
-		//HACK: adding Orders to the postThread to be able to sort it
-		// because API.GetPostThread returns a postList without the Orders
+		// GetPostThread returns the posts without their order, so they are ranked here, most
+		// recent first. Posts created in the same millisecond are ordered by ID, so that the
+		// choice never depends on the iteration order of the map.
+		posts := make([]*model.Post, 0, len(postThread.Posts))
 		for _, post := range postThread.Posts {
-			postThread.AddOrder(post.Id)
+			posts = append(posts, post)
+		}
+		sort.Slice(posts, func(i, j int) bool {
+			if posts[i].CreateAt != posts[j].CreateAt {
+				return posts[i].CreateAt > posts[j].CreateAt
+			}
+			return posts[i].Id > posts[j].Id
+		})
+
+		if lookback := p.getConfiguration().limits().MaxLookbackPosts; lookback > 0 && len(posts) > lookback && !target.Root {
+			posts = posts[:lookback]
 		}
 
-		postThread.SortByCreateAt()
+		for _, post := range posts {
+			if target.Root && post.Id != target.RootID {
+				continue
+			}
 
-		for _, key := range postThread.Order {
-			post := postThread.Posts[key]
-			if post.UserId == user.Id {
-				return post, ""
+			if post.UserId == user.Id && isTypedPost(post) && target.matches(post) {
+				ranked = append(ranked, post)
+			}
+			if len(ranked) == limit {
+				break
 			}
 		}
 
-		return nil, noPostsFoundError
+		return ranked, ""
+	}
+
+	// Direct and group messages belong to no team and cannot be searched.
+	if target.TeamID == "" {
+		return p.getRecentChannelPosts(user, target, limit)
 	}
 
-	searchParams := model.ParseSearchParams("from:"+user.Username, 0)
+	terms := "from:" + user.Username
+	if target.ChannelName != "" {
+		terms += " in:" + target.ChannelName
+	}
+	if target.Phrase != "" {
+		terms += ` "` + target.Phrase + `"`
+	}
 
-	posts, err := p.API.SearchPostsInTeam(teamId, searchParams)
+	searchParams := model.ParseSearchParams(terms, 0)
+
+	posts, err := p.API.SearchPostsInTeam(target.TeamID, searchParams)
 
 	if err != nil {
 		return nil, err.Error()
 	}
 
-	if len(posts) < 1 {
-		return nil, noPostsFoundError
+	// The search runs with the plugin's privileges and may return posts from channels the
+	// requester can no longer edit in, such as channels they left. Those are skipped, as the
+	// user scoped search of Mattermost 5.26 and later would; the plugin API of the server version
+	// this plugin supports does not offer it. Phrase searches also match word stems, so the
+	// phrase is checked again.
+	for _, post := range posts {
+		if target.ChannelID != "" && post.ChannelId != target.ChannelID {
+			continue
+		}
+
+		if isTypedPost(post) && target.matches(post) && p.API.HasPermissionToChannel(requesterID, post.ChannelId, model.PERMISSION_EDIT_POST) {
+			ranked = append(ranked, post)
+		}
+		if len(ranked) == limit {
+			break
+		}
+	}
+
+	return ranked, ""
+}
+
+// getRecentChannelPosts returns up to limit posts of user in the channel of target, most recent
+// first, by paging through the channel instead of searching. At most MaxLookbackPosts posts of the
+// channel are inspected, or bulkMaxPosts without that limit. The second return value is the
+// error message to show the user, if any.
+func (p *Plugin) getRecentChannelPosts(user *model.User, target *postTarget, limit int) ([]*model.Post, string) {
+	maxPosts := p.getConfiguration().limits().MaxLookbackPosts
+	if maxPosts <= 0 {
+		maxPosts = bulkMaxPosts
+	}
+
+	var ranked []*model.Post
+	for page, scanned := 0, 0; scanned < maxPosts && len(ranked) < limit; page++ {
+		postList, appErr := p.API.GetPostsForChannel(target.ChannelID, page, bulkPageSize)
+		if appErr != nil {
+			return nil, appErr.Error()
+		}
+
+		for _, id := range postList.Order {
+			if scanned >= maxPosts || len(ranked) == limit {
+				break
+			}
+			scanned++
+
+			post := postList.Posts[id]
+			if post.UserId == user.Id && isTypedPost(post) && target.matches(post) {
+				ranked = append(ranked, post)
+			}
+		}
+
+		if len(postList.Order) < bulkPageSize {
+			break
+		}
+	}
+
+	return ranked, ""
+}
+
+// invalidCommandMessage returns the reply to a malformed command, pointing out what is wrong
+// with it. Users experimenting tend to send several in a row, so the full usage is only shown
+// for the first of them and a pointer to the help afterwards, until a command is parsed
+// successfully or usageRepeatInterval has passed.
+func (p *Plugin) invalidCommandMessage(userID string, err error) string {
+	hint := usage
+	if _, shown := p.usageShown.get(userID); shown {
+		hint = compactUsage
+	} else {
+		p.usageShown.set(userID, true, usageRepeatInterval)
+	}
+
+	return fmt.Sprintf("Invalid command format: %s. %s", err.Error(), hint)
+}
+
+// Ways of telling the user why an s/ command was refused.
+const (
+	rejectEphemeral string = "ephemeral"
+	rejectReason    string = "reason"
+)
+
+// dismiss refuses an s/ command with message. By default the post is dismissed and message sent
+// as an ephemeral post; with the reason rejection mode it is returned as the rejection reason
+// instead, which clients show as an error below the message box.
+func (p *Plugin) dismiss(userID string, notification *model.Post, message string) (*model.Post, string) {
+	if p.getConfiguration().RejectionMode == rejectReason {
+		// The reason is shown as plain text, so drop the code span wrapping most messages.
+		return nil, strings.Trim(message, "`")
+	}
+
+	notification.Message = message
+	p.API.SendEphemeralPost(userID, notification)
+
+	return nil, "plugin.message_will_be_posted.dismiss_post"
+}
+
+// rejectCommand refuses an s/ command that cannot be applied. In shadow mode the rejection is
+// only recorded and the post goes through.
+func (p *Plugin) rejectCommand(userID string, notification *model.Post, message string) (*model.Post, string) {
+	if p.getConfiguration().ShadowMode {
+		p.recordShadow(shadowRejected, 0)
+		return nil, ""
+	}
+
+	return p.dismiss(userID, notification, message)
+}
+
+// isChannelAllowed reports whether posts in channel may be edited under the PublicChannelsOnly
+// setting.
+func (p *Plugin) isChannelAllowed(channel *model.Channel) bool {
+	return !p.getConfiguration().PublicChannelsOnly || channel.Type == model.CHANNEL_OPEN
+}
+
+// updatePost updates post, marking it as edited by the plugin while the update runs so that
+// MessageWillBeUpdated lets it through. UpdatePost saves the file attachments, props and pinned
+// and reaction flags of post along with its message, so post must be complete as fetched from
+// the server, with only its message changed.
+func (p *Plugin) updatePost(post *model.Post) (*model.Post, *model.AppError) {
+	p.ownUpdates.set(post.Id, true, time.Minute)
+	defer p.ownUpdates.delete(post.Id)
+
+	return p.API.UpdatePost(post)
+}
+
+// refreshEdit fetches post again right before it is updated, so that the update starts from the
+// post as stored rather than as found by a search. The post may also have been edited since it
+// was looked up, by the user or by a command typed on another device, in which case subs are
+// applied again to its current message. It returns the post, result and confirmation to save, or
+// the error message to show the user if the post is gone or no longer contains the pattern.
+func (p *Plugin) refreshEdit(post *model.Post, subs []*substitute.Substitution, result *substitute.Result, confirmation string) (*model.Post, *substitute.Result, string, string) {
+	current, appErr := p.API.GetPost(post.Id)
+	if appErr != nil || current.DeleteAt != 0 {
+		return nil, nil, "", "`s/ Command: The post was deleted before it could be edited.`"
+	}
+
+	if current.UpdateAt == post.UpdateAt && current.EditAt == post.EditAt {
+		return current, result, confirmation, ""
+	}
+
+	matched := result.Replacements > 0
+	result, confirmation = applyScript(subs, current.Message)
+	if matched && result.Replacements == 0 {
+		return nil, nil, "", "`s/ Command: The post was edited in the meantime and no longer contains the pattern.`"
+	}
+
+	return current, result, confirmation, ""
+}
+
+// noMatchMessage tells the user that the post selected by target, written by author, does not
+// contain the pattern.
+func noMatchMessage(target *postTarget, author *model.User, moderated bool) string {
+	post := "your last post"
+	switch {
+	case target.PostID != "":
+		post = "the selected post"
+	case target.Rank > 1:
+		post = "your " + ordinal(target.Rank) + " most recent post"
+	}
+	if moderated {
+		post = "the last post of @" + author.Username
+	}
+
+	return fmt.Sprintf("`s/ Command: The pattern was not found in %s, so it was left unchanged.`", post)
+}
+
+// previewMessage shows message, the text the post at link would have after a dry run, followed by
+// the confirmation of the command.
+func previewMessage(link, message, confirmation string) string {
+	return fmt.Sprintf("Preview of [the post](%s), which was left unchanged:\n%s\n%s", link, quoteMessage(message), confirmation)
+}
+
+// maxMessageRunes is the longest message the server stores, in characters.
+const maxMessageRunes = model.POST_MESSAGE_MAX_RUNES_V2
+
+// messageExcess returns by how many characters message is longer than the server allows.
+func messageExcess(message string) int {
+	return utf8.RuneCountInString(message) - maxMessageRunes
+}
+
+// lengthError returns why message, the result of a command, cannot be saved because it is longer
+// than the server allows, or the empty string if it fits.
+func lengthError(message string) string {
+	excess := messageExcess(message)
+	if excess <= 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("`s/ Command: The result would be %d characters over the limit of %d, so nothing was changed.`", excess, maxMessageRunes)
+}
+
+// editRejection returns why post may not be edited by a command of userID, or the empty string
+// if it may.
+func (p *Plugin) editRejection(post *model.Post, userID string) (string, *model.AppError) {
+	// The channel may have been archived or converted since it was cached, so it is fetched
+	// afresh for the decision.
+	postChannel, appErr := p.getFreshChannel(post.ChannelId)
+	if appErr != nil {
+		return "", appErr
+	}
+
+	// Selectors may reach a post in another channel, which must be allowed as well.
+	if !p.isChannelAllowed(postChannel) {
+		return publicOnlyError, nil
+	}
+
+	// UpdatePost fails on such posts with an error meant for the logs, so the reason is given
+	// up front.
+	if rejection := p.channelRejection(postChannel, userID); rejection != "" {
+		return rejection, nil
+	}
+
+	// Posts drawn from props, such as polls or workflow cards, would be corrupted by editing
+	// only their message.
+	if reason := propsDrivenReason(post); reason != "" {
+		return fmt.Sprintf(propsDrivenError, reason), nil
+	}
+
+	return "", nil
+}
+
+// channelRejection returns why userID may not edit posts in channel, as the channel is archived,
+// read-only or moderated, or the empty string if they may.
+func (p *Plugin) channelRejection(channel *model.Channel, userID string) string {
+	if channel.DeleteAt != 0 {
+		return archivedError
+	}
+
+	// Town Square may be made read-only for all but system admins.
+	if channel.Name == model.DEFAULT_CHANNEL {
+		config := p.API.GetConfig()
+		if config != nil && config.TeamSettings.ExperimentalTownSquareIsReadOnly != nil && *config.TeamSettings.ExperimentalTownSquareIsReadOnly &&
+			!p.API.HasPermissionTo(userID, model.PERMISSION_MANAGE_SYSTEM) {
+			return fmt.Sprintf(readOnlyError, channel.Name)
+		}
+	}
+
+	// Channel schemes may take the permission away from members.
+	if !p.API.HasPermissionToChannel(userID, channel.Id, model.PERMISSION_EDIT_POST) {
+		return fmt.Sprintf(moderationError, channel.Name)
 	}
 
-	return posts[0], ""
+	return ""
 }
 
-func replace(str, old, new string) string {
-	re := regexp.MustCompile(`\b(` + old + `)\b`)
-	return re.ReplaceAllString(str, new)
+// isTypedPost reports whether post was typed by its author, as opposed to system messages such as
+// joins and leaves, and posts made by webhooks and other integrations under the author's name.
+// Only typed posts are looked up by s/ commands. Posts of plugin types are still looked up, so
+// that the user is told why they cannot be edited rather than having an older post edited.
+func isTypedPost(post *model.Post) bool {
+	return !post.IsSystemMessage() && post.Type != model.POST_SLACK_ATTACHMENT && post.Props["from_webhook"] != "true"
+}
+
+// propsDrivenReason describes why the visible content of post is generated from its props by
+// another plugin or integration, or returns the empty string if editing its message is safe.
+func propsDrivenReason(post *model.Post) string {
+	if strings.HasPrefix(post.Type, model.POST_CUSTOM_TYPE_PREFIX) {
+		return fmt.Sprintf("a custom post type (%s)", post.Type)
+	}
+
+	for _, attachment := range post.Attachments() {
+		if len(attachment.Actions) > 0 {
+			return "interactive message attachments"
+		}
+	}
+
+	return ""
 }
 
 // MessageWillBePosted parses every post. If our s/ command is present, it replaces the last post.
-func (p *Plugin) MessageWillBePosted(c *plugin.Context, post *model.Post) (*model.Post, string) {
-	trimmedMessage := strings.TrimSpace(post.Message)
+func (p *Plugin) MessageWillBePosted(c *plugin.Context, post *model.Post) (_ *model.Post, rejection string) {
+	defer func() {
+		if r := recover(); r != nil {
+			p.reportPanic(r, postContext("MessageWillBePosted", post))
+			rejection = ""
+		}
+	}()
+
+	return p.runCommand(post, "")
+}
+
+// MessageWillBeUpdated catches posts edited into an s/ command. The command is applied to the
+// previous post of the user, leaving out the edited one, and the edit itself is rejected so that
+// the post keeps its message.
+func (p *Plugin) MessageWillBeUpdated(c *plugin.Context, newPost, oldPost *model.Post) (updated *model.Post, rejection string) {
+	defer func() {
+		if r := recover(); r != nil {
+			p.reportPanic(r, postContext("MessageWillBeUpdated", newPost))
+			updated, rejection = newPost, ""
+		}
+	}()
+
+	// Posts edited by the plugin itself, and commands that were already commands, are let
+	// through.
+	config := p.getConfiguration()
+	if _, own := p.ownUpdates.get(newPost.Id); own {
+		return newPost, ""
+	}
+	if _, wasCommand := config.canonicalCommand(strings.TrimSpace(oldPost.Message)); wasCommand {
+		return newPost, ""
+	}
+	if _, isCommand := config.canonicalCommand(strings.TrimSpace(newPost.Message)); !isCommand {
+		return newPost, ""
+	}
 
-	//Explicitly check if the message starts with "s/" after trimming whitespace.
-	if !strings.HasPrefix(trimmedMessage, "s/") {
+	command := newPost.Clone()
+	command.RootId = oldPost.RootId
+	if _, reason := p.runCommand(command, oldPost.Id); reason == "" {
+		// Shadow mode lets the command through.
+		return newPost, ""
+	}
+
+	return nil, editedCommandRejection
+}
+
+// runCommand applies the s/ command in post, if any, to the last post of its author other than
+// excludedID. The return values are those of MessageWillBePosted.
+func (p *Plugin) runCommand(post *model.Post, excludedID string) (*model.Post, string) {
+	config := p.getConfiguration()
+
+	//Explicitly check if the message starts with "s/", "y/" or an alias after trimming whitespace.
+	trimmedMessage, isCommand := config.canonicalCommand(strings.TrimSpace(post.Message))
+	if !isCommand {
+		// alice: s/teh/the/ quotes the corrected post of alice instead of editing it.
+		if username, command, ok := config.correctionCommand(strings.TrimSpace(post.Message)); ok {
+			return p.runCorrection(post, username, command)
+		}
 		return nil, ""
 	}
 
 	//notification that will be sent as an ephemeral post
-	notification := &model.Post{ChannelId: post.ChannelId, CreateAt: model.GetMillis(), RootId: post.RootId}
+	notification := newNotification(post)
+	defer releaseNotification(notification)
+	// The edit history is browsed and restored with commands of its own.
+	n, revert := revertNumber(trimmedMessage)
+	switch {
+	case trimmedMessage == undoCommand:
+		return p.runRevisionCommand(post, notification, p.undoLastEdit)
+	case trimmedMessage == historyCommand:
+		return p.runRevisionCommand(post, notification, p.listHistory)
+	case revert:
+		return p.runRevisionCommand(post, notification, func(userID, teamID string) (string, bool, error) {
+			return p.revertEdit(userID, teamID, n)
+		})
+	}
+
 	//Validate input
-	oldAndNew, err := splitAndValidateInput(trimmedMessage)
+	cmds, err := parser.ParseScript(trimmedMessage)
+	var subs []*substitute.Substitution
+	if err == nil {
+		subs, err = substitute.FromScript(cmds)
+	}
 
-	//Handle cases where the format is invalid *after* "s/" (e.g., "s/foo", "s//bar")
+	//Handle cases where the format is invalid *after* "s/" (e.g., "s/foo", "s//bar", "s/foo/bar/q")
 	if err != nil {
-		errMsg := fmt.Sprintf("Invalid command format. %s", usage)
-		notification.Message = errMsg
-		p.API.SendEphemeralPost(post.UserId, notification)
-		return nil, "plugin.message_will_be_posted.dismiss_post"
-	}
+		if config.ShadowMode {
+			p.recordShadow(shadowInvalid, 0)
+			return nil, ""
+		}
 
-	old := oldAndNew[0]
+		message := p.invalidCommandMessage(post.UserId, err)
+		if word, ok := suggestionWord(trimmedMessage); ok && config.SpellcheckURL != "" {
+			message = p.suggestionMessage(word)
+		}
+		return p.dismiss(post.UserId, notification, message)
+	}
 
-	new := oldAndNew[1]
+	p.usageShown.delete(post.UserId)
 
 	//Get user data
-	user, appErr := p.API.GetUser(post.UserId)
+	user, appErr := p.getUser(post.UserId)
 	if appErr != nil {
+		p.reportError(appErr, postContext("MessageWillBePosted", post))
 		return nil, ""
 	}
 
 	//Find channel to get access to teamId
-	ch, appErr := p.API.GetChannel(post.ChannelId)
+	ch, appErr := p.getChannel(post.ChannelId)
 	if appErr != nil {
+		p.reportError(appErr, postContext("MessageWillBePosted", post))
 		return nil, ""
 	}
 
+	if explainsScript(subs) {
+		notification.Message = p.explainCommand(post.UserId, ch.TeamId, trimmedMessage)
+		p.API.SendEphemeralPost(post.UserId, notification)
+		return nil, "plugin.message_will_be_posted.dismiss_post"
+	}
+
+	for i, sub := range subs {
+		if err := config.applyDefaults(sub, ch.TeamId, user.Id); err != nil {
+			return p.rejectCommand(post.UserId, notification, invalidPatternMessage(i, len(subs), err))
+		}
+	}
+
+	if !p.isChannelAllowed(ch) {
+		return p.rejectCommand(post.UserId, notification, publicOnlyError)
+	}
+
+	// Scopes follow the last command and apply to all of them.
+	scopes := cmds[len(cmds)-1].Scopes
+
+	target, errMsg := p.resolveTarget(user, ch, post.RootId, scopes)
+	if errMsg != "" {
+		return p.rejectCommand(post.UserId, notification, errMsg)
+	}
+	target.Rank = cmds[len(cmds)-1].Rank
+	target.ExcludedID = excludedID
+
+	var runAt time.Time
+	if at, ok := scopes["at"]; ok {
+		if runAt, err = parseAt(at, userLocation(user), time.Now()); err != nil {
+			return p.rejectCommand(post.UserId, notification, "`s/ Command: at: must be followed by a time such as 17:00 or 2026-03-01T09:30.`")
+		}
+	}
+
+	author, errMsg := p.resolveAuthor(user, target.TeamID, scopes)
+	if errMsg != "" {
+		return p.rejectCommand(post.UserId, notification, errMsg)
+	}
+	moderated := author.Id != user.Id && !author.IsBot
+
 	// find posts by user name
-	lastPost, errId := p.getLastPost(user, ch.TeamId, post.RootId)
+	lastPost, errId := p.getLastPost(author, user.Id, target)
 	if errId != "" {
-		notification.Message = errId
-		p.API.SendEphemeralPost(user.Id, notification)
+		return p.rejectCommand(post.UserId, notification, errId)
+	}
+
+	refusal, appErr := p.editRejection(lastPost, user.Id)
+	if appErr != nil {
+		p.reportError(appErr, postContext("MessageWillBePosted", lastPost))
+		return nil, ""
+	}
+	if refusal != "" {
+		return p.rejectCommand(post.UserId, notification, refusal)
+	}
+	if moderated && !p.canModerate(user.Id, lastPost.ChannelId) {
+		return p.rejectCommand(post.UserId, notification, moderatorError)
+	}
+
+	prefs, err := p.getUserPreferences(user.Id)
+	if err != nil {
+		p.reportError(err, postContext("MessageWillBePosted", post))
+		return nil, ""
+	}
+
+	for _, sub := range subs {
+		prefs.apply(sub)
+	}
+	result, confirmation := applyScript(subs, lastPost.Message)
+	_, attachmentReplacements := rewriteAttachments(subs, lastPost)
+
+	// A last post without the pattern would be left unchanged, so the newest recent post
+	// containing it is edited instead, unless the post was selected explicitly or belongs to
+	// another user.
+	searchedBack := false
+	if result.Replacements+attachmentReplacements == 0 && target.PostID == "" && target.Rank <= 1 && !moderated {
+		if earlier, earlierResult, earlierConfirmation := p.searchBack(ch, author, user.Id, target, subs); earlier != nil {
+			lastPost, result, confirmation = earlier, earlierResult, earlierConfirmation
+			searchedBack = true
+		}
+	}
+
+	// A dry run only shows the result, whatever the channel does with commands.
+	if previewsScript(subs) && !config.ShadowMode {
+		if result.Replacements+attachmentReplacements == 0 {
+			return p.rejectCommand(post.UserId, notification, noMatchMessage(target, author, moderated))
+		}
+		if errMsg := lengthError(result.Message); errMsg != "" {
+			return p.rejectCommand(post.UserId, notification, errMsg)
+		}
+		if attachmentReplacements > 0 {
+			confirmation += "\n" + attachmentsConfirmation(attachmentReplacements)
+		}
+		notification.Message = previewMessage(p.permalink(target.TeamID, lastPost.Id), result.Message, confirmation)
+		p.API.SendEphemeralPost(post.UserId, notification)
+		return nil, "plugin.message_will_be_posted.dismiss_post"
+	}
+
+	// In channels corrected with quotes, the post is left alone and the command is replaced by a
+	// quote of the corrected text.
+	if !config.ShadowMode {
+		mode, err := p.getChannelCorrectionMode(lastPost.ChannelId)
+		if err != nil {
+			p.reportError(err, postContext("MessageWillBePosted", post))
+			return nil, ""
+		}
+
+		if mode == correctionQuote {
+			switch {
+			case !runAt.IsZero():
+				return p.rejectCommand(post.UserId, notification, "`s/ Command: Posts in this channel are corrected with a quote, which cannot be scheduled.`")
+			case excludedID != "":
+				return p.rejectCommand(post.UserId, notification, "`s/ Command: Posts in this channel are corrected with a quote, post the command as a new message.`")
+			case lastPost.ChannelId != ch.Id:
+				return p.rejectCommand(post.UserId, notification, "`s/ Command: Posts in that channel are corrected with a quote, type the command there.`")
+			case result.Replacements == 0:
+				return p.rejectCommand(post.UserId, notification, "`s/ Command: Your last post does not contain the pattern.`")
+			}
+
+			post.Message = correctionMessage(author.Username, p.permalink(target.TeamID, lastPost.Id), result.Message)
+			if errMsg := lengthError(post.Message); errMsg != "" {
+				return p.rejectCommand(post.UserId, notification, errMsg)
+			}
+			return post, ""
+		}
+	}
+
+	// In shadow mode the command is only recorded, and the s/ message is posted as is.
+	if config.ShadowMode {
+		outcome := shadowEdited
+		if result.Replacements == 0 {
+			outcome = shadowUnchanged
+		}
+		p.recordShadow(outcome, result.Replacements)
+		p.API.LogInfo("Shadow mode: s/ command not applied", "user_id", user.Id, "post_id", lastPost.Id, "replacements", result.Replacements)
+		return nil, ""
+	}
+
+	// Saving an unchanged post would only bump its edit time.
+	if result.Replacements+attachmentReplacements == 0 {
+		return p.rejectCommand(post.UserId, notification, noMatchMessage(target, author, moderated))
+	}
+
+	// A scheduled command is stored with the post it applies to, and applied by the scheduler.
+	if !runAt.IsZero() {
+		if moderated {
+			return p.rejectCommand(post.UserId, notification, "`s/ Command: Edits of other users' posts cannot be scheduled.`")
+		}
+		return p.scheduleCommand(user, notification, &scheduledReplacement{
+			ID:        model.NewId(),
+			PostID:    lastPost.Id,
+			TeamID:    target.TeamID,
+			ChannelID: post.ChannelId,
+			UserID:    user.Id,
+			Command:   trimmedMessage,
+			RunAt:     runAt.UnixNano() / int64(time.Millisecond),
+			CreatedAt: model.GetMillis(),
+		}, runAt)
+	}
+
+	// Risky edits are applied only once the user confirms them, as are large ones whatever the
+	// user chose.
+	confirm, err := p.needsConfirmation(lastPost.ChannelId, prefs)
+	if err != nil {
+		p.reportError(err, postContext("MessageWillBePosted", post))
+		return nil, ""
+	}
+	reason := config.largeEditReason(result)
+	if confirm || reason != "" {
+		if attachmentReplacements > 0 {
+			confirmation += "\n" + attachmentsConfirmation(attachmentReplacements)
+		}
+		p.API.SendEphemeralPost(post.UserId, p.confirmationRequest(post, user.Id, target.TeamID, trimmedMessage, lastPost, result, confirmation, reason))
 		return nil, "plugin.message_will_be_posted.dismiss_post"
 	}
 
-	lastPost.Message = replace(lastPost.Message, old, new)
+	p.editLock.Lock()
+	lastPost, result, confirmation, errMsg = p.refreshEdit(lastPost, subs, result, confirmation)
+	if errMsg != "" {
+		p.editLock.Unlock()
+		return p.rejectCommand(post.UserId, notification, errMsg)
+	}
+
+	// Message attachments are rewritten last, from the post about to be saved.
+	attachments, attachmentReplacements := rewriteAttachments(subs, lastPost)
+	if attachments != nil {
+		lastPost.AddProp("attachments", attachments)
+		confirmation += "\n" + attachmentsConfirmation(attachmentReplacements)
+	}
+
+	if errMsg := lengthError(result.Message); errMsg != "" {
+		p.editLock.Unlock()
+		return p.rejectCommand(post.UserId, notification, errMsg)
+	}
+
+	lastPost.Message = result.Message
+	if moderated {
+		lastPost.AddProp(moderatorProp, user.Id)
+	}
 
-	_, appErr = p.API.UpdatePost(lastPost)
+	_, appErr = p.updatePost(lastPost)
+	p.editLock.Unlock()
 	if appErr != nil {
+		p.reportError(appErr, postContext("MessageWillBePosted", lastPost))
 		return nil, ""
 	}
 
-	notification.Message = `s/ Replaced "` + old + `" for "` + new + `"`
-	p.API.SendEphemeralPost(user.Id, notification)
+	if err := p.recordRevision(lastPost.Id, newRevision(user.Id, trimmedMessage, result.Original, result.Message)); err != nil {
+		p.reportError(err, postContext("MessageWillBePosted", lastPost))
+	} else if err := p.rememberEdit(user.Id, lastPost.Id); err != nil {
+		p.reportError(err, postContext("MessageWillBePosted", lastPost))
+	}
+
+	style, err := p.resolveNotificationStyle(post.ChannelId, prefs)
+	if err != nil {
+		p.reportError(err, postContext("MessageWillBePosted", post))
+	}
+
+	// The edited post may be out of sight, so the confirmation links to it.
+	var prefix string
+	switch {
+	case moderated && result.Replacements > 0:
+		prefix = fmt.Sprintf("You edited [the last post of @%s](%s) as a moderator.\n", author.Username, p.permalink(target.TeamID, lastPost.Id))
+	case searchedBack:
+		prefix = fmt.Sprintf("Your last post does not contain the pattern, so [an earlier post](%s) was edited.\n", p.permalink(target.TeamID, lastPost.Id))
+	case lastPost.ChannelId != ch.Id && result.Replacements > 0:
+		prefix = fmt.Sprintf("[Your post](%s) in another channel was edited.\n", p.permalink(target.TeamID, lastPost.Id))
+	default:
+		prefix = fmt.Sprintf("[Your post](%s) was edited.\n", p.permalink(target.TeamID, lastPost.Id))
+	}
+
+	notification.Message = prefix + confirmation + config.undoHint(user, time.Now())
+	p.sendConfirmation(style, user, notification)
 
 	return nil, "plugin.message_will_be_posted.dismiss_post"
 }
@@ -5,6 +5,7 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/mattermost/mattermost-server/model"
 	"github.com/mattermost/mattermost-server/plugin"
@@ -12,6 +13,9 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carmo-evan/mattermost-plugin-replace/server/pkg/substitute"
 )
 
 func setupTestPlugin(t *testing.T, api *plugintest.API) *Plugin {
@@ -40,6 +44,8 @@ type testAPIConfig struct {
 
 func setupAPI(api *plugintest.API) {
 	api.On("GetServerVersion").Return(minServerVersion)
+	api.On("KVGet", botUserKey).Return([]byte("botUserId"), nil)
+	api.On("RegisterCommand", mock.AnythingOfType("*model.Command")).Return(nil)
 }
 
 // TestExecuteCommand mocks the API calls (by using the private method setupAPI) and validates the inputs given
@@ -86,13 +92,22 @@ func TestExecuteCommand(t *testing.T) {
 			if !tc.isInvalidFormat && tc.shouldDismiss {
 				api.On("GetUser", post.UserId).Return(config.User, nil)
 				api.On("GetChannel", post.ChannelId).Return(config.Channel, nil)
-				if tc.rootId == "" {
-					api.On("SearchPostsInTeam", mock.AnythingOfType("string"), mock.AnythingOfType("[]*model.SearchParams")).Return(config.Posts, nil)
-				} else {
-					api.On("SearchPostsInTeam", mock.AnythingOfType("string"), mock.AnythingOfType("[]*model.SearchParams")).Return(config.Posts, nil)
-				}
+				api.On("GetChannel", "").Return(config.Channel, nil)
+				api.On("SearchPostsInTeam", mock.AnythingOfType("string"), mock.AnythingOfType("[]*model.SearchParams")).Return(config.Posts, nil)
+				api.On("HasPermissionToChannel", post.UserId, "", model.PERMISSION_EDIT_POST).Return(true)
+				api.On("KVGet", preferencesKey(post.UserId)).Return(nil, nil)
+				api.On("KVGet", channelNotificationKey(post.ChannelId)).Return(nil, nil)
+				api.On("KVGet", channelCorrectionKey("")).Return(nil, nil)
+				api.On("KVGet", channelConfirmKey("")).Return(nil, nil)
+				api.On("GetPost", "").Return(config.Posts[0], nil)
 				api.On("UpdatePost", mock.AnythingOfType("*model.Post")).Return(config.Post, nil)
+				api.On("KVGet", historyKey("")).Return(nil, nil)
+				api.On("KVSet", historyKey(""), mock.AnythingOfType("[]uint8")).Return(nil)
+				api.On("KVSet", lastEditKey("testUserId"), []byte("")).Return(nil)
+				api.On("KVSet", undoKey("testUserId"), []byte("")).Return(nil)
 				api.On("SendEphemeralPost", post.UserId, mock.AnythingOfType("*model.Post")).Return(nil)
+				api.On("GetConfig").Return(&model.Config{})
+				api.On("GetTeam", "testTeamId").Return(&model.Team{Name: "engineering"}, nil)
 			} else if tc.isInvalidFormat && tc.shouldDismiss {
 				api.On("SendEphemeralPost", post.UserId, mock.AnythingOfType("*model.Post")).Return(nil)
 			}
@@ -115,16 +130,15 @@ func TestExecuteCommand(t *testing.T) {
 
 		t.Run(tc.command+" - Replace", func(t *testing.T) {
 			trimmedCmd := strings.TrimSpace(tc.command)
-			oldAndNew, err := splitAndValidateInput(trimmedCmd)
+			sub, err := substitute.Parse(trimmedCmd)
 
 			if tc.isInvalidFormat {
 				assert.NotNil(t, err)
 			} else if strings.HasPrefix(trimmedCmd, "s/") {
 				assert.Nil(t, err)
-				assert.NotNil(t, oldAndNew)
-				assert.Len(t, oldAndNew, 2)
+				assert.NotNil(t, sub)
 				if tc.expectedMessage != "" {
-					assert.Equal(t, tc.expectedMessage, replace(tc.message, oldAndNew[0], oldAndNew[1]))
+					assert.Equal(t, tc.expectedMessage, sub.Apply(tc.message))
 				}
 			}
 		})
@@ -136,6 +150,9 @@ func TestPluginOnActivate(t *testing.T) {
 	api := &plugintest.API{}
 
 	api.On("GetServerVersion").Return(minServerVersion)
+	api.On("KVGet", botUserKey).Return([]byte("botUserId"), nil)
+	api.On("RegisterCommand", getCommand()).Return(nil)
+	api.On("RegisterCommand", getReplaceAllCommand()).Return(nil)
 
 	defer api.AssertExpectations(t)
 
@@ -162,3 +179,527 @@ func TestServeHTTP(t *testing.T) {
 
 	assert.Equal("please log in\n", bodyString)
 }
+
+func TestMessageWillBePostedPropsDrivenPost(t *testing.T) {
+	poll := &model.Post{UserId: "testUserId", Message: "Pick one", Type: "custom_poll"}
+	buttons := &model.Post{UserId: "testUserId", Message: "Deploy?"}
+	buttons.AddProp("attachments", []*model.SlackAttachment{{Actions: []*model.PostAction{{Name: "Approve"}}}})
+
+	for _, lastPost := range []*model.Post{poll, buttons} {
+		api := &plugintest.API{}
+		p := setupTestPlugin(t, api)
+
+		api.On("GetUser", "testUserId").Return(&model.User{Id: "testUserId", Username: "test"}, nil)
+		api.On("GetChannel", "testChannelId").Return(&model.Channel{TeamId: "testTeamId"}, nil)
+		api.On("GetChannel", "").Return(&model.Channel{TeamId: "testTeamId"}, nil)
+		api.On("SearchPostsInTeam", "testTeamId", mock.AnythingOfType("[]*model.SearchParams")).Return([]*model.Post{lastPost}, nil)
+		api.On("HasPermissionToChannel", "testUserId", "", model.PERMISSION_EDIT_POST).Return(true)
+		api.On("SendEphemeralPost", "testUserId", mock.MatchedBy(func(notification *model.Post) bool {
+			return strings.Contains(notification.Message, "cannot be edited safely")
+		})).Return(nil)
+
+		_, rejection := p.MessageWillBePosted(&plugin.Context{}, &model.Post{UserId: "testUserId", ChannelId: "testChannelId", Message: "s/one/two"})
+
+		assert.Equal(t, "plugin.message_will_be_posted.dismiss_post", rejection)
+		api.AssertExpectations(t)
+	}
+}
+
+func TestMessageWillBePostedPublicChannelsOnly(t *testing.T) {
+	t.Run("command in a direct message", func(t *testing.T) {
+		api := &plugintest.API{}
+		p := setupTestPlugin(t, api)
+		p.setConfiguration(&configuration{PublicChannelsOnly: true})
+
+		api.On("GetUser", "testUserId").Return(&model.User{Id: "testUserId", Username: "test"}, nil)
+		api.On("GetChannel", "testChannelId").Return(&model.Channel{Id: "testChannelId", Type: model.CHANNEL_DIRECT}, nil)
+		api.On("SendEphemeralPost", "testUserId", mock.MatchedBy(func(notification *model.Post) bool {
+			return notification.Message == publicOnlyError
+		})).Return(nil)
+
+		_, rejection := p.MessageWillBePosted(&plugin.Context{}, &model.Post{UserId: "testUserId", ChannelId: "testChannelId", Message: "s/one/two"})
+
+		assert.Equal(t, "plugin.message_will_be_posted.dismiss_post", rejection)
+		api.AssertExpectations(t)
+	})
+
+	t.Run("last post of the team in a private channel", func(t *testing.T) {
+		api := &plugintest.API{}
+		p := setupTestPlugin(t, api)
+		p.setConfiguration(&configuration{PublicChannelsOnly: true})
+
+		lastPost := &model.Post{UserId: "testUserId", ChannelId: "privateChannelId", Message: "one"}
+		api.On("GetUser", "testUserId").Return(&model.User{Id: "testUserId", Username: "test"}, nil)
+		api.On("GetChannel", "testChannelId").Return(&model.Channel{Id: "testChannelId", TeamId: "testTeamId", Type: model.CHANNEL_OPEN}, nil)
+		api.On("GetChannel", "privateChannelId").Return(&model.Channel{Id: "privateChannelId", TeamId: "testTeamId", Type: model.CHANNEL_PRIVATE}, nil)
+		api.On("SearchPostsInTeam", "testTeamId", mock.AnythingOfType("[]*model.SearchParams")).Return([]*model.Post{lastPost}, nil)
+		api.On("HasPermissionToChannel", "testUserId", "privateChannelId", model.PERMISSION_EDIT_POST).Return(true)
+		api.On("SendEphemeralPost", "testUserId", mock.MatchedBy(func(notification *model.Post) bool {
+			return notification.Message == publicOnlyError
+		})).Return(nil)
+
+		_, rejection := p.MessageWillBePosted(&plugin.Context{}, &model.Post{UserId: "testUserId", ChannelId: "testChannelId", Message: "s/one/two in:*"})
+
+		assert.Equal(t, "plugin.message_will_be_posted.dismiss_post", rejection)
+		assert.Equal(t, "one", lastPost.Message)
+		api.AssertExpectations(t)
+	})
+}
+
+func TestChannelRejection(t *testing.T) {
+	readOnly := true
+	readOnlyConfig := &model.Config{TeamSettings: model.TeamSettings{ExperimentalTownSquareIsReadOnly: &readOnly}}
+
+	t.Run("archived", func(t *testing.T) {
+		p := setupTestPlugin(t, &plugintest.API{})
+
+		channel := &model.Channel{Id: "testChannelId", Name: "deploys", DeleteAt: 1}
+		assert.Equal(t, archivedError, p.channelRejection(channel, "testUserId"))
+	})
+
+	t.Run("read-only town square", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		p := setupTestPlugin(t, api)
+		api.On("GetConfig").Return(readOnlyConfig)
+		api.On("HasPermissionTo", "testUserId", model.PERMISSION_MANAGE_SYSTEM).Return(false)
+
+		channel := &model.Channel{Id: "testChannelId", Name: model.DEFAULT_CHANNEL}
+		assert.Equal(t, "`s/ Command: The post cannot be edited, as ~town-square is read-only.`", p.channelRejection(channel, "testUserId"))
+	})
+
+	t.Run("read-only town square as a system admin", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		p := setupTestPlugin(t, api)
+		api.On("GetConfig").Return(readOnlyConfig)
+		api.On("HasPermissionTo", "testUserId", model.PERMISSION_MANAGE_SYSTEM).Return(true)
+		api.On("HasPermissionToChannel", "testUserId", "testChannelId", model.PERMISSION_EDIT_POST).Return(true)
+
+		channel := &model.Channel{Id: "testChannelId", Name: model.DEFAULT_CHANNEL}
+		assert.Equal(t, "", p.channelRejection(channel, "testUserId"))
+	})
+
+	t.Run("moderated", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		p := setupTestPlugin(t, api)
+		api.On("HasPermissionToChannel", "testUserId", "testChannelId", model.PERMISSION_EDIT_POST).Return(false)
+
+		channel := &model.Channel{Id: "testChannelId", Name: "announcements"}
+		assert.Equal(t, "`s/ Command: Editing posts in ~announcements is disabled by the channel's moderation settings.`", p.channelRejection(channel, "testUserId"))
+	})
+}
+
+func TestMessageWillBePostedRejectionReason(t *testing.T) {
+	api := &plugintest.API{}
+	p := setupTestPlugin(t, api)
+	p.setConfiguration(&configuration{PublicChannelsOnly: true, RejectionMode: rejectReason})
+
+	api.On("GetUser", "testUserId").Return(&model.User{Id: "testUserId", Username: "test"}, nil)
+	api.On("GetChannel", "testChannelId").Return(&model.Channel{Id: "testChannelId", Type: model.CHANNEL_DIRECT}, nil)
+
+	_, rejection := p.MessageWillBePosted(&plugin.Context{}, &model.Post{UserId: "testUserId", ChannelId: "testChannelId", Message: "s/one/two"})
+	assert.Equal(t, strings.Trim(publicOnlyError, "`"), rejection)
+
+	_, rejection = p.MessageWillBePosted(&plugin.Context{}, &model.Post{UserId: "testUserId", ChannelId: "testChannelId", Message: "s/one"})
+	assert.Equal(t, "Invalid command format: unterminated pattern at column 3. "+usage, rejection)
+
+	api.AssertExpectations(t)
+}
+
+func TestMessageWillBePostedSkipsPostsWithoutPermission(t *testing.T) {
+	api := &plugintest.API{}
+	p := setupTestPlugin(t, api)
+
+	leftPost := &model.Post{Id: "leftPostId", UserId: "testUserId", ChannelId: "leftChannelId", Message: "one"}
+	lastPost := &model.Post{Id: "lastPostId", UserId: "testUserId", ChannelId: "testChannelId", Message: "one"}
+	api.On("GetUser", "testUserId").Return(&model.User{Id: "testUserId", Username: "test"}, nil)
+	api.On("GetChannel", "testChannelId").Return(&model.Channel{Id: "testChannelId", TeamId: "testTeamId"}, nil)
+	api.On("SearchPostsInTeam", "testTeamId", mock.AnythingOfType("[]*model.SearchParams")).Return([]*model.Post{leftPost, lastPost}, nil)
+	api.On("HasPermissionToChannel", "testUserId", "leftChannelId", model.PERMISSION_EDIT_POST).Return(false)
+	api.On("HasPermissionToChannel", "testUserId", "testChannelId", model.PERMISSION_EDIT_POST).Return(true)
+	api.On("KVGet", preferencesKey("testUserId")).Return(nil, nil)
+	api.On("KVGet", channelNotificationKey("testChannelId")).Return(nil, nil)
+	api.On("KVGet", channelCorrectionKey(lastPost.ChannelId)).Return(nil, nil)
+	api.On("KVGet", channelConfirmKey(lastPost.ChannelId)).Return(nil, nil)
+	api.On("GetPost", "lastPostId").Return(lastPost, nil)
+	api.On("UpdatePost", lastPost).Return(lastPost, nil)
+	api.On("KVGet", historyKey("lastPostId")).Return(nil, nil)
+	api.On("KVSet", historyKey("lastPostId"), mock.AnythingOfType("[]uint8")).Return(nil)
+	api.On("KVSet", lastEditKey("testUserId"), []byte("lastPostId")).Return(nil)
+	api.On("KVSet", undoKey("testUserId"), []byte("lastPostId")).Return(nil)
+	api.On("SendEphemeralPost", "testUserId", mock.AnythingOfType("*model.Post")).Return(nil)
+	api.On("GetConfig").Return(&model.Config{})
+	api.On("GetTeam", "testTeamId").Return(&model.Team{Name: "engineering"}, nil)
+
+	_, rejection := p.MessageWillBePosted(&plugin.Context{}, &model.Post{UserId: "testUserId", ChannelId: "testChannelId", Message: "s/one/two in:*"})
+
+	assert.Equal(t, "plugin.message_will_be_posted.dismiss_post", rejection)
+	assert.Equal(t, "one", leftPost.Message)
+	assert.Equal(t, "two", lastPost.Message)
+	api.AssertExpectations(t)
+}
+
+func TestMessageWillBePostedInvalidPattern(t *testing.T) {
+	api := &plugintest.API{}
+	p := setupTestPlugin(t, api)
+
+	api.On("GetUser", "testUserId").Return(&model.User{Id: "testUserId", Username: "test"}, nil)
+	api.On("GetChannel", "testChannelId").Return(&model.Channel{Id: "testChannelId", TeamId: "testTeamId"}, nil)
+	api.On("SendEphemeralPost", "testUserId", mock.MatchedBy(func(notification *model.Post) bool {
+		return notification.Message == "`s/ Command: The pattern is an invalid regular expression, missing closing ) in foo(.`"
+	})).Return(nil)
+
+	_, rejection := p.MessageWillBePosted(&plugin.Context{}, &model.Post{UserId: "testUserId", ChannelId: "testChannelId", Message: "s/foo(/bar"})
+
+	assert.Equal(t, "plugin.message_will_be_posted.dismiss_post", rejection)
+	api.AssertExpectations(t)
+}
+
+func TestMessageWillBePostedLiteralPatternMode(t *testing.T) {
+	api := &plugintest.API{}
+	p := setupTestPlugin(t, api)
+	p.setConfiguration(&configuration{PatternMode: patternLiteral})
+
+	lastPost := &model.Post{Id: "lastPostId", UserId: "testUserId", ChannelId: "testChannelId", Message: "tests are red :("}
+	api.On("GetUser", "testUserId").Return(&model.User{Id: "testUserId", Username: "test"}, nil)
+	api.On("GetChannel", "testChannelId").Return(&model.Channel{Id: "testChannelId", TeamId: "testTeamId"}, nil)
+	api.On("SearchPostsInTeam", "testTeamId", mock.AnythingOfType("[]*model.SearchParams")).Return([]*model.Post{lastPost}, nil)
+	api.On("HasPermissionToChannel", "testUserId", "testChannelId", model.PERMISSION_EDIT_POST).Return(true)
+	api.On("KVGet", preferencesKey("testUserId")).Return(nil, nil)
+	api.On("KVGet", channelNotificationKey("testChannelId")).Return(nil, nil)
+	api.On("KVGet", channelCorrectionKey("testChannelId")).Return(nil, nil)
+	api.On("KVGet", channelConfirmKey("testChannelId")).Return(nil, nil)
+	api.On("GetPost", "lastPostId").Return(lastPost, nil)
+	api.On("UpdatePost", lastPost).Return(lastPost, nil)
+	api.On("KVGet", historyKey("lastPostId")).Return(nil, nil)
+	api.On("KVSet", historyKey("lastPostId"), mock.AnythingOfType("[]uint8")).Return(nil)
+	api.On("KVSet", lastEditKey("testUserId"), []byte("lastPostId")).Return(nil)
+	api.On("KVSet", undoKey("testUserId"), []byte("lastPostId")).Return(nil)
+	api.On("SendEphemeralPost", "testUserId", mock.AnythingOfType("*model.Post")).Return(nil)
+	api.On("GetConfig").Return(&model.Config{})
+	api.On("GetTeam", "testTeamId").Return(&model.Team{Name: "engineering"}, nil)
+
+	_, rejection := p.MessageWillBePosted(&plugin.Context{}, &model.Post{UserId: "testUserId", ChannelId: "testChannelId", Message: "s/:(/:)/"})
+
+	assert.Equal(t, "plugin.message_will_be_posted.dismiss_post", rejection)
+	assert.Equal(t, "tests are red :)", lastPost.Message)
+	api.AssertExpectations(t)
+}
+
+func TestMessageWillBePostedThrottlesUsage(t *testing.T) {
+	api := &plugintest.API{}
+	p := setupTestPlugin(t, api)
+
+	var messages []string
+	api.On("SendEphemeralPost", "testUserId", mock.AnythingOfType("*model.Post")).Run(func(args mock.Arguments) {
+		messages = append(messages, args.Get(1).(*model.Post).Message)
+	}).Return(nil)
+	api.On("KVGet", preferencesKey("testUserId")).Return(nil, nil)
+	api.On("GetUser", "testUserId").Return(&model.User{Id: "testUserId", Username: "test"}, nil)
+	api.On("GetChannel", "testChannelId").Return(&model.Channel{Id: "testChannelId", TeamId: "testTeamId"}, nil)
+
+	for i := 0; i < 3; i++ {
+		p.MessageWillBePosted(&plugin.Context{}, &model.Post{UserId: "testUserId", ChannelId: "testChannelId", Message: "s/bad"})
+	}
+
+	full := "Invalid command format: unterminated pattern at column 3. " + usage
+	compact := "Invalid command format: unterminated pattern at column 3. " + compactUsage
+	assert.Equal(t, []string{full, compact, compact}, messages)
+
+	// A valid command shows the full usage again on the next mistake.
+	p.MessageWillBePosted(&plugin.Context{}, &model.Post{UserId: "testUserId", ChannelId: "testChannelId", Message: "s/one/two/e"})
+	p.MessageWillBePosted(&plugin.Context{}, &model.Post{UserId: "testUserId", ChannelId: "testChannelId", Message: "s/bad"})
+
+	assert.Equal(t, full, messages[len(messages)-1])
+}
+
+func TestMessageWillBePostedOtherChannel(t *testing.T) {
+	api := &plugintest.API{}
+	p := setupTestPlugin(t, api)
+
+	lastPost := &model.Post{Id: "lastPostId", UserId: "testUserId", ChannelId: "deploysId", Message: "one"}
+	siteURL := "https://chat.example.com"
+	api.On("GetUser", "testUserId").Return(&model.User{Id: "testUserId", Username: "test"}, nil)
+	api.On("GetChannel", "testChannelId").Return(&model.Channel{Id: "testChannelId", TeamId: "testTeamId"}, nil)
+	api.On("GetChannelByName", "testTeamId", "deploys", false).Return(&model.Channel{Id: "deploysId", Name: "deploys"}, nil)
+	api.On("GetChannel", "deploysId").Return(&model.Channel{Id: "deploysId", Name: "deploys"}, nil)
+	api.On("GetChannelMember", "deploysId", "testUserId").Return(&model.ChannelMember{}, nil)
+	api.On("HasPermissionToChannel", "testUserId", "deploysId", model.PERMISSION_EDIT_POST).Return(true)
+	api.On("SearchPostsInTeam", "testTeamId", mock.AnythingOfType("[]*model.SearchParams")).Return([]*model.Post{lastPost}, nil)
+	api.On("KVGet", preferencesKey("testUserId")).Return(nil, nil)
+	api.On("KVGet", channelNotificationKey("testChannelId")).Return(nil, nil)
+	api.On("KVGet", channelCorrectionKey(lastPost.ChannelId)).Return(nil, nil)
+	api.On("KVGet", channelConfirmKey(lastPost.ChannelId)).Return(nil, nil)
+	api.On("GetPost", "lastPostId").Return(lastPost, nil)
+	api.On("UpdatePost", lastPost).Return(lastPost, nil)
+	api.On("KVGet", historyKey("lastPostId")).Return(nil, nil)
+	api.On("KVSet", historyKey("lastPostId"), mock.AnythingOfType("[]uint8")).Return(nil)
+	api.On("KVSet", lastEditKey("testUserId"), []byte("lastPostId")).Return(nil)
+	api.On("KVSet", undoKey("testUserId"), []byte("lastPostId")).Return(nil)
+	api.On("GetConfig").Return(&model.Config{ServiceSettings: model.ServiceSettings{SiteURL: &siteURL}})
+	api.On("GetTeam", "testTeamId").Return(&model.Team{Name: "engineering"}, nil)
+	api.On("SendEphemeralPost", "testUserId", mock.MatchedBy(func(notification *model.Post) bool {
+		return strings.HasPrefix(notification.Message, "[Your post](https://chat.example.com/engineering/pl/lastPostId) in another channel was edited.\n")
+	})).Return(nil)
+
+	_, rejection := p.MessageWillBePosted(&plugin.Context{}, &model.Post{UserId: "testUserId", ChannelId: "testChannelId", Message: "s/one/two in:deploys"})
+
+	assert.Equal(t, "plugin.message_will_be_posted.dismiss_post", rejection)
+	assert.Equal(t, "two", lastPost.Message)
+	api.AssertExpectations(t)
+}
+
+func TestMessageWillBePostedModerator(t *testing.T) {
+	api := &plugintest.API{}
+	p := setupTestPlugin(t, api)
+	rules, err := parseFeatureFlags("moderator=on")
+	require.NoError(t, err)
+	p.setConfiguration(&configuration{featureRules: rules})
+
+	lastPost := &model.Post{Id: "lastPostId", UserId: "aliceId", ChannelId: "testChannelId", Message: "see http://evil.example.com"}
+	siteURL := "https://chat.example.com"
+	api.On("GetUser", "testUserId").Return(&model.User{Id: "testUserId", Username: "test"}, nil)
+	api.On("GetChannel", "testChannelId").Return(&model.Channel{Id: "testChannelId", TeamId: "testTeamId"}, nil)
+	api.On("GetUserByUsername", "alice").Return(&model.User{Id: "aliceId", Username: "alice"}, nil)
+	api.On("SearchPostsInTeam", "testTeamId", mock.AnythingOfType("[]*model.SearchParams")).Return([]*model.Post{lastPost}, nil)
+	api.On("HasPermissionToChannel", "testUserId", "testChannelId", model.PERMISSION_EDIT_POST).Return(true)
+	api.On("HasPermissionTo", "testUserId", model.PERMISSION_MANAGE_SYSTEM).Return(true)
+	api.On("KVGet", preferencesKey("testUserId")).Return(nil, nil)
+	api.On("KVGet", channelNotificationKey("testChannelId")).Return(nil, nil)
+	api.On("KVGet", channelCorrectionKey(lastPost.ChannelId)).Return(nil, nil)
+	api.On("KVGet", channelConfirmKey(lastPost.ChannelId)).Return(nil, nil)
+	api.On("GetPost", "lastPostId").Return(lastPost, nil)
+	api.On("UpdatePost", lastPost).Return(lastPost, nil)
+	api.On("KVGet", historyKey("lastPostId")).Return(nil, nil)
+	api.On("KVSet", historyKey("lastPostId"), mock.AnythingOfType("[]uint8")).Return(nil)
+	api.On("KVSet", lastEditKey("testUserId"), []byte("lastPostId")).Return(nil)
+	api.On("KVSet", undoKey("testUserId"), []byte("lastPostId")).Return(nil)
+	api.On("GetConfig").Return(&model.Config{ServiceSettings: model.ServiceSettings{SiteURL: &siteURL}})
+	api.On("GetTeam", "testTeamId").Return(&model.Team{Name: "engineering"}, nil)
+	api.On("SendEphemeralPost", "testUserId", mock.MatchedBy(func(notification *model.Post) bool {
+		return strings.HasPrefix(notification.Message, "You edited [the last post of @alice](https://chat.example.com/engineering/pl/lastPostId) as a moderator.\n")
+	})).Return(nil)
+
+	_, rejection := p.MessageWillBePosted(&plugin.Context{}, &model.Post{UserId: "testUserId", ChannelId: "testChannelId", Message: "s|http://evil.example.com|(link removed)| u:@alice"})
+
+	assert.Equal(t, "plugin.message_will_be_posted.dismiss_post", rejection)
+	assert.Equal(t, "see (link removed)", lastPost.Message)
+	assert.Equal(t, "testUserId", lastPost.Props[moderatorProp])
+	api.AssertExpectations(t)
+}
+
+func TestRefreshEdit(t *testing.T) {
+	sub, err := substitute.Parse("s/teh/the/")
+	require.NoError(t, err)
+	subs := []*substitute.Substitution{sub}
+
+	looked := &model.Post{Id: "postId", Message: "teh plan", UpdateAt: 1}
+	result, confirmation := applyScript(subs, looked.Message)
+
+	for _, tc := range []struct {
+		Name    string
+		Current *model.Post
+		Message string
+		ErrMsg  string
+	}{
+		{"unchanged", &model.Post{Id: "postId", Message: "teh plan", UpdateAt: 1}, "the plan", ""},
+		{"edited", &model.Post{Id: "postId", Message: "teh new plan", UpdateAt: 2, EditAt: 2}, "the new plan", ""},
+		{"edited without the pattern", &model.Post{Id: "postId", Message: "the plan", UpdateAt: 2, EditAt: 2}, "", "`s/ Command: The post was edited in the meantime and no longer contains the pattern.`"},
+		{"deleted", &model.Post{Id: "postId", Message: "teh plan", UpdateAt: 2, DeleteAt: 2}, "", "`s/ Command: The post was deleted before it could be edited.`"},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			api := &plugintest.API{}
+			defer api.AssertExpectations(t)
+			p := setupTestPlugin(t, api)
+			api.On("GetPost", "postId").Return(tc.Current, nil)
+
+			post, refreshed, _, errMsg := p.refreshEdit(looked, subs, result, confirmation)
+			assert.Equal(t, tc.ErrMsg, errMsg)
+			if tc.ErrMsg == "" {
+				assert.Equal(t, tc.Message, refreshed.Message)
+				assert.Equal(t, tc.Current.UpdateAt, post.UpdateAt)
+			}
+		})
+	}
+}
+
+func TestMessageWillBePostedPreservesPostData(t *testing.T) {
+	image := &model.Post{Id: "lastPostId", UserId: "testUserId", ChannelId: "testChannelId", Message: "teh view", UpdateAt: 1,
+		FileIds: model.StringArray{"fileId"}, IsPinned: true, HasReactions: true}
+
+	attachment := &model.Post{Id: "lastPostId", UserId: "testUserId", ChannelId: "testChannelId", Message: "teh report", UpdateAt: 1}
+	attachment.AddProp("attachments", []*model.SlackAttachment{{Title: "Coverage", Text: "87%"}})
+	attachment.AddProp("override_username", "ci")
+	attachment.AddProp("override_icon_url", "https://ci.example.com/icon.png")
+
+	for name, stored := range map[string]*model.Post{"image": image, "attachment": attachment} {
+		t.Run(name, func(t *testing.T) {
+			api := &plugintest.API{}
+			p := setupTestPlugin(t, api)
+
+			// Search results are only used to find the post; the update starts from the stored post.
+			found := &model.Post{Id: stored.Id, UserId: stored.UserId, ChannelId: stored.ChannelId, Message: stored.Message, UpdateAt: stored.UpdateAt}
+			expected := stored.Clone()
+			expected.Message = strings.Replace(stored.Message, "teh", "the", 1)
+
+			api.On("GetUser", "testUserId").Return(&model.User{Id: "testUserId", Username: "test"}, nil)
+			api.On("GetChannel", "testChannelId").Return(&model.Channel{Id: "testChannelId", TeamId: "testTeamId"}, nil)
+			api.On("SearchPostsInTeam", "testTeamId", mock.AnythingOfType("[]*model.SearchParams")).Return([]*model.Post{found}, nil)
+			api.On("HasPermissionToChannel", "testUserId", "testChannelId", model.PERMISSION_EDIT_POST).Return(true)
+			api.On("KVGet", preferencesKey("testUserId")).Return(nil, nil)
+			api.On("KVGet", channelNotificationKey("testChannelId")).Return(nil, nil)
+			api.On("KVGet", channelCorrectionKey("testChannelId")).Return(nil, nil)
+			api.On("KVGet", channelConfirmKey("testChannelId")).Return(nil, nil)
+			api.On("GetPost", "lastPostId").Return(stored, nil)
+			api.On("UpdatePost", mock.MatchedBy(func(post *model.Post) bool {
+				return assert.ObjectsAreEqual(expected, post)
+			})).Return(stored, nil)
+			api.On("KVGet", historyKey("lastPostId")).Return(nil, nil)
+			api.On("KVSet", historyKey("lastPostId"), mock.AnythingOfType("[]uint8")).Return(nil)
+			api.On("KVSet", lastEditKey("testUserId"), []byte("lastPostId")).Return(nil)
+			api.On("KVSet", undoKey("testUserId"), []byte("lastPostId")).Return(nil)
+			api.On("SendEphemeralPost", "testUserId", mock.AnythingOfType("*model.Post")).Return(nil)
+			api.On("GetConfig").Return(&model.Config{})
+			api.On("GetTeam", "testTeamId").Return(&model.Team{Name: "engineering"}, nil)
+
+			_, rejection := p.MessageWillBePosted(&plugin.Context{}, &model.Post{UserId: "testUserId", ChannelId: "testChannelId", Message: "s/teh/the/"})
+
+			assert.Equal(t, "plugin.message_will_be_posted.dismiss_post", rejection)
+			api.AssertExpectations(t)
+		})
+	}
+}
+
+func TestMessageWillBePostedNoMatch(t *testing.T) {
+	api := &plugintest.API{}
+	p := setupTestPlugin(t, api)
+
+	lastPost := &model.Post{Id: "lastPostId", UserId: "testUserId", ChannelId: "testChannelId", Message: "all good"}
+	api.On("GetUser", "testUserId").Return(&model.User{Id: "testUserId", Username: "test"}, nil)
+	api.On("GetChannel", "testChannelId").Return(&model.Channel{Id: "testChannelId", TeamId: "testTeamId"}, nil)
+	api.On("SearchPostsInTeam", "testTeamId", mock.AnythingOfType("[]*model.SearchParams")).Return([]*model.Post{lastPost}, nil)
+	api.On("HasPermissionToChannel", "testUserId", "testChannelId", model.PERMISSION_EDIT_POST).Return(true)
+	api.On("KVGet", preferencesKey("testUserId")).Return(nil, nil)
+	api.On("KVGet", channelCorrectionKey("testChannelId")).Return(nil, nil)
+	api.On("SendEphemeralPost", "testUserId", mock.MatchedBy(func(notification *model.Post) bool {
+		return notification.Message == "`s/ Command: The pattern was not found in your last post, so it was left unchanged.`"
+	})).Return(nil)
+
+	_, rejection := p.MessageWillBePosted(&plugin.Context{}, &model.Post{UserId: "testUserId", ChannelId: "testChannelId", Message: "s/teh/the/"})
+
+	assert.Equal(t, "plugin.message_will_be_posted.dismiss_post", rejection)
+	api.AssertExpectations(t)
+}
+
+func TestNoMatchMessage(t *testing.T) {
+	author := &model.User{Username: "alice"}
+
+	assert.Equal(t, "`s/ Command: The pattern was not found in your 3rd most recent post, so it was left unchanged.`", noMatchMessage(&postTarget{Rank: 3}, author, false))
+	assert.Equal(t, "`s/ Command: The pattern was not found in the selected post, so it was left unchanged.`", noMatchMessage(&postTarget{PostID: "postId"}, author, false))
+	assert.Equal(t, "`s/ Command: The pattern was not found in the last post of @alice, so it was left unchanged.`", noMatchMessage(&postTarget{}, author, true))
+}
+
+func TestMessageWillBePostedTooLong(t *testing.T) {
+	api := &plugintest.API{}
+	p := setupTestPlugin(t, api)
+
+	lastPost := &model.Post{Id: "lastPostId", UserId: "testUserId", ChannelId: "testChannelId", Message: strings.Repeat("x", maxMessageRunes-4) + " teh"}
+	api.On("GetUser", "testUserId").Return(&model.User{Id: "testUserId", Username: "test"}, nil)
+	api.On("GetChannel", "testChannelId").Return(&model.Channel{Id: "testChannelId", TeamId: "testTeamId"}, nil)
+	api.On("SearchPostsInTeam", "testTeamId", mock.AnythingOfType("[]*model.SearchParams")).Return([]*model.Post{lastPost}, nil)
+	api.On("HasPermissionToChannel", "testUserId", "testChannelId", model.PERMISSION_EDIT_POST).Return(true)
+	api.On("KVGet", preferencesKey("testUserId")).Return(nil, nil)
+	api.On("KVGet", channelCorrectionKey("testChannelId")).Return(nil, nil)
+	api.On("KVGet", channelConfirmKey("testChannelId")).Return(nil, nil)
+	api.On("GetPost", "lastPostId").Return(lastPost, nil)
+	api.On("SendEphemeralPost", "testUserId", mock.MatchedBy(func(notification *model.Post) bool {
+		return notification.Message == "`s/ Command: The result would be 2 characters over the limit of 16383, so nothing was changed.`"
+	})).Return(nil)
+
+	_, rejection := p.MessageWillBePosted(&plugin.Context{}, &model.Post{UserId: "testUserId", ChannelId: "testChannelId", Message: "s/teh/three/"})
+
+	assert.Equal(t, "plugin.message_will_be_posted.dismiss_post", rejection)
+	assert.True(t, strings.HasSuffix(lastPost.Message, " teh"))
+	api.AssertExpectations(t)
+}
+
+func TestMessageWillBePostedDryRun(t *testing.T) {
+	api := &plugintest.API{}
+	p := setupTestPlugin(t, api)
+
+	lastPost := &model.Post{Id: "lastPostId", UserId: "testUserId", ChannelId: "testChannelId", Message: "teh cat\nteh dog"}
+	api.On("GetUser", "testUserId").Return(&model.User{Id: "testUserId", Username: "test"}, nil)
+	api.On("GetChannel", "testChannelId").Return(&model.Channel{Id: "testChannelId", TeamId: "testTeamId"}, nil)
+	api.On("SearchPostsInTeam", "testTeamId", mock.AnythingOfType("[]*model.SearchParams")).Return([]*model.Post{lastPost}, nil)
+	api.On("HasPermissionToChannel", "testUserId", "testChannelId", model.PERMISSION_EDIT_POST).Return(true)
+	api.On("KVGet", preferencesKey("testUserId")).Return(nil, nil)
+	api.On("GetConfig").Return(&model.Config{})
+	api.On("GetTeam", "testTeamId").Return(&model.Team{Name: "engineering"}, nil)
+	api.On("SendEphemeralPost", "testUserId", mock.MatchedBy(func(notification *model.Post) bool {
+		return notification.Message == "Preview of [the post](/engineering/pl/lastPostId), which was left unchanged:\n> the cat\n> the dog\n"+
+			`s/ Replaced 2 occurrences of "teh" with "the"`
+	})).Return(nil)
+
+	_, rejection := p.MessageWillBePosted(&plugin.Context{}, &model.Post{UserId: "testUserId", ChannelId: "testChannelId", Message: "s/teh/the/n"})
+
+	assert.Equal(t, "plugin.message_will_be_posted.dismiss_post", rejection)
+	assert.Equal(t, "teh cat\nteh dog", lastPost.Message)
+	api.AssertExpectations(t)
+}
+
+func TestMessageWillBeUpdated(t *testing.T) {
+	t.Run("regular edit", func(t *testing.T) {
+		p := setupTestPlugin(t, &plugintest.API{})
+
+		newPost := &model.Post{Id: "editedId", Message: "hello world"}
+		updated, rejection := p.MessageWillBeUpdated(&plugin.Context{}, newPost, &model.Post{Id: "editedId", Message: "hello wrold"})
+		assert.Equal(t, newPost, updated)
+		assert.Equal(t, "", rejection)
+	})
+
+	t.Run("edited into a command", func(t *testing.T) {
+		api := &plugintest.API{}
+		p := setupTestPlugin(t, api)
+
+		edited := &model.Post{Id: "editedId", UserId: "testUserId", ChannelId: "testChannelId", Message: "ok"}
+		previous := &model.Post{Id: "previousId", UserId: "testUserId", ChannelId: "testChannelId", Message: "hello wrold"}
+		api.On("GetUser", "testUserId").Return(&model.User{Id: "testUserId", Username: "test"}, nil)
+		api.On("GetChannel", "testChannelId").Return(&model.Channel{Id: "testChannelId", TeamId: "testTeamId"}, nil)
+		api.On("SearchPostsInTeam", "testTeamId", mock.AnythingOfType("[]*model.SearchParams")).Return([]*model.Post{edited, previous}, nil)
+		api.On("HasPermissionToChannel", "testUserId", "testChannelId", model.PERMISSION_EDIT_POST).Return(true)
+		api.On("KVGet", preferencesKey("testUserId")).Return(nil, nil)
+		api.On("KVGet", channelNotificationKey("testChannelId")).Return(nil, nil)
+		api.On("KVGet", channelCorrectionKey("testChannelId")).Return(nil, nil)
+		api.On("KVGet", channelConfirmKey("testChannelId")).Return(nil, nil)
+		api.On("GetPost", "previousId").Return(previous, nil)
+		api.On("UpdatePost", previous).Return(previous, nil)
+		api.On("KVGet", historyKey("previousId")).Return(nil, nil)
+		api.On("KVSet", historyKey("previousId"), mock.AnythingOfType("[]uint8")).Return(nil)
+		api.On("KVSet", lastEditKey("testUserId"), []byte("previousId")).Return(nil)
+		api.On("KVSet", undoKey("testUserId"), []byte("previousId")).Return(nil)
+		api.On("SendEphemeralPost", "testUserId", mock.AnythingOfType("*model.Post")).Return(nil)
+		api.On("GetConfig").Return(&model.Config{})
+		api.On("GetTeam", "testTeamId").Return(&model.Team{Name: "engineering"}, nil)
+
+		newPost := &model.Post{Id: "editedId", UserId: "testUserId", ChannelId: "testChannelId", Message: "s/wrold/world"}
+		updated, rejection := p.MessageWillBeUpdated(&plugin.Context{}, newPost, edited)
+
+		assert.Nil(t, updated)
+		assert.Equal(t, editedCommandRejection, rejection)
+		assert.Equal(t, "ok", edited.Message)
+		assert.Equal(t, "hello world", previous.Message)
+		api.AssertExpectations(t)
+	})
+
+	t.Run("update by the plugin", func(t *testing.T) {
+		p := setupTestPlugin(t, &plugintest.API{})
+		p.ownUpdates.set("editedId", true, time.Minute)
+
+		newPost := &model.Post{Id: "editedId", Message: "s/a/b"}
+		updated, rejection := p.MessageWillBeUpdated(&plugin.Context{}, newPost, &model.Post{Id: "editedId", Message: "a"})
+		assert.Equal(t, newPost, updated)
+		assert.Equal(t, "", rejection)
+	})
+}
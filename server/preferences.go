@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+
+	"github.com/carmo-evan/mattermost-plugin-replace/server/pkg/substitute"
+)
+
+// userPreferences holds the per-user settings chosen through /replace settings.
+type userPreferences struct {
+	// ReplaceInSpoilers allows substitutions inside spoiler and collapsible blocks, which are
+	// left untouched by default.
+	ReplaceInSpoilers bool `json:"replace_in_spoilers"`
+
+	// CollapseWhitespace removes the doubled spaces left behind by a substitution, such as when
+	// a word is deleted.
+	CollapseWhitespace bool `json:"collapse_whitespace"`
+
+	// SmartCase ignores case for patterns written all in lowercase, as many editors do.
+	SmartCase bool `json:"smart_case"`
+
+	// ConfirmEdits shows a preview with buttons to apply or cancel each edit instead of making it
+	// right away.
+	ConfirmEdits bool `json:"confirm_edits"`
+
+	// NotificationStyle is the confirmation style chosen by the user. Empty means the global
+	// default.
+	NotificationStyle string `json:"notification_style,omitempty"`
+}
+
+// userSetting describes a preference that can be changed with /replace settings.
+type userSetting struct {
+	Name        string
+	Description string
+	Values      []string
+	Get         func(prefs *userPreferences) string
+	Set         func(prefs *userPreferences, value string)
+}
+
+// accepts reports whether value is valid for the setting.
+func (setting userSetting) accepts(value string) bool {
+	for _, v := range setting.Values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// boolSetting describes an on/off preference stored in field.
+func boolSetting(name, description string, field func(prefs *userPreferences) *bool) userSetting {
+	return userSetting{
+		Name:        name,
+		Description: description,
+		Values:      []string{"on", "off"},
+		Get:         func(prefs *userPreferences) string { return onOff(*field(prefs)) },
+		Set:         func(prefs *userPreferences, value string) { *field(prefs) = value == "on" },
+	}
+}
+
+// userSettings lists the settings shown by /replace settings, in display order.
+var userSettings = []userSetting{
+	boolSetting("spoilers", "Replace text inside spoiler and collapsible blocks.",
+		func(prefs *userPreferences) *bool { return &prefs.ReplaceInSpoilers }),
+	boolSetting("whitespace", "Collapse the doubled spaces left behind when a word is removed.",
+		func(prefs *userPreferences) *bool { return &prefs.CollapseWhitespace }),
+	boolSetting("smartcase", "Ignore case when the pattern is all lowercase, and match it exactly otherwise.",
+		func(prefs *userPreferences) *bool { return &prefs.SmartCase }),
+	boolSetting("confirm", "Preview each edit with buttons to apply or cancel it, instead of editing the post right away.",
+		func(prefs *userPreferences) *bool { return &prefs.ConfirmEdits }),
+	{
+		Name:        "notifications",
+		Description: "How a replacement is confirmed: `ephemeral`, `public`, `none` or `default`.",
+		Values:      append(append([]string{}, notificationStyles...), "default"),
+		Get: func(prefs *userPreferences) string {
+			if prefs.NotificationStyle == "" {
+				return "default"
+			}
+			return prefs.NotificationStyle
+		},
+		Set: func(prefs *userPreferences, value string) {
+			if value == "default" {
+				value = ""
+			}
+			prefs.NotificationStyle = value
+		},
+	},
+}
+
+// apply configures a substitution according to the preferences.
+func (prefs *userPreferences) apply(sub *substitute.Substitution) {
+	sub.IncludeSpoilers = prefs.ReplaceInSpoilers
+	sub.CollapseWhitespace = prefs.CollapseWhitespace
+	sub.SmartCase = prefs.SmartCase
+}
+
+// applyDefaults adds the preferences to the defaults clients preview commands with.
+func (prefs *userPreferences) applyDefaults(d *substitute.Defaults) {
+	d.IncludeSpoilers = prefs.ReplaceInSpoilers
+	d.CollapseWhitespace = prefs.CollapseWhitespace
+	d.SmartCase = prefs.SmartCase
+}
+
+func preferencesKey(userID string) string {
+	return "preferences_" + userID
+}
+
+// getUserPreferences loads the user's preferences from the KV store, falling back to the
+// defaults if the user never saved any.
+func (p *Plugin) getUserPreferences(userID string) (*userPreferences, error) {
+	prefs := &userPreferences{}
+
+	data, appErr := p.API.KVGet(preferencesKey(userID))
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to get user preferences")
+	}
+
+	if data == nil {
+		return prefs, nil
+	}
+
+	if err := json.Unmarshal(data, prefs); err != nil {
+		return nil, errors.Wrap(err, "failed to decode user preferences")
+	}
+
+	return prefs, nil
+}
+
+// saveUserPreferences stores the user's preferences in the KV store.
+func (p *Plugin) saveUserPreferences(userID string, prefs *userPreferences) error {
+	data, err := json.Marshal(prefs)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode user preferences")
+	}
+
+	if appErr := p.API.KVSet(preferencesKey(userID), data); appErr != nil {
+		return errors.Wrap(appErr, "failed to save user preferences")
+	}
+
+	return nil
+}
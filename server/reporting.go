@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/pkg/errors"
+)
+
+// errorReportTimeout bounds how long a single delivery to the error-reporting webhook may take.
+const errorReportTimeout = 5 * time.Second
+
+// reportableContextKeys lists the context values that may leave the server with an error report.
+// Anything else, message text in particular, is dropped before the report is sent.
+var reportableContextKeys = map[string]bool{
+	"hook":       true,
+	"user_id":    true,
+	"team_id":    true,
+	"channel_id": true,
+	"root_id":    true,
+	"post_id":    true,
+}
+
+// errorReport is the JSON payload delivered to the configured error-reporting webhook.
+type errorReport struct {
+	PluginID      string            `json:"plugin_id"`
+	PluginVersion string            `json:"plugin_version"`
+	Error         string            `json:"error"`
+	Stack         string            `json:"stack"`
+	Context       map[string]string `json:"context,omitempty"`
+	Timestamp     int64             `json:"timestamp"`
+}
+
+// postContext describes the post being handled by a hook without exposing its contents.
+func postContext(hook string, post *model.Post) map[string]string {
+	return map[string]string{
+		"hook":       hook,
+		"user_id":    post.UserId,
+		"channel_id": post.ChannelId,
+		"root_id":    post.RootId,
+		"post_id":    post.Id,
+	}
+}
+
+// sanitizeReportContext returns a copy of context holding only the allowed, non-empty keys.
+func sanitizeReportContext(context map[string]string) map[string]string {
+	sanitized := make(map[string]string)
+	for key, value := range context {
+		if reportableContextKeys[key] && value != "" {
+			sanitized[key] = value
+		}
+	}
+
+	return sanitized
+}
+
+// reportError logs an unexpected error and forwards it to the error-reporting webhook, if one
+// is configured.
+func (p *Plugin) reportError(err error, context map[string]string) {
+	p.API.LogError("Unexpected error", "error", err.Error())
+	p.sendErrorReport(err.Error(), debug.Stack(), context)
+}
+
+// reportPanic logs a recovered panic and forwards it to the error-reporting webhook, if one is
+// configured. It must be called from the deferred function that recovered.
+func (p *Plugin) reportPanic(recovered interface{}, context map[string]string) {
+	message := fmt.Sprintf("panic: %v", recovered)
+	p.API.LogError("Recovered from panic", "error", message)
+	p.sendErrorReport(message, debug.Stack(), context)
+}
+
+// recoverPanic reports a panic instead of letting it crash the plugin. It must be deferred
+// directly, at the top of a hook or goroutine.
+func (p *Plugin) recoverPanic(context map[string]string) {
+	if r := recover(); r != nil {
+		p.reportPanic(r, context)
+	}
+}
+
+// sendErrorReport delivers the report in the background so hooks are never delayed by a slow
+// or unreachable webhook.
+func (p *Plugin) sendErrorReport(message string, stack []byte, context map[string]string) {
+	url := p.getConfiguration().ErrorReportingURL
+	if url == "" {
+		return
+	}
+
+	report := &errorReport{
+		PluginID:      manifest.Id,
+		PluginVersion: manifest.Version,
+		Error:         message,
+		Stack:         string(stack),
+		Context:       sanitizeReportContext(context),
+		Timestamp:     model.GetMillis(),
+	}
+
+	go func() {
+		// A failing report is only logged, so that it cannot report itself in turn.
+		defer func() {
+			if r := recover(); r != nil {
+				p.API.LogError("Recovered from panic while reporting an error", "error", fmt.Sprintf("%v", r))
+			}
+		}()
+
+		if err := postErrorReport(url, report); err != nil {
+			p.API.LogWarn("Failed to deliver error report", "error", err.Error())
+		}
+	}()
+}
+
+// postErrorReport sends a single report to url.
+func postErrorReport(url string, report *errorReport) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal error report")
+	}
+
+	client := &http.Client{Timeout: errorReportTimeout}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "failed to post error report")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("error report rejected with status %d", resp.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/mattermost/mattermost-server/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSanitizeReportContext(t *testing.T) {
+	context := map[string]string{
+		"hook":       "MessageWillBePosted",
+		"user_id":    "testUserId",
+		"root_id":    "",
+		"message":    "s/secret/public",
+		"channel_id": "testChannelId",
+	}
+
+	assert.Equal(t, map[string]string{
+		"hook":       "MessageWillBePosted",
+		"user_id":    "testUserId",
+		"channel_id": "testChannelId",
+	}, sanitizeReportContext(context))
+}
+
+func TestPostErrorReport(t *testing.T) {
+	var received errorReport
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&received))
+	}))
+	defer server.Close()
+
+	report := &errorReport{PluginID: manifest.Id, Error: "boom", Stack: "stack"}
+	assert.Nil(t, postErrorReport(server.URL, report))
+	assert.Equal(t, *report, received)
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	assert.NotNil(t, postErrorReport(failing.URL, report))
+}
+
+func TestRecoverPanic(t *testing.T) {
+	api := &plugintest.API{}
+	defer api.AssertExpectations(t)
+
+	p := setupTestPlugin(t, api)
+	api.On("LogError", "Recovered from panic", "error", "panic: boom").Return()
+
+	p.router = mux.NewRouter()
+	p.router.HandleFunc("/boom", func(w http.ResponseWriter, r *http.Request) { panic("boom") })
+	r := httptest.NewRequest(http.MethodGet, "/boom", nil)
+	r.Header.Set("Mattermost-User-Id", "testUserId")
+	w := httptest.NewRecorder()
+
+	assert.NotPanics(t, func() { p.ServeHTTP(nil, w, r) })
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+
+	assert.NotPanics(t, func() {
+		defer p.recoverPanic(map[string]string{"hook": "runDue"})
+		panic("boom")
+	})
+}
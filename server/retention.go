@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Key prefixes of the KV entries subject to retention.
+const (
+	historyKeyPrefix string = "history_"
+	auditKeyPrefix   string = "audit_"
+	statsKeyPrefix   string = "stats_"
+)
+
+const (
+	// pruneInterval is the time between two runs of the pruning job.
+	pruneInterval = 6 * time.Hour
+	// kvListPageSize is the number of keys fetched per page when walking the KV store.
+	kvListPageSize = 100
+)
+
+// retainedEntry is the part of a retained KV entry read by the pruning job. Entries stored under
+// a retained prefix must be JSON objects recording when they were created.
+type retainedEntry struct {
+	CreatedAt int64 `json:"created_at"`
+}
+
+// retentionDays returns how many days the entry stored under key is kept, or zero if it is kept
+// forever.
+func (c *configuration) retentionDays(key string) int {
+	switch {
+	case strings.HasPrefix(key, historyKeyPrefix):
+		return c.HistoryRetentionDays
+	case strings.HasPrefix(key, auditKeyPrefix):
+		return c.AuditRetentionDays
+	case strings.HasPrefix(key, statsKeyPrefix):
+		return c.StatsRetentionDays
+	default:
+		return 0
+	}
+}
+
+// pruneExpired deletes the retained entries older than their configured retention and returns
+// how many were deleted.
+func (p *Plugin) pruneExpired(now time.Time) (int, error) {
+	config := p.getConfiguration()
+
+	var expired []string
+	for page := 0; ; page++ {
+		keys, appErr := p.API.KVList(page, kvListPageSize)
+		if appErr != nil {
+			return 0, errors.Wrap(appErr, "failed to list keys")
+		}
+
+		for _, key := range keys {
+			days := config.retentionDays(key)
+			if days <= 0 {
+				continue
+			}
+
+			data, appErr := p.API.KVGet(key)
+			if appErr != nil {
+				return 0, errors.Wrapf(appErr, "failed to get %s", key)
+			}
+
+			var entry retainedEntry
+			if data == nil || json.Unmarshal(data, &entry) != nil || entry.CreatedAt == 0 {
+				continue
+			}
+
+			if entry.CreatedAt < now.AddDate(0, 0, -days).UnixNano()/int64(time.Millisecond) {
+				expired = append(expired, key)
+			}
+		}
+
+		if len(keys) < kvListPageSize {
+			break
+		}
+	}
+
+	// Keys are deleted once the listing is complete, so that pages do not shift underneath it.
+	for i, key := range expired {
+		if appErr := p.API.KVDelete(key); appErr != nil {
+			return i, errors.Wrapf(appErr, "failed to delete %s", key)
+		}
+	}
+
+	return len(expired), nil
+}
+
+// pruneOnce runs pruneExpired, reporting a panic rather than ending the pruning loop.
+func (p *Plugin) pruneOnce() {
+	defer p.recoverPanic(map[string]string{"hook": "pruneExpired"})
+
+	if _, err := p.pruneExpired(time.Now()); err != nil {
+		p.reportError(err, map[string]string{"hook": "pruneExpired"})
+	}
+}
+
+// startPruning runs pruneExpired every pruneInterval until stopPruning is called.
+func (p *Plugin) startPruning() {
+	stop := make(chan struct{})
+	p.pruneStop = stop
+
+	go func() {
+		ticker := time.NewTicker(pruneInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				p.pruneOnce()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+func (p *Plugin) stopPruning() {
+	if p.pruneStop != nil {
+		close(p.pruneStop)
+		p.pruneStop = nil
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPruneExpired(t *testing.T) {
+	api := &plugintest.API{}
+	defer api.AssertExpectations(t)
+
+	p := setupTestPlugin(t, api)
+	p.setConfiguration(&configuration{HistoryRetentionDays: 30, StatsRetentionDays: 90})
+
+	now := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	daysAgo := func(days int) []byte {
+		return []byte(fmt.Sprintf(`{"created_at":%d}`, now.AddDate(0, 0, -days).UnixNano()/int64(time.Millisecond)))
+	}
+
+	api.On("KVList", 0, kvListPageSize).Return([]string{"history_old", "history_new", "stats_shadow", "audit_old", "preferences_user", botUserKey}, nil)
+	api.On("KVGet", "history_old").Return(daysAgo(31), nil)
+	api.On("KVGet", "history_new").Return(daysAgo(29), nil)
+	api.On("KVGet", "stats_shadow").Return(daysAgo(31), nil)
+	api.On("KVDelete", "history_old").Return(nil)
+
+	pruned, err := p.pruneExpired(now)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, pruned)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-server/model"
+)
+
+// maxRevisionsPerPost caps the number of revisions kept for a single post; the oldest are
+// dropped first.
+const maxRevisionsPerPost = 20
+
+var (
+	// errRevisionCorrupted means the stored original no longer matches its hash.
+	errRevisionCorrupted = errors.New("the stored revision is corrupted")
+
+	// errEditedSince means the post changed after the plugin edited it.
+	errEditedSince = errors.New("the post was edited since")
+)
+
+// revision records an edit made by the plugin, so that it can be audited and undone. Hashes of
+// the message before and after the edit let an undo detect both a damaged record and a post that
+// was edited again in the meantime.
+type revision struct {
+	UserID       string `json:"user_id"`
+	Command      string `json:"command"`
+	Original     string `json:"original"`
+	OriginalHash string `json:"original_hash"`
+	Edited       string `json:"edited"`
+	EditedHash   string `json:"edited_hash"`
+	CreatedAt    int64  `json:"created_at"`
+}
+
+// postHistory holds the revisions of a post, oldest first.
+type postHistory struct {
+	// CreatedAt is the time of the latest revision, so the pruning job only removes the history
+	// of posts that were not edited for the whole retention period.
+	CreatedAt int64       `json:"created_at"`
+	Revisions []*revision `json:"revisions"`
+}
+
+func historyKey(postID string) string {
+	return historyKeyPrefix + postID
+}
+
+// contentHash returns the hex encoded SHA-256 hash of a message.
+func contentHash(message string) string {
+	sum := sha256.Sum256([]byte(message))
+	return hex.EncodeToString(sum[:])
+}
+
+func newRevision(userID, command, original, edited string) *revision {
+	return &revision{
+		UserID:       userID,
+		Command:      command,
+		Original:     original,
+		OriginalHash: contentHash(original),
+		Edited:       edited,
+		EditedHash:   contentHash(edited),
+		CreatedAt:    model.GetMillis(),
+	}
+}
+
+// check verifies that the revision can be restored over current, the post's present message.
+func (rev *revision) check(current string) error {
+	if contentHash(rev.Original) != rev.OriginalHash || contentHash(rev.Edited) != rev.EditedHash {
+		return errRevisionCorrupted
+	}
+
+	if contentHash(current) != rev.EditedHash {
+		return errEditedSince
+	}
+
+	return nil
+}
+
+// threeWayView shows the original message, the plugin's edit and the current message side by
+// side, for when a revision cannot be restored blindly.
+func (rev *revision) threeWayView(current string) string {
+	return fmt.Sprintf("###### Before the edit\n%s\n###### After the edit\n%s\n###### Now\n%s",
+		quoteMessage(rev.Original), quoteMessage(rev.Edited), quoteMessage(current))
+}
+
+// getPostHistory loads the revisions of a post, which may be empty.
+func (p *Plugin) getPostHistory(postID string) (*postHistory, error) {
+	history := &postHistory{}
+
+	data, appErr := p.API.KVGet(historyKey(postID))
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to get post history")
+	}
+
+	if data == nil {
+		return history, nil
+	}
+
+	if err := json.Unmarshal(data, history); err != nil {
+		return nil, errors.Wrap(err, "failed to decode post history")
+	}
+
+	return history, nil
+}
+
+// recordRevision appends a revision to the history of a post.
+func (p *Plugin) recordRevision(postID string, rev *revision) error {
+	history, err := p.getPostHistory(postID)
+	if err != nil {
+		return err
+	}
+
+	history.Revisions = append(history.Revisions, rev)
+	if len(history.Revisions) > maxRevisionsPerPost {
+		history.Revisions = history.Revisions[len(history.Revisions)-maxRevisionsPerPost:]
+	}
+	history.CreatedAt = rev.CreatedAt
+
+	return p.saveHistory(postID, history)
+}
+
+// saveHistory stores the revisions of a post.
+func (p *Plugin) saveHistory(postID string, history *postHistory) error {
+	data, err := json.Marshal(history)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode post history")
+	}
+
+	if appErr := p.API.KVSet(historyKey(postID), data); appErr != nil {
+		return errors.Wrap(appErr, "failed to save post history")
+	}
+
+	return nil
+}
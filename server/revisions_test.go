@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestRevisionCheck(t *testing.T) {
+	rev := newRevision("testUserId", "s/teh/the", "teh fox", "the fox")
+
+	assert.Nil(t, rev.check("the fox"))
+	assert.Equal(t, errEditedSince, rev.check("the quick fox"))
+
+	rev.Original = "tampered"
+	assert.Equal(t, errRevisionCorrupted, rev.check("the fox"))
+
+	view := newRevision("testUserId", "s/teh/the", "teh fox", "the fox").threeWayView("the quick fox")
+	assert.Equal(t, "###### Before the edit\n> teh fox\n###### After the edit\n> the fox\n###### Now\n> the quick fox", view)
+}
+
+func TestRecordRevision(t *testing.T) {
+	api := &plugintest.API{}
+	defer api.AssertExpectations(t)
+
+	p := setupTestPlugin(t, api)
+
+	history := &postHistory{}
+	for i := 0; i < maxRevisionsPerPost; i++ {
+		history.Revisions = append(history.Revisions, newRevision("testUserId", "s/a/b", fmt.Sprint(i), fmt.Sprint(i+1)))
+	}
+	data, _ := json.Marshal(history)
+
+	rev := newRevision("testUserId", "s/a/b", "last", "latest")
+	api.On("KVGet", historyKey("postId")).Return(data, nil)
+	api.On("KVSet", historyKey("postId"), mock.MatchedBy(func(data []byte) bool {
+		var saved postHistory
+		return json.Unmarshal(data, &saved) == nil &&
+			len(saved.Revisions) == maxRevisionsPerPost &&
+			saved.Revisions[0].Original == "1" &&
+			saved.Revisions[maxRevisionsPerPost-1].Edited == "latest" &&
+			saved.CreatedAt == rev.CreatedAt
+	})).Return(nil)
+
+	assert.Nil(t, p.recordRevision("postId", rev))
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-server/model"
+
+	"github.com/carmo-evan/mattermost-plugin-replace/server/pkg/parser"
+	"github.com/carmo-evan/mattermost-plugin-replace/server/pkg/substitute"
+)
+
+const (
+	// scheduledKey holds the replacements waiting for their time, as a JSON list.
+	scheduledKey string = "scheduled_replacements"
+
+	// scheduleInterval is the time between two checks for due replacements.
+	scheduleInterval = time.Minute
+
+	// maxScheduledPerUser caps how many replacements a user may have waiting.
+	maxScheduledPerUser = 20
+)
+
+// scheduledReplacement is an s/ command to apply to a post later, as asked with at:. The command
+// is stored as typed; its scopes only served to find the post and are ignored when it is applied.
+type scheduledReplacement struct {
+	ID     string `json:"id"`
+	PostID string `json:"post_id"`
+	TeamID string `json:"team_id"`
+
+	// ChannelID is where the command was typed, and where the outcome is reported.
+	ChannelID string `json:"channel_id"`
+	UserID    string `json:"user_id"`
+	Command   string `json:"command"`
+	RunAt     int64  `json:"run_at"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// parseAt reads the time of an at: scope, either a time of day such as 17:00, taken as the next
+// time the clock shows it, or a date and time such as 2026-03-01T09:30. Both are read in loc.
+func parseAt(value string, loc *time.Location, now time.Time) (time.Time, error) {
+	now = now.In(loc)
+
+	if at, err := time.ParseInLocation("2006-01-02T15:04", value, loc); err == nil {
+		if !at.After(now) {
+			return time.Time{}, errors.Errorf("%s is in the past", value)
+		}
+		return at, nil
+	}
+
+	clock, err := time.Parse("15:04", value)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	at := time.Date(now.Year(), now.Month(), now.Day(), clock.Hour(), clock.Minute(), 0, 0, loc)
+	if !at.After(now) {
+		at = at.AddDate(0, 0, 1)
+	}
+
+	return at, nil
+}
+
+// userLocation returns the time zone of user, or UTC when it is unknown.
+func userLocation(user *model.User) *time.Location {
+	if name := user.GetPreferredTimezone(); name != "" {
+		if loc, err := time.LoadLocation(name); err == nil {
+			return loc
+		}
+	}
+
+	return time.UTC
+}
+
+func (p *Plugin) getScheduled() ([]*scheduledReplacement, error) {
+	data, appErr := p.API.KVGet(scheduledKey)
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to get scheduled replacements")
+	}
+
+	var scheduled []*scheduledReplacement
+	if data == nil {
+		return scheduled, nil
+	}
+
+	if err := json.Unmarshal(data, &scheduled); err != nil {
+		return nil, errors.Wrap(err, "failed to decode scheduled replacements")
+	}
+
+	return scheduled, nil
+}
+
+func (p *Plugin) saveScheduled(scheduled []*scheduledReplacement) error {
+	data, err := json.Marshal(scheduled)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode scheduled replacements")
+	}
+
+	if appErr := p.API.KVSet(scheduledKey, data); appErr != nil {
+		return errors.Wrap(appErr, "failed to save scheduled replacements")
+	}
+
+	return nil
+}
+
+// schedule stores a replacement until its time. The second return value is the error message to
+// show the user, if any.
+func (p *Plugin) schedule(replacement *scheduledReplacement) (string, error) {
+	p.scheduleLock.Lock()
+	defer p.scheduleLock.Unlock()
+
+	scheduled, err := p.getScheduled()
+	if err != nil {
+		return "", err
+	}
+
+	pending := 0
+	for _, other := range scheduled {
+		if other.UserID == replacement.UserID {
+			pending++
+		}
+	}
+	if pending >= maxScheduledPerUser {
+		return fmt.Sprintf("`s/ Command: You already have %d scheduled replacements waiting.`", pending), nil
+	}
+
+	return "", p.saveScheduled(append(scheduled, replacement))
+}
+
+// takeDue removes the replacements due at now from the store and returns them. The plugin API
+// offers no atomic update of a key, so the store is only guarded within this server: in a cluster
+// every node runs the scheduler, and a replacement may be applied by more than one of them.
+func (p *Plugin) takeDue(now time.Time) ([]*scheduledReplacement, error) {
+	p.scheduleLock.Lock()
+	defer p.scheduleLock.Unlock()
+
+	scheduled, err := p.getScheduled()
+	if err != nil {
+		return nil, err
+	}
+
+	var due, pending []*scheduledReplacement
+	for _, replacement := range scheduled {
+		if replacement.RunAt <= now.UnixNano()/int64(time.Millisecond) {
+			due = append(due, replacement)
+		} else {
+			pending = append(pending, replacement)
+		}
+	}
+
+	if len(due) == 0 {
+		return nil, nil
+	}
+
+	return due, p.saveScheduled(pending)
+}
+
+// runDue applies the replacements due at now. Replacements overdue because the plugin was not
+// running, such as during a restart, are applied on the first run afterwards.
+func (p *Plugin) runDue(now time.Time) error {
+	due, err := p.takeDue(now)
+	if err != nil {
+		return err
+	}
+
+	for _, replacement := range due {
+		message := p.applyScheduled(replacement)
+		p.API.SendEphemeralPost(replacement.UserID, &model.Post{ChannelId: replacement.ChannelID, Message: message, CreateAt: model.GetMillis()})
+	}
+
+	return nil
+}
+
+// applyScheduled applies a replacement whose time has come and returns the message reporting the
+// outcome to its user.
+func (p *Plugin) applyScheduled(replacement *scheduledReplacement) string {
+	context := map[string]string{"hook": "runDue", "user_id": replacement.UserID, "post_id": replacement.PostID}
+	link := p.permalink(replacement.TeamID, replacement.PostID)
+
+	post, appErr := p.API.GetPost(replacement.PostID)
+	if appErr != nil || post.DeleteAt != 0 {
+		return fmt.Sprintf("The replacement `%s` scheduled on your post was dropped, as the post no longer exists.", replacement.Command)
+	}
+
+	if refusal, err := p.deferredEditRejection(replacement.UserID, post); err != nil {
+		p.reportError(err, context)
+		return fmt.Sprintf("The replacement `%s` scheduled on [your post](%s) failed.", replacement.Command, link)
+	} else if refusal != "" {
+		return fmt.Sprintf("The replacement `%s` scheduled on [your post](%s) was dropped:\n%s", replacement.Command, link, refusal)
+	}
+
+	// Quote corrections cannot be scheduled, so a channel switched to them since must not see the
+	// post edited in place.
+	mode, err := p.getChannelCorrectionMode(post.ChannelId)
+	if err != nil {
+		p.reportError(err, context)
+		return fmt.Sprintf("The replacement `%s` scheduled on [your post](%s) failed.", replacement.Command, link)
+	}
+	if mode == correctionQuote {
+		return fmt.Sprintf("The replacement `%s` scheduled on [your post](%s) was dropped, as posts in that channel are now corrected with a quote.", replacement.Command, link)
+	}
+
+	cmds, err := parser.ParseScript(replacement.Command)
+	var subs []*substitute.Substitution
+	if err == nil {
+		subs, err = substitute.FromScript(cmds)
+	}
+	if err == nil {
+		err = p.getConfiguration().applyScriptDefaults(subs, replacement.TeamID, replacement.UserID)
+	}
+	if err != nil {
+		return fmt.Sprintf("The replacement `%s` scheduled on [your post](%s) is no longer valid: %s.", replacement.Command, link, err.Error())
+	}
+
+	prefs, err := p.getUserPreferences(replacement.UserID)
+	if err != nil {
+		p.reportError(err, context)
+	} else {
+		for _, sub := range subs {
+			prefs.apply(sub)
+		}
+	}
+
+	result, confirmation := applyScript(subs, post.Message)
+	_, attachmentReplacements := rewriteAttachments(subs, post)
+	if result.Replacements+attachmentReplacements == 0 {
+		return fmt.Sprintf("The replacement `%s` scheduled on [your post](%s) changed nothing, as the post no longer contains the pattern.", replacement.Command, link)
+	}
+
+	if p.getConfiguration().ShadowMode {
+		p.recordShadow(shadowEdited, result.Replacements)
+		return fmt.Sprintf("The replacement `%s` scheduled on [your post](%s) was not applied, as shadow mode is on.", replacement.Command, link)
+	}
+
+	p.editLock.Lock()
+	post, result, confirmation, errMsg := p.refreshEdit(post, subs, result, confirmation)
+	if errMsg != "" {
+		p.editLock.Unlock()
+		return fmt.Sprintf("The replacement `%s` scheduled on [your post](%s) was dropped:\n%s", replacement.Command, link, errMsg)
+	}
+
+	// Message attachments are rewritten last, from the post about to be saved.
+	attachments, attachmentReplacements := rewriteAttachments(subs, post)
+	if attachments != nil {
+		post.AddProp("attachments", attachments)
+		confirmation += "\n" + attachmentsConfirmation(attachmentReplacements)
+	}
+
+	if excess := messageExcess(result.Message); excess > 0 {
+		p.editLock.Unlock()
+		return fmt.Sprintf("The replacement `%s` scheduled on [your post](%s) was dropped, as the post would be %d characters over the limit of %d.", replacement.Command, link, excess, maxMessageRunes)
+	}
+
+	post.Message = result.Message
+	_, appErr = p.updatePost(post)
+	p.editLock.Unlock()
+	if appErr != nil {
+		p.reportError(appErr, context)
+		return fmt.Sprintf("The replacement `%s` scheduled on [your post](%s) failed.", replacement.Command, link)
+	}
+
+	if err := p.recordRevision(post.Id, newRevision(replacement.UserID, replacement.Command, result.Original, result.Message)); err != nil {
+		p.reportError(err, context)
+	} else if err := p.rememberEdit(replacement.UserID, post.Id); err != nil {
+		p.reportError(err, context)
+	}
+
+	return fmt.Sprintf("[Your post](%s) was edited as scheduled.\n%s", link, confirmation)
+}
+
+// runDueOnce runs runDue, reporting a panic rather than ending the scheduler loop.
+func (p *Plugin) runDueOnce(now time.Time) {
+	defer p.recoverPanic(map[string]string{"hook": "runDue"})
+
+	if err := p.runDue(now); err != nil {
+		p.reportError(err, map[string]string{"hook": "runDue"})
+	}
+}
+
+// startScheduler runs runDue every scheduleInterval until stopScheduler is called.
+func (p *Plugin) startScheduler() {
+	stop := make(chan struct{})
+	p.scheduleStop = stop
+
+	go func() {
+		ticker := time.NewTicker(scheduleInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case now := <-ticker.C:
+				p.runDueOnce(now)
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+func (p *Plugin) stopScheduler() {
+	if p.scheduleStop != nil {
+		close(p.scheduleStop)
+		p.scheduleStop = nil
+	}
+}
+
+// scheduleCommand stores replacement, due at runAt, and tells user when it will be applied. The
+// return values are those of MessageWillBePosted.
+func (p *Plugin) scheduleCommand(user *model.User, notification *model.Post, replacement *scheduledReplacement, runAt time.Time) (*model.Post, string) {
+	errMsg, err := p.schedule(replacement)
+	if err != nil {
+		p.reportError(err, map[string]string{"hook": "MessageWillBePosted", "user_id": user.Id})
+		return p.dismiss(user.Id, notification, "`s/ Command: Failed to schedule the replacement.`")
+	}
+	if errMsg != "" {
+		return p.dismiss(user.Id, notification, errMsg)
+	}
+
+	notification.Message = fmt.Sprintf("[Your post](%s) will be edited on %s.",
+		p.permalink(replacement.TeamID, replacement.PostID), runAt.Format("Mon Jan 2 at 15:04 MST"))
+	p.API.SendEphemeralPost(user.Id, notification)
+
+	return nil, "plugin.message_will_be_posted.dismiss_post"
+}
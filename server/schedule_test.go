@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/mattermost/mattermost-server/plugin"
+	"github.com/mattermost/mattermost-server/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAt(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	require.NoError(t, err)
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, paris)
+
+	at, err := parseAt("17:00", paris, now)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2026, 3, 1, 17, 0, 0, 0, paris), at)
+
+	at, err = parseAt("09:30", paris, now)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2026, 3, 2, 9, 30, 0, 0, paris), at)
+
+	at, err = parseAt("2026-03-05T08:15", paris, now)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2026, 3, 5, 8, 15, 0, 0, paris), at)
+
+	for _, value := range []string{"2026-02-01T08:15", "25:00", "5pm"} {
+		_, err := parseAt(value, paris, now)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestMessageWillBePostedScheduled(t *testing.T) {
+	api := &plugintest.API{}
+	p := setupTestPlugin(t, api)
+	// Scheduling succeeded, so the reply is not shown as a rejection reason.
+	p.setConfiguration(&configuration{RejectionMode: rejectReason})
+
+	lastPost := &model.Post{Id: "lastPostId", UserId: "testUserId", ChannelId: "testChannelId", Message: "launch is TBD"}
+	api.On("GetUser", "testUserId").Return(&model.User{Id: "testUserId", Username: "test"}, nil)
+	api.On("GetChannel", "testChannelId").Return(&model.Channel{Id: "testChannelId", TeamId: "testTeamId"}, nil)
+	api.On("SearchPostsInTeam", "testTeamId", mock.AnythingOfType("[]*model.SearchParams")).Return([]*model.Post{lastPost}, nil)
+	api.On("HasPermissionToChannel", "testUserId", "testChannelId", model.PERMISSION_EDIT_POST).Return(true)
+	api.On("KVGet", preferencesKey("testUserId")).Return(nil, nil)
+	api.On("KVGet", channelCorrectionKey("testChannelId")).Return(nil, nil)
+	api.On("KVGet", scheduledKey).Return(nil, nil)
+	api.On("GetConfig").Return(&model.Config{})
+	api.On("GetTeam", "testTeamId").Return(&model.Team{Name: "engineering"}, nil)
+	api.On("SendEphemeralPost", "testUserId", mock.MatchedBy(func(notification *model.Post) bool {
+		return assert.Contains(t, notification.Message, "[Your post](/engineering/pl/lastPostId) will be edited on ")
+	})).Return(nil)
+
+	var scheduled []*scheduledReplacement
+	api.On("KVSet", scheduledKey, mock.AnythingOfType("[]uint8")).Return(nil).Run(func(args mock.Arguments) {
+		require.NoError(t, json.Unmarshal(args.Get(1).([]byte), &scheduled))
+	})
+
+	_, rejection := p.MessageWillBePosted(&plugin.Context{}, &model.Post{UserId: "testUserId", ChannelId: "testChannelId", Message: "s/TBD/on Monday/ at:17:00"})
+
+	assert.Equal(t, "plugin.message_will_be_posted.dismiss_post", rejection)
+	assert.Equal(t, "launch is TBD", lastPost.Message)
+	require.Len(t, scheduled, 1)
+	assert.Equal(t, "lastPostId", scheduled[0].PostID)
+	assert.Equal(t, "s/TBD/on Monday/ at:17:00", scheduled[0].Command)
+	assert.True(t, scheduled[0].RunAt > model.GetMillis())
+	api.AssertExpectations(t)
+}
+
+func TestRunDue(t *testing.T) {
+	api := &plugintest.API{}
+	defer api.AssertExpectations(t)
+	p := setupTestPlugin(t, api)
+
+	now := time.Now()
+	millis := now.UnixNano() / int64(time.Millisecond)
+	due := &scheduledReplacement{ID: "dueId", PostID: "postId", TeamID: "testTeamId", ChannelID: "testChannelId", UserID: "testUserId", Command: "s/TBD/on Monday/ at:17:00", RunAt: millis - 1000}
+	later := &scheduledReplacement{ID: "laterId", PostID: "otherPostId", UserID: "testUserId", Command: "s/a/b/ at:18:00", RunAt: millis + 60000}
+	data, err := json.Marshal([]*scheduledReplacement{due, later})
+	require.NoError(t, err)
+
+	post := &model.Post{Id: "postId", UserId: "testUserId", ChannelId: "testChannelId", Message: "launch is TBD"}
+	api.On("KVGet", scheduledKey).Return(data, nil)
+	api.On("KVSet", scheduledKey, mock.MatchedBy(func(data []byte) bool {
+		var pending []*scheduledReplacement
+		return json.Unmarshal(data, &pending) == nil && len(pending) == 1 && pending[0].ID == "laterId"
+	})).Return(nil)
+	api.On("GetConfig").Return(&model.Config{})
+	api.On("GetTeam", "testTeamId").Return(&model.Team{Name: "engineering"}, nil)
+	api.On("GetPost", "postId").Return(post, nil)
+	api.On("GetChannel", "testChannelId").Return(&model.Channel{Id: "testChannelId", Type: model.CHANNEL_OPEN}, nil)
+	api.On("HasPermissionToChannel", "testUserId", "testChannelId", model.PERMISSION_EDIT_POST).Return(true)
+	api.On("KVGet", channelCorrectionKey("testChannelId")).Return(nil, nil)
+	api.On("KVGet", preferencesKey("testUserId")).Return(nil, nil)
+	api.On("UpdatePost", post).Return(post, nil)
+	api.On("KVGet", historyKey("postId")).Return(nil, nil)
+	api.On("KVSet", historyKey("postId"), mock.AnythingOfType("[]uint8")).Return(nil)
+	api.On("KVSet", lastEditKey("testUserId"), []byte("postId")).Return(nil)
+	api.On("KVSet", undoKey("testUserId"), []byte("postId")).Return(nil)
+	api.On("SendEphemeralPost", "testUserId", mock.MatchedBy(func(notification *model.Post) bool {
+		return notification.ChannelId == "testChannelId" && assert.Contains(t, notification.Message, "[Your post](/engineering/pl/postId) was edited as scheduled.")
+	})).Return(nil)
+
+	require.NoError(t, p.runDue(now))
+	assert.Equal(t, "launch is on Monday", post.Message)
+}
+
+func TestApplyScheduledRechecks(t *testing.T) {
+	replacement := &scheduledReplacement{PostID: "postId", TeamID: "testTeamId", ChannelID: "testChannelId", UserID: "testUserId", Command: "s/TBD/on Monday/ at:17:00"}
+	setup := func(api *plugintest.API, channel *model.Channel) *model.Post {
+		post := &model.Post{Id: "postId", UserId: "testUserId", ChannelId: "testChannelId", Message: "launch is TBD"}
+		api.On("GetConfig").Return(&model.Config{})
+		api.On("GetTeam", "testTeamId").Return(&model.Team{Name: "engineering"}, nil)
+		api.On("GetPost", "postId").Return(post, nil)
+		api.On("GetChannel", "testChannelId").Return(channel, nil)
+		return post
+	}
+
+	t.Run("archived since", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		p := setupTestPlugin(t, api)
+		post := setup(api, &model.Channel{Id: "testChannelId", Type: model.CHANNEL_OPEN, DeleteAt: 1})
+
+		message := p.applyScheduled(replacement)
+		assert.Equal(t, "The replacement `s/TBD/on Monday/ at:17:00` scheduled on [your post](/engineering/pl/postId) was dropped:\n"+archivedError, message)
+		assert.Equal(t, "launch is TBD", post.Message)
+	})
+
+	t.Run("excluded since", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		p := setupTestPlugin(t, api)
+		p.setConfiguration(&configuration{PublicChannelsOnly: true})
+		setup(api, &model.Channel{Id: "testChannelId", Type: model.CHANNEL_PRIVATE})
+
+		assert.Contains(t, p.applyScheduled(replacement), publicOnlyError)
+	})
+
+	t.Run("shadow mode", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		p := setupTestPlugin(t, api)
+		p.setConfiguration(&configuration{ShadowMode: true})
+		post := setup(api, &model.Channel{Id: "testChannelId", Type: model.CHANNEL_OPEN})
+		api.On("HasPermissionToChannel", "testUserId", "testChannelId", model.PERMISSION_EDIT_POST).Return(true)
+		api.On("KVGet", channelCorrectionKey("testChannelId")).Return(nil, nil)
+		api.On("KVGet", preferencesKey("testUserId")).Return(nil, nil)
+		api.On("KVGet", shadowStatsKey).Return(nil, nil)
+		api.On("KVSet", shadowStatsKey, mock.AnythingOfType("[]uint8")).Return(nil)
+
+		assert.Contains(t, p.applyScheduled(replacement), "was not applied, as shadow mode is on.")
+		assert.Equal(t, "launch is TBD", post.Message)
+	})
+
+	t.Run("quote corrections since", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		p := setupTestPlugin(t, api)
+		post := setup(api, &model.Channel{Id: "testChannelId", Type: model.CHANNEL_OPEN})
+		api.On("HasPermissionToChannel", "testUserId", "testChannelId", model.PERMISSION_EDIT_POST).Return(true)
+		api.On("KVGet", channelCorrectionKey("testChannelId")).Return([]byte(correctionQuote), nil)
+
+		assert.Contains(t, p.applyScheduled(replacement), "was dropped, as posts in that channel are now corrected with a quote.")
+		assert.Equal(t, "launch is TBD", post.Message)
+	})
+
+	t.Run("edited meanwhile", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		p := setupTestPlugin(t, api)
+		api.On("GetConfig").Return(&model.Config{})
+		api.On("GetTeam", "testTeamId").Return(&model.Team{Name: "engineering"}, nil)
+		api.On("GetChannel", "testChannelId").Return(&model.Channel{Id: "testChannelId", Type: model.CHANNEL_OPEN}, nil)
+		api.On("HasPermissionToChannel", "testUserId", "testChannelId", model.PERMISSION_EDIT_POST).Return(true)
+		api.On("KVGet", channelCorrectionKey("testChannelId")).Return(nil, nil)
+		api.On("KVGet", preferencesKey("testUserId")).Return(nil, nil)
+
+		// The post is edited between the first look and the update, which must start from the
+		// edited message.
+		api.On("GetPost", "postId").Return(&model.Post{Id: "postId", UserId: "testUserId", ChannelId: "testChannelId", Message: "launch is TBD"}, nil).Once()
+		edited := &model.Post{Id: "postId", UserId: "testUserId", ChannelId: "testChannelId", Message: "launch is TBD, see the plan", EditAt: 1, UpdateAt: 1}
+		api.On("GetPost", "postId").Return(edited, nil)
+		api.On("UpdatePost", edited).Return(edited, nil)
+		api.On("KVGet", historyKey("postId")).Return(nil, nil)
+		api.On("KVSet", historyKey("postId"), mock.AnythingOfType("[]uint8")).Return(nil)
+		api.On("KVSet", lastEditKey("testUserId"), []byte("postId")).Return(nil)
+		api.On("KVSet", undoKey("testUserId"), []byte("postId")).Return(nil)
+
+		assert.Contains(t, p.applyScheduled(replacement), "was edited as scheduled.")
+		assert.Equal(t, "launch is on Monday, see the plan", edited.Message)
+	})
+}
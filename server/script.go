@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/carmo-evan/mattermost-plugin-replace/server/pkg/substitute"
+)
+
+// applyScript applies the substitutions of a script in order, each to the result of the previous
+// ones. The combined result counts the replacements of all of them, and the confirmation reports
+// each on its own line.
+func applyScript(subs []*substitute.Substitution, message string) (*substitute.Result, string) {
+	combined := &substitute.Result{Original: message, Message: message}
+	confirmations := make([]string, 0, len(subs))
+
+	for _, sub := range subs {
+		result := sub.Preview(combined.Message)
+		combined.Message = result.Message
+		combined.Replacements += result.Replacements
+		combined.Matched = append(combined.Matched, result.Matched...)
+		confirmations = append(confirmations, confirmationMessage(sub, result))
+	}
+
+	return combined, strings.Join(confirmations, "\n")
+}
+
+// explainsScript reports whether any command of a script asks for an explanation.
+func explainsScript(subs []*substitute.Substitution) bool {
+	for _, sub := range subs {
+		if sub.Explain {
+			return true
+		}
+	}
+
+	return false
+}
+
+// previewsScript reports whether any command of a script asks for a dry run.
+func previewsScript(subs []*substitute.Substitution) bool {
+	for _, sub := range subs {
+		if sub.DryRun {
+			return true
+		}
+	}
+
+	return false
+}
+
+// invalidPatternMessage rejects the pattern of command index, counted from 0, of a script of
+// count commands.
+func invalidPatternMessage(index, count int, err error) string {
+	if count > 1 {
+		return fmt.Sprintf("`s/ Command: The pattern of command %d is an %s.`", index+1, err.Error())
+	}
+
+	return fmt.Sprintf("`s/ Command: The pattern is an %s.`", err.Error())
+}
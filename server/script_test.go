@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carmo-evan/mattermost-plugin-replace/server/pkg/parser"
+	"github.com/carmo-evan/mattermost-plugin-replace/server/pkg/substitute"
+)
+
+func TestApplyScript(t *testing.T) {
+	cmds, err := parser.ParseScript("s/teh/the/; s/the cat/a dog/; s/recieve/receive/g")
+	require.Nil(t, err)
+	subs, err := substitute.FromScript(cmds)
+	require.Nil(t, err)
+
+	result, confirmation := applyScript(subs, "teh cat will recieve it")
+
+	assert.Equal(t, "teh cat will recieve it", result.Original)
+	assert.Equal(t, "a dog will receive it", result.Message)
+	assert.Equal(t, 3, result.Replacements)
+	assert.Equal(t, "s/ Replaced 1 occurrence of \"teh\" with \"the\"\ns/ Replaced 1 occurrence of \"the cat\" with \"a dog\"\ns/ Replaced all 1 occurrences of \"recieve\" with \"receive\"", confirmation)
+}
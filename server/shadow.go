@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-server/model"
+)
+
+const shadowStatsKey string = statsKeyPrefix + "shadow"
+
+// Outcomes of an s/ command handled in shadow mode.
+const (
+	shadowEdited    string = "edited"
+	shadowUnchanged string = "unchanged"
+	shadowRejected  string = "rejected"
+	shadowInvalid   string = "invalid"
+)
+
+// shadowStats counts what the plugin would have done while running in shadow mode.
+type shadowStats struct {
+	// CreatedAt is when counting started, so the statistics are reset once they are older than
+	// the statistics retention.
+	CreatedAt int64 `json:"created_at"`
+
+	// Edited counts commands that would have edited a post, and Replacements the matches they
+	// would have replaced.
+	Edited       int `json:"edited"`
+	Replacements int `json:"replacements"`
+
+	// Unchanged counts commands whose pattern matched nothing in the last post.
+	Unchanged int `json:"unchanged"`
+
+	// Rejected counts commands refused for lack of a post, permissions or similar.
+	Rejected int `json:"rejected"`
+
+	// Invalid counts messages starting with s/ that are not valid commands, which are likely
+	// regular messages.
+	Invalid int `json:"invalid"`
+}
+
+func (p *Plugin) getShadowStats() (*shadowStats, error) {
+	stats := &shadowStats{}
+
+	data, appErr := p.API.KVGet(shadowStatsKey)
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to get shadow mode statistics")
+	}
+
+	if data == nil {
+		return stats, nil
+	}
+
+	if err := json.Unmarshal(data, stats); err != nil {
+		return nil, errors.Wrap(err, "failed to decode shadow mode statistics")
+	}
+
+	return stats, nil
+}
+
+// recordShadow adds the outcome of a command to the shadow mode statistics. Updates are
+// serialized within this server only, so counts may be slightly off in a cluster.
+func (p *Plugin) recordShadow(outcome string, replacements int) {
+	p.shadowLock.Lock()
+	defer p.shadowLock.Unlock()
+
+	context := map[string]string{"hook": "MessageWillBePosted"}
+
+	stats, err := p.getShadowStats()
+	if err != nil {
+		p.reportError(err, context)
+		return
+	}
+
+	if stats.CreatedAt == 0 {
+		stats.CreatedAt = model.GetMillis()
+	}
+
+	switch outcome {
+	case shadowEdited:
+		stats.Edited++
+		stats.Replacements += replacements
+	case shadowUnchanged:
+		stats.Unchanged++
+	case shadowRejected:
+		stats.Rejected++
+	case shadowInvalid:
+		stats.Invalid++
+	}
+
+	data, err := json.Marshal(stats)
+	if err != nil {
+		p.reportError(errors.Wrap(err, "failed to encode shadow mode statistics"), context)
+		return
+	}
+
+	if appErr := p.API.KVSet(shadowStatsKey, data); appErr != nil {
+		p.reportError(errors.Wrap(appErr, "failed to save shadow mode statistics"), context)
+	}
+}
+
+// executeShadowCommand shows or resets the shadow mode statistics, for system admins only.
+func (p *Plugin) executeShadowCommand(args *model.CommandArgs, params []string) *model.CommandResponse {
+	if len(params) > 1 || len(params) == 1 && params[0] != "reset" {
+		return commandResponse("Usage: `/replace shadow [reset]`")
+	}
+
+	if !p.API.HasPermissionTo(args.UserId, model.PERMISSION_MANAGE_SYSTEM) {
+		return commandResponse("Only system admins may view the shadow mode statistics.")
+	}
+
+	context := map[string]string{"hook": "ExecuteCommand", "user_id": args.UserId}
+
+	if len(params) == 1 {
+		p.shadowLock.Lock()
+		defer p.shadowLock.Unlock()
+
+		if appErr := p.API.KVDelete(shadowStatsKey); appErr != nil {
+			p.reportError(errors.Wrap(appErr, "failed to reset shadow mode statistics"), context)
+			return commandResponse("Failed to reset the shadow mode statistics.")
+		}
+
+		return commandResponse("Shadow mode statistics were reset.")
+	}
+
+	stats, err := p.getShadowStats()
+	if err != nil {
+		p.reportError(err, context)
+		return commandResponse("Failed to load the shadow mode statistics.")
+	}
+
+	mode := "off"
+	if p.getConfiguration().ShadowMode {
+		mode = "on"
+	}
+
+	return commandResponse("###### Shadow mode statistics\nShadow mode is %s.\n"+
+		"* Would have edited: %d posts, replacing %d matches\n"+
+		"* Matched nothing: %d\n"+
+		"* Refused: %d\n"+
+		"* Not a valid command: %d",
+		mode, stats.Edited, stats.Replacements, stats.Unchanged, stats.Rejected, stats.Invalid)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/mattermost/mattermost-server/plugin"
+	"github.com/mattermost/mattermost-server/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestMessageWillBePostedShadowMode(t *testing.T) {
+	t.Run("would edit", func(t *testing.T) {
+		api := &plugintest.API{}
+		p := setupTestPlugin(t, api)
+		p.setConfiguration(&configuration{ShadowMode: true})
+
+		lastPost := &model.Post{Id: "lastPostId", UserId: "testUserId", Message: "one one"}
+		api.On("GetUser", "testUserId").Return(&model.User{Id: "testUserId", Username: "test"}, nil)
+		api.On("GetChannel", "testChannelId").Return(&model.Channel{TeamId: "testTeamId"}, nil)
+		api.On("GetChannel", "").Return(&model.Channel{TeamId: "testTeamId"}, nil)
+		api.On("SearchPostsInTeam", "testTeamId", mock.AnythingOfType("[]*model.SearchParams")).Return([]*model.Post{lastPost}, nil)
+		api.On("HasPermissionToChannel", "testUserId", "", model.PERMISSION_EDIT_POST).Return(true)
+		api.On("KVGet", preferencesKey("testUserId")).Return(nil, nil)
+		api.On("KVGet", shadowStatsKey).Return(nil, nil)
+		api.On("KVSet", shadowStatsKey, mock.MatchedBy(func(data []byte) bool {
+			var stats shadowStats
+			return json.Unmarshal(data, &stats) == nil && stats.CreatedAt > 0 && stats.Edited == 1 && stats.Replacements == 2
+		})).Return(nil)
+		api.On("LogInfo", "Shadow mode: s/ command not applied", "user_id", "testUserId", "post_id", "lastPostId", "replacements", 2).Return()
+
+		_, rejection := p.MessageWillBePosted(&plugin.Context{}, &model.Post{UserId: "testUserId", ChannelId: "testChannelId", Message: "s/one/two"})
+
+		assert.Equal(t, "", rejection)
+		assert.Equal(t, "one one", lastPost.Message)
+		api.AssertExpectations(t)
+	})
+
+	t.Run("invalid command", func(t *testing.T) {
+		api := &plugintest.API{}
+		p := setupTestPlugin(t, api)
+		p.setConfiguration(&configuration{ShadowMode: true})
+
+		api.On("KVGet", shadowStatsKey).Return([]byte(`{"created_at":1,"invalid":3}`), nil)
+		api.On("KVSet", shadowStatsKey, []byte(`{"created_at":1,"edited":0,"replacements":0,"unchanged":0,"rejected":0,"invalid":4}`)).Return(nil)
+
+		_, rejection := p.MessageWillBePosted(&plugin.Context{}, &model.Post{UserId: "testUserId", ChannelId: "testChannelId", Message: "s/he said"})
+
+		assert.Equal(t, "", rejection)
+		api.AssertExpectations(t)
+	})
+}
+
+func TestExecuteShadowCommand(t *testing.T) {
+	api := &plugintest.API{}
+	p := setupTestPlugin(t, api)
+
+	api.On("HasPermissionTo", "testUserId", model.PERMISSION_MANAGE_SYSTEM).Return(true)
+	api.On("KVGet", shadowStatsKey).Return([]byte(`{"edited":5,"replacements":7,"unchanged":1,"rejected":2,"invalid":9}`), nil)
+
+	response, appErr := p.ExecuteCommand(nil, &model.CommandArgs{UserId: "testUserId", Command: "/replace shadow"})
+
+	assert.Nil(t, appErr)
+	assert.Contains(t, response.Text, "Would have edited: 5 posts, replacing 7 matches")
+	assert.Contains(t, response.Text, "Not a valid command: 9")
+	api.AssertExpectations(t)
+}
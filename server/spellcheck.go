@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// spellcheckTimeout bounds a request to the spellcheck service, which is made while the
+	// user's post is being handled.
+	spellcheckTimeout = 3 * time.Second
+	// spellcheckCacheTTL is how long suggestions for a word are reused.
+	spellcheckCacheTTL = time.Hour
+	// maxSuggestions caps the number of suggestions shown to the user.
+	maxSuggestions = 5
+)
+
+// suggestionPattern matches an s/word command that names a word but no replacement.
+var suggestionPattern = regexp.MustCompile(`^s/([^/\s]+)/?$`)
+
+// languageToolResponse is the part of a LanguageTool /v2/check response used by the plugin.
+type languageToolResponse struct {
+	Matches []struct {
+		Replacements []struct {
+			Value string `json:"value"`
+		} `json:"replacements"`
+	} `json:"matches"`
+}
+
+// suggestionWord returns the word of an s/word command, if message is one.
+func suggestionWord(message string) (string, bool) {
+	match := suggestionPattern.FindStringSubmatch(message)
+	if match == nil {
+		return "", false
+	}
+
+	return match[1], true
+}
+
+// getSuggestions returns spelling suggestions for word from the configured LanguageTool
+// compatible service.
+func (p *Plugin) getSuggestions(word string) ([]string, error) {
+	config := p.getConfiguration()
+	language := config.SpellcheckLanguage
+	if language == "" {
+		language = "en-US"
+	}
+
+	key := config.SpellcheckURL + "\x00" + language + "\x00" + word
+	if suggestions, ok := p.spellcheckCache.get(key); ok {
+		return suggestions.([]string), nil
+	}
+
+	suggestions, err := checkSpelling(config.SpellcheckURL, language, word)
+	if err != nil {
+		return nil, err
+	}
+
+	p.spellcheckCache.set(key, suggestions, spellcheckCacheTTL)
+
+	return suggestions, nil
+}
+
+// checkSpelling asks the LanguageTool compatible service at serviceURL for corrections of text.
+func checkSpelling(serviceURL, language, text string) ([]string, error) {
+	client := &http.Client{Timeout: spellcheckTimeout}
+	resp, err := client.PostForm(strings.TrimSuffix(serviceURL, "/")+"/v2/check", url.Values{
+		"text":     {text},
+		"language": {language},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query spellcheck service")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("spellcheck service responded with status %d", resp.StatusCode)
+	}
+
+	var result languageToolResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, errors.Wrap(err, "failed to decode spellcheck response")
+	}
+
+	suggestions := []string{}
+	seen := make(map[string]bool)
+	for _, match := range result.Matches {
+		for _, replacement := range match.Replacements {
+			if len(suggestions) == maxSuggestions {
+				return suggestions, nil
+			}
+
+			if !seen[replacement.Value] {
+				seen[replacement.Value] = true
+				suggestions = append(suggestions, replacement.Value)
+			}
+		}
+	}
+
+	return suggestions, nil
+}
+
+// suggestionMessage builds the ephemeral reply to an s/word command.
+func (p *Plugin) suggestionMessage(word string) string {
+	suggestions, err := p.getSuggestions(word)
+	if err != nil {
+		p.API.LogWarn("Failed to get spelling suggestions", "error", err.Error())
+		return fmt.Sprintf("`s/ Command: Spelling suggestions are unavailable right now.` %s", usage)
+	}
+
+	if len(suggestions) == 0 {
+		return fmt.Sprintf("`s/ Command: No suggestions for \"%s\".` %s", word, usage)
+	}
+
+	return fmt.Sprintf("`s/ Command: Suggestions for \"%s\": %s.` Post `s/%s/%s` to apply the first one.",
+		word, strings.Join(suggestions, ", "), word, suggestions[0])
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSuggestionWord(t *testing.T) {
+	word, ok := suggestionWord("s/recieve")
+	assert.True(t, ok)
+	assert.Equal(t, "recieve", word)
+
+	word, ok = suggestionWord("s/recieve/")
+	assert.True(t, ok)
+	assert.Equal(t, "recieve", word)
+
+	_, ok = suggestionWord("s/recieve/receive")
+	assert.False(t, ok)
+
+	_, ok = suggestionWord("s/two words")
+	assert.False(t, ok)
+}
+
+func TestGetSuggestions(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		assert.Equal(t, "/v2/check", r.URL.Path)
+		assert.Equal(t, "recieve", r.FormValue("text"))
+		assert.Equal(t, "en-US", r.FormValue("language"))
+		fmt.Fprint(w, `{"matches": [{"replacements": [{"value": "receive"}, {"value": "relieve"}, {"value": "receive"}]}]}`)
+	}))
+	defer server.Close()
+
+	p := setupTestPlugin(t, &plugintest.API{})
+	p.setConfiguration(&configuration{SpellcheckURL: server.URL + "/"})
+
+	for i := 0; i < 2; i++ {
+		suggestions, err := p.getSuggestions("recieve")
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"receive", "relieve"}, suggestions)
+	}
+	assert.Equal(t, 1, requests)
+
+	assert.Contains(t, p.suggestionMessage("recieve"), "Post `s/recieve/receive`")
+}
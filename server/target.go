@@ -0,0 +1,328 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-server/model"
+
+	"github.com/carmo-evan/mattermost-plugin-replace/server/pkg/substitute"
+)
+
+// anyChannel is the value of the in: scope searching every channel of the team.
+const anyChannel = "*"
+
+// Posts of a thread selectable with the thread: scope and the ThreadTarget setting.
+const (
+	threadReply string = "reply"
+	threadRoot  string = "root"
+)
+
+// postTarget describes where to look for the post to edit.
+type postTarget struct {
+	TeamID string
+
+	// ChannelName restricts the search to a single channel of the team when set.
+	ChannelName string
+
+	// ChannelID, when set, keeps only the posts of that channel. Unlike ChannelName it also
+	// applies to direct and group messages, which searches cannot name.
+	ChannelID string
+
+	// RootID restricts the search to a thread when set.
+	RootID string
+
+	// Root selects the root post of the thread rather than the latest reply.
+	Root bool
+
+	// Phrase, when set, selects the most recent post containing it rather than the last post.
+	Phrase string
+
+	// ExcludedID, when set, leaves out that post, such as a post being edited into a command.
+	ExcludedID string
+
+	// PostID, when set, selects that post, which must have been written by the author.
+	PostID string
+
+	// Since, when positive, excludes the posts created before it, in milliseconds since the
+	// epoch. Within is the duration it was computed from, as the user wrote it.
+	Since  int64
+	Within string
+
+	// Rank selects the nth most recent of the posts found, counted from 1, rather than the most
+	// recent one when above 1.
+	Rank int
+}
+
+// matches reports whether post is recent enough for the target and contains its phrase, if any.
+// Case is ignored, as in Mattermost searches.
+func (t *postTarget) matches(post *model.Post) bool {
+	if post.CreateAt < t.Since || post.Id == t.ExcludedID && t.ExcludedID != "" {
+		return false
+	}
+
+	return t.Phrase == "" || strings.Contains(strings.ToLower(post.Message), strings.ToLower(t.Phrase))
+}
+
+// notFoundMessage is the error shown when no post matches the target.
+func (t *postTarget) notFoundMessage() string {
+	switch {
+	case t.Within != "":
+		return fmt.Sprintf("`s/ Command: No post of yours from the last %s matches, older posts are left alone.`", t.Within)
+	case t.Root && t.Phrase != "":
+		return fmt.Sprintf("`s/ Command: The root post of this thread is not yours or does not contain \"%s\".`", t.Phrase)
+	case t.Root:
+		return "`s/ Command: The root post of this thread is not yours.`"
+	case t.PostID != "":
+		return "`s/ Command: Post " + t.PostID + " was not found among the posts you may edit.`"
+	case t.Rank > 1 && t.Phrase != "":
+		return fmt.Sprintf("`s/ Command: Fewer than %d previous posts contain \"%s\".`", t.Rank, t.Phrase)
+	case t.Rank > 1:
+		return fmt.Sprintf("`s/ Command: You have fewer than %d previous posts.`", t.Rank)
+	}
+
+	if t.Phrase != "" {
+		return fmt.Sprintf("`s/ Command: No previous post contains \"%s\".`", t.Phrase)
+	}
+
+	return noPostsFoundError
+}
+
+// resolveTarget works out where to look for the user's post from the channel the command was
+// typed in and the scopes given after it, such as team:engineering, in:deploys or
+// match:"deploy failed". Without them only the channel the command was typed in is searched;
+// in:* widens the search to the whole team. In a thread, the latest reply of the user is edited,
+// or the root post with thread:root or the ThreadTarget setting. Permissions
+// are checked against the selected team and channel. A post ID, given by a permalink or
+// id:, names the post itself and cannot be combined with those. within:10m leaves out posts
+// older than the duration. The second return value is the error message to show the user, if
+// any.
+func (p *Plugin) resolveTarget(user *model.User, channel *model.Channel, rootID string, scopes map[string]string) (*postTarget, string) {
+	target, errMsg := p.resolveLocation(user, channel, rootID, scopes)
+	if errMsg != "" {
+		return nil, errMsg
+	}
+
+	if within, ok := scopes["within"]; ok {
+		duration, err := parseWithin(within)
+		if err != nil {
+			return nil, "`s/ Command: within: must be followed by a duration such as 10m, 2h or 1d.`"
+		}
+
+		target.Within = within
+		target.Since = model.GetMillis() - int64(duration/time.Millisecond)
+	}
+
+	return target, ""
+}
+
+// parseWithin reads the duration of a within: scope. Besides the units of time.ParseDuration, d
+// stands for days.
+func parseWithin(value string) (time.Duration, error) {
+	var duration time.Duration
+	if strings.HasSuffix(value, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(value, "d"))
+		if err != nil {
+			return 0, err
+		}
+		duration = time.Duration(days) * 24 * time.Hour
+	} else {
+		var err error
+		if duration, err = time.ParseDuration(value); err != nil {
+			return 0, err
+		}
+	}
+
+	if duration <= 0 {
+		return 0, errors.Errorf("duration %s is not positive", value)
+	}
+
+	return duration, nil
+}
+
+// resolveLocation works out the team, channel, thread or post a target points to, along with
+// its phrase.
+func (p *Plugin) resolveLocation(user *model.User, channel *model.Channel, rootID string, scopes map[string]string) (*postTarget, string) {
+	teamName, hasTeam := scopes["team"]
+	channelName, hasChannel := scopes["in"]
+	phrase, hasPhrase := scopes["match"]
+
+	if postID, ok := scopes["id"]; ok {
+		if hasTeam || hasChannel || hasPhrase {
+			return nil, "`s/ Command: A permalink or post ID cannot be combined with team:, in: or match:.`"
+		}
+		return &postTarget{TeamID: channel.TeamId, PostID: postID}, ""
+	}
+	threadTarget, hasThread := scopes["thread"]
+	if !hasThread {
+		threadTarget = p.getConfiguration().ThreadTarget
+	}
+	if hasThread && threadTarget != threadReply && threadTarget != threadRoot {
+		return nil, "`s/ Command: thread: must be followed by root or reply.`"
+	}
+
+	if !hasTeam && !hasChannel {
+		target := &postTarget{TeamID: channel.TeamId, ChannelID: channel.Id, RootID: rootID, Root: rootID != "" && threadTarget == threadRoot, Phrase: phrase}
+		if channel.Type == model.CHANNEL_OPEN || channel.Type == model.CHANNEL_PRIVATE {
+			target.ChannelName = channel.Name
+		}
+		return target, ""
+	}
+
+	if channelName == anyChannel {
+		if !hasTeam && channel.TeamId == "" {
+			return nil, "`s/ Command: Direct and group messages belong to no team, add team:name to in:*.`"
+		}
+		hasChannel = false
+	}
+
+	target := &postTarget{TeamID: channel.TeamId, Phrase: phrase}
+	if hasTeam {
+		team, appErr := p.API.GetTeamByName(teamName)
+		if appErr != nil {
+			return nil, fmt.Sprintf("`s/ Command: Team %s not found.`", teamName)
+		}
+
+		if member, appErr := p.API.GetTeamMember(team.Id, user.Id); appErr != nil || member.DeleteAt != 0 {
+			return nil, fmt.Sprintf("`s/ Command: You are not a member of team %s.`", teamName)
+		}
+
+		target.TeamID = team.Id
+	}
+
+	if hasChannel {
+		targetChannel, appErr := p.API.GetChannelByName(target.TeamID, channelName, false)
+		if appErr != nil {
+			return nil, fmt.Sprintf("`s/ Command: Channel %s not found.`", channelName)
+		}
+
+		if _, appErr := p.API.GetChannelMember(targetChannel.Id, user.Id); appErr != nil {
+			return nil, fmt.Sprintf("`s/ Command: You are not a member of channel %s.`", channelName)
+		}
+
+		if !p.API.HasPermissionToChannel(user.Id, targetChannel.Id, model.PERMISSION_EDIT_POST) {
+			return nil, fmt.Sprintf("`s/ Command: You do not have permission to edit posts in channel %s.`", channelName)
+		}
+
+		target.ChannelName = targetChannel.Name
+	}
+
+	return target, ""
+}
+
+// Roles allowed to edit the posts of other users with the u: scope, for the ModeratorRole
+// setting.
+const (
+	moderatorSystemAdmin  string = "system_admin"
+	moderatorChannelAdmin string = "channel_admin"
+)
+
+// moderatorProp is the post prop recording the ID of the moderator who last edited a post of
+// another user.
+const moderatorProp = "replace_moderator_id"
+
+// resolveAuthor returns whose post to edit: the user, or with a bot:name scope a bot account the
+// user owns, or may manage as an admin of others' bots. Bots cannot fix their own typos, so
+// their owners do it for them. With u:name, moderators may edit the posts of another user of
+// teamID; whether they moderate the channel of the post is checked by canModerate once it is
+// found. The second return value is the error message to show the user, if any.
+func (p *Plugin) resolveAuthor(user *model.User, teamID string, scopes map[string]string) (*model.User, string) {
+	if username, ok := scopes["u"]; ok {
+		if _, ok := scopes["bot"]; ok {
+			return nil, "`s/ Command: u: and bot: cannot be combined.`"
+		}
+
+		if !p.getConfiguration().isFeatureEnabled(flagModerator, teamID, user.Id) {
+			return nil, "`s/ Command: Editing other users' posts is not enabled.`"
+		}
+
+		author, appErr := p.API.GetUserByUsername(username)
+		if appErr != nil || author.IsBot {
+			return nil, fmt.Sprintf("`s/ Command: User %s not found.`", username)
+		}
+
+		return author, ""
+	}
+
+	botName, ok := scopes["bot"]
+	if !ok {
+		return user, ""
+	}
+
+	notFound := fmt.Sprintf("`s/ Command: Bot %s not found.`", botName)
+
+	botUser, appErr := p.API.GetUserByUsername(botName)
+	if appErr != nil || !botUser.IsBot {
+		return nil, notFound
+	}
+
+	bot, appErr := p.API.GetBot(botUser.Id, false)
+	if appErr != nil {
+		return nil, notFound
+	}
+
+	if bot.OwnerId != user.Id && !p.API.HasPermissionTo(user.Id, model.PERMISSION_MANAGE_OTHERS_BOTS) {
+		return nil, fmt.Sprintf("`s/ Command: You do not manage bot %s.`", botName)
+	}
+
+	return botUser, ""
+}
+
+// canModerate reports whether userID may edit the posts of other users in channelID, under the
+// ModeratorRole setting.
+func (p *Plugin) canModerate(userID, channelID string) bool {
+	if p.API.HasPermissionTo(userID, model.PERMISSION_MANAGE_SYSTEM) {
+		return true
+	}
+
+	return p.getConfiguration().ModeratorRole == moderatorChannelAdmin &&
+		p.API.HasPermissionToChannel(userID, channelID, model.PERMISSION_MANAGE_CHANNEL_ROLES)
+}
+
+// searchBack looks through the recent posts of author within target, up to the SearchBackPosts
+// setting, for the newest one after the last post that subs change and that may be edited from
+// ch. It returns the post along with the result and confirmation of the script, or nil if none
+// qualifies.
+func (p *Plugin) searchBack(ch *model.Channel, author *model.User, requesterID string, target *postTarget, subs []*substitute.Substitution) (*model.Post, *substitute.Result, string) {
+	limit := p.getConfiguration().SearchBackPosts
+	if limit <= 1 {
+		return nil, nil, ""
+	}
+
+	posts, errMsg := p.getRecentPosts(author, requesterID, target, limit)
+	if errMsg != "" || len(posts) < 2 {
+		return nil, nil, ""
+	}
+
+	for _, post := range posts[1:] {
+		if rejection, appErr := p.editRejection(post, requesterID); appErr != nil || rejection != "" {
+			continue
+		}
+
+		if result, confirmation := applyScript(subs, post.Message); result.Replacements > 0 {
+			return post, result, confirmation
+		}
+	}
+
+	return nil, nil, ""
+}
+
+// permalink returns the link to a post of team teamID. It is relative when the site URL is not
+// configured.
+func (p *Plugin) permalink(teamID, postID string) string {
+	siteURL := ""
+	if config := p.API.GetConfig(); config != nil && config.ServiceSettings.SiteURL != nil {
+		siteURL = strings.TrimRight(*config.ServiceSettings.SiteURL, "/")
+	}
+
+	teamName := "_redirect"
+	if team, appErr := p.API.GetTeam(teamID); appErr == nil {
+		teamName = team.Name
+	}
+
+	return siteURL + "/" + teamName + "/pl/" + postID
+}
@@ -0,0 +1,534 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/mattermost/mattermost-server/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/carmo-evan/mattermost-plugin-replace/server/pkg/substitute"
+)
+
+func TestResolveTarget(t *testing.T) {
+	user := &model.User{Id: "testUserId", Username: "test"}
+	channel := &model.Channel{Id: "testChannelId", TeamId: "testTeamId", Name: "town-square", Type: model.CHANNEL_OPEN}
+
+	t.Run("current channel", func(t *testing.T) {
+		p := setupTestPlugin(t, &plugintest.API{})
+
+		target, errMsg := p.resolveTarget(user, channel, "rootId", map[string]string{})
+		assert.Equal(t, "", errMsg)
+		assert.Equal(t, &postTarget{TeamID: "testTeamId", ChannelName: "town-square", ChannelID: "testChannelId", RootID: "rootId"}, target)
+
+		direct := &model.Channel{Id: "directId", Name: "a__b", Type: model.CHANNEL_DIRECT}
+		target, errMsg = p.resolveTarget(user, direct, "", map[string]string{})
+		assert.Equal(t, "", errMsg)
+		assert.Equal(t, &postTarget{ChannelID: "directId"}, target)
+	})
+
+	t.Run("whole team", func(t *testing.T) {
+		p := setupTestPlugin(t, &plugintest.API{})
+
+		target, errMsg := p.resolveTarget(user, channel, "rootId", map[string]string{"in": "*"})
+		assert.Equal(t, "", errMsg)
+		assert.Equal(t, &postTarget{TeamID: "testTeamId"}, target)
+	})
+
+	t.Run("phrase", func(t *testing.T) {
+		p := setupTestPlugin(t, &plugintest.API{})
+
+		target, errMsg := p.resolveTarget(user, channel, "rootId", map[string]string{"match": "deploy failed"})
+		assert.Equal(t, "", errMsg)
+		assert.Equal(t, &postTarget{TeamID: "testTeamId", ChannelName: "town-square", ChannelID: "testChannelId", RootID: "rootId", Phrase: "deploy failed"}, target)
+	})
+
+	t.Run("other team and channel", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		p := setupTestPlugin(t, api)
+
+		api.On("GetTeamByName", "engineering").Return(&model.Team{Id: "engTeamId"}, nil)
+		api.On("GetTeamMember", "engTeamId", "testUserId").Return(&model.TeamMember{}, nil)
+		api.On("GetChannelByName", "engTeamId", "deploys", false).Return(&model.Channel{Id: "deploysId", Name: "deploys"}, nil)
+		api.On("GetChannelMember", "deploysId", "testUserId").Return(&model.ChannelMember{}, nil)
+		api.On("HasPermissionToChannel", "testUserId", "deploysId", mock.Anything).Return(true)
+
+		target, errMsg := p.resolveTarget(user, channel, "rootId", map[string]string{"team": "engineering", "in": "deploys"})
+		assert.Equal(t, "", errMsg)
+		assert.Equal(t, &postTarget{TeamID: "engTeamId", ChannelName: "deploys"}, target)
+	})
+
+	t.Run("not a team member", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		p := setupTestPlugin(t, api)
+
+		api.On("GetTeamByName", "engineering").Return(&model.Team{Id: "engTeamId"}, nil)
+		api.On("GetTeamMember", "engTeamId", "testUserId").Return(nil, &model.AppError{Message: "not found"})
+
+		target, errMsg := p.resolveTarget(user, channel, "", map[string]string{"team": "engineering"})
+		assert.Nil(t, target)
+		assert.Contains(t, errMsg, "not a member of team engineering")
+	})
+}
+
+func TestGetLastPostPhrase(t *testing.T) {
+	user := &model.User{Id: "testUserId", Username: "test"}
+	target := &postTarget{TeamID: "testTeamId", Phrase: "Deploy failed"}
+
+	t.Run("search", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		p := setupTestPlugin(t, api)
+
+		stemmed := &model.Post{Id: "stemmedId", ChannelId: "testChannelId", Message: "deploys failing"}
+		wanted := &model.Post{Id: "wantedId", ChannelId: "testChannelId", Message: "the deploy failed again"}
+		api.On("SearchPostsInTeam", "testTeamId", mock.MatchedBy(func(params []*model.SearchParams) bool {
+			return len(params) == 1 && params[0].Terms == `"Deploy failed"` && params[0].FromUsers[0] == "test"
+		})).Return([]*model.Post{stemmed, wanted}, nil)
+		api.On("HasPermissionToChannel", "testUserId", "testChannelId", model.PERMISSION_EDIT_POST).Return(true)
+
+		post, errMsg := p.getLastPost(user, user.Id, target)
+		assert.Equal(t, "", errMsg)
+		assert.Equal(t, wanted, post)
+	})
+
+	t.Run("thread", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		p := setupTestPlugin(t, api)
+
+		thread := model.NewPostList()
+		thread.AddPost(&model.Post{Id: "latestId", UserId: "testUserId", Message: "all good", CreateAt: 3})
+		thread.AddPost(&model.Post{Id: "wantedId", UserId: "testUserId", Message: "deploy failed", CreateAt: 2})
+		api.On("GetPostThread", "rootId").Return(thread, nil)
+
+		post, errMsg := p.getLastPost(user, user.Id, &postTarget{TeamID: "testTeamId", RootID: "rootId", Phrase: "deploy failed"})
+		assert.Equal(t, "", errMsg)
+		assert.Equal(t, "wantedId", post.Id)
+
+		_, errMsg = p.getLastPost(user, user.Id, &postTarget{TeamID: "testTeamId", RootID: "rootId", Phrase: "rollback"})
+		assert.Equal(t, "`s/ Command: No previous post contains \"rollback\".`", errMsg)
+	})
+}
+
+func TestGetLastPostChannel(t *testing.T) {
+	user := &model.User{Id: "testUserId", Username: "test"}
+
+	api := &plugintest.API{}
+	defer api.AssertExpectations(t)
+	p := setupTestPlugin(t, api)
+
+	elsewhere := &model.Post{Id: "elsewhereId", ChannelId: "otherChannelId"}
+	wanted := &model.Post{Id: "wantedId", ChannelId: "testChannelId"}
+	api.On("SearchPostsInTeam", "testTeamId", mock.MatchedBy(func(params []*model.SearchParams) bool {
+		return len(params) == 1 && params[0].InChannels[0] == "town-square"
+	})).Return([]*model.Post{elsewhere, wanted}, nil)
+	api.On("HasPermissionToChannel", "testUserId", "testChannelId", model.PERMISSION_EDIT_POST).Return(true)
+
+	post, errMsg := p.getLastPost(user, user.Id, &postTarget{TeamID: "testTeamId", ChannelName: "town-square", ChannelID: "testChannelId"})
+	assert.Equal(t, "", errMsg)
+	assert.Equal(t, wanted, post)
+}
+
+func TestGetLastPostWithin(t *testing.T) {
+	user := &model.User{Id: "testUserId", Username: "test"}
+	channel := &model.Channel{Id: "testChannelId", TeamId: "testTeamId"}
+
+	api := &plugintest.API{}
+	defer api.AssertExpectations(t)
+	p := setupTestPlugin(t, api)
+
+	target, errMsg := p.resolveTarget(user, channel, "", map[string]string{"within": "10m"})
+	assert.Equal(t, "", errMsg)
+	assert.Equal(t, "10m", target.Within)
+	assert.InDelta(t, model.GetMillis()-10*60*1000, target.Since, 1000)
+
+	old := &model.Post{Id: "oldId", ChannelId: "testChannelId", CreateAt: target.Since - 1}
+	api.On("SearchPostsInTeam", "testTeamId", mock.Anything).Return([]*model.Post{old}, nil)
+
+	_, errMsg = p.getLastPost(user, user.Id, target)
+	assert.Equal(t, "`s/ Command: No post of yours from the last 10m matches, older posts are left alone.`", errMsg)
+
+	for _, within := range []string{"soon", "0m", "-1h", "xd"} {
+		_, errMsg = p.resolveTarget(user, channel, "", map[string]string{"within": within})
+		assert.Contains(t, errMsg, "within: must be followed by a duration", within)
+	}
+
+	duration, err := parseWithin("2d")
+	assert.Nil(t, err)
+	assert.Equal(t, 48*time.Hour, duration)
+}
+
+func TestGetLastPostThread(t *testing.T) {
+	user := &model.User{Id: "testUserId", Username: "test"}
+
+	thread := model.NewPostList()
+	thread.AddPost(&model.Post{Id: "rootId", UserId: "testUserId", CreateAt: 1})
+	thread.AddPost(&model.Post{Id: "replyaId", UserId: "testUserId", CreateAt: 2})
+	thread.AddPost(&model.Post{Id: "replybId", UserId: "testUserId", CreateAt: 2})
+	thread.AddPost(&model.Post{Id: "otherId", UserId: "otherUserId", CreateAt: 3})
+
+	api := &plugintest.API{}
+	defer api.AssertExpectations(t)
+	p := setupTestPlugin(t, api)
+	api.On("GetPostThread", "rootId").Return(thread, nil)
+
+	post, errMsg := p.getLastPost(user, user.Id, &postTarget{TeamID: "testTeamId", RootID: "rootId"})
+	assert.Equal(t, "", errMsg)
+	assert.Equal(t, "replybId", post.Id)
+
+	post, errMsg = p.getLastPost(user, user.Id, &postTarget{TeamID: "testTeamId", RootID: "rootId", Root: true})
+	assert.Equal(t, "", errMsg)
+	assert.Equal(t, "rootId", post.Id)
+
+	_, errMsg = p.getLastPost(&model.User{Id: "otherUserId"}, user.Id, &postTarget{TeamID: "testTeamId", RootID: "rootId", Root: true})
+	assert.Equal(t, "`s/ Command: The root post of this thread is not yours.`", errMsg)
+
+	channel := &model.Channel{Id: "testChannelId", TeamId: "testTeamId"}
+	target, _ := p.resolveTarget(user, channel, "rootId", map[string]string{"thread": "root"})
+	assert.True(t, target.Root)
+
+	p.setConfiguration(&configuration{ThreadTarget: threadRoot})
+	target, _ = p.resolveTarget(user, channel, "rootId", map[string]string{"thread": "reply"})
+	assert.False(t, target.Root)
+	target, _ = p.resolveTarget(user, channel, "rootId", map[string]string{})
+	assert.True(t, target.Root)
+
+	_, errMsg = p.resolveTarget(user, channel, "rootId", map[string]string{"thread": "first"})
+	assert.Contains(t, errMsg, "root or reply")
+}
+
+func TestGetLastPostThreadFallback(t *testing.T) {
+	user := &model.User{Id: "testUserId", Username: "test"}
+
+	thread := model.NewPostList()
+	thread.AddPost(&model.Post{Id: "rootId", UserId: "otherUserId", CreateAt: 1})
+	thread.AddPost(&model.Post{Id: "replyId", UserId: "otherUserId", CreateAt: 2})
+
+	api := &plugintest.API{}
+	defer api.AssertExpectations(t)
+	p := setupTestPlugin(t, api)
+	api.On("GetPostThread", "rootId").Return(thread, nil)
+
+	target := &postTarget{TeamID: "testTeamId", ChannelID: "testChannelId", ChannelName: "town-square", RootID: "rootId"}
+	_, errMsg := p.getLastPost(user, user.Id, target)
+	assert.Equal(t, noPostsFoundError, errMsg)
+
+	channelPost := &model.Post{Id: "channelPostId", UserId: "testUserId", ChannelId: "testChannelId"}
+	api.On("SearchPostsInTeam", "testTeamId", mock.AnythingOfType("[]*model.SearchParams")).Return([]*model.Post{channelPost}, nil)
+	api.On("HasPermissionToChannel", "testUserId", "testChannelId", model.PERMISSION_EDIT_POST).Return(true)
+
+	p.setConfiguration(&configuration{ThreadFallback: true})
+	post, errMsg := p.getLastPost(user, user.Id, target)
+	assert.Equal(t, "", errMsg)
+	assert.Equal(t, "channelPostId", post.Id)
+}
+
+func TestGetLastPostSkipsIntegrationPosts(t *testing.T) {
+	user := &model.User{Id: "testUserId", Username: "test"}
+
+	api := &plugintest.API{}
+	defer api.AssertExpectations(t)
+	p := setupTestPlugin(t, api)
+
+	webhookPost := &model.Post{Id: "webhookId", UserId: "testUserId", ChannelId: "testChannelId", Message: "Build passed"}
+	webhookPost.AddProp("from_webhook", "true")
+	posts := []*model.Post{
+		webhookPost,
+		{Id: "attachmentId", UserId: "testUserId", ChannelId: "testChannelId", Type: model.POST_SLACK_ATTACHMENT},
+		{Id: "leftId", UserId: "testUserId", ChannelId: "testChannelId", Type: model.POST_LEAVE_CHANNEL},
+		{Id: "wantedId", UserId: "testUserId", ChannelId: "testChannelId", Message: "helo"},
+	}
+	api.On("SearchPostsInTeam", "testTeamId", mock.AnythingOfType("[]*model.SearchParams")).Return(posts, nil)
+	api.On("HasPermissionToChannel", "testUserId", "testChannelId", model.PERMISSION_EDIT_POST).Return(true)
+
+	post, errMsg := p.getLastPost(user, user.Id, &postTarget{TeamID: "testTeamId", ChannelID: "testChannelId", ChannelName: "town-square"})
+	assert.Equal(t, "", errMsg)
+	assert.Equal(t, "wantedId", post.Id)
+}
+
+func TestGetLastPostDirectMessage(t *testing.T) {
+	user := &model.User{Id: "testUserId", Username: "test"}
+
+	api := &plugintest.API{}
+	defer api.AssertExpectations(t)
+	p := setupTestPlugin(t, api)
+
+	postList := model.NewPostList()
+	postList.AddPost(&model.Post{Id: "theirsId", UserId: "otherUserId", Message: "hi"})
+	postList.AddPost(&model.Post{Id: "joinedId", UserId: "testUserId", Type: model.POST_JOIN_CHANNEL})
+	postList.AddPost(&model.Post{Id: "wantedId", UserId: "testUserId", Message: "helo"})
+	postList.AddOrder("theirsId")
+	postList.AddOrder("joinedId")
+	postList.AddOrder("wantedId")
+	api.On("GetPostsForChannel", "directId", 0, bulkPageSize).Return(postList, nil)
+
+	post, errMsg := p.getLastPost(user, user.Id, &postTarget{ChannelID: "directId"})
+	assert.Equal(t, "", errMsg)
+	assert.Equal(t, "wantedId", post.Id)
+
+	channel := &model.Channel{Id: "directId", Type: model.CHANNEL_DIRECT}
+	_, errMsg = p.resolveTarget(user, channel, "", map[string]string{"in": "*"})
+	assert.Contains(t, errMsg, "belong to no team")
+}
+
+func TestGetLastPostRank(t *testing.T) {
+	user := &model.User{Id: "testUserId", Username: "test"}
+
+	t.Run("search", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		p := setupTestPlugin(t, api)
+
+		posts := []*model.Post{
+			{Id: "latestId", ChannelId: "testChannelId"},
+			{Id: "leftId", ChannelId: "leftChannelId"},
+			{Id: "secondId", ChannelId: "testChannelId"},
+			{Id: "thirdId", ChannelId: "testChannelId"},
+		}
+		api.On("SearchPostsInTeam", "testTeamId", mock.Anything).Return(posts, nil)
+		api.On("HasPermissionToChannel", "testUserId", "testChannelId", model.PERMISSION_EDIT_POST).Return(true)
+		api.On("HasPermissionToChannel", "testUserId", "leftChannelId", model.PERMISSION_EDIT_POST).Return(false)
+
+		post, errMsg := p.getLastPost(user, user.Id, &postTarget{TeamID: "testTeamId", Rank: 2})
+		assert.Equal(t, "", errMsg)
+		assert.Equal(t, "secondId", post.Id)
+
+		_, errMsg = p.getLastPost(user, user.Id, &postTarget{TeamID: "testTeamId", Rank: 4})
+		assert.Equal(t, "`s/ Command: You have fewer than 4 previous posts.`", errMsg)
+
+		p.setConfiguration(&configuration{LookbackPosts: 1})
+		_, errMsg = p.getLastPost(user, user.Id, &postTarget{TeamID: "testTeamId", Rank: 2})
+		assert.Equal(t, "`s/ Command: Only your last 1 posts may be edited.`", errMsg)
+	})
+
+	t.Run("thread", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		p := setupTestPlugin(t, api)
+
+		thread := model.NewPostList()
+		thread.AddPost(&model.Post{Id: "latestId", UserId: "testUserId", CreateAt: 4})
+		thread.AddPost(&model.Post{Id: "otherId", UserId: "otherUserId", CreateAt: 3})
+		thread.AddPost(&model.Post{Id: "secondId", UserId: "testUserId", CreateAt: 2})
+		api.On("GetPostThread", "rootId").Return(thread, nil)
+
+		post, errMsg := p.getLastPost(user, user.Id, &postTarget{TeamID: "testTeamId", RootID: "rootId", Rank: 2})
+		assert.Equal(t, "", errMsg)
+		assert.Equal(t, "secondId", post.Id)
+	})
+}
+
+func TestGetLastPostByID(t *testing.T) {
+	user := &model.User{Id: "testUserId", Username: "test"}
+	target := &postTarget{TeamID: "testTeamId", PostID: "wantedId"}
+	notFound := "`s/ Command: Post wantedId was not found among the posts you may edit.`"
+
+	t.Run("own post", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		p := setupTestPlugin(t, api)
+
+		wanted := &model.Post{Id: "wantedId", UserId: "testUserId", ChannelId: "testChannelId"}
+		api.On("GetPost", "wantedId").Return(wanted, nil)
+		api.On("HasPermissionToChannel", "testUserId", "testChannelId", model.PERMISSION_EDIT_POST).Return(true)
+
+		post, errMsg := p.getLastPost(user, user.Id, target)
+		assert.Equal(t, "", errMsg)
+		assert.Equal(t, wanted, post)
+	})
+
+	t.Run("someone else's post", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		p := setupTestPlugin(t, api)
+
+		api.On("GetPost", "wantedId").Return(&model.Post{Id: "wantedId", UserId: "otherUserId", ChannelId: "testChannelId"}, nil)
+
+		post, errMsg := p.getLastPost(user, user.Id, target)
+		assert.Nil(t, post)
+		assert.Equal(t, notFound, errMsg)
+	})
+
+	t.Run("missing post", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		p := setupTestPlugin(t, api)
+
+		api.On("GetPost", "wantedId").Return(nil, &model.AppError{Message: "not found"})
+
+		_, errMsg := p.getLastPost(user, user.Id, target)
+		assert.Equal(t, notFound, errMsg)
+	})
+
+	t.Run("combined with selectors", func(t *testing.T) {
+		p := setupTestPlugin(t, &plugintest.API{})
+
+		channel := &model.Channel{Id: "testChannelId", TeamId: "testTeamId"}
+		target, errMsg := p.resolveTarget(user, channel, "rootId", map[string]string{"id": "wantedId"})
+		assert.Equal(t, "", errMsg)
+		assert.Equal(t, &postTarget{TeamID: "testTeamId", PostID: "wantedId"}, target)
+
+		_, errMsg = p.resolveTarget(user, channel, "", map[string]string{"id": "wantedId", "in": "deploys"})
+		assert.Contains(t, errMsg, "cannot be combined")
+	})
+}
+
+func TestResolveAuthor(t *testing.T) {
+	user := &model.User{Id: "testUserId", Username: "test"}
+	botUser := &model.User{Id: "botId", Username: "deploybot", IsBot: true}
+
+	t.Run("no bot scope", func(t *testing.T) {
+		p := setupTestPlugin(t, &plugintest.API{})
+
+		author, errMsg := p.resolveAuthor(user, "testTeamId", map[string]string{})
+		assert.Equal(t, "", errMsg)
+		assert.Equal(t, user, author)
+	})
+
+	t.Run("owned bot", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		p := setupTestPlugin(t, api)
+
+		api.On("GetUserByUsername", "deploybot").Return(botUser, nil)
+		api.On("GetBot", "botId", false).Return(&model.Bot{UserId: "botId", OwnerId: "testUserId"}, nil)
+
+		author, errMsg := p.resolveAuthor(user, "testTeamId", map[string]string{"bot": "deploybot"})
+		assert.Equal(t, "", errMsg)
+		assert.Equal(t, botUser, author)
+	})
+
+	t.Run("someone else's bot", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		p := setupTestPlugin(t, api)
+
+		api.On("GetUserByUsername", "deploybot").Return(botUser, nil)
+		api.On("GetBot", "botId", false).Return(&model.Bot{UserId: "botId", OwnerId: "otherUserId"}, nil)
+		api.On("HasPermissionTo", "testUserId", model.PERMISSION_MANAGE_OTHERS_BOTS).Return(false)
+
+		author, errMsg := p.resolveAuthor(user, "testTeamId", map[string]string{"bot": "deploybot"})
+		assert.Nil(t, author)
+		assert.Contains(t, errMsg, "You do not manage bot deploybot")
+	})
+
+	t.Run("not a bot", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		p := setupTestPlugin(t, api)
+
+		api.On("GetUserByUsername", "alice").Return(&model.User{Id: "aliceId", Username: "alice"}, nil)
+
+		author, errMsg := p.resolveAuthor(user, "testTeamId", map[string]string{"bot": "alice"})
+		assert.Nil(t, author)
+		assert.Contains(t, errMsg, "Bot alice not found")
+	})
+
+	t.Run("moderator mode disabled", func(t *testing.T) {
+		p := setupTestPlugin(t, &plugintest.API{})
+
+		author, errMsg := p.resolveAuthor(user, "testTeamId", map[string]string{"u": "alice"})
+		assert.Nil(t, author)
+		assert.Contains(t, errMsg, "not enabled")
+	})
+
+	t.Run("other user", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		p := setupTestPlugin(t, api)
+		rules, err := parseFeatureFlags("moderator=on")
+		require.NoError(t, err)
+		p.setConfiguration(&configuration{featureRules: rules})
+
+		alice := &model.User{Id: "aliceId", Username: "alice"}
+		api.On("GetUserByUsername", "alice").Return(alice, nil)
+
+		author, errMsg := p.resolveAuthor(user, "testTeamId", map[string]string{"u": "alice"})
+		assert.Equal(t, "", errMsg)
+		assert.Equal(t, alice, author)
+
+		author, errMsg = p.resolveAuthor(user, "testTeamId", map[string]string{"u": "alice", "bot": "deploybot"})
+		assert.Nil(t, author)
+		assert.Contains(t, errMsg, "cannot be combined")
+	})
+}
+
+func TestCanModerate(t *testing.T) {
+	t.Run("system admin", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		p := setupTestPlugin(t, api)
+
+		api.On("HasPermissionTo", "adminId", model.PERMISSION_MANAGE_SYSTEM).Return(true)
+
+		assert.True(t, p.canModerate("adminId", "testChannelId"))
+	})
+
+	t.Run("channel admin", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		p := setupTestPlugin(t, api)
+
+		api.On("HasPermissionTo", "testUserId", model.PERMISSION_MANAGE_SYSTEM).Return(false)
+		api.On("HasPermissionToChannel", "testUserId", "testChannelId", model.PERMISSION_MANAGE_CHANNEL_ROLES).Return(true)
+
+		assert.False(t, p.canModerate("testUserId", "testChannelId"))
+
+		p.setConfiguration(&configuration{ModeratorRole: moderatorChannelAdmin})
+		assert.True(t, p.canModerate("testUserId", "testChannelId"))
+	})
+}
+
+func TestSearchBack(t *testing.T) {
+	user := &model.User{Id: "testUserId", Username: "test"}
+	channel := &model.Channel{Id: "testChannelId", TeamId: "testTeamId"}
+	subs := []*substitute.Substitution{{Pattern: "teh", Replacement: "the"}}
+
+	api := &plugintest.API{}
+	defer api.AssertExpectations(t)
+	p := setupTestPlugin(t, api)
+	p.setConfiguration(&configuration{SearchBackPosts: 10})
+
+	posts := []*model.Post{
+		{Id: "latestId", ChannelId: "testChannelId", Message: "all good"},
+		{Id: "pollId", ChannelId: "testChannelId", Message: "teh poll", Type: model.POST_CUSTOM_TYPE_PREFIX + "poll"},
+		{Id: "wantedId", ChannelId: "testChannelId", Message: "teh deploy"},
+		{Id: "olderId", ChannelId: "testChannelId", Message: "teh rollback"},
+	}
+	api.On("SearchPostsInTeam", "testTeamId", mock.Anything).Return(posts, nil)
+	api.On("GetChannel", "testChannelId").Return(channel, nil)
+	api.On("HasPermissionToChannel", "testUserId", "testChannelId", model.PERMISSION_EDIT_POST).Return(true)
+
+	post, result, confirmation := p.searchBack(channel, user, user.Id, &postTarget{TeamID: "testTeamId"}, subs)
+	if assert.NotNil(t, post) {
+		assert.Equal(t, "wantedId", post.Id)
+		assert.Equal(t, "the deploy", result.Message)
+		assert.Equal(t, `s/ Replaced 1 occurrence of "teh" with "the"`, confirmation)
+	}
+
+	p.setConfiguration(&configuration{SearchBackPosts: 10, LookbackPosts: 2})
+	post, _, _ = p.searchBack(channel, user, user.Id, &postTarget{TeamID: "testTeamId"}, subs)
+	assert.Nil(t, post)
+
+	p.setConfiguration(&configuration{})
+	post, _, _ = p.searchBack(channel, user, user.Id, &postTarget{TeamID: "testTeamId"}, subs)
+	assert.Nil(t, post)
+}
+
+func TestPermalink(t *testing.T) {
+	api := &plugintest.API{}
+	defer api.AssertExpectations(t)
+	p := setupTestPlugin(t, api)
+
+	siteURL := "https://chat.example.com/"
+	api.On("GetConfig").Return(&model.Config{ServiceSettings: model.ServiceSettings{SiteURL: &siteURL}})
+	api.On("GetTeam", "testTeamId").Return(&model.Team{Name: "engineering"}, nil)
+
+	assert.Equal(t, "https://chat.example.com/engineering/pl/postId", p.permalink("testTeamId", "postId"))
+}
@@ -0,0 +1,445 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-server/model"
+
+	"github.com/carmo-evan/mattermost-plugin-replace/server/pkg/parser"
+	"github.com/carmo-evan/mattermost-plugin-replace/server/pkg/substitute"
+)
+
+// States of a team job.
+const (
+	teamJobPreviewed string = "previewed"
+	teamJobRunning   string = "running"
+	teamJobDone      string = "done"
+	teamJobFailed    string = "failed"
+)
+
+const (
+	// teamJobExpiry is how long a dry run may be confirmed once previewed.
+	teamJobExpiry = time.Hour
+	// teamJobMaxPosts caps how many posts a team job inspects.
+	teamJobMaxPosts = 10000
+	// teamJobSamples is how many of the changed posts a dry run shows.
+	teamJobSamples = 5
+	// teamJobSampleLength caps the length of a sample, in characters.
+	teamJobSampleLength = 200
+	// searchResultLimit is the most posts a search returns on the supported server versions.
+	searchResultLimit = 100
+)
+
+// teamJob is a find and replace over the whole history of a team, for admins removing text such
+// as a leaked internal hostname. It is previewed as a dry run first and only applied once
+// confirmed by the admin who created it. Matching posts are found by searching the team, one
+// batch of search results at a time: edited posts no longer match, so each search brings the
+// next batch. Once a search only returns posts already inspected, the search moves on to the
+// days before the oldest of them.
+type teamJob struct {
+	ID        string `json:"id"`
+	TeamID    string `json:"team_id"`
+	ChannelID string `json:"channel_id"`
+	UserID    string `json:"user_id"`
+	Command   string `json:"command"`
+	Status    string `json:"status"`
+
+	// Matched is the number of posts the dry run would change, and More reports whether the
+	// search stopped before finding them all.
+	Matched int  `json:"matched"`
+	More    bool `json:"more"`
+
+	Scanned      int      `json:"scanned"`
+	Edited       int      `json:"edited"`
+	Replacements int      `json:"replacements"`
+	EditedIDs    []string `json:"edited_ids,omitempty"`
+
+	// FailedIDs are the posts whose update failed, such as posts of archived channels.
+	FailedIDs []string `json:"failed_ids,omitempty"`
+	Error     string   `json:"error,omitempty"`
+
+	CreatedAt  int64 `json:"created_at"`
+	FinishedAt int64 `json:"finished_at,omitempty"`
+}
+
+func teamJobKey(jobID string) string {
+	return "team_job_" + jobID
+}
+
+// teamJobAuditKey is where the report of a finished job is kept, subject to the audit retention.
+func teamJobAuditKey(jobID string) string {
+	return auditKeyPrefix + "team_job_" + jobID
+}
+
+func (p *Plugin) getTeamJob(jobID string) (*teamJob, error) {
+	data, appErr := p.API.KVGet(teamJobKey(jobID))
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to get team job")
+	}
+
+	if data == nil {
+		return nil, nil
+	}
+
+	job := &teamJob{}
+	if err := json.Unmarshal(data, job); err != nil {
+		return nil, errors.Wrap(err, "failed to decode team job")
+	}
+
+	return job, nil
+}
+
+func (p *Plugin) saveTeamJob(key string, job *teamJob) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode team job")
+	}
+
+	if appErr := p.API.KVSet(key, data); appErr != nil {
+		return errors.Wrap(appErr, "failed to save team job")
+	}
+
+	return nil
+}
+
+// escapePattern matches an escaped character of a field.
+var escapePattern = regexp.MustCompile(`\\(.)`)
+
+// teamJobScript parses the command of a team job run by userID. A single command is accepted, and
+// its pattern is taken as plain text since it is also the search term finding the posts to
+// change. The search term is returned along with the substitution.
+func (c *configuration) teamJobScript(command, teamID, userID string) (*substitute.Substitution, string, error) {
+	cmds, err := parser.ParseScript(command)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if len(cmds) > 1 {
+		return nil, "", errors.New("a team job applies a single command")
+	}
+	if len(cmds[0].Scopes) > 0 || cmds[0].Rank > 0 {
+		return nil, "", errors.New("a team job takes no selectors or ~n")
+	}
+
+	subs, err := substitute.FromScript(cmds)
+	if err != nil {
+		return nil, "", err
+	}
+
+	sub := subs[0]
+	if sub.Regex || sub.Fuzzy || sub.Transliteration != nil {
+		return nil, "", errors.New("a team job searches for the pattern as plain text, so the r and f flags and y/ are not supported")
+	}
+	if sub.Attachments {
+		return nil, "", errors.New("a team job only rewrites the text of posts, so the a flag is not supported")
+	}
+	if sub.DryRun {
+		return nil, "", errors.New("a team job is always previewed before it runs, so the n flag is not supported")
+	}
+	sub.Literal = true
+	if err := c.applyDefaults(sub, teamID, userID); err != nil {
+		return nil, "", err
+	}
+
+	// Leaked hostnames and the like are mostly found in links.
+	sub.IncludeLinks = true
+
+	return sub, escapePattern.ReplaceAllString(cmds[0].Pattern, "$1"), nil
+}
+
+// searchTeamJob returns the posts of the team containing term that are not in seen, marking them
+// as seen. When before is a day as formatted by searchDay, only posts from earlier days are
+// searched. It also returns the day of the oldest post found, seen or not, or the empty string if
+// the search found nothing.
+func (p *Plugin) searchTeamJob(teamID, term, before string, seen map[string]bool) ([]*model.Post, string, error) {
+	params := model.ParseSearchParams(`"`+term+`"`, 0)
+	for _, param := range params {
+		param.BeforeDate = before
+	}
+
+	posts, appErr := p.API.SearchPostsInTeam(teamID, params)
+	if appErr != nil {
+		return nil, "", errors.Wrap(appErr, "failed to search posts")
+	}
+
+	var unseen []*model.Post
+	oldest := ""
+	for _, post := range posts {
+		if !seen[post.Id] {
+			seen[post.Id] = true
+			unseen = append(unseen, post)
+		}
+		if day := searchDay(post.CreateAt); oldest == "" || day < oldest {
+			oldest = day
+		}
+	}
+
+	return unseen, oldest, nil
+}
+
+// searchDay formats the UTC day of a time in milliseconds as search dates are written.
+func searchDay(millis int64) string {
+	return time.Unix(0, millis*int64(time.Millisecond)).UTC().Format("2006-01-02")
+}
+
+// truncate shortens text to at most length characters, marking the cut with an ellipsis.
+func truncate(text string, length int) string {
+	runes := []rune(text)
+	if len(runes) <= length {
+		return text
+	}
+
+	return string(runes[:length-1]) + "…"
+}
+
+// editable reports whether a team job may change post.
+func editable(post *model.Post) bool {
+	return post.DeleteAt == 0 && isTypedPost(post) && propsDrivenReason(post) == ""
+}
+
+// executeTeamCommand previews, confirms and reports on team jobs, for system admins only.
+func (p *Plugin) executeTeamCommand(args *model.CommandArgs, params []string) *model.CommandResponse {
+	usage := "Usage: `/replace team preview s/old/new/`, `/replace team confirm <job ID>` or `/replace team status <job ID>`"
+	if len(params) < 2 {
+		return commandResponse(usage)
+	}
+
+	if !p.API.HasPermissionTo(args.UserId, model.PERMISSION_MANAGE_SYSTEM) {
+		return commandResponse("Only system admins may run team jobs.")
+	}
+
+	if !p.getConfiguration().limits().AllowModerator {
+		return commandResponse("Rewriting other users' posts is disabled on this server.")
+	}
+
+	switch params[0] {
+	case "preview":
+		command := strings.TrimSpace(strings.TrimPrefix(subcommandText(args.Command, "team"), "preview"))
+		return p.previewTeamJob(args, command)
+	case "confirm":
+		return p.confirmTeamJob(args, params[1])
+	case "status":
+		return p.teamJobStatus(args, params[1])
+	default:
+		return commandResponse(usage)
+	}
+}
+
+// previewTeamJob runs command as a dry run over the team and saves it as a job awaiting
+// confirmation.
+func (p *Plugin) previewTeamJob(args *model.CommandArgs, command string) *model.CommandResponse {
+	sub, term, err := p.getConfiguration().teamJobScript(command, args.TeamId, args.UserId)
+	if err != nil {
+		return commandResponse("`%s` is not a valid command: %s.", command, err.Error())
+	}
+
+	context := map[string]string{"hook": "ExecuteCommand", "user_id": args.UserId, "team_id": args.TeamId}
+
+	posts, _, err := p.searchTeamJob(args.TeamId, term, "", make(map[string]bool))
+	if err != nil {
+		p.reportError(err, context)
+		return commandResponse("Failed to search the team.")
+	}
+
+	job := &teamJob{
+		ID:        model.NewId(),
+		TeamID:    args.TeamId,
+		ChannelID: args.ChannelId,
+		UserID:    args.UserId,
+		Command:   command,
+		Status:    teamJobPreviewed,
+		More:      len(posts) >= searchResultLimit,
+		CreatedAt: model.GetMillis(),
+	}
+
+	var samples []string
+	replacements := 0
+	for _, post := range posts {
+		if !editable(post) {
+			continue
+		}
+
+		result := sub.Preview(post.Message)
+		if result.Replacements == 0 || messageExcess(result.Message) > 0 {
+			continue
+		}
+
+		job.Matched++
+		replacements += result.Replacements
+		if len(samples) < teamJobSamples {
+			samples = append(samples, fmt.Sprintf("* [Post](%s): %s", p.permalink(args.TeamId, post.Id), truncate(result.Message, teamJobSampleLength)))
+		}
+	}
+
+	if job.Matched == 0 {
+		return commandResponse("No post of this team would be changed by `%s`.", command)
+	}
+
+	if err := p.saveTeamJob(teamJobKey(job.ID), job); err != nil {
+		p.reportError(err, context)
+		return commandResponse("Failed to save the job.")
+	}
+
+	count := fmt.Sprintf("%d posts", job.Matched)
+	if job.More {
+		count = "At least " + count
+	}
+
+	return commandResponse("###### Dry run of `%s`\n%s would change, with %d replacements. Direct and group messages are left alone. Samples of the result:\n%s\n\nRun `/replace team confirm %s` within %s to apply it.",
+		command, count, replacements, strings.Join(samples, "\n"), job.ID, teamJobExpiry)
+}
+
+// confirmTeamJob starts a previewed job in the background.
+func (p *Plugin) confirmTeamJob(args *model.CommandArgs, jobID string) *model.CommandResponse {
+	job, err := p.getTeamJob(jobID)
+	if err != nil {
+		p.reportError(err, map[string]string{"hook": "ExecuteCommand", "user_id": args.UserId})
+		return commandResponse("Failed to load the job.")
+	}
+
+	if job == nil || job.UserID != args.UserId {
+		return commandResponse("Job `%s` not found.", jobID)
+	}
+
+	if job.Status != teamJobPreviewed {
+		return commandResponse("Job `%s` was already confirmed.", jobID)
+	}
+
+	if time.Since(time.Unix(0, job.CreatedAt*int64(time.Millisecond))) > teamJobExpiry {
+		return commandResponse("The dry run of job `%s` expired, preview it again.", jobID)
+	}
+
+	// The job stays previewed, so it may still be confirmed once shadow mode is turned off.
+	if p.getConfiguration().ShadowMode {
+		return commandResponse("Shadow mode is on, so job `%s` cannot be applied. Its dry run shows what it would change.", jobID)
+	}
+
+	job.Status = teamJobRunning
+	job.ChannelID = args.ChannelId
+	if err := p.saveTeamJob(teamJobKey(job.ID), job); err != nil {
+		p.reportError(err, map[string]string{"hook": "ExecuteCommand", "user_id": args.UserId})
+		return commandResponse("Failed to save the job.")
+	}
+
+	go p.runTeamJobAndNotify(job)
+
+	return commandResponse("Job `%s` started. You will be notified when done; `/replace team status %s` shows its progress.", job.ID, job.ID)
+}
+
+// teamJobStatus reports the progress of a job.
+func (p *Plugin) teamJobStatus(args *model.CommandArgs, jobID string) *model.CommandResponse {
+	job, err := p.getTeamJob(jobID)
+	if err != nil {
+		p.reportError(err, map[string]string{"hook": "ExecuteCommand", "user_id": args.UserId})
+		return commandResponse("Failed to load the job.")
+	}
+
+	if job == nil {
+		return commandResponse("Job `%s` not found.", jobID)
+	}
+
+	return commandResponse("Job `%s` (`%s`) is %s: %s", job.ID, job.Command, job.Status, job.progress())
+}
+
+func (job *teamJob) progress() string {
+	progress := fmt.Sprintf("%d replacements in %d of %d posts inspected.", job.Replacements, job.Edited, job.Scanned)
+	if len(job.FailedIDs) > 0 {
+		progress += fmt.Sprintf(" %d posts could not be edited.", len(job.FailedIDs))
+	}
+
+	return progress
+}
+
+// runTeamJob applies a confirmed job, batch by batch, saving its progress after each batch. Posts
+// that fail to update are recorded in the job and skipped. A day holding more unchangeable
+// matches than a search returns may be left partly uninspected.
+func (p *Plugin) runTeamJob(job *teamJob) error {
+	sub, term, err := p.getConfiguration().teamJobScript(job.Command, job.TeamID, job.UserID)
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool)
+	before := ""
+	for job.Scanned < teamJobMaxPosts {
+		posts, oldest, err := p.searchTeamJob(job.TeamID, term, before, seen)
+		if err != nil {
+			return err
+		}
+		if len(posts) == 0 {
+			// Everything found was inspected and left as it is, so older days are searched
+			// next, until the search finds nothing or no longer goes back in time.
+			if oldest == "" || oldest == before {
+				return nil
+			}
+			before = oldest
+			continue
+		}
+
+		for _, post := range posts {
+			job.Scanned++
+			if !editable(post) {
+				continue
+			}
+
+			result := sub.Preview(post.Message)
+			if result.Replacements == 0 || messageExcess(result.Message) > 0 {
+				continue
+			}
+
+			post.Message = result.Message
+			if _, appErr := p.updatePost(post); appErr != nil {
+				p.reportError(appErr, postContext("runTeamJob", post))
+				job.FailedIDs = append(job.FailedIDs, post.Id)
+				continue
+			}
+
+			if err := p.recordRevision(post.Id, newRevision(job.UserID, job.Command, result.Original, result.Message)); err != nil {
+				p.reportError(err, postContext("runTeamJob", post))
+			}
+
+			job.Edited++
+			job.Replacements += result.Replacements
+			job.EditedIDs = append(job.EditedIDs, post.Id)
+		}
+
+		if err := p.saveTeamJob(teamJobKey(job.ID), job); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// runTeamJobAndNotify runs the job, records its report in the audit log and sends it to the admin
+// who confirmed it. It is meant to be run in its own goroutine.
+func (p *Plugin) runTeamJobAndNotify(job *teamJob) {
+	defer p.recoverPanic(map[string]string{"hook": "runTeamJob", "user_id": job.UserID, "team_id": job.TeamID})
+
+	job.Status = teamJobDone
+	if err := p.runTeamJob(job); err != nil {
+		p.reportError(err, map[string]string{"hook": "runTeamJob", "user_id": job.UserID, "team_id": job.TeamID})
+		job.Status = teamJobFailed
+		job.Error = err.Error()
+	}
+	job.FinishedAt = model.GetMillis()
+
+	for _, key := range []string{teamJobKey(job.ID), teamJobAuditKey(job.ID)} {
+		if err := p.saveTeamJob(key, job); err != nil {
+			p.reportError(err, map[string]string{"hook": "runTeamJob", "user_id": job.UserID, "team_id": job.TeamID})
+		}
+	}
+
+	message := fmt.Sprintf("Team job `%s` (`%s`) finished: %s", job.ID, job.Command, job.progress())
+	if job.Status == teamJobFailed {
+		message += " The job stopped early because of an error."
+	}
+
+	p.API.SendEphemeralPost(job.UserID, &model.Post{ChannelId: job.ChannelID, Message: message, CreateAt: model.GetMillis()})
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/mattermost/mattermost-server/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTeamJobScript(t *testing.T) {
+	config := &configuration{}
+
+	sub, term, err := config.teamJobScript(`s/db1.corp.internal/db.example.com/`, "teamId", "userId")
+	require.NoError(t, err)
+	assert.Equal(t, "db1.corp.internal", term)
+	assert.True(t, sub.Literal)
+	assert.Equal(t, "at db.example.com", sub.Preview("at db1.corp.internal").Message)
+
+	_, term, err = config.teamJobScript(`s/a\/b/c/`, "teamId", "userId")
+	require.NoError(t, err)
+	assert.Equal(t, "a/b", term)
+
+	for _, command := range []string{`s/a.*/b/r`, `s/a/b/; s/c/d/`, `s/a/b/ in:town-square`, `y/ab/cd/`} {
+		_, _, err := config.teamJobScript(command, "teamId", "userId")
+		assert.Error(t, err, command)
+	}
+}
+
+func TestExecuteTeamCommand(t *testing.T) {
+	args := &model.CommandArgs{UserId: "adminId", TeamId: "testTeamId", ChannelId: "testChannelId"}
+
+	t.Run("not an admin", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		p := setupTestPlugin(t, api)
+
+		api.On("HasPermissionTo", "adminId", model.PERMISSION_MANAGE_SYSTEM).Return(false)
+
+		args.Command = "/replace team preview s/leaked/redacted/"
+		response, appErr := p.ExecuteCommand(nil, args)
+		assert.Nil(t, appErr)
+		assert.Equal(t, "Only system admins may run team jobs.", response.Text)
+	})
+
+	t.Run("preview", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		p := setupTestPlugin(t, api)
+
+		posts := []*model.Post{
+			{Id: "post1", Message: "see leaked host"},
+			{Id: "post2", Message: "`leaked` in code"},
+			{Id: "post3", Message: "leaked joined", Type: model.POST_JOIN_CHANNEL},
+		}
+		api.On("HasPermissionTo", "adminId", model.PERMISSION_MANAGE_SYSTEM).Return(true)
+		api.On("SearchPostsInTeam", "testTeamId", mock.AnythingOfType("[]*model.SearchParams")).Return(posts, nil)
+		api.On("GetConfig").Return(&model.Config{})
+		api.On("GetTeam", "testTeamId").Return(&model.Team{Name: "engineering"}, nil)
+
+		var saved teamJob
+		api.On("KVSet", mock.AnythingOfType("string"), mock.AnythingOfType("[]uint8")).Return(nil).Run(func(args mock.Arguments) {
+			require.NoError(t, json.Unmarshal(args.Get(1).([]byte), &saved))
+		})
+
+		args.Command = "/replace team preview s/leaked/redacted/"
+		response, appErr := p.ExecuteCommand(nil, args)
+
+		assert.Nil(t, appErr)
+		assert.Contains(t, response.Text, "1 posts would change, with 1 replacements.")
+		assert.Contains(t, response.Text, "* [Post](/engineering/pl/post1): see redacted host")
+		assert.Contains(t, response.Text, "/replace team confirm "+saved.ID)
+		assert.Equal(t, teamJobPreviewed, saved.Status)
+		assert.Equal(t, "s/leaked/redacted/", saved.Command)
+	})
+
+	t.Run("confirm someone else's job", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		p := setupTestPlugin(t, api)
+
+		data, err := json.Marshal(&teamJob{ID: "jobId", UserID: "otherAdminId", Status: teamJobPreviewed, CreatedAt: model.GetMillis()})
+		require.NoError(t, err)
+		api.On("HasPermissionTo", "adminId", model.PERMISSION_MANAGE_SYSTEM).Return(true)
+		api.On("KVGet", teamJobKey("jobId")).Return(data, nil)
+
+		args.Command = "/replace team confirm jobId"
+		response, appErr := p.ExecuteCommand(nil, args)
+
+		assert.Nil(t, appErr)
+		assert.Equal(t, "Job `jobId` not found.", response.Text)
+	})
+
+	t.Run("confirm an expired job", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		p := setupTestPlugin(t, api)
+
+		data, err := json.Marshal(&teamJob{ID: "jobId", UserID: "adminId", Status: teamJobPreviewed, CreatedAt: model.GetMillis() - 2*60*60*1000})
+		require.NoError(t, err)
+		api.On("HasPermissionTo", "adminId", model.PERMISSION_MANAGE_SYSTEM).Return(true)
+		api.On("KVGet", teamJobKey("jobId")).Return(data, nil)
+
+		args.Command = "/replace team confirm jobId"
+		response, appErr := p.ExecuteCommand(nil, args)
+
+		assert.Nil(t, appErr)
+		assert.Contains(t, response.Text, "expired")
+	})
+
+	t.Run("confirm in shadow mode", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		p := setupTestPlugin(t, api)
+		p.setConfiguration(&configuration{ShadowMode: true})
+
+		data, err := json.Marshal(&teamJob{ID: "jobId", UserID: "adminId", Status: teamJobPreviewed, CreatedAt: model.GetMillis()})
+		require.NoError(t, err)
+		api.On("HasPermissionTo", "adminId", model.PERMISSION_MANAGE_SYSTEM).Return(true)
+		api.On("KVGet", teamJobKey("jobId")).Return(data, nil)
+
+		args.Command = "/replace team confirm jobId"
+		response, appErr := p.ExecuteCommand(nil, args)
+
+		assert.Nil(t, appErr)
+		assert.Contains(t, response.Text, "Shadow mode is on")
+	})
+}
+
+func TestRunTeamJob(t *testing.T) {
+	api := &plugintest.API{}
+	defer api.AssertExpectations(t)
+	p := setupTestPlugin(t, api)
+
+	posts := []*model.Post{
+		{Id: "post1", Message: "see leaked host"},
+		{Id: "post2", Message: "`leaked` in code"},
+	}
+	api.On("SearchPostsInTeam", "testTeamId", mock.AnythingOfType("[]*model.SearchParams")).Return(posts, nil)
+	api.On("UpdatePost", mock.MatchedBy(func(post *model.Post) bool {
+		return post.Id == "post1" && post.Message == "see redacted host"
+	})).Return(nil, nil)
+	api.On("KVGet", historyKey("post1")).Return(nil, nil)
+	api.On("KVSet", historyKey("post1"), mock.AnythingOfType("[]uint8")).Return(nil)
+	api.On("KVSet", teamJobKey("jobId"), mock.AnythingOfType("[]uint8")).Return(nil)
+
+	job := &teamJob{ID: "jobId", TeamID: "testTeamId", UserID: "adminId", Command: "s/leaked/redacted/", Status: teamJobRunning}
+	require.NoError(t, p.runTeamJob(job))
+
+	assert.Equal(t, 2, job.Scanned)
+	assert.Equal(t, 1, job.Edited)
+	assert.Equal(t, []string{"post1"}, job.EditedIDs)
+	api.AssertNumberOfCalls(t, "SearchPostsInTeam", 3)
+}
+
+func TestRunTeamJobPaging(t *testing.T) {
+	api := &plugintest.API{}
+	defer api.AssertExpectations(t)
+	p := setupTestPlugin(t, api)
+
+	searching := func(before string) interface{} {
+		return mock.MatchedBy(func(params []*model.SearchParams) bool {
+			return len(params) == 1 && params[0].BeforeDate == before
+		})
+	}
+
+	// The newest match cannot be changed and another fails to update, so that only searching
+	// older days reaches the last one.
+	unchanged := &model.Post{Id: "post1", Message: "leaked", Type: model.POST_JOIN_CHANNEL, CreateAt: 1767312000000}
+	archived := &model.Post{Id: "post2", Message: "leaked in an archived channel", CreateAt: 1767225600000}
+	older := &model.Post{Id: "post3", Message: "leaked long ago", CreateAt: 1735689600000}
+	api.On("SearchPostsInTeam", "testTeamId", searching("")).Return([]*model.Post{unchanged, archived}, nil)
+	api.On("SearchPostsInTeam", "testTeamId", searching("2026-01-01")).Return([]*model.Post{older}, nil)
+	api.On("SearchPostsInTeam", "testTeamId", searching("2025-01-01")).Return([]*model.Post{}, nil)
+	api.On("UpdatePost", mock.MatchedBy(func(post *model.Post) bool { return post.Id == "post2" })).Return(nil, model.NewAppError("UpdatePost", "archived", nil, "", 400))
+	api.On("UpdatePost", mock.MatchedBy(func(post *model.Post) bool { return post.Id == "post3" })).Return(nil, nil)
+	api.On("LogError", "Unexpected error", "error", mock.AnythingOfType("string")).Return()
+	api.On("KVGet", historyKey("post3")).Return(nil, nil)
+	api.On("KVSet", historyKey("post3"), mock.AnythingOfType("[]uint8")).Return(nil)
+	api.On("KVSet", teamJobKey("jobId"), mock.AnythingOfType("[]uint8")).Return(nil)
+
+	job := &teamJob{ID: "jobId", TeamID: "testTeamId", UserID: "adminId", Command: "s/leaked/redacted/", Status: teamJobRunning}
+	require.NoError(t, p.runTeamJob(job))
+
+	assert.Equal(t, 3, job.Scanned)
+	assert.Equal(t, []string{"post3"}, job.EditedIDs)
+	assert.Equal(t, []string{"post2"}, job.FailedIDs)
+	assert.Equal(t, "1 replacements in 1 of 3 posts inspected. 1 posts could not be edited.", job.progress())
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-server/model"
+)
+
+// undoCommand is the s/ message reverting the last edit made for its author.
+const undoCommand = "s/undo"
+
+// lastEditKey holds the ID of the post most recently edited by an s/ command of a user, whose
+// history s/history lists.
+func lastEditKey(userID string) string {
+	return "last_edit_" + userID
+}
+
+// undoKeyPrefix starts the keys of undoKey.
+const undoKeyPrefix string = "undo_"
+
+// undoKey holds the ID of the post whose latest revision an undo by a user restores. It expires
+// with the undo window.
+func undoKey(userID string) string {
+	return undoKeyPrefix + userID
+}
+
+// rememberEdit records postID as the post most recently edited for userID, which may be undone
+// until the undo window closes.
+func (p *Plugin) rememberEdit(userID, postID string) error {
+	if appErr := p.API.KVSet(lastEditKey(userID), []byte(postID)); appErr != nil {
+		return errors.Wrap(appErr, "failed to save last edit")
+	}
+
+	var appErr *model.AppError
+	if window := p.getConfiguration().undoWindow(); window > 0 {
+		appErr = p.API.KVSetWithExpiry(undoKey(userID), []byte(postID), int64(window/time.Second))
+	} else {
+		appErr = p.API.KVSet(undoKey(userID), []byte(postID))
+	}
+	if appErr != nil {
+		return errors.Wrap(appErr, "failed to save undo")
+	}
+
+	return nil
+}
+
+// undoWindow returns how long after an edit it may be undone, or zero if there is no limit.
+func (c *configuration) undoWindow() time.Duration {
+	if c.UndoWindowMinutes <= 0 {
+		return 0
+	}
+
+	return time.Duration(c.UndoWindowMinutes) * time.Minute
+}
+
+// undoHint tells user until when an edit made at now may be undone, or returns the empty string
+// if there is no limit.
+func (c *configuration) undoHint(user *model.User, now time.Time) string {
+	window := c.undoWindow()
+	if window == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("\nPost `s/undo` before %s to undo the edit.", now.Add(window).In(userLocation(user)).Format("15:04 MST"))
+}
+
+// lastEdited returns the ID of the post most recently edited for userID and its history, or the
+// empty string if the plugin has no record of such an edit.
+func (p *Plugin) lastEdited(userID string) (string, *postHistory, error) {
+	data, appErr := p.API.KVGet(lastEditKey(userID))
+	if appErr != nil {
+		return "", nil, errors.Wrap(appErr, "failed to get last edit")
+	}
+	if data == nil {
+		return "", nil, nil
+	}
+
+	history, err := p.getPostHistory(string(data))
+	if err != nil {
+		return "", nil, err
+	}
+	if len(history.Revisions) == 0 {
+		return "", nil, nil
+	}
+
+	return string(data), history, nil
+}
+
+// deferredEditRejection returns why userID may not edit post in an edit made after the command
+// that picked the post, such as an undo or a scheduled replacement, or the empty string if they
+// may. Permissions and settings are checked again, as they may have changed in between.
+func (p *Plugin) deferredEditRejection(userID string, post *model.Post) (string, error) {
+	if allowed, _ := p.mayEdit(userID, post); !allowed {
+		return "`s/ Command: You may no longer edit that post.`", nil
+	}
+
+	refusal, appErr := p.editRejection(post, userID)
+	if appErr != nil {
+		return "", appErr
+	}
+
+	return refusal, nil
+}
+
+// undoLastEdit restores the post most recently edited for userID to its message before the edit,
+// provided nobody changed it since. It returns the message telling the user the outcome. Only the
+// message is restored, and only the latest edit can be undone.
+func (p *Plugin) undoLastEdit(userID, teamID string) (string, bool, error) {
+	nothing := "`s/ Command: There is no edit of yours to undo.`"
+	if window := p.getConfiguration().UndoWindowMinutes; window > 0 {
+		nothing = fmt.Sprintf("`s/ Command: There is no edit of yours from the last %d minutes to undo.`", window)
+	}
+
+	data, appErr := p.API.KVGet(undoKey(userID))
+	if appErr != nil {
+		return "", false, errors.Wrap(appErr, "failed to get undo")
+	}
+	if data == nil {
+		return nothing, false, nil
+	}
+	postID := string(data)
+
+	history, err := p.getPostHistory(postID)
+	if err != nil {
+		return "", false, err
+	}
+	if len(history.Revisions) == 0 {
+		return nothing, false, nil
+	}
+	rev := history.Revisions[len(history.Revisions)-1]
+	link := p.permalink(teamID, postID)
+
+	if rev.UserID != userID {
+		return fmt.Sprintf("[The post](%s) was edited by someone else since, so your edit cannot be undone.", link), false, nil
+	}
+
+	post, appErr := p.API.GetPost(postID)
+	if appErr != nil || post.DeleteAt != 0 {
+		return "`s/ Command: The post to restore no longer exists.`", false, nil
+	}
+
+	switch err := rev.check(post.Message); err {
+	case nil:
+	case errEditedSince:
+		return fmt.Sprintf("[The post](%s) was edited since `%s`, so it was left as it is:\n%s", link, rev.Command, rev.threeWayView(post.Message)), false, nil
+	default:
+		return fmt.Sprintf("`s/ Command: The post cannot be restored, %s.`", err.Error()), false, nil
+	}
+
+	if refusal, err := p.deferredEditRejection(userID, post); refusal != "" || err != nil {
+		return refusal, false, err
+	}
+
+	post.Message = rev.Original
+	p.editLock.Lock()
+	_, appErr = p.updatePost(post)
+	p.editLock.Unlock()
+	if appErr != nil {
+		return "", false, appErr
+	}
+
+	// The edit is gone, so it may not be undone twice.
+	history.Revisions = history.Revisions[:len(history.Revisions)-1]
+	if err := p.saveHistory(postID, history); err != nil {
+		return "", false, err
+	}
+	if appErr := p.API.KVDelete(undoKey(userID)); appErr != nil {
+		return "", false, errors.Wrap(appErr, "failed to delete undo")
+	}
+
+	return fmt.Sprintf("[The post](%s) was restored to its message before `%s`.", link, rev.Command), true, nil
+}
+
+// runRevisionCommand answers s/undo, s/history or s/revert in post with run, which returns the
+// message for its author and whether the command succeeded. Only failures are subject to the
+// rejection mode. The return values are those of MessageWillBePosted.
+func (p *Plugin) runRevisionCommand(post *model.Post, notification *model.Post, run func(userID, teamID string) (string, bool, error)) (*model.Post, string) {
+	// In shadow mode nothing is changed, including the post itself.
+	if p.getConfiguration().ShadowMode {
+		return nil, ""
+	}
+
+	ch, appErr := p.getChannel(post.ChannelId)
+	if appErr != nil {
+		p.reportError(appErr, postContext("MessageWillBePosted", post))
+		return nil, ""
+	}
+
+	message, ok, err := run(post.UserId, ch.TeamId)
+	if err != nil {
+		p.reportError(err, postContext("MessageWillBePosted", post))
+		return p.dismiss(post.UserId, notification, "`s/ Command: Failed to read or restore the edit history.`")
+	}
+	if !ok {
+		return p.dismiss(post.UserId, notification, message)
+	}
+
+	notification.Message = message
+	p.API.SendEphemeralPost(post.UserId, notification)
+
+	return nil, "plugin.message_will_be_posted.dismiss_post"
+}
+
+// executeUndoCommand answers /replace undo, as s/undo.
+func (p *Plugin) executeUndoCommand(args *model.CommandArgs) *model.CommandResponse {
+	message, _, err := p.undoLastEdit(args.UserId, args.TeamId)
+	if err != nil {
+		p.reportError(err, map[string]string{"hook": "ExecuteCommand", "user_id": args.UserId, "channel_id": args.ChannelId})
+		return commandResponse("Failed to undo the edit.")
+	}
+
+	return commandResponse("%s", message)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/mattermost/mattermost-server/plugin"
+	"github.com/mattermost/mattermost-server/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestUndoHint(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	user := &model.User{Timezone: map[string]string{"useAutomaticTimezone": "false", "manualTimezone": "Europe/Berlin"}}
+
+	assert.Equal(t, "", (&configuration{}).undoHint(user, now))
+	assert.Equal(t, "\nPost `s/undo` before 13:10 CET to undo the edit.", (&configuration{UndoWindowMinutes: 10}).undoHint(user, now))
+}
+
+func TestRememberEdit(t *testing.T) {
+	api := &plugintest.API{}
+	defer api.AssertExpectations(t)
+
+	p := setupTestPlugin(t, api)
+	p.setConfiguration(&configuration{UndoWindowMinutes: 10})
+	api.On("KVSet", lastEditKey("testUserId"), []byte("lastPostId")).Return(nil)
+	api.On("KVSetWithExpiry", undoKey("testUserId"), []byte("lastPostId"), int64(600)).Return(nil)
+
+	assert.NoError(t, p.rememberEdit("testUserId", "lastPostId"))
+}
+
+func TestUndoLastEdit(t *testing.T) {
+	history := func(revisions ...*revision) []byte {
+		data, _ := json.Marshal(&postHistory{Revisions: revisions})
+		return data
+	}
+
+	t.Run("nothing to undo", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+
+		p := setupTestPlugin(t, api)
+		api.On("KVGet", undoKey("testUserId")).Return(nil, nil)
+
+		message, _, err := p.undoLastEdit("testUserId", "testTeamId")
+		assert.NoError(t, err)
+		assert.Equal(t, "`s/ Command: There is no edit of yours to undo.`", message)
+	})
+
+	t.Run("window closed", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+
+		p := setupTestPlugin(t, api)
+		p.setConfiguration(&configuration{UndoWindowMinutes: 10})
+		api.On("KVGet", undoKey("testUserId")).Return(nil, nil)
+
+		message, _, err := p.undoLastEdit("testUserId", "testTeamId")
+		assert.NoError(t, err)
+		assert.Equal(t, "`s/ Command: There is no edit of yours from the last 10 minutes to undo.`", message)
+	})
+
+	t.Run("restore", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+
+		p := setupTestPlugin(t, api)
+		post := &model.Post{Id: "lastPostId", UserId: "testUserId", ChannelId: "testChannelId", Message: "the cat"}
+		api.On("KVGet", undoKey("testUserId")).Return([]byte("lastPostId"), nil)
+		api.On("KVGet", historyKey("lastPostId")).Return(history(
+			newRevision("testUserId", "s/dog/cat/", "teh dog", "teh cat"),
+			newRevision("testUserId", "s/teh/the/", "teh cat", "the cat"),
+		), nil)
+		api.On("GetConfig").Return(&model.Config{})
+		api.On("GetTeam", "testTeamId").Return(&model.Team{Name: "engineering"}, nil)
+		api.On("GetPost", "lastPostId").Return(post, nil)
+		api.On("GetChannel", "testChannelId").Return(&model.Channel{Id: "testChannelId", Type: model.CHANNEL_OPEN}, nil)
+		api.On("HasPermissionToChannel", "testUserId", "testChannelId", model.PERMISSION_EDIT_POST).Return(true)
+		api.On("UpdatePost", mock.MatchedBy(func(post *model.Post) bool {
+			return post.Message == "teh cat"
+		})).Return(post, nil)
+		api.On("KVSet", historyKey("lastPostId"), mock.MatchedBy(func(data []byte) bool {
+			var saved postHistory
+			return json.Unmarshal(data, &saved) == nil && len(saved.Revisions) == 1 && saved.Revisions[0].Command == "s/dog/cat/"
+		})).Return(nil)
+		api.On("KVDelete", undoKey("testUserId")).Return(nil)
+
+		message, ok, err := p.undoLastEdit("testUserId", "testTeamId")
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, "[The post](/engineering/pl/lastPostId) was restored to its message before `s/teh/the/`.", message)
+	})
+
+	t.Run("edited since", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+
+		p := setupTestPlugin(t, api)
+		api.On("KVGet", undoKey("testUserId")).Return([]byte("lastPostId"), nil)
+		api.On("KVGet", historyKey("lastPostId")).Return(history(newRevision("testUserId", "s/teh/the/", "teh cat", "the cat")), nil)
+		api.On("GetConfig").Return(&model.Config{})
+		api.On("GetTeam", "testTeamId").Return(&model.Team{Name: "engineering"}, nil)
+		api.On("GetPost", "lastPostId").Return(&model.Post{Id: "lastPostId", UserId: "testUserId", Message: "the black cat"}, nil)
+
+		message, _, err := p.undoLastEdit("testUserId", "testTeamId")
+		assert.NoError(t, err)
+		assert.True(t, strings.HasPrefix(message, "[The post](/engineering/pl/lastPostId) was edited since `s/teh/the/`, so it was left as it is:\n"))
+		assert.True(t, strings.HasSuffix(message, "###### Now\n> the black cat"))
+	})
+
+	t.Run("edited by someone else", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+
+		p := setupTestPlugin(t, api)
+		api.On("KVGet", undoKey("testUserId")).Return([]byte("lastPostId"), nil)
+		api.On("KVGet", historyKey("lastPostId")).Return(history(newRevision("moderatorId", "s/cat/dog/", "the cat", "the dog")), nil)
+		api.On("GetConfig").Return(&model.Config{})
+		api.On("GetTeam", "testTeamId").Return(&model.Team{Name: "engineering"}, nil)
+
+		message, _, err := p.undoLastEdit("testUserId", "testTeamId")
+		assert.NoError(t, err)
+		assert.Equal(t, "[The post](/engineering/pl/lastPostId) was edited by someone else since, so your edit cannot be undone.", message)
+	})
+}
+
+func TestMessageWillBePostedUndo(t *testing.T) {
+	api := &plugintest.API{}
+	defer api.AssertExpectations(t)
+
+	p := setupTestPlugin(t, api)
+	api.On("GetChannel", "testChannelId").Return(&model.Channel{Id: "testChannelId", TeamId: "testTeamId"}, nil)
+	api.On("KVGet", undoKey("testUserId")).Return(nil, nil)
+	api.On("SendEphemeralPost", "testUserId", mock.MatchedBy(func(notification *model.Post) bool {
+		return notification.Message == "`s/ Command: There is no edit of yours to undo.`"
+	})).Return(nil)
+
+	_, rejection := p.MessageWillBePosted(&plugin.Context{}, &model.Post{UserId: "testUserId", ChannelId: "testChannelId", Message: " s/undo "})
+
+	assert.Equal(t, "plugin.message_will_be_posted.dismiss_post", rejection)
+}
+
+func TestRunRevisionCommandRejectionMode(t *testing.T) {
+	api := &plugintest.API{}
+	defer api.AssertExpectations(t)
+
+	p := setupTestPlugin(t, api)
+	p.setConfiguration(&configuration{RejectionMode: rejectReason})
+	post := &model.Post{UserId: "testUserId", ChannelId: "testChannelId", Message: "s/undo"}
+	api.On("GetChannel", "testChannelId").Return(&model.Channel{Id: "testChannelId", TeamId: "testTeamId"}, nil)
+	api.On("SendEphemeralPost", "testUserId", mock.MatchedBy(func(notification *model.Post) bool {
+		return notification.Message == "The post was restored."
+	})).Return(nil)
+
+	_, rejection := p.runRevisionCommand(post, &model.Post{}, func(userID, teamID string) (string, bool, error) {
+		return "The post was restored.", true, nil
+	})
+	assert.Equal(t, "plugin.message_will_be_posted.dismiss_post", rejection)
+
+	_, rejection = p.runRevisionCommand(post, &model.Post{}, func(userID, teamID string) (string, bool, error) {
+		return "`s/ Command: There is no edit of yours to undo.`", false, nil
+	})
+	assert.Equal(t, "s/ Command: There is no edit of yours to undo.", rejection)
+}
@@ -0,0 +1,72 @@
+//go:build js && wasm
+// +build js,wasm
+
+// Command wasm exposes the substitute engine to JavaScript, so that a webapp component can show a
+// live preview of a replacement as the user types without duplicating the Go logic. Build it with
+// make wasm.
+package main
+
+import (
+	"encoding/json"
+	"syscall/js"
+
+	"github.com/carmo-evan/mattermost-plugin-replace/server/pkg/parser"
+	"github.com/carmo-evan/mattermost-plugin-replace/server/pkg/substitute"
+)
+
+func main() {
+	js.Global().Set("replacePreview", js.FuncOf(preview))
+
+	// Keep the Go runtime alive for as long as the page calls into it.
+	select {}
+}
+
+// preview is called from JavaScript as replacePreview(command, message, defaults), where
+// defaults is the object returned by the plugin's /api/v1/preview/defaults endpoint. It returns
+// an object shaped like the plugin's /api/v1/preview response.
+func preview(this js.Value, args []js.Value) interface{} {
+	if len(args) != 3 {
+		return map[string]interface{}{"error": "expected a command, a message and the defaults"}
+	}
+
+	var defaults substitute.Defaults
+	data := js.Global().Get("JSON").Call("stringify", args[2]).String()
+	if err := json.Unmarshal([]byte(data), &defaults); err != nil {
+		return map[string]interface{}{"error": "invalid defaults: " + err.Error()}
+	}
+
+	cmds, err := parser.ParseScript(args[0].String())
+	var subs []*substitute.Substitution
+	if err == nil {
+		subs, err = substitute.FromScript(cmds)
+	}
+	if err == nil {
+		err = defaults.ApplyScript(subs)
+	}
+	if err != nil {
+		return map[string]interface{}{"error": err.Error()}
+	}
+
+	message := args[1].String()
+	replacements := 0
+	matched := []interface{}{}
+	for _, s := range subs {
+		result := s.Preview(message)
+		message = result.Message
+		replacements += result.Replacements
+		for _, text := range result.Matched {
+			matched = append(matched, text)
+		}
+	}
+
+	response := map[string]interface{}{
+		"original":     args[1].String(),
+		"message":      message,
+		"replacements": replacements,
+	}
+	if len(matched) > 0 {
+		response["matched"] = matched
+	}
+
+	return response
+}